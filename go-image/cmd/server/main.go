@@ -1,39 +1,49 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"go-image/internal/api"
+	"go-image/internal/config"
 	"go-image/internal/middleware"
 	"go-image/internal/service"
 	"go-image/internal/storage"
 )
 
 func main() {
+	// 解析命令行参数，配置文件路径也可以通过 GO_IMAGE_CONFIG 环境变量指定
+	defaultConfigPath := config.DefaultPath
+	if path := os.Getenv("GO_IMAGE_CONFIG"); path != "" {
+		defaultConfigPath = path
+	}
+	configPath := flag.String("config", defaultConfigPath, "配置文件路径")
+	flag.Parse()
 
-	// 初始化存储目录
-	initStorageDirs()
-
-	// 初始化配置服务
-	configService, err := service.NewConfigService("config.json")
+	// 加载配置
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("初始化配置服务失败: %v", err)
-	}
-	// 设置默认存储限制
-	if err := configService.InitConfig(1024 * 1024 * 1024); err != nil { // 1GB
-		log.Printf("设置默认存储限制失败: %v", err)
+		log.Fatalf("加载配置失败: %v", err)
 	}
 
+	// 初始化存储目录
+	initStorageDirs(cfg)
+
 	// 创建Gin引擎
 	r := gin.Default()
 
+	// 只信任配置的反向代理转发的访问者 IP，签名链接绑定 IP 时依赖它
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("设置信任的反向代理失败: %v", err)
+	}
+
 	// 设置会话存储
-	store := cookie.NewStore([]byte("change-this-to-a-random-string"))
+	store := cookie.NewStore([]byte(cfg.Auth.SessionSecret))
 	r.Use(sessions.Sessions("go-image-session", store))
 
 	// 加载HTML模板
@@ -42,17 +52,68 @@ func main() {
 	// 设置静态文件路由
 	r.Static("/static", "./static")
 
-	// 初始化存储服务
-	fileStorage, err := storage.NewLocalStorage("static/uploads")
+	// 初始化存储服务（根据配置选择本地存储或 S3 兼容对象存储）
+	fileStorage, err := storage.New(cfg.StorageConfig())
 	if err != nil {
-		log.Fatalf("初始化本地存储失败: %v", err)
+		log.Fatalf("初始化存储失败: %v", err)
 	}
 
+	// 初始化认证服务
+	authService := service.NewAuthService(cfg.AuthServiceConfig())
+
+	// 创建默认管理员账户
+	if cfg.Auth.Admin.Username != "" && cfg.Auth.Admin.Password != "" {
+		if err := authService.EnsureUser(cfg.Auth.Admin.Username, cfg.Auth.Admin.Password); err != nil {
+			log.Printf("创建管理员账户失败: %v", err)
+		}
+	}
+
+	// 初始化配额服务
+	quotaService := service.NewQuotaService(cfg.QuotaServiceConfig(), authService)
+
+	// 初始化个人设置服务
+	settingsService := service.NewSettingsService(cfg.UserSettingsDefaults())
+
 	// 初始化图片服务
-	imageService := service.NewImageService(fileStorage)
+	imageService := service.NewImageService(fileStorage, quotaService, settingsService, cfg.ImageConfig())
 
-	// 初始化认证服务
-	authService := service.NewAuthService()
+	// 重新统计存储空间使用量
+	if cfg.Quota.ReconcileOnStart || quotaService.Stale() {
+		reconcileUsage(imageService, authService)
+	}
+
+	// 定期永久删除回收站中过期的图片
+	imageService.PurgeExpiredTrash()
+	imageService.StartTrashPurge(time.Hour)
+
+	// 初始化断点续传服务，并定期清理过期的上传
+	tusService, err := service.NewTusService(imageService, cfg.TusServiceConfig())
+	if err != nil {
+		log.Fatalf("初始化断点续传服务失败: %v", err)
+	}
+	tusService.CleanupExpired()
+	tusService.StartCleanup(time.Hour)
+
+	// 初始化网址导入服务
+	fetchService := service.NewFetchService(imageService, cfg.FetchServiceConfig())
+
+	// 初始化相册服务
+	albumService := service.NewAlbumService(fileStorage)
+
+	// 初始化API令牌服务
+	tokenService := service.NewTokenService()
+
+	// 初始化签名链接服务
+	signer := service.NewURLSigner(cfg.SigningServiceConfig())
+
+	// 初始化防盗链服务
+	hotlink, err := service.NewHotlinkProtector(cfg.HotlinkServiceConfig(), settingsService)
+	if err != nil {
+		log.Fatalf("初始化防盗链服务失败: %v", err)
+	}
+
+	// 初始化分享服务
+	shareService := service.NewShareService(fileStorage, cfg.ShareServiceConfig())
 
 	// 注册中间件
 	r.Use(middleware.Logger())
@@ -60,9 +121,9 @@ func main() {
 	// 公共路由
 	r.GET("/", api.HomeHandler)
 	r.GET("/register", api.RegisterPageHandler)
-	r.POST("/register", api.RegisterHandler)
+	r.POST("/register", api.RegisterHandler(authService))
 	r.GET("/login", api.LoginPageHandler)
-	r.POST("/login", api.LoginHandler)
+	r.POST("/login", api.LoginHandler(authService))
 	r.GET("/logout", api.LogoutHandler)
 	r.GET("/api-docs", api.APIDocsHandler)
 
@@ -71,41 +132,137 @@ func main() {
 	auth.Use(middleware.AuthRequired())
 	{
 		// 图片上传
-		auth.GET("/upload", api.UploadPageHandler)
+		auth.GET("/upload", api.UploadPageHandler(imageService))
 		auth.POST("/upload", api.UploadHandler(imageService))
+		auth.POST("/upload/batch", api.BatchUploadHandler(imageService))
 
 		// 图片管理
-		auth.GET("/images", api.ListImagesHandler(imageService, configService))
+		auth.GET("/images", api.ListImagesHandler(imageService, albumService, signer))
 		auth.GET("/images/:id", api.GetImageHandler(imageService))
+		auth.PATCH("/images/:id", api.UpdateImageHandler(imageService))
 		auth.DELETE("/images/:id", api.DeleteImageHandler(imageService))
+		auth.POST("/images/batch-delete", api.BatchDeleteHandler(imageService))
+		auth.POST("/images/:id/signed-url", api.SignedURLHandler(imageService, signer))
+
+		// 回收站
+		auth.GET("/trash", api.TrashPageHandler(imageService))
+		auth.DELETE("/trash", api.EmptyTrashHandler(imageService))
+		auth.GET("/trash/:id/thumb", api.TrashThumbnailHandler(imageService))
+		auth.POST("/trash/:id/restore", api.RestoreImageHandler(imageService))
+		auth.DELETE("/trash/:id", api.PurgeImageHandler(imageService))
+
+		// 相册管理
+		auth.GET("/albums", api.AlbumsPageHandler(albumService))
+		auth.GET("/albums/:id", api.AlbumPageHandler(albumService))
+		auth.POST("/albums", api.CreateAlbumHandler(albumService))
+		auth.PUT("/albums/order", api.ReorderAlbumsHandler(albumService))
+		auth.PATCH("/albums/:id", api.UpdateAlbumHandler(albumService))
+		auth.DELETE("/albums/:id", api.DeleteAlbumHandler(albumService))
+		auth.POST("/albums/:id/images", api.AddAlbumImagesHandler(albumService))
+		auth.DELETE("/albums/:id/images/:image_id", api.RemoveAlbumImageHandler(albumService))
+
+		// 分享管理
+		auth.GET("/shares", api.ListSharesHandler(shareService))
+		auth.POST("/shares", api.CreateShareHandler(shareService))
+		auth.GET("/shares/:id", api.GetShareHandler(shareService))
+		auth.PATCH("/shares/:id", api.UpdateShareHandler(shareService))
+		auth.DELETE("/shares/:id", api.DeleteShareHandler(shareService))
+
+		// API令牌管理
+		auth.GET("/tokens", api.TokensPageHandler(tokenService))
+		auth.POST("/tokens", api.CreateTokenHandler(tokenService))
+		auth.DELETE("/tokens/:id", api.RevokeTokenHandler(tokenService))
+
+		// 个人设置
+		auth.GET("/settings", api.SettingsPageHandler(settingsService))
+		auth.PATCH("/settings", api.UpdateSettingsHandler(settingsService))
+		auth.GET("/settings/watermark/logo", api.WatermarkLogoHandler(settingsService))
+		auth.PUT("/settings/watermark/logo", api.UploadWatermarkLogoHandler(settingsService))
+		auth.DELETE("/settings/watermark/logo", api.DeleteWatermarkLogoHandler(settingsService))
 	}
 
+	// tus 协议的 OPTIONS 请求用于发现服务端能力，不需要认证
+	r.OPTIONS("/api/tus", api.TusOptionsHandler(tusService))
+	r.OPTIONS("/api/tus/:id", api.TusOptionsHandler(tusService))
+
 	// API路由组
 	apiGroup := r.Group("/api")
-	apiGroup.Use(middleware.APIAuthRequired(authService))
+	apiGroup.Use(middleware.APIAuthRequired(authService, tokenService))
 	{
 		// 图片上传
 		apiGroup.POST("/upload", api.APIUploadHandler(imageService))
+		// 批量上传
+		apiGroup.POST("/upload/batch", api.APIBatchUploadHandler(imageService))
+		// 从网址导入图片
+		apiGroup.POST("/upload/url", api.APIUploadURLHandler(fetchService, imageService))
 		// 图片列表
 		apiGroup.GET("/images", api.APIListImagesHandler(imageService))
-		// 删除图片
+		// 存储空间使用量
+		apiGroup.GET("/usage", api.APIUsageHandler(imageService))
+		// 断点续传上传（tus 1.0）
+		apiGroup.POST("/tus", api.TusCreateHandler(tusService))
+		apiGroup.HEAD("/tus/:id", api.TusHeadHandler(tusService))
+		apiGroup.PATCH("/tus/:id", api.TusPatchHandler(tusService))
+		apiGroup.DELETE("/tus/:id", api.TusDeleteHandler(tusService))
+		// 修改图片设置
+		apiGroup.PATCH("/images/:id", api.APIUpdateImageHandler(imageService))
+		// 相似图片
+		apiGroup.GET("/images/:id/similar", api.APISimilarImagesHandler(imageService))
+		// 生成限时签名链接
+		apiGroup.POST("/images/:id/signed-url", api.APISignedURLHandler(imageService, signer))
+		// 删除图片（移到回收站）
 		apiGroup.DELETE("/images/:id", api.APIDeleteImageHandler(imageService))
+		// 批量删除图片
+		apiGroup.POST("/images/batch-delete", api.APIBatchDeleteHandler(imageService))
+		// 回收站
+		apiGroup.GET("/trash", api.ListTrashHandler(imageService))
+		apiGroup.DELETE("/trash", api.EmptyTrashHandler(imageService))
+		apiGroup.GET("/trash/:id/thumb", api.TrashThumbnailHandler(imageService))
+		apiGroup.POST("/trash/:id/restore", api.RestoreImageHandler(imageService))
+		apiGroup.DELETE("/trash/:id", api.PurgeImageHandler(imageService))
+		// 相册
+		apiGroup.GET("/albums", api.ListAlbumsHandler(albumService))
+		apiGroup.POST("/albums", api.CreateAlbumHandler(albumService))
+		apiGroup.PUT("/albums/order", api.ReorderAlbumsHandler(albumService))
+		apiGroup.GET("/albums/:id", api.GetAlbumHandler(albumService))
+		apiGroup.PATCH("/albums/:id", api.UpdateAlbumHandler(albumService))
+		apiGroup.DELETE("/albums/:id", api.DeleteAlbumHandler(albumService))
+		apiGroup.POST("/albums/:id/images", api.AddAlbumImagesHandler(albumService))
+		apiGroup.DELETE("/albums/:id/images/:image_id", api.RemoveAlbumImageHandler(albumService))
+		// 分享
+		apiGroup.GET("/shares", api.ListSharesHandler(shareService))
+		apiGroup.POST("/shares", api.CreateShareHandler(shareService))
+		apiGroup.GET("/shares/:id", api.GetShareHandler(shareService))
+		apiGroup.PATCH("/shares/:id", api.UpdateShareHandler(shareService))
+		apiGroup.DELETE("/shares/:id", api.DeleteShareHandler(shareService))
+		// 个人设置
+		apiGroup.GET("/settings", api.GetSettingsHandler(settingsService))
+		apiGroup.PATCH("/settings", api.UpdateSettingsHandler(settingsService))
+		apiGroup.GET("/settings/watermark/logo", api.WatermarkLogoHandler(settingsService))
+		apiGroup.PUT("/settings/watermark/logo", api.UploadWatermarkLogoHandler(settingsService))
+		apiGroup.DELETE("/settings/watermark/logo", api.DeleteWatermarkLogoHandler(settingsService))
 	}
 
 	// 公共图片访问
-	r.GET("/i/:id", api.ServeImageHandler(imageService))
+	r.GET("/i/:id", api.ServeImageHandler(imageService, signer, hotlink))
+
+	// 公共分享页面
+	r.GET("/s/:token", api.SharePageHandler(shareService))
+	r.POST("/s/:token", api.ShareUnlockHandler(shareService))
+	r.GET("/s/:token/i/:id", api.ShareImageHandler(shareService, imageService))
 
 	// 启动服务器
-	port := 28080
-	log.Printf("服务器启动在 http://localhost:%d", port)
-	r.Run(fmt.Sprintf(":%d", port))
+	log.Printf("服务器启动在 http://%s", cfg.Addr())
+	if err := r.Run(cfg.Addr()); err != nil {
+		log.Fatalf("服务器启动失败: %v", err)
+	}
 }
 
 // 初始化存储目录
-func initStorageDirs() {
+func initStorageDirs(cfg *config.Config) {
 	dirs := []string{
-		"static/uploads",
-		"static/uploads/thumbnails",
+		cfg.Upload.StoragePath,
+		cfg.Upload.Thumbnail.Path,
 	}
 
 	for _, dir := range dirs {
@@ -114,3 +271,15 @@ func initStorageDirs() {
 		}
 	}
 }
+
+// 根据实际存储重新统计所有用户的存储空间使用量
+func reconcileUsage(imageService *service.ImageService, authService *service.AuthService) {
+	for _, userID := range authService.ListUserIDs() {
+		used, err := imageService.ReconcileUsage(userID)
+		if err != nil {
+			log.Printf("统计用户 %s 的存储空间失败: %v", userID, err)
+			continue
+		}
+		log.Printf("用户 %s 已使用存储空间 %d 字节", userID, used)
+	}
+}
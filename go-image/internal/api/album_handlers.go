@@ -0,0 +1,253 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+	"go-image/internal/storage"
+)
+
+// 相册接口同时注册在会话路由组和 /api 路由组中，用户ID统一从上下文中获取
+
+// AlbumsPageHandler 显示相册列表页面
+func AlbumsPageHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+
+		albums, err := albumService.ListAlbums(userID)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "获取相册列表失败")
+			return
+		}
+
+		c.HTML(http.StatusOK, "albums.html", gin.H{
+			"title":  "我的相册 - Go-Image",
+			"albums": albums,
+		})
+	}
+}
+
+// AlbumPageHandler 显示单个相册页面
+func AlbumPageHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+
+		album, images, err := albumService.AlbumImages(userID, c.Param("id"))
+		if err != nil {
+			if errors.Is(err, storage.ErrAlbumNotFound) {
+				c.String(http.StatusNotFound, "相册不存在")
+				return
+			}
+			c.String(http.StatusInternalServerError, "获取相册失败")
+			return
+		}
+
+		c.HTML(http.StatusOK, "album.html", gin.H{
+			"title":  album.Name + " - Go-Image",
+			"album":  album,
+			"images": images,
+		})
+	}
+}
+
+// ListAlbumsHandler 列出用户的相册
+func ListAlbumsHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		albums, err := albumService.ListAlbums(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取相册列表失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, albums)
+	}
+}
+
+// CreateAlbumHandler 创建相册
+func CreateAlbumHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Name        string `json:"name" form:"name"`
+			Description string `json:"description" form:"description"`
+		}
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+			return
+		}
+
+		album, err := albumService.CreateAlbum(userID, req.Name, req.Description)
+		if err != nil {
+			albumError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, album)
+	}
+}
+
+// GetAlbumHandler 获取相册信息及其中的图片
+func GetAlbumHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		album, images, err := albumService.AlbumImages(userID, c.Param("id"))
+		if err != nil {
+			albumError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"album":  album,
+			"images": images,
+		})
+	}
+}
+
+// UpdateAlbumHandler 重命名相册、修改描述或设置封面
+func UpdateAlbumHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		var req service.AlbumUpdate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+			return
+		}
+
+		album, err := albumService.UpdateAlbum(userID, c.Param("id"), req)
+		if err != nil {
+			albumError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, album)
+	}
+}
+
+// DeleteAlbumHandler 删除相册，相册中的图片不会被删除
+func DeleteAlbumHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		if err := albumService.DeleteAlbum(userID, c.Param("id")); err != nil {
+			albumError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+	}
+}
+
+// AddAlbumImagesHandler 将图片加入相册
+func AddAlbumImagesHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			ImageIDs []string `json:"image_ids"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+			return
+		}
+
+		if err := albumService.AddImages(userID, c.Param("id"), req.ImageIDs); err != nil {
+			albumError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "添加成功"})
+	}
+}
+
+// RemoveAlbumImageHandler 将图片移出相册
+func RemoveAlbumImageHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		if err := albumService.RemoveImages(userID, c.Param("id"), []string{c.Param("image_id")}); err != nil {
+			albumError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "移除成功"})
+	}
+}
+
+// ReorderAlbumsHandler 调整相册顺序
+func ReorderAlbumsHandler(albumService *service.AlbumService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := albumUser(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			AlbumIDs []string `json:"album_ids"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+			return
+		}
+
+		albums, err := albumService.ReorderAlbums(userID, req.AlbumIDs)
+		if err != nil {
+			albumError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, albums)
+	}
+}
+
+// albumUser 获取用户ID，未认证时已经写入响应
+func albumUser(c *gin.Context) (string, bool) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return "", false
+	}
+	return userID, true
+}
+
+// albumError 将服务层错误转换为JSON响应
+func albumError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidAlbum):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrAlbumNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "相册不存在"})
+	case errors.Is(err, storage.ErrImageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("操作失败: %v", err)})
+	}
+}
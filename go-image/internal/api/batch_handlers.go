@@ -0,0 +1,207 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+	"go-image/internal/storage"
+)
+
+// BatchUploadHandler 批量上传图片
+func BatchUploadHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		session := sessions.Default(c)
+		userID := session.Get("userID").(string)
+
+		batchUpload(c, imageService, userID)
+	}
+}
+
+// APIBatchUploadHandler 批量上传图片（API）
+func APIBatchUploadHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		batchUpload(c, imageService, userID)
+	}
+}
+
+// batchUpload 保存表单中 images 字段的所有文件，返回每个文件的结果，部分文件失败时仍返回 200
+func batchUpload(c *gin.Context, imageService *service.ImageService, userID string) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要上传的图片"})
+		return
+	}
+	files := append(form.File["images"], form.File["image"]...)
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要上传的图片"})
+		return
+	}
+
+	strip, err := service.ParseStripMetadata(c.PostForm("strip_metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	watermark, err := service.ParseWatermark(c.PostForm("watermark"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 上传图片，所有文件使用相同的选项
+	results, err := imageService.UploadImages(userID, files, service.UploadOptions{
+		Visibility:    c.PostForm("visibility"),
+		Tags:          service.SplitTags(c.PostForm("tags")),
+		Description:   c.PostForm("description"),
+		StripMetadata: strip,
+		Watermark:     watermark,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrBatchTooLarge),
+			errors.Is(err, service.ErrInvalidVisibility), errors.Is(err, service.ErrInvalidMetadata):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("上传失败: %v", err)})
+		}
+		return
+	}
+
+	// 构建完整的URL
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+
+	items := make([]gin.H, 0, len(results))
+	succeeded := 0
+	for _, result := range results {
+		if result.Err != nil {
+			status, message := uploadError(imageService, result.Err)
+			items = append(items, gin.H{
+				"filename": result.Filename,
+				"status":   status,
+				"error":    message,
+			})
+			continue
+		}
+
+		succeeded++
+		item := gin.H{
+			"filename":   result.Image.Filename,
+			"id":         result.Image.ID,
+			"url":        baseURL + result.Image.PublicPath(),
+			"visibility": result.Image.Visibility,
+		}
+		if similar := imageService.UploadWarnings(result.Image); len(similar) > 0 {
+			item["similar"] = similar
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   items,
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+	})
+}
+
+// uploadError 上传单个文件失败时对应的状态码和提示
+func uploadError(imageService *service.ImageService, err error) (int, string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidVisibility), errors.Is(err, service.ErrInvalidMetadata):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, service.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("图片大小不能超过%s", formatSize(imageService.MaxSize()))
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge, "上传失败: 存储空间不足"
+	case errors.Is(err, service.ErrTooManyPixels):
+		return http.StatusRequestEntityTooLarge, err.Error()
+	case errors.Is(err, service.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType, err.Error()
+	}
+	return http.StatusInternalServerError, fmt.Sprintf("上传失败: %v", err)
+}
+
+// BatchDeleteHandler 批量删除图片
+func BatchDeleteHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		session := sessions.Default(c)
+		userID := session.Get("userID").(string)
+
+		batchDelete(c, imageService, userID)
+	}
+}
+
+// APIBatchDeleteHandler 批量删除图片（API）
+func APIBatchDeleteHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		batchDelete(c, imageService, userID)
+	}
+}
+
+// batchDelete 删除请求中列出的所有图片，返回每张图片的结果，部分图片失败时仍返回 200
+func batchDelete(c *gin.Context, imageService *service.ImageService, userID string) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要删除的图片"})
+		return
+	}
+
+	results, err := imageService.DeleteImages(userID, req.IDs)
+	if err != nil {
+		if errors.Is(err, service.ErrBatchTooLarge) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("删除失败: %v", err)})
+		return
+	}
+
+	items := make([]gin.H, 0, len(results))
+	deleted := 0
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			deleted++
+			items = append(items, gin.H{"id": result.ID, "deleted": true})
+		case errors.Is(result.Err, storage.ErrImageNotFound):
+			items = append(items, gin.H{"id": result.ID, "deleted": false, "error": "图片不存在"})
+		default:
+			items = append(items, gin.H{"id": result.ID, "deleted": false, "error": fmt.Sprintf("删除失败: %v", result.Err)})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": items,
+		"deleted": deleted,
+		"failed":  len(results) - deleted,
+	})
+}
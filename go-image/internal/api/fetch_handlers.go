@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+)
+
+// APIUploadURLHandler 从网址导入图片（API），其他表单字段与 /api/upload 相同
+func APIUploadURLHandler(fetchService *service.FetchService, imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		rawURL := c.PostForm("url")
+		if rawURL == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请填写图片地址"})
+			return
+		}
+
+		strip, err := service.ParseStripMetadata(c.PostForm("strip_metadata"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		watermark, err := service.ParseWatermark(c.PostForm("watermark"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 下载并保存图片
+		imageInfo, err := fetchService.Import(userID, rawURL, service.UploadOptions{
+			Visibility:    c.PostForm("visibility"),
+			Tags:          service.SplitTags(c.PostForm("tags")),
+			Description:   c.PostForm("description"),
+			StripMetadata: strip,
+			Watermark:     watermark,
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrInvalidURL), errors.Is(err, service.ErrAddressBlocked),
+				errors.Is(err, service.ErrInvalidVisibility), errors.Is(err, service.ErrInvalidMetadata):
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			case errors.Is(err, service.ErrFileTooLarge):
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("图片大小不能超过%s", formatSize(imageService.MaxSize()))})
+			case errors.Is(err, service.ErrQuotaExceeded):
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "上传失败: 存储空间不足"})
+			case errors.Is(err, service.ErrTooManyPixels):
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			case errors.Is(err, service.ErrUnsupportedType):
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			case errors.Is(err, service.ErrTooManyRedirect), errors.Is(err, service.ErrFetchFailed):
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("上传失败: %v", err)})
+			}
+			return
+		}
+
+		// 构建完整的URL
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+		imageURL := baseURL + imageInfo.PublicPath()
+
+		// 返回上传成功的信息，有相似的已有图片时一并列出
+		response := gin.H{
+			"id":         imageInfo.ID,
+			"filename":   imageInfo.Filename,
+			"url":        imageURL,
+			"visibility": imageInfo.Visibility,
+		}
+		if similar := imageService.UploadWarnings(imageInfo); len(similar) > 0 {
+			response["similar"] = similar
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
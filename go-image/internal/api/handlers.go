@@ -1,14 +1,18 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"go-image/internal/service"
+	"go-image/internal/storage"
 )
 
 // APIDocsHandler 处理API文档页面的请求
@@ -41,15 +45,43 @@ func APIUploadHandler(imageService *service.ImageService) gin.HandlerFunc {
 		}
 
 		// 检查文件大小
-		const maxSize = 10 * 1024 * 1024 // 10MB
-		if file.Size > maxSize {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "图片大小不能超过10MB"})
+		if maxSize := imageService.MaxSize(); file.Size > maxSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("图片大小不能超过%s", formatSize(maxSize))})
+			return
+		}
+
+		strip, err := service.ParseStripMetadata(c.PostForm("strip_metadata"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		watermark, err := service.ParseWatermark(c.PostForm("watermark"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
 		// 上传图片
-		imageInfo, err := imageService.UploadImage(userID, file)
+		imageInfo, err := imageService.UploadImage(userID, file, service.UploadOptions{
+			Visibility:    c.PostForm("visibility"),
+			Tags:          service.SplitTags(c.PostForm("tags")),
+			Description:   c.PostForm("description"),
+			StripMetadata: strip,
+			Watermark:     watermark,
+		})
 		if err != nil {
+			if errors.Is(err, service.ErrInvalidVisibility) || errors.Is(err, service.ErrInvalidMetadata) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, service.ErrQuotaExceeded) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "上传失败: 存储空间不足"})
+				return
+			}
+			if errors.Is(err, service.ErrTooManyPixels) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("上传失败: %v", err)})
 			return
 		}
@@ -60,14 +92,19 @@ func APIUploadHandler(imageService *service.ImageService) gin.HandlerFunc {
 			scheme = "https"
 		}
 		baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
-		imageURL := fmt.Sprintf("%s/i/%s", baseURL, imageInfo.ID)
-
-		// 返回上传成功的信息
-		c.JSON(http.StatusOK, gin.H{
-			"id":       imageInfo.ID,
-			"filename": imageInfo.Filename,
-			"url":      imageURL,
-		})
+		imageURL := baseURL + imageInfo.PublicPath()
+
+		// 返回上传成功的信息，有相似的已有图片时一并列出
+		response := gin.H{
+			"id":         imageInfo.ID,
+			"filename":   imageInfo.Filename,
+			"url":        imageURL,
+			"visibility": imageInfo.Visibility,
+		}
+		if similar := imageService.UploadWarnings(imageInfo); len(similar) > 0 {
+			response["similar"] = similar
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
@@ -81,17 +118,45 @@ func APIListImagesHandler(imageService *service.ImageService) gin.HandlerFunc {
 			return
 		}
 
-		images, err := imageService.ListImages(userID)
+		query, opts, err := parseListQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		list, err := imageService.ListImages(userID, query, opts)
 		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) || errors.Is(err, storage.ErrInvalidSort) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取图片列表失败"})
 			return
 		}
 
-		c.JSON(http.StatusOK, images)
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// APIUsageHandler 获取存储空间使用量（API）
+func APIUsageHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		used, limit := imageService.StorageUsage(userID)
+		c.JSON(http.StatusOK, gin.H{
+			"used":  used,
+			"limit": limit, // 0 表示不限制
+		})
 	}
 }
 
-// APIDeleteImageHandler 删除图片（API）
+// APIDeleteImageHandler 将图片移到回收站（API）
 func APIDeleteImageHandler(imageService *service.ImageService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 获取用户ID
@@ -110,7 +175,44 @@ func APIDeleteImageHandler(imageService *service.ImageService) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+		c.JSON(http.StatusOK, gin.H{"message": "已移到回收站"})
+	}
+}
+
+// APISimilarImagesHandler 列出与指定图片相似的图片（API）
+func APISimilarImagesHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		// 未指定距离时使用配置的默认值
+		distance := -1
+		if value := c.Query("distance"); value != "" {
+			var err error
+			if distance, err = strconv.Atoi(value); err != nil || distance < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrInvalidDistance.Error()})
+				return
+			}
+		}
+
+		similar, err := imageService.SimilarImages(userID, c.Param("id"), distance)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrImageNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+			case errors.Is(err, service.ErrInvalidDistance), errors.Is(err, service.ErrNoPerceptualHash):
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("查找相似图片失败: %v", err)})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"images": similar})
 	}
 }
 
@@ -140,76 +242,96 @@ func RegisterPageHandler(c *gin.Context) {
 }
 
 // RegisterHandler 处理注册请求
-func RegisterHandler(c *gin.Context) {
-	username := c.PostForm("username")
-	password := c.PostForm("password")
-
-	// 创建认证服务实例
-	authService := service.NewAuthService()
+func RegisterHandler(authService *service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.PostForm("username")
+		password := c.PostForm("password")
 
-	// 注册新用户
-	err := authService.Register(username, password)
-	if err != nil {
-		c.HTML(http.StatusBadRequest, "register.html", gin.H{
-			"title": "注册 - Go-Image",
-			"error": err.Error(),
-		})
-		return
-	}
+		// 注册新用户
+		err := authService.Register(username, password)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "register.html", gin.H{
+				"title": "注册 - Go-Image",
+				"error": err.Error(),
+			})
+			return
+		}
 
-	// 注册成功后自动登录
-	user, _ := authService.Authenticate(username, password)
-	session := sessions.Default(c)
-	session.Set("user", user.Username)
-	session.Set("userID", user.ID)
-	session.Save()
+		// 注册成功后自动登录
+		user, _ := authService.Authenticate(username, password)
+		session := sessions.Default(c)
+		session.Set("user", user.Username)
+		session.Set("userID", user.ID)
+		session.Save()
 
-	// 重定向到首页
-	c.Redirect(http.StatusFound, "/")
+		// 重定向到首页
+		c.Redirect(http.StatusFound, "/")
+	}
 }
 
 // LoginHandler 处理登录请求
-func LoginHandler(c *gin.Context) {
-	username := c.PostForm("username")
-	password := c.PostForm("password")
-
-	// 创建认证服务实例
-	authService := service.NewAuthService()
+func LoginHandler(authService *service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.PostForm("username")
+		password := c.PostForm("password")
 
-	// 验证用户凭据
-	user, err := authService.Authenticate(username, password)
-	if err != nil {
-		c.HTML(http.StatusUnauthorized, "login.html", gin.H{
-			"title": "登录 - Go-Image",
-			"error": "用户名或密码不正确",
-		})
-		return
-	}
+		// 验证用户凭据
+		user, err := authService.Authenticate(username, password)
+		if err != nil {
+			c.HTML(http.StatusUnauthorized, "login.html", gin.H{
+				"title": "登录 - Go-Image",
+				"error": "用户名或密码不正确",
+			})
+			return
+		}
 
-	// 设置会话
-	session := sessions.Default(c)
-	session.Set("user", user.Username)
-	session.Set("userID", user.ID)
-	session.Save()
+		// 设置会话
+		session := sessions.Default(c)
+		session.Set("user", user.Username)
+		session.Set("userID", user.ID)
+		session.Save()
 
-	// 重定向到首页
-	c.Redirect(http.StatusFound, "/")
+		// 重定向到首页
+		c.Redirect(http.StatusFound, "/")
+	}
 }
 
 // LogoutHandler 处理登出请求
 func LogoutHandler(c *gin.Context) {
+	// 清除会话中的所有数据，包括用户ID和访问过的分享
 	session := sessions.Default(c)
-	session.Delete("user")
+	session.Clear()
 	session.Save()
 
 	c.Redirect(http.StatusFound, "/login")
 }
 
+// sessionViewerID 会话中已登录用户的ID，未登录时为空
+//
+// 与 AuthRequired 一样以 "user" 判断是否登录，避免只残留 "userID" 的会话被当作已登录。
+func sessionViewerID(c *gin.Context) string {
+	session := sessions.Default(c)
+	if session.Get("user") == nil {
+		return ""
+	}
+	viewerID, _ := session.Get("userID").(string)
+	return viewerID
+}
+
 // UploadPageHandler 显示上传页面
-func UploadPageHandler(c *gin.Context) {
-	c.HTML(http.StatusOK, "upload.html", gin.H{
-		"title": "上传图片 - Go-Image",
-	})
+func UploadPageHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.HTML(http.StatusOK, "upload.html", gin.H{
+			"title":        "上传图片 - Go-Image",
+			"maxSize":      imageService.MaxSize(),
+			"maxBatch":     service.MaxBatchSize,
+			"allowedTypes": strings.Join(imageService.AllowedTypes(), ","),
+
+			"defaultVisibility": imageService.DefaultVisibility(),
+			"stripMetadata":     imageService.StripMetadataDefault(c.GetString("userID")),
+			"watermark":         imageService.WatermarkDefault(c.GetString("userID")),
+		})
+	}
 }
 
 // UploadHandler 处理图片上传
@@ -227,15 +349,43 @@ func UploadHandler(imageService *service.ImageService) gin.HandlerFunc {
 		}
 
 		// 检查文件大小
-		const maxSize = 10 * 1024 * 1024 // 10MB
-		if file.Size > maxSize {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "图片大小不能超过10MB"})
+		if maxSize := imageService.MaxSize(); file.Size > maxSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("图片大小不能超过%s", formatSize(maxSize))})
+			return
+		}
+
+		strip, err := service.ParseStripMetadata(c.PostForm("strip_metadata"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		watermark, err := service.ParseWatermark(c.PostForm("watermark"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
 		// 上传图片
-		imageInfo, err := imageService.UploadImage(userID, file)
+		imageInfo, err := imageService.UploadImage(userID, file, service.UploadOptions{
+			Visibility:    c.PostForm("visibility"),
+			Tags:          service.SplitTags(c.PostForm("tags")),
+			Description:   c.PostForm("description"),
+			StripMetadata: strip,
+			Watermark:     watermark,
+		})
 		if err != nil {
+			if errors.Is(err, service.ErrInvalidVisibility) || errors.Is(err, service.ErrInvalidMetadata) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, service.ErrQuotaExceeded) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "上传失败: 存储空间不足"})
+				return
+			}
+			if errors.Is(err, service.ErrTooManyPixels) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("上传失败: %v", err)})
 			return
 		}
@@ -246,41 +396,81 @@ func UploadHandler(imageService *service.ImageService) gin.HandlerFunc {
 			scheme = "https"
 		}
 		baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
-		imageURL := fmt.Sprintf("%s/i/%s", baseURL, imageInfo.ID)
-
-		// 返回上传成功的信息
-		c.JSON(http.StatusOK, gin.H{
-			"message":  "上传成功",
-			"id":       imageInfo.ID,
-			"filename": imageInfo.Filename,
-			"url":      imageURL,
-		})
+		imageURL := baseURL + imageInfo.PublicPath()
+
+		// 返回上传成功的信息，有相似的已有图片时一并列出
+		response := gin.H{
+			"message":    "上传成功",
+			"id":         imageInfo.ID,
+			"filename":   imageInfo.Filename,
+			"url":        imageURL,
+			"visibility": imageInfo.Visibility,
+		}
+		if similar := imageService.UploadWarnings(imageInfo); len(similar) > 0 {
+			response["similar"] = similar
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
 // ListImagesHandler 列出所有图片
-func ListImagesHandler(imageService *service.ImageService, configService *service.ConfigService) gin.HandlerFunc {
+func ListImagesHandler(imageService *service.ImageService, albumService *service.AlbumService, signer *service.URLSigner) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 获取用户ID
 		session := sessions.Default(c)
 		userID := session.Get("userID").(string)
 
-		images, err := imageService.ListImages(userID)
+		query, opts, err := parseListQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		list, err := imageService.ListImages(userID, query, opts)
 		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) || errors.Is(err, storage.ErrInvalidSort) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取图片列表失败"})
 			return
 		}
 
-		// 获取存储空间信息
-		config := configService.GetConfig()
-		usedStorage := float64(config.CurrentStorage) / float64(1024*1024*1024) // 转换为GB
-		totalStorage := float64(config.StorageLimit) / float64(1024*1024*1024)  // 转换为GB
+		// 下一页的链接保留当前的搜索和排序条件
+		nextURL := ""
+		if list.NextCursor != "" {
+			params := c.Request.URL.Query()
+			params.Set("cursor", list.NextCursor)
+			nextURL = "/images?" + params.Encode()
+		}
+
+		// 用于“加入相册”的相册列表
+		albums, err := albumService.ListAlbums(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取相册列表失败"})
+			return
+		}
+
+		// 获取存储空间信息（字节），由页面脚本格式化显示
+		usedStorage, totalStorage := imageService.StorageUsage(userID)
 
 		c.HTML(http.StatusOK, "images.html", gin.H{
 			"title":        "我的图片 - Go-Image",
-			"images":       images,
-			"usedStorage":  fmt.Sprintf("%.2f GB", usedStorage),
-			"totalStorage": fmt.Sprintf("%.2f GB", totalStorage),
+			"images":       list.Images,
+			"total":        list.Total,
+			"nextURL":      nextURL,
+			"paged":        opts.Cursor != "",
+			"albums":       albums,
+			"signing":      signer.Enabled(),
+			"usedStorage":  usedStorage,
+			"totalStorage": totalStorage,
+			"searching":    !query.IsZero() || !opts.From.IsZero() || !opts.To.IsZero() || len(opts.MimeTypes) > 0,
+			"query":        c.Query("q"),
+			"from":         c.Query("from"),
+			"to":           c.Query("to"),
+			"sort":         c.DefaultQuery("sort", storage.SortUploadedAt) + ":" + c.DefaultQuery("order", "desc"),
+			"type":         c.Query("type"),
+			"trashDays":    trashDays(imageService),
 		})
 	}
 }
@@ -304,7 +494,7 @@ func GetImageHandler(imageService *service.ImageService) gin.HandlerFunc {
 	}
 }
 
-// DeleteImageHandler 删除图片
+// DeleteImageHandler 将图片移到回收站
 func DeleteImageHandler(imageService *service.ImageService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 获取用户ID
@@ -319,40 +509,387 @@ func DeleteImageHandler(imageService *service.ImageService) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+		c.JSON(http.StatusOK, gin.H{"message": "已移到回收站"})
 	}
 }
 
-// ServeImageHandler 提供图片访问
-func ServeImageHandler(imageService *service.ImageService) gin.HandlerFunc {
+// UpdateImageHandler 修改图片设置
+func UpdateImageHandler(imageService *service.ImageService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 获取用户ID
 		session := sessions.Default(c)
 		userID := session.Get("userID").(string)
 
-		id := c.Param("id")
+		updateImage(c, imageService, userID)
+	}
+}
 
-		image, err := imageService.GetImage(userID, id)
-		if err != nil {
+// APIUpdateImageHandler 修改图片设置（API）
+func APIUpdateImageHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		updateImage(c, imageService, userID)
+	}
+}
+
+// updateImage 按请求修改图片设置并返回修改后的图片信息
+func updateImage(c *gin.Context, imageService *service.ImageService, userID string) {
+	var req service.ImageUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	image, err := imageService.UpdateImage(userID, c.Param("id"), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidVisibility), errors.Is(err, service.ErrInvalidMetadata):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("修改失败: %v", err)})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, image)
+}
+
+// ServeImageHandler 提供图片访问，匿名访问者只能查看公开的图片
+func ServeImageHandler(imageService *service.ImageService, signer *service.URLSigner, hotlink *service.HotlinkProtector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取访问者的用户ID，未登录时为空
+		viewerID := sessionViewerID(c)
+
+		// 带签名的链接先验证签名，验证通过后不再检查可见性
+		var image *storage.ImageInfo
+		var err error
+		if query := c.Request.URL.Query(); service.Signed(query) {
+			expiresAt, err := signer.Verify(c.Param("id"), query, c.ClientIP())
+			if err != nil {
+				if errors.Is(err, service.ErrSignatureExpired) {
+					c.String(http.StatusGone, err.Error())
+					return
+				}
+				c.String(http.StatusForbidden, err.Error())
+				return
+			}
+			if image, err = imageService.SignedImage(c.Param("id")); err != nil {
+				c.String(http.StatusNotFound, "图片不存在")
+				return
+			}
+			// 共享缓存不能缓存签名链接，浏览器最多缓存到链接过期
+			c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(time.Until(expiresAt).Seconds())))
+		} else if image, err = imageService.PublicImage(c.Param("id"), viewerID); err != nil {
 			c.String(http.StatusNotFound, "图片不存在")
 			return
+		} else if viewerID != image.UserID && !checkHotlink(c, hotlink, image) {
+			return
 		}
 
-		// 构建文件路径
-		filePath := filepath.Join("./static/uploads", image.Path)
+		serveImage(c, imageService, image, viewerID)
+	}
+}
+
+// checkHotlink 按上传者的防盗链设置检查请求来源，不允许访问时按设置拦截并返回 false
+//
+// 签名链接和分享页面已经有各自的访问控制，不检查来源。
+func checkHotlink(c *gin.Context, hotlink *service.HotlinkProtector, image *storage.ImageInfo) bool {
+	result := hotlink.Check(image.UserID, c.GetHeader("Referer"), c.GetHeader("Origin"), c.Request.Host)
+	if !result.Protected {
+		return true
+	}
+
+	// 同一个链接按来源返回不同的内容，共享缓存需要区分来源
+	c.Writer.Header().Add("Vary", "Referer")
+	c.Writer.Header().Add("Vary", "Origin")
+	if result.Allowed {
+		return true
+	}
+
+	c.Header("Cache-Control", "no-store")
+	switch result.Action {
+	case service.HotlinkActionPlaceholder:
+		data, contentType := hotlink.Placeholder()
+		c.Data(http.StatusForbidden, contentType, data)
+	case service.HotlinkActionRedirect:
+		c.Redirect(http.StatusFound, result.RedirectURL)
+	default:
+		c.String(http.StatusForbidden, "禁止盗链")
+	}
+	return false
+}
+
+// serveImage 按查询参数返回原图、缩略图或变换版本，调用前已经检查过访问权限
+func serveImage(c *gin.Context, imageService *service.ImageService, image *storage.ImageInfo, viewerID string) {
+	// 带变换参数时返回缩放/裁剪/转码后的版本
+	opts, err := parseTransformOptions(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	thumb := c.Query("thumb") == "1"
+	if image.Frames <= 1 {
+		// 静态图片本身就是第一帧
+		opts.Poster = false
+	}
 
-		// 检查是否请求缩略图
-		if c.Query("thumb") == "1" && image.ThumbPath != "" {
-			filePath = filepath.Join("./static/uploads/thumbnails", image.ThumbPath)
+	// 没有指定输出格式时，按 Accept 请求头为支持 WebP 的浏览器返回 WebP 版本的变换和缩略图，原图始终原样返回
+	toWebP := false
+	if opts.Format == "" && (!opts.IsZero() || thumb) && imageService.WebPNegotiable(image) {
+		c.Writer.Header().Add("Vary", "Accept")
+		toWebP = acceptsWebP(c.GetHeader("Accept"))
+	}
+
+	// 上传者设置了访问时添加水印的图片，匿名访问者看到的是添加水印后的版本
+	watermark := false
+	if imageService.WatermarkOnServe(image) {
+		c.Writer.Header().Add("Vary", "Cookie")
+		watermark = viewerID == ""
+	}
+	hasThumb := thumb && image.ThumbPath != ""
+	cacheControl := imageService.CacheControl(image, viewerID)
+
+	if !opts.IsZero() || (watermark && !hasThumb) {
+		if toWebP {
+			opts.Format = "webp"
+		}
+		opts.Watermark = watermark
+		variant, mimeType, err := imageService.OpenVariant(image, opts)
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidTransform) {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			c.String(http.StatusInternalServerError, "图片处理失败")
+			return
 		}
+		defer variant.Close()
 
-		// 检查文件是否存在
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			c.String(http.StatusNotFound, "图片文件不存在")
+		serveContent(c, image, variant, mimeType, imageService.VariantETag(image, variant), cacheControl)
+		return
+	}
+
+	if hasThumb && (toWebP || watermark) {
+		format := ""
+		if toWebP {
+			format = "webp"
+		}
+		variant, mimeType, err := imageService.OpenThumbnailAs(image, format, watermark)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "图片处理失败")
 			return
 		}
+		defer variant.Close()
+
+		serveContent(c, image, variant, mimeType, imageService.VariantETag(image, variant), cacheControl)
+		return
+	}
+
+	// 打开图片文件（如果请求缩略图且存在，则打开缩略图）
+	file, err := imageService.OpenImage(image, thumb)
+	if err != nil {
+		c.String(http.StatusNotFound, "图片文件不存在")
+		return
+	}
+	defer file.Close()
+
+	serveContent(c, image, file, image.MimeType, imageService.ETag(image, hasThumb), cacheControl)
+}
+
+// serveContent 返回图片内容，Last-Modified 为上传时间
+//
+// 内容支持 Seek 时（本地文件、对象存储和缓存的变换版本）交给 http.ServeContent，
+// 由它处理 If-None-Match、If-Modified-Since 等条件请求（返回 304）以及 Range 请求。
+// cacheControl 只在之前没有设置 Cache-Control 时使用，签名链接和分享页面有各自的缓存策略。
+func serveContent(c *gin.Context, image *storage.ImageInfo, content io.Reader, mimeType, etag, cacheControl string) {
+	header := c.Writer.Header()
+	if header.Get("Cache-Control") == "" && cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		header.Set("Content-Type", mimeType)
+		http.ServeContent(c.Writer, c.Request, "", image.UploadedAt, seeker)
+		return
+	}
+	c.DataFromReader(http.StatusOK, -1, mimeType, content, nil)
+}
+
+// acceptsWebP Accept 请求头是否接受 WebP，显式指定 q=0 的类型视为不接受
+func acceptsWebP(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "image/webp") {
+			continue
+		}
+		for _, param := range params[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// parseTransformOptions 解析 /i/:id 上的图片变换参数（w、h、fit、q、fmt、poster）
+func parseTransformOptions(c *gin.Context) (service.TransformOptions, error) {
+	var opts service.TransformOptions
+	var err error
+
+	if opts.Width, err = queryInt(c, "w"); err != nil {
+		return opts, errors.New("参数 w 必须是整数")
+	}
+	if opts.Height, err = queryInt(c, "h"); err != nil {
+		return opts, errors.New("参数 h 必须是整数")
+	}
+	if opts.Quality, err = queryInt(c, "q"); err != nil {
+		return opts, errors.New("参数 q 必须是整数")
+	}
+	opts.Fit = c.Query("fit")
+	opts.Format = strings.ToLower(c.Query("fmt"))
+	opts.Poster = c.Query("poster") == "1"
+
+	return opts, nil
+}
+
+// 图片列表每页的默认数量和最大数量
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// parseListQuery 解析图片列表的搜索、过滤、排序和分页参数
+//
+// 搜索：q 中形如 "tag:风景" 的词按标签过滤；tag 可以重复出现，也可以用逗号分隔多个标签。
+// 过滤：type 为MIME类型或扩展名（png、jpg 等），from 和 to 可以是日期（2006-01-02，to 包含当天）或 RFC3339 时间。
+// 排序：sort 为 uploaded_at、size 或 filename，order 为 asc 或 desc，默认按上传时间倒序。
+// 分页：limit 为每页数量，cursor 为上一页返回的 next_cursor。
+func parseListQuery(c *gin.Context) (service.SearchQuery, storage.ListOptions, error) {
+	var query service.SearchQuery
+	var opts storage.ListOptions
+
+	var words []string
+	for _, word := range strings.Fields(c.Query("q")) {
+		if tag := strings.TrimPrefix(word, "tag:"); tag != word {
+			query.Tags = append(query.Tags, splitQueryList(tag)...)
+			continue
+		}
+		words = append(words, word)
+	}
+	query.Text = strings.Join(words, " ")
+	for _, value := range c.QueryArray("tag") {
+		query.Tags = append(query.Tags, splitQueryList(value)...)
+	}
+
+	for _, value := range c.QueryArray("type") {
+		for _, mimeType := range splitQueryList(value) {
+			opts.MimeTypes = append(opts.MimeTypes, normalizeMimeType(mimeType))
+		}
+	}
+
+	var err error
+	if opts.From, err = parseSearchTime(c.Query("from"), false); err != nil {
+		return query, opts, errors.New("参数 from 必须是日期（2006-01-02）或 RFC3339 时间")
+	}
+	if opts.To, err = parseSearchTime(c.Query("to"), true); err != nil {
+		return query, opts, errors.New("参数 to 必须是日期（2006-01-02）或 RFC3339 时间")
+	}
+
+	opts.SortBy = c.DefaultQuery("sort", storage.SortUploadedAt)
+	if !storage.IsValidSort(opts.SortBy) {
+		return query, opts, storage.ErrInvalidSort
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return query, opts, errors.New("参数 order 必须是 asc 或 desc")
+	}
+
+	opts.Limit = defaultPageSize
+	if c.Query("limit") != "" {
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, opts, fmt.Errorf("参数 limit 必须是 1 到 %d 之间的整数", maxPageSize)
+		}
+		opts.Limit = limit
+	}
+	opts.Cursor = c.Query("cursor")
+
+	return query, opts, nil
+}
+
+// splitQueryList 拆分逗号分隔的查询参数，忽略空项
+func splitQueryList(value string) []string {
+	var items []string
+	for _, item := range service.SplitTags(value) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// normalizeMimeType 将 png、jpg 等扩展名转换为MIME类型
+func normalizeMimeType(value string) string {
+	value = strings.ToLower(value)
+	if strings.Contains(value, "/") {
+		return value
+	}
+	if value == "jpg" {
+		value = "jpeg"
+	}
+	return "image/" + value
+}
+
+// parseSearchTime 解析搜索的时间参数，endOfDay 为 true 时日期表示当天结束
+func parseSearchTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// queryInt 读取整数查询参数，参数不存在时返回 0
+func queryInt(c *gin.Context, key string) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
 
-		// 提供文件
-		c.File(filePath)
+// formatSize 将字节数格式化为便于阅读的大小
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
 	}
+	// 最多保留两位小数，并去掉多余的 0
+	text := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", value), "0"), ".")
+	return text + units[unit]
 }
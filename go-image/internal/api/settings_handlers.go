@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+)
+
+// 设置接口同时注册在会话路由组和 /api 路由组中，用户ID统一从上下文中获取
+
+// SettingsPageHandler 显示个人设置页面
+func SettingsPageHandler(settingsService *service.SettingsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+
+		c.HTML(http.StatusOK, "settings.html", gin.H{
+			"title":    "个人设置 - Go-Image",
+			"settings": settingsService.Get(userID),
+		})
+	}
+}
+
+// GetSettingsHandler 获取个人设置
+func GetSettingsHandler(settingsService *service.SettingsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settingsService.Get(userID))
+	}
+}
+
+// UpdateSettingsHandler 修改个人设置，请求中没有出现的设置项保持不变
+func UpdateSettingsHandler(settingsService *service.SettingsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		settings := settingsService.Get(userID)
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+			return
+		}
+
+		if err := settingsService.Update(userID, settings); err != nil {
+			if errors.Is(err, service.ErrInvalidSettings) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存设置失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settingsService.Get(userID))
+	}
+}
+
+// WatermarkLogoHandler 获取已上传的水印 Logo
+func WatermarkLogoHandler(settingsService *service.SettingsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		path := settingsService.WatermarkLogoPath(userID)
+		if _, err := os.Stat(path); err != nil || settingsService.Get(userID).Watermark.Logo == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有上传水印 Logo"})
+			return
+		}
+		c.Header("Cache-Control", "private, no-cache")
+		c.File(path)
+	}
+}
+
+// UploadWatermarkLogoHandler 上传水印 Logo（表单字段 logo，PNG 图片）
+func UploadWatermarkLogoHandler(settingsService *service.SettingsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		file, err := c.FormFile("logo")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要上传的 Logo"})
+			return
+		}
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取文件失败"})
+			return
+		}
+		defer src.Close()
+		// 多读一个字节，超过大小限制时由设置服务拒绝
+		data, err := io.ReadAll(io.LimitReader(src, service.MaxWatermarkLogoSize+1))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取文件失败"})
+			return
+		}
+
+		settings, err := settingsService.SetWatermarkLogo(userID, data)
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidSettings) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存 Logo 失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// DeleteWatermarkLogoHandler 删除水印 Logo
+func DeleteWatermarkLogoHandler(settingsService *service.SettingsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		settings, err := settingsService.RemoveWatermarkLogo(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除 Logo 失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
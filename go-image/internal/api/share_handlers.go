@@ -0,0 +1,371 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+	"go-image/internal/storage"
+)
+
+// 分享管理接口同时注册在会话路由组和 /api 路由组中，用户ID统一从上下文中获取
+
+// SharePageHandler 显示分享页面：单张图片或图片列表，需要密码时显示密码输入框
+func SharePageHandler(shareService *service.ShareService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		share, ok := lookupShare(c, shareService)
+		if !ok {
+			return
+		}
+
+		if !shareGranted(c, share) {
+			if share.Status() == service.ShareStatusExhausted {
+				renderShareError(c, http.StatusGone, service.ErrShareExhausted)
+				return
+			}
+			if share.HasPassword() {
+				c.HTML(http.StatusOK, "share.html", gin.H{
+					"title":    "需要密码 - Go-Image",
+					"share":    share,
+					"password": true,
+				})
+				return
+			}
+			if !recordShareView(c, shareService, share) {
+				return
+			}
+		}
+
+		renderShare(c, shareService, share)
+	}
+}
+
+// ShareUnlockHandler 校验分享的访问密码
+func ShareUnlockHandler(shareService *service.ShareService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		share, ok := lookupShare(c, shareService)
+		if !ok {
+			return
+		}
+
+		if !shareGranted(c, share) {
+			if err := shareService.CheckPassword(share, c.PostForm("password")); err != nil {
+				c.HTML(http.StatusUnauthorized, "share.html", gin.H{
+					"title":    "需要密码 - Go-Image",
+					"share":    share,
+					"password": true,
+					"error":    err.Error(),
+				})
+				return
+			}
+			if !recordShareView(c, shareService, share) {
+				return
+			}
+		}
+
+		c.Redirect(http.StatusSeeOther, "/s/"+share.Token)
+	}
+}
+
+// ShareImageHandler 提供分享中的图片，查询参数与 /i/:id 相同
+func ShareImageHandler(shareService *service.ShareService, imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		share, err := shareService.Lookup(c.Param("token"))
+		if err != nil {
+			if errors.Is(err, service.ErrShareExpired) {
+				c.String(http.StatusGone, err.Error())
+				return
+			}
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		// 只有打开过分享页面（输入过密码）的访问者可以查看其中的图片
+		if !shareGranted(c, share) {
+			c.String(http.StatusForbidden, "请先打开分享页面")
+			return
+		}
+
+		image, err := shareService.ShareImage(share, c.Param("id"))
+		if err != nil {
+			c.String(http.StatusNotFound, "图片不存在")
+			return
+		}
+
+		c.Header("Cache-Control", "private")
+		serveImage(c, imageService, image, sessionViewerID(c))
+	}
+}
+
+// lookupShare 按链接中的 token 获取分享，分享不存在或已过期时显示错误页面
+func lookupShare(c *gin.Context, shareService *service.ShareService) (*service.Share, bool) {
+	share, err := shareService.Lookup(c.Param("token"))
+	switch {
+	case err == nil:
+		return share, true
+	case errors.Is(err, service.ErrShareExpired):
+		renderShareError(c, http.StatusGone, err)
+	default:
+		renderShareError(c, http.StatusNotFound, service.ErrShareNotFound)
+	}
+	return nil, false
+}
+
+// shareGrantsKey 会话中记录打开过的分享使用的键
+//
+// 值为 "<分享ID>:<访问凭证>" 用逗号连接，最近打开的分享在最后。
+const shareGrantsKey = "shares"
+
+// maxShareGrants 会话中最多记住的分享数，超过时忘记最早打开的分享，
+// 避免 Cookie 超过浏览器的大小限制导致会话无法保存
+const maxShareGrants = 20
+
+// shareGrant 会话中记录的一个分享
+func shareGrant(share *service.Share) string {
+	return share.ID + ":" + share.AccessKey()
+}
+
+// shareGrants 会话中记录的所有分享
+func shareGrants(session sessions.Session) []string {
+	value, _ := session.Get(shareGrantsKey).(string)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// shareGranted 访问者是否可以查看分享的内容：上传者本人，或者在当前会话中打开过分享
+func shareGranted(c *gin.Context, share *service.Share) bool {
+	if sessionViewerID(c) == share.UserID {
+		return true
+	}
+	grant := shareGrant(share)
+	for _, g := range shareGrants(sessions.Default(c)) {
+		if g == grant {
+			return true
+		}
+	}
+	return false
+}
+
+// recordShareView 记录一次访问并在会话中记住，之后同一个浏览器再次打开不再计数
+func recordShareView(c *gin.Context, shareService *service.ShareService, share *service.Share) bool {
+	if _, err := shareService.RecordView(share.Token); err != nil {
+		switch {
+		case errors.Is(err, service.ErrShareExpired), errors.Is(err, service.ErrShareExhausted):
+			renderShareError(c, http.StatusGone, err)
+		default:
+			renderShareError(c, http.StatusNotFound, service.ErrShareNotFound)
+		}
+		return false
+	}
+
+	// 同一个分享只保留最新的访问凭证，并移到最后
+	session := sessions.Default(c)
+	grants := []string{}
+	for _, g := range shareGrants(session) {
+		if !strings.HasPrefix(g, share.ID+":") {
+			grants = append(grants, g)
+		}
+	}
+	grants = append(grants, shareGrant(share))
+	if len(grants) > maxShareGrants {
+		grants = grants[len(grants)-maxShareGrants:]
+	}
+	session.Set(shareGrantsKey, strings.Join(grants, ","))
+	if err := session.Save(); err != nil {
+		renderShareError(c, http.StatusInternalServerError, fmt.Errorf("保存会话失败: %w", err))
+		return false
+	}
+	return true
+}
+
+// renderShare 显示分享的图片
+func renderShare(c *gin.Context, shareService *service.ShareService, share *service.Share) {
+	images, err := shareService.ShareImages(share)
+	if err != nil {
+		renderShareError(c, http.StatusInternalServerError, errors.New("获取分享的图片失败"))
+		return
+	}
+
+	title := share.Title
+	if title == "" {
+		title = "分享的图片"
+	}
+	c.HTML(http.StatusOK, "share.html", gin.H{
+		"title":  title + " - Go-Image",
+		"share":  share,
+		"name":   title,
+		"images": images,
+		"single": share.Type == service.ShareTypeImages && len(images) == 1,
+	})
+}
+
+// renderShareError 显示分享无法访问的原因
+func renderShareError(c *gin.Context, status int, err error) {
+	c.HTML(status, "share.html", gin.H{
+		"title": "分享无法访问 - Go-Image",
+		"error": err.Error(),
+	})
+}
+
+// ListSharesHandler 列出用户的分享
+func ListSharesHandler(shareService *service.ShareService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := shareUser(c)
+		if !ok {
+			return
+		}
+
+		shares := shareService.ListShares(userID)
+		result := make([]gin.H, 0, len(shares))
+		for _, share := range shares {
+			result = append(result, shareResponse(c, share))
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// CreateShareHandler 为图片或相册创建分享
+func CreateShareHandler(shareService *service.ShareService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := shareUser(c)
+		if !ok {
+			return
+		}
+
+		var req service.ShareRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+			return
+		}
+
+		share, err := shareService.CreateShare(userID, req)
+		if err != nil {
+			shareError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, shareResponse(c, share))
+	}
+}
+
+// GetShareHandler 获取分享的详细信息
+func GetShareHandler(shareService *service.ShareService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := shareUser(c)
+		if !ok {
+			return
+		}
+
+		share, err := shareService.GetShare(userID, c.Param("id"))
+		if err != nil {
+			shareError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, shareResponse(c, share))
+	}
+}
+
+// UpdateShareHandler 修改分享的标题、密码、有效期和访问次数上限
+func UpdateShareHandler(shareService *service.ShareService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := shareUser(c)
+		if !ok {
+			return
+		}
+
+		var req service.ShareUpdate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+			return
+		}
+
+		share, err := shareService.UpdateShare(userID, c.Param("id"), req)
+		if err != nil {
+			shareError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, shareResponse(c, share))
+	}
+}
+
+// DeleteShareHandler 撤销分享
+func DeleteShareHandler(shareService *service.ShareService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := shareUser(c)
+		if !ok {
+			return
+		}
+
+		if err := shareService.DeleteShare(userID, c.Param("id")); err != nil {
+			shareError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已撤销分享"})
+	}
+}
+
+// shareResponse 分享的 JSON 表示，不包含密码哈希
+func shareResponse(c *gin.Context, share *service.Share) gin.H {
+	// 构建完整的URL
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+
+	response := gin.H{
+		"id":           share.ID,
+		"token":        share.Token,
+		"url":          baseURL + "/s/" + share.Token,
+		"type":         share.Type,
+		"title":        share.Title,
+		"has_password": share.HasPassword(),
+		"views":        share.Views,
+		"max_views":    share.MaxViews,
+		"status":       share.Status(),
+		"created_at":   share.CreatedAt,
+	}
+	if share.Type == service.ShareTypeAlbum {
+		response["album_id"] = share.AlbumID
+	} else {
+		response["image_ids"] = share.ImageIDs
+	}
+	if share.ExpiresAt != nil {
+		response["expires_at"] = share.ExpiresAt
+	}
+	if share.LastViewedAt != nil {
+		response["last_viewed_at"] = share.LastViewedAt
+	}
+	return response
+}
+
+// shareUser 从上下文中获取用户ID，未登录时返回 401
+func shareUser(c *gin.Context) (string, bool) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return "", false
+	}
+	return userID, true
+}
+
+// shareError 将服务层错误转换为JSON响应
+func shareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidShare):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrShareNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "分享不存在"})
+	case errors.Is(err, storage.ErrImageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+	case errors.Is(err, storage.ErrAlbumNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "相册不存在"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("操作失败: %v", err)})
+	}
+}
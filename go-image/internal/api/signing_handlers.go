@@ -0,0 +1,169 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+	"go-image/internal/storage"
+)
+
+// signedURLRequest 生成限时链接的请求，变换参数与 /i/:id 的查询参数相同
+type signedURLRequest struct {
+	// ExpiresIn 有效期（秒），为 0 时使用默认有效期
+	ExpiresIn int64  `json:"expires_in"`
+	Width     int    `json:"w"`
+	Height    int    `json:"h"`
+	Fit       string `json:"fit"`
+	Quality   int    `json:"q"`
+	Format    string `json:"fmt"`
+	Thumb     bool   `json:"thumb"`
+	Poster    bool   `json:"poster"`
+	// BindIP 只允许从生成链接时的 IP 访问
+	BindIP bool `json:"bind_ip"`
+	// IP 只允许从指定的 IP 访问，用于为其他客户端生成链接
+	IP string `json:"ip"`
+}
+
+// SignedURLHandler 为图片生成限时链接
+func SignedURLHandler(imageService *service.ImageService, signer *service.URLSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		session := sessions.Default(c)
+		userID := session.Get("userID").(string)
+
+		signedURL(c, imageService, signer, userID)
+	}
+}
+
+// APISignedURLHandler 为图片生成限时链接（API）
+func APISignedURLHandler(imageService *service.ImageService, signer *service.URLSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		userID := c.GetString("userID")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+			return
+		}
+
+		signedURL(c, imageService, signer, userID)
+	}
+}
+
+// signedURL 按请求为用户自己的图片生成签名链接
+func signedURL(c *gin.Context, imageService *service.ImageService, signer *service.URLSigner, userID string) {
+	if !signer.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": service.ErrSigningDisabled.Error()})
+		return
+	}
+
+	var req signedURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	expiry, err := signer.Expiry(time.Duration(req.ExpiresIn) * time.Second)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ip := strings.TrimSpace(req.IP)
+	switch {
+	case ip != "":
+		if net.ParseIP(ip) == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("无效的 IP 地址 %q", ip)})
+			return
+		}
+	case req.BindIP:
+		ip = c.ClientIP()
+	}
+
+	// 只能为自己的图片生成链接
+	image, err := imageService.GetImage(userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+		return
+	}
+
+	params, err := signedParams(imageService, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresAt := time.Now().Add(expiry)
+	query, err := signer.Sign(image.ID, params, expiresAt, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("生成链接失败: %v", err)})
+		return
+	}
+
+	// 构建完整的URL
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+
+	response := gin.H{
+		"url":        baseURL + "/i/" + image.ID + "?" + query.Encode(),
+		"expires_at": time.Unix(expiresAt.Unix(), 0),
+	}
+	if ip != "" {
+		response["ip"] = ip
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// signedParams 校验请求中的变换参数并转换为 /i/:id 的查询参数
+func signedParams(imageService *service.ImageService, req signedURLRequest) (url.Values, error) {
+	opts := service.TransformOptions{
+		Width:   req.Width,
+		Height:  req.Height,
+		Fit:     req.Fit,
+		Quality: req.Quality,
+		Format:  strings.ToLower(req.Format),
+		Poster:  req.Poster,
+	}
+	if err := imageService.ValidateTransform(opts); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	if opts.Width != 0 {
+		params.Set("w", strconv.Itoa(opts.Width))
+	}
+	if opts.Height != 0 {
+		params.Set("h", strconv.Itoa(opts.Height))
+	}
+	if opts.Fit != "" {
+		params.Set("fit", opts.Fit)
+	}
+	if opts.Quality != 0 {
+		params.Set("q", strconv.Itoa(opts.Quality))
+	}
+	if opts.Format != "" {
+		params.Set("fmt", opts.Format)
+	}
+	if req.Thumb {
+		params.Set("thumb", "1")
+	}
+	if opts.Poster {
+		params.Set("poster", "1")
+	}
+	return params, nil
+}
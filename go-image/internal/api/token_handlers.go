@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+)
+
+// tokenResponse 返回给客户端的令牌信息，不包含哈希
+type tokenResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Expired    bool       `json:"expired"`
+}
+
+func newTokenResponse(token *service.APIToken) tokenResponse {
+	return tokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Hint:       token.Hint,
+		CreatedAt:  token.CreatedAt,
+		LastUsedAt: token.LastUsedAt,
+		ExpiresAt:  token.ExpiresAt,
+		Expired:    token.Expired(),
+	}
+}
+
+// TokensPageHandler 显示API令牌管理页面
+func TokensPageHandler(tokenService *service.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		session := sessions.Default(c)
+		userID := session.Get("userID").(string)
+
+		var tokens []tokenResponse
+		for _, token := range tokenService.ListTokens(userID) {
+			tokens = append(tokens, newTokenResponse(token))
+		}
+
+		c.HTML(http.StatusOK, "tokens.html", gin.H{
+			"title":  "API令牌 - Go-Image",
+			"tokens": tokens,
+		})
+	}
+}
+
+// CreateTokenHandler 创建API令牌
+func CreateTokenHandler(tokenService *service.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		session := sessions.Default(c)
+		userID := session.Get("userID").(string)
+
+		var req struct {
+			Name          string `json:"name" form:"name"`
+			ExpiresInDays int    `json:"expires_in_days" form:"expires_in_days"`
+		}
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数不正确"})
+			return
+		}
+		if req.ExpiresInDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "有效期不能为负数"})
+			return
+		}
+
+		// 有效期为 0 表示永不过期
+		var expiresAt *time.Time
+		if req.ExpiresInDays > 0 {
+			t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			expiresAt = &t
+		}
+
+		plain, token, err := tokenService.CreateToken(userID, req.Name, expiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 明文令牌只在创建时返回一次
+		c.JSON(http.StatusOK, gin.H{
+			"message": "创建成功",
+			"token":   plain,
+			"info":    newTokenResponse(token),
+		})
+	}
+}
+
+// RevokeTokenHandler 吊销API令牌
+func RevokeTokenHandler(tokenService *service.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 获取用户ID
+		session := sessions.Default(c)
+		userID := session.Get("userID").(string)
+
+		if err := tokenService.RevokeToken(userID, c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "吊销成功"})
+	}
+}
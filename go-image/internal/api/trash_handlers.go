@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+	"go-image/internal/storage"
+)
+
+// 回收站接口同时注册在会话路由组和 /api 路由组中，用户ID统一从上下文中获取
+
+// trashedImage 回收站中的图片，附带自动永久删除的时间
+type trashedImage struct {
+	*storage.ImageInfo
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashPageHandler 显示回收站页面
+func TrashPageHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+
+		images, err := listTrash(imageService, userID)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "获取回收站失败")
+			return
+		}
+
+		c.HTML(http.StatusOK, "trash.html", gin.H{
+			"title":     "回收站 - Go-Image",
+			"images":    images,
+			"trashDays": trashDays(imageService),
+		})
+	}
+}
+
+// ListTrashHandler 列出回收站中的图片
+func ListTrashHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := trashUser(c)
+		if !ok {
+			return
+		}
+
+		images, err := listTrash(imageService, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取回收站失败: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"images":         images,
+			"retention_days": trashDays(imageService),
+		})
+	}
+}
+
+// TrashThumbnailHandler 提供回收站中图片的缩略图，只有上传者本人可以访问
+func TrashThumbnailHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := trashUser(c)
+		if !ok {
+			return
+		}
+
+		image, err := imageService.TrashedImage(userID, c.Param("id"))
+		if err != nil {
+			c.String(http.StatusNotFound, "图片不存在")
+			return
+		}
+		file, err := imageService.OpenImage(image, true)
+		if err != nil {
+			c.String(http.StatusNotFound, "图片文件不存在")
+			return
+		}
+		defer file.Close()
+
+		serveContent(c, image, file, image.MimeType, imageService.ETag(image, true), "private, no-cache")
+	}
+}
+
+// RestoreImageHandler 从回收站恢复图片
+func RestoreImageHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := trashUser(c)
+		if !ok {
+			return
+		}
+
+		image, err := imageService.RestoreImage(userID, c.Param("id"))
+		if err != nil {
+			trashError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, image)
+	}
+}
+
+// PurgeImageHandler 永久删除回收站中的图片
+func PurgeImageHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := trashUser(c)
+		if !ok {
+			return
+		}
+
+		if err := imageService.PurgeImage(userID, c.Param("id")); err != nil {
+			trashError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已永久删除"})
+	}
+}
+
+// EmptyTrashHandler 清空回收站
+func EmptyTrashHandler(imageService *service.ImageService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := trashUser(c)
+		if !ok {
+			return
+		}
+
+		purged, err := imageService.EmptyTrash(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("清空回收站失败: %v", err), "purged": purged})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已清空回收站", "purged": purged})
+	}
+}
+
+// listTrash 列出用户回收站中的图片及其永久删除的时间
+func listTrash(imageService *service.ImageService, userID string) ([]trashedImage, error) {
+	images, err := imageService.ListTrash(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]trashedImage, 0, len(images))
+	for _, image := range images {
+		result = append(result, trashedImage{ImageInfo: image, PurgeAt: imageService.PurgeAt(image)})
+	}
+	return result, nil
+}
+
+// trashDays 回收站的保留天数
+func trashDays(imageService *service.ImageService) int {
+	return int(imageService.TrashRetention() / (24 * time.Hour))
+}
+
+// trashUser 从上下文中获取用户ID，未登录时返回 401
+func trashUser(c *gin.Context) (string, bool) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return "", false
+	}
+	return userID, true
+}
+
+// trashError 将服务层错误转换为JSON响应
+func trashError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "回收站中没有这张图片"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("操作失败: %v", err)})
+}
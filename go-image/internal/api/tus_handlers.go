@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go-image/internal/service"
+)
+
+// tus 协议相关常量
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,creation-with-upload,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// TusOptionsHandler 返回服务端支持的 tus 版本和扩展，无需认证
+func TusOptionsHandler(tusService *service.TusService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", tusExtensions)
+		c.Header("Tus-Max-Size", strconv.FormatInt(tusService.MaxSize(), 10))
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// TusCreateHandler 创建断点续传上传（POST /api/tus）
+func TusCreateHandler(tusService *service.TusService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := tusUser(c)
+		if !ok {
+			return
+		}
+
+		if c.GetHeader("Upload-Defer-Length") != "" {
+			tusError(c, http.StatusBadRequest, "不支持 Upload-Defer-Length")
+			return
+		}
+		size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || size <= 0 {
+			tusError(c, http.StatusBadRequest, "Upload-Length 无效")
+			return
+		}
+		metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+		if err != nil {
+			tusError(c, http.StatusBadRequest, "Upload-Metadata 无效")
+			return
+		}
+
+		upload, err := tusService.Create(userID, size, metadata)
+		if err != nil {
+			tusServiceError(c, err)
+			return
+		}
+
+		c.Header("Location", "/api/tus/"+upload.ID)
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+		// creation-with-upload：创建请求中可以直接携带第一块数据
+		if c.ContentType() == tusContentType && c.Request.ContentLength != 0 {
+			upload, err = tusService.WriteChunk(userID, upload.ID, 0, c.Request.Body)
+			if err != nil {
+				tusServiceError(c, err)
+				return
+			}
+			setTusResult(c, upload)
+		}
+
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Status(http.StatusCreated)
+	}
+}
+
+// TusHeadHandler 查询上传偏移量（HEAD /api/tus/:id）
+func TusHeadHandler(tusService *service.TusService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := tusUser(c)
+		if !ok {
+			return
+		}
+
+		// 偏移量随时可能变化，禁止缓存
+		c.Header("Cache-Control", "no-store")
+
+		upload, err := tusService.Get(userID, c.Param("id"))
+		if err != nil {
+			tusServiceError(c, err)
+			return
+		}
+
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		if len(upload.Metadata) > 0 {
+			c.Header("Upload-Metadata", formatTusMetadata(upload.Metadata))
+		}
+		setTusResult(c, upload)
+		c.Status(http.StatusOK)
+	}
+}
+
+// TusPatchHandler 追加上传数据（PATCH /api/tus/:id）
+//
+// 最后一块数据接收完成后图片即被保存，响应头 X-Image-Id 和 X-Image-Url 给出图片的ID和链接。
+func TusPatchHandler(tusService *service.TusService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := tusUser(c)
+		if !ok {
+			return
+		}
+
+		if c.ContentType() != tusContentType {
+			tusError(c, http.StatusUnsupportedMediaType, "Content-Type 必须是 "+tusContentType)
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			tusError(c, http.StatusBadRequest, "Upload-Offset 无效")
+			return
+		}
+
+		upload, err := tusService.WriteChunk(userID, c.Param("id"), offset, c.Request.Body)
+		if err != nil {
+			tusServiceError(c, err)
+			return
+		}
+
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		setTusResult(c, upload)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// TusDeleteHandler 终止上传（DELETE /api/tus/:id）
+func TusDeleteHandler(tusService *service.TusService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := tusUser(c)
+		if !ok {
+			return
+		}
+
+		if err := tusService.Terminate(userID, c.Param("id")); err != nil {
+			tusServiceError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// tusUser 检查协议版本并获取用户ID，失败时已经写入响应
+func tusUser(c *gin.Context) (string, bool) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		tusError(c, http.StatusPreconditionFailed, "不支持的 tus 协议版本")
+		return "", false
+	}
+
+	userID := c.GetString("userID")
+	if userID == "" {
+		tusError(c, http.StatusUnauthorized, "未授权的访问")
+		return "", false
+	}
+	return userID, true
+}
+
+// setTusResult 上传完成后返回生成的图片
+func setTusResult(c *gin.Context, upload *service.TusUpload) {
+	if !upload.Completed() {
+		return
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	c.Header("X-Image-Id", upload.ImageID)
+	c.Header("X-Image-Url", scheme+"://"+c.Request.Host+upload.ImagePath)
+}
+
+// tusServiceError 将服务层错误转换为 tus 响应
+func tusServiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		tusError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrOffsetMismatch), errors.Is(err, service.ErrUploadCompleted):
+		tusError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrUploadLocked):
+		tusError(c, http.StatusLocked, err.Error())
+	case errors.Is(err, service.ErrFileTooLarge), errors.Is(err, service.ErrQuotaExceeded), errors.Is(err, service.ErrTooManyPixels):
+		tusError(c, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, service.ErrUnsupportedType):
+		tusError(c, http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, service.ErrInvalidVisibility), errors.Is(err, service.ErrInvalidMetadata):
+		tusError(c, http.StatusBadRequest, err.Error())
+	default:
+		tusError(c, http.StatusInternalServerError, "上传失败: "+err.Error())
+	}
+}
+
+// tusError tus 客户端通常只读取状态码，错误信息以纯文本返回
+func tusError(c *gin.Context, status int, message string) {
+	c.String(status, message)
+	c.Abort()
+}
+
+// parseTusMetadata 解析 Upload-Metadata：逗号分隔的 "键 base64值" 对，值可以省略
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("元数据键不能为空")
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// formatTusMetadata 将元数据编码为 Upload-Metadata 格式
+func formatTusMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+	}
+	return strings.Join(pairs, ",")
+}
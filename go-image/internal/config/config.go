@@ -0,0 +1,520 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"time"
+
+	"go-image/internal/service"
+	"go-image/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath 默认配置文件路径
+const DefaultPath = "configs/config.yaml"
+
+// defaultSessionSecret 示例配置中的会话密钥，生产环境必须修改
+const defaultSessionSecret = "change-this-to-a-random-string"
+
+// Config 应用配置，对应 configs/config.yaml
+type Config struct {
+	Server       ServerConfig               `yaml:"server"`
+	Upload       UploadConfig               `yaml:"upload"`
+	Storage      storage.Config             `yaml:"storage"`
+	Transform    service.TransformConfig    `yaml:"transform"`
+	Similar      service.SimilarConfig      `yaml:"similar"`
+	Watermark    service.WatermarkConfig    `yaml:"watermark"`
+	CacheControl service.CacheControlConfig `yaml:"cache_control"`
+	Quota        QuotaConfig                `yaml:"quota"`
+	Trash        TrashConfig                `yaml:"trash"`
+	Tus          TusConfig                  `yaml:"tus"`
+	Fetch        FetchConfig                `yaml:"fetch"`
+	Signing      SigningConfig              `yaml:"signing"`
+	Hotlink      HotlinkConfig              `yaml:"hotlink"`
+	Auth         AuthConfig                 `yaml:"auth"`
+}
+
+// ServerConfig 服务器配置
+type ServerConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// TrustedProxies 信任的反向代理地址（CIDR 或单个 IP），只有来自这些地址的请求才按
+	// X-Forwarded-For 等请求头获取访问者 IP；为空时使用连接的对端地址
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// UploadConfig 上传配置
+type UploadConfig struct {
+	// MaxSize 单个文件大小上限（MB）
+	MaxSize      int64           `yaml:"max_size"`
+	AllowedTypes []string        `yaml:"allowed_types"`
+	StoragePath  string          `yaml:"storage_path"`
+	Thumbnail    ThumbnailConfig `yaml:"thumbnail"`
+	// DefaultVisibility 新上传图片的默认可见性：public、unlisted 或 private
+	DefaultVisibility string `yaml:"default_visibility"`
+	// StripMetadata 默认移除原图中的 EXIF、XMP 和 IPTC 信息，用户可以在个人设置中修改
+	StripMetadata bool `yaml:"strip_metadata"`
+	// MaxMegapixels 单张图片的像素数上限（百万像素），在解码之前按图片声明的尺寸检查，0 表示不限制
+	MaxMegapixels int64 `yaml:"max_megapixels"`
+}
+
+// ThumbnailConfig 缩略图配置
+type ThumbnailConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Width   int    `yaml:"width"`
+	Height  int    `yaml:"height"`
+	Path    string `yaml:"path"`
+	// Animated 动图生成保留动画的缩略图，关闭时使用第一帧生成静态缩略图
+	Animated bool `yaml:"animated"`
+}
+
+// QuotaConfig 存储配额配置，单位均为 MB，0 表示不限制
+type QuotaConfig struct {
+	Default int64            `yaml:"default"`
+	Users   map[string]int64 `yaml:"users"` // 用户名 -> 配额
+	// ReconcileOnStart 启动时根据实际存储重新统计所有用户的使用量
+	ReconcileOnStart bool `yaml:"reconcile_on_start"`
+}
+
+// TrashConfig 回收站配置
+type TrashConfig struct {
+	// Retention 删除的图片在回收站中保留多久（天），之后自动永久删除
+	Retention int `yaml:"retention"`
+}
+
+// TusConfig 断点续传配置
+type TusConfig struct {
+	// Dir 未完成上传的临时目录
+	Dir string `yaml:"dir"`
+	// Expiration 未完成的上传保留多久（小时）
+	Expiration int `yaml:"expiration"`
+}
+
+// FetchConfig 从网址导入图片的配置
+type FetchConfig struct {
+	// Timeout 下载超时时间（秒），包括连接、重定向和读取内容
+	Timeout int `yaml:"timeout"`
+	// MaxRedirects 最多跟随的重定向次数
+	MaxRedirects int `yaml:"max_redirects"`
+	// AllowedNetworks 允许访问的内网地址段（CIDR 或单个 IP），默认禁止访问本机和内网地址
+	AllowedNetworks []string `yaml:"allowed_networks"`
+}
+
+// SigningConfig 限时签名链接配置
+type SigningConfig struct {
+	// Keys 签名密钥，密钥ID -> 密钥；为空时不能生成签名链接
+	Keys map[string]string `yaml:"keys"`
+	// ActiveKey 生成新链接使用的密钥ID，其余密钥只用于验证已经生成的链接
+	ActiveKey string `yaml:"active_key"`
+	// DefaultExpiry 默认有效期（秒）
+	DefaultExpiry int `yaml:"default_expiry"`
+	// MaxExpiry 最长有效期（秒）
+	MaxExpiry int `yaml:"max_expiry"`
+}
+
+// signingKeyID 密钥ID只能包含字母、数字、下划线和连字符，出现在链接中
+var signingKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// minSigningKeyLength 签名密钥的最短长度
+const minSigningKeyLength = 32
+
+// HotlinkConfig 防盗链配置，作为用户没有使用自己的设置时的默认设置
+type HotlinkConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// AllowEmptyReferer 允许没有 Referer 和 Origin 的请求
+	AllowEmptyReferer bool `yaml:"allow_empty_referer"`
+	// Allowed 允许的来源域名，为空时允许禁止列表以外的所有来源
+	Allowed []string `yaml:"allowed"`
+	// Denied 禁止的来源域名
+	Denied []string `yaml:"denied"`
+	// Action 拦截方式：block、placeholder 或 redirect
+	Action string `yaml:"action"`
+	// RedirectURL Action 为 redirect 时重定向到的网址
+	RedirectURL string `yaml:"redirect_url"`
+	// Placeholder 占位图文件，为空时使用内置的占位图
+	Placeholder string `yaml:"placeholder"`
+}
+
+// AuthConfig 认证配置
+type AuthConfig struct {
+	SessionSecret string      `yaml:"session_secret"`
+	BcryptCost    int         `yaml:"bcrypt_cost"`
+	Admin         AdminConfig `yaml:"admin"`
+}
+
+// AdminConfig 默认管理员账户，密码为空时不创建
+type AdminConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host: "0.0.0.0",
+			Port: 28080,
+		},
+		Upload: UploadConfig{
+			MaxSize:      10,
+			AllowedTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+			StoragePath:  "static/uploads",
+			Thumbnail: ThumbnailConfig{
+				Enabled:  true,
+				Width:    300,
+				Height:   300,
+				Path:     "static/uploads/thumbnails",
+				Animated: true,
+			},
+			DefaultVisibility: storage.VisibilityPublic,
+			MaxMegapixels:     50,
+		},
+		Storage: storage.Config{
+			Type: "local",
+			Metadata: storage.MetadataConfig{
+				Type: "bolt",
+				Path: "data/metadata.db",
+			},
+		},
+		Transform:    service.DefaultTransformConfig(),
+		Similar:      service.DefaultSimilarConfig(),
+		CacheControl: service.DefaultCacheControlConfig(),
+		Quota: QuotaConfig{
+			Default: 1024,
+		},
+		Trash: TrashConfig{
+			Retention: 30,
+		},
+		Tus: TusConfig{
+			Dir:        "data/tus",
+			Expiration: 24,
+		},
+		Fetch: FetchConfig{
+			Timeout:      15,
+			MaxRedirects: 3,
+		},
+		Signing: SigningConfig{
+			DefaultExpiry: 3600,
+			MaxExpiry:     7 * 24 * 3600,
+		},
+		Hotlink: HotlinkConfig{
+			AllowEmptyReferer: true,
+			Action:            service.HotlinkActionBlock,
+		},
+		Auth: AuthConfig{
+			SessionSecret: defaultSessionSecret,
+			BcryptCost:    10,
+		},
+	}
+}
+
+// Load 读取配置文件，应用环境变量覆盖并校验
+//
+// 配置文件不存在时使用默认配置。环境变量的命名规则见 applyEnv。
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+		}
+	case os.IsNotExist(err):
+		log.Printf("配置文件 %s 不存在，使用默认配置", path)
+	default:
+		return nil, fmt.Errorf("读取配置文件 %s 失败: %w", path, err)
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Auth.SessionSecret == defaultSessionSecret {
+		log.Printf("警告: auth.session_secret 仍为示例值，请在生产环境中修改")
+	}
+
+	return cfg, nil
+}
+
+// Validate 校验配置
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port 必须在 1-65535 之间，当前为 %d", c.Server.Port))
+	}
+	for _, p := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies 包含无效的地址 %q", p))
+		}
+	}
+
+	if c.Upload.MaxSize <= 0 {
+		errs = append(errs, errors.New("upload.max_size 必须大于 0"))
+	}
+	if c.Upload.MaxMegapixels < 0 {
+		errs = append(errs, errors.New("upload.max_megapixels 不能为负数"))
+	}
+	if len(c.Upload.AllowedTypes) == 0 {
+		errs = append(errs, errors.New("upload.allowed_types 不能为空"))
+	}
+	for _, t := range c.Upload.AllowedTypes {
+		if !service.IsSupportedImageType(t) {
+			errs = append(errs, fmt.Errorf("upload.allowed_types 包含不支持的类型 %s", t))
+		}
+	}
+	if c.Upload.StoragePath == "" {
+		errs = append(errs, errors.New("upload.storage_path 不能为空"))
+	}
+	if c.Upload.Thumbnail.Enabled {
+		if c.Upload.Thumbnail.Width <= 0 || c.Upload.Thumbnail.Height <= 0 {
+			errs = append(errs, errors.New("upload.thumbnail 的 width 和 height 必须大于 0"))
+		}
+		if c.Upload.Thumbnail.Path == "" {
+			errs = append(errs, errors.New("upload.thumbnail.path 不能为空"))
+		}
+	}
+
+	if !storage.IsValidVisibility(c.Upload.DefaultVisibility) {
+		errs = append(errs, fmt.Errorf("upload.default_visibility 不支持 %q，可选 public、unlisted 或 private", c.Upload.DefaultVisibility))
+	}
+
+	switch c.Storage.Type {
+	case "local":
+	case "s3":
+		if c.Storage.S3.Endpoint == "" || c.Storage.S3.Bucket == "" {
+			errs = append(errs, errors.New("storage.s3 的 endpoint 和 bucket 不能为空"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.type 不支持 %q，可选 local 或 s3", c.Storage.Type))
+	}
+	switch c.Storage.Metadata.Type {
+	case "bolt":
+		if c.Storage.Metadata.Path == "" {
+			errs = append(errs, errors.New("storage.metadata.path 不能为空"))
+		}
+	case "s3":
+		if c.Storage.Type != "s3" {
+			errs = append(errs, errors.New("storage.metadata.type 为 s3 时 storage.type 也必须为 s3"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.metadata.type 不支持 %q，可选 bolt 或 s3", c.Storage.Metadata.Type))
+	}
+
+	if c.Transform.CacheDir == "" {
+		errs = append(errs, errors.New("transform.cache_dir 不能为空"))
+	}
+	for _, q := range c.Transform.AllowedQualities {
+		if q < 1 || q > 100 {
+			errs = append(errs, fmt.Errorf("transform.allowed_qualities 中的 %d 不在 1-100 之间", q))
+		}
+	}
+	if c.Transform.MaxVariants < 0 {
+		errs = append(errs, errors.New("transform.max_variants 不能为负数"))
+	}
+
+	if c.Similar.MaxDistance < 0 || c.Similar.MaxDistance > 64 {
+		errs = append(errs, fmt.Errorf("similar.max_distance 必须在 0-64 之间，当前为 %d", c.Similar.MaxDistance))
+	}
+
+	if c.Quota.Default < 0 {
+		errs = append(errs, errors.New("quota.default 不能为负数"))
+	}
+	for name, limit := range c.Quota.Users {
+		if limit < 0 {
+			errs = append(errs, fmt.Errorf("quota.users.%s 不能为负数", name))
+		}
+	}
+
+	if c.Trash.Retention <= 0 {
+		errs = append(errs, errors.New("trash.retention 必须大于 0"))
+	}
+
+	if c.Tus.Dir == "" {
+		errs = append(errs, errors.New("tus.dir 不能为空"))
+	}
+	if c.Tus.Expiration <= 0 {
+		errs = append(errs, errors.New("tus.expiration 必须大于 0"))
+	}
+
+	if c.Fetch.Timeout <= 0 {
+		errs = append(errs, errors.New("fetch.timeout 必须大于 0"))
+	}
+	if c.Fetch.MaxRedirects < 0 {
+		errs = append(errs, errors.New("fetch.max_redirects 不能为负数"))
+	}
+	if _, err := service.ParseCIDRs(c.Fetch.AllowedNetworks); err != nil {
+		errs = append(errs, fmt.Errorf("fetch.allowed_networks %w", err))
+	}
+
+	for id, key := range c.Signing.Keys {
+		if !signingKeyID.MatchString(id) {
+			errs = append(errs, fmt.Errorf("signing.keys 的密钥ID %q 只能包含字母、数字、下划线和连字符", id))
+		}
+		if len(key) < minSigningKeyLength {
+			errs = append(errs, fmt.Errorf("signing.keys.%s 至少需要 %d 个字符", id, minSigningKeyLength))
+		}
+	}
+	if c.Signing.ActiveKey != "" {
+		if _, ok := c.Signing.Keys[c.Signing.ActiveKey]; !ok {
+			errs = append(errs, fmt.Errorf("signing.active_key %q 不在 signing.keys 中", c.Signing.ActiveKey))
+		}
+	} else if len(c.Signing.Keys) > 0 {
+		errs = append(errs, errors.New("配置了 signing.keys 时必须指定 signing.active_key"))
+	}
+	if c.Signing.DefaultExpiry <= 0 {
+		errs = append(errs, errors.New("signing.default_expiry 必须大于 0"))
+	}
+	if c.Signing.MaxExpiry < c.Signing.DefaultExpiry {
+		errs = append(errs, errors.New("signing.max_expiry 不能小于 signing.default_expiry"))
+	}
+
+	if _, err := c.hotlinkSettings(); err != nil {
+		errs = append(errs, fmt.Errorf("hotlink %w", err))
+	}
+
+	if c.Auth.SessionSecret == "" {
+		errs = append(errs, errors.New("auth.session_secret 不能为空"))
+	}
+	if c.Auth.BcryptCost < 4 || c.Auth.BcryptCost > 31 {
+		errs = append(errs, fmt.Errorf("auth.bcrypt_cost 必须在 4-31 之间，当前为 %d", c.Auth.BcryptCost))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("配置校验失败: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Addr 服务器监听地址
+func (c *Config) Addr() string {
+	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
+}
+
+// StorageConfig 存储后端配置，本地存储路径取自 upload 配置
+func (c *Config) StorageConfig() storage.Config {
+	cfg := c.Storage
+	cfg.Path = c.Upload.StoragePath
+	cfg.ThumbnailPath = c.Upload.Thumbnail.Path
+	return cfg
+}
+
+// ImageConfig 图片服务配置
+func (c *Config) ImageConfig() service.ImageConfig {
+	return service.ImageConfig{
+		MaxSize:      c.Upload.MaxSize * 1024 * 1024,
+		MaxPixels:    c.Upload.MaxMegapixels * 1000000,
+		AllowedTypes: c.Upload.AllowedTypes,
+		Thumbnail: service.ThumbnailConfig{
+			Enabled:  c.Upload.Thumbnail.Enabled,
+			Width:    uint(c.Upload.Thumbnail.Width),
+			Height:   uint(c.Upload.Thumbnail.Height),
+			Animated: c.Upload.Thumbnail.Animated,
+		},
+		Transform:         c.Transform,
+		Similar:           c.Similar,
+		Watermark:         c.Watermark,
+		CacheControl:      c.CacheControl,
+		DefaultVisibility: c.Upload.DefaultVisibility,
+		TrashRetention:    time.Duration(c.Trash.Retention) * 24 * time.Hour,
+	}
+}
+
+// UserSettingsDefaults 用户没有修改过的个人设置使用的默认值
+func (c *Config) UserSettingsDefaults() service.UserSettings {
+	hotlink, _ := c.hotlinkSettings() // 已在 Validate 中校验
+	return service.UserSettings{
+		StripMetadata: c.Upload.StripMetadata,
+		Watermark:     service.DefaultWatermarkSettings(),
+		Hotlink:       hotlink,
+	}
+}
+
+// QuotaServiceConfig 配额服务配置
+func (c *Config) QuotaServiceConfig() service.QuotaConfig {
+	users := make(map[string]int64, len(c.Quota.Users))
+	for name, limit := range c.Quota.Users {
+		users[name] = limit * 1024 * 1024
+	}
+	return service.QuotaConfig{
+		Default: c.Quota.Default * 1024 * 1024,
+		Users:   users,
+	}
+}
+
+// TusServiceConfig 断点续传服务配置
+func (c *Config) TusServiceConfig() service.TusConfig {
+	return service.TusConfig{
+		Dir:        c.Tus.Dir,
+		Expiration: time.Duration(c.Tus.Expiration) * time.Hour,
+	}
+}
+
+// FetchServiceConfig 网址导入服务配置
+func (c *Config) FetchServiceConfig() service.FetchConfig {
+	networks, _ := service.ParseCIDRs(c.Fetch.AllowedNetworks) // 已在 Validate 中校验
+	return service.FetchConfig{
+		Timeout:         time.Duration(c.Fetch.Timeout) * time.Second,
+		MaxRedirects:    c.Fetch.MaxRedirects,
+		AllowedNetworks: networks,
+	}
+}
+
+// SigningServiceConfig 签名链接服务配置
+func (c *Config) SigningServiceConfig() service.SigningConfig {
+	keys := make(map[string][]byte, len(c.Signing.Keys))
+	for id, key := range c.Signing.Keys {
+		keys[id] = []byte(key)
+	}
+	return service.SigningConfig{
+		Keys:          keys,
+		ActiveKey:     c.Signing.ActiveKey,
+		DefaultExpiry: time.Duration(c.Signing.DefaultExpiry) * time.Second,
+		MaxExpiry:     time.Duration(c.Signing.MaxExpiry) * time.Second,
+	}
+}
+
+// ShareServiceConfig 分享服务配置，访问密码与登录密码使用相同的 bcrypt 计算成本
+func (c *Config) ShareServiceConfig() service.ShareConfig {
+	return service.ShareConfig{
+		BcryptCost: c.Auth.BcryptCost,
+	}
+}
+
+// HotlinkServiceConfig 防盗链服务配置
+func (c *Config) HotlinkServiceConfig() service.HotlinkConfig {
+	defaults, _ := c.hotlinkSettings() // 已在 Validate 中校验
+	return service.HotlinkConfig{
+		Defaults:    defaults,
+		Placeholder: c.Hotlink.Placeholder,
+	}
+}
+
+// hotlinkSettings 校验配置文件中的防盗链设置并整理域名的写法
+func (c *Config) hotlinkSettings() (service.HotlinkSettings, error) {
+	settings := service.HotlinkSettings{
+		Enabled:           c.Hotlink.Enabled,
+		AllowEmptyReferer: c.Hotlink.AllowEmptyReferer,
+		Allowed:           c.Hotlink.Allowed,
+		Denied:            c.Hotlink.Denied,
+		Action:            c.Hotlink.Action,
+		RedirectURL:       c.Hotlink.RedirectURL,
+	}
+	return settings, settings.Validate()
+}
+
+// AuthServiceConfig 认证服务配置
+func (c *Config) AuthServiceConfig() service.AuthConfig {
+	return service.AuthConfig{
+		BcryptCost: c.Auth.BcryptCost,
+	}
+}
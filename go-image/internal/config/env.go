@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix 环境变量前缀
+const envPrefix = "GO_IMAGE_"
+
+// applyEnv 使用环境变量覆盖配置
+//
+// 变量名由前缀和 yaml 键路径组成，例如 server.port 对应 GO_IMAGE_SERVER_PORT，
+// upload.thumbnail.width 对应 GO_IMAGE_UPLOAD_THUMBNAIL_WIDTH。
+// 列表使用逗号分隔，例如 GO_IMAGE_UPLOAD_ALLOWED_TYPES=image/png,image/jpeg；
+// 映射使用逗号分隔的 key=value，例如 GO_IMAGE_QUOTA_USERS=alice=2048,bob=0。
+func applyEnv(cfg *Config) error {
+	return applyEnvValue(reflect.ValueOf(cfg).Elem(), envPrefix)
+}
+
+func applyEnvValue(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + strings.ToUpper(tag)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnvValue(fv, name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, raw); err != nil {
+			return fmt.Errorf("环境变量 %s 的值 %q 无效: %w", name, raw, err)
+		}
+	}
+	return nil
+}
+
+// setValue 将字符串解析为字段对应的类型
+func setValue(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		var parts []string
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				parts = append(parts, p)
+			}
+		}
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err := setValue(slice.Index(i), p); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(p, "=")
+			if !ok {
+				return fmt.Errorf("%q 不是 key=value 格式", p)
+			}
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := setValue(k, strings.TrimSpace(key)); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(e, strings.TrimSpace(value)); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("不支持的类型 %s", v.Kind())
+	}
+	return nil
+}
@@ -2,18 +2,47 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
+
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"go-image/internal/service"
 )
 
 // APIAuthRequired API认证中间件
-func APIAuthRequired(authService *service.AuthService) gin.HandlerFunc {
+//
+// 优先使用 "Authorization: Bearer <令牌>" 形式的个人API令牌，
+// 同时兼容旧的 HTTP Basic 认证。
+func APIAuthRequired(authService *service.AuthService, tokenService *service.TokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 个人API令牌
+		if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token, err := tokenService.ValidateToken(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+
+			user, err := authService.GetUserByID(token.UserID)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "认证失败"})
+				c.Abort()
+				return
+			}
+
+			c.Set("user", user)
+			c.Set("userID", user.ID)
+			c.Next()
+			return
+		}
+
 		// 从请求头获取认证信息
 		username, password, ok := c.Request.BasicAuth()
 		if !ok {
-			c.Header("WWW-Authenticate", "Basic realm=\"Authorization Required\"")
+			// 两种方式都接受，同时发送两个质询
+			c.Writer.Header().Add("WWW-Authenticate", "Basic realm=\"Authorization Required\"")
+			c.Writer.Header().Add("WWW-Authenticate", "Bearer realm=\"Authorization Required\"")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "需要认证"})
 			c.Abort()
 			return
@@ -49,6 +78,7 @@ func AuthRequired() gin.HandlerFunc {
 
 		// 将用户信息存储在上下文中，以便后续处理器使用
 		c.Set("user", user)
+		c.Set("userID", session.Get("userID"))
 		c.Next()
 	}
 }
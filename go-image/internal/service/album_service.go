@@ -0,0 +1,317 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"go-image/internal/storage"
+)
+
+// maxAlbumNameLength 相册名称的最大长度（字符）
+const maxAlbumNameLength = 100
+
+// ErrInvalidAlbum 相册参数不合法
+var ErrInvalidAlbum = errors.New("相册参数不合法")
+
+// AlbumSummary 相册列表中的一项
+type AlbumSummary struct {
+	*storage.Album
+	ImageCount int    `json:"image_count"`
+	Cover      string `json:"cover,omitempty"` // 实际使用的封面图片ID
+}
+
+// AlbumUpdate 修改相册的请求，字段为 nil 表示不修改
+type AlbumUpdate struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	CoverID     *string `json:"cover_id"`
+}
+
+// AlbumService 处理相册相关的业务逻辑
+type AlbumService struct {
+	storage storage.Storage
+	albums  storage.AlbumStore
+
+	// mutex 串行化相册成员的修改，避免并发读改写图片元数据时互相覆盖
+	mutex sync.Mutex
+}
+
+// NewAlbumService 创建一个新的相册服务实例
+func NewAlbumService(storage storage.Storage) *AlbumService {
+	return &AlbumService{
+		storage: storage,
+		albums:  storage.Albums(),
+	}
+}
+
+// CreateAlbum 创建相册，新相册排在最后
+func (s *AlbumService) CreateAlbum(userID, name, description string) (*storage.Album, error) {
+	name, err := normalizeAlbumName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	albums, err := s.albums.ListAlbums(userID)
+	if err != nil {
+		return nil, err
+	}
+	position := 0
+	if len(albums) > 0 {
+		position = albums[len(albums)-1].Position + 1
+	}
+
+	now := time.Now()
+	album := &storage.Album{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Name:        name,
+		Description: strings.TrimSpace(description),
+		Position:    position,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.albums.PutAlbum(album); err != nil {
+		return nil, err
+	}
+	return album, nil
+}
+
+// GetAlbum 获取用户的相册
+func (s *AlbumService) GetAlbum(userID, id string) (*storage.Album, error) {
+	album, err := s.albums.GetAlbum(id)
+	if err != nil || album.UserID != userID {
+		return nil, storage.ErrAlbumNotFound
+	}
+	return album, nil
+}
+
+// ListAlbums 按顺序列出用户的相册，包括图片数量和封面
+func (s *AlbumService) ListAlbums(userID string) ([]*AlbumSummary, error) {
+	albums, err := s.albums.ListAlbums(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]*AlbumSummary, 0, len(albums))
+	for _, album := range albums {
+		images, err := s.albums.ListAlbumImages(album.ID)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, &AlbumSummary{
+			Album:      album,
+			ImageCount: len(images),
+			Cover:      albumCover(album, images),
+		})
+	}
+	return summaries, nil
+}
+
+// AlbumImages 获取用户相册及其中的图片
+func (s *AlbumService) AlbumImages(userID, id string) (*AlbumSummary, []*storage.ImageInfo, error) {
+	album, err := s.GetAlbum(userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	images, err := s.albums.ListAlbumImages(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	summary := &AlbumSummary{
+		Album:      album,
+		ImageCount: len(images),
+		Cover:      albumCover(album, images),
+	}
+	return summary, images, nil
+}
+
+// UpdateAlbum 重命名相册、修改描述或设置封面
+func (s *AlbumService) UpdateAlbum(userID, id string, update AlbumUpdate) (*storage.Album, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	album, err := s.GetAlbum(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		name, err := normalizeAlbumName(*update.Name)
+		if err != nil {
+			return nil, err
+		}
+		album.Name = name
+	}
+	if update.Description != nil {
+		album.Description = strings.TrimSpace(*update.Description)
+	}
+	if update.CoverID != nil {
+		// 封面必须是相册中的图片，空字符串表示恢复默认封面
+		if *update.CoverID != "" {
+			image, err := s.storage.Get(userID, *update.CoverID)
+			if err != nil || !image.InAlbum(id) {
+				return nil, fmt.Errorf("%w: 封面图片必须是相册中的图片", ErrInvalidAlbum)
+			}
+		}
+		album.CoverID = *update.CoverID
+	}
+
+	album.UpdatedAt = time.Now()
+	if err := s.albums.PutAlbum(album); err != nil {
+		return nil, err
+	}
+	return album, nil
+}
+
+// DeleteAlbum 删除相册，相册中的图片不会被删除
+func (s *AlbumService) DeleteAlbum(userID, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.GetAlbum(userID, id); err != nil {
+		return err
+	}
+	return s.albums.DeleteAlbum(id)
+}
+
+// AddImages 将图片加入相册，已在相册中的图片会被忽略
+func (s *AlbumService) AddImages(userID, albumID string, imageIDs []string) error {
+	return s.updateMembership(userID, albumID, imageIDs, func(image *storage.ImageInfo) bool {
+		if image.InAlbum(albumID) {
+			return false
+		}
+		image.Albums = append(image.Albums, albumID)
+		return true
+	})
+}
+
+// RemoveImages 将图片移出相册，图片本身不会被删除
+func (s *AlbumService) RemoveImages(userID, albumID string, imageIDs []string) error {
+	return s.updateMembership(userID, albumID, imageIDs, func(image *storage.ImageInfo) bool {
+		if !image.InAlbum(albumID) {
+			return false
+		}
+		image.RemoveAlbum(albumID)
+		return true
+	})
+}
+
+// ReorderAlbums 按给定顺序排列相册，未列出的相册保持原有顺序排在后面
+func (s *AlbumService) ReorderAlbums(userID string, albumIDs []string) ([]*storage.Album, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	albums, err := s.albums.ListAlbums(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*storage.Album, len(albums))
+	for _, album := range albums {
+		byID[album.ID] = album
+	}
+
+	ordered := make([]*storage.Album, 0, len(albums))
+	seen := make(map[string]bool, len(albumIDs))
+	for _, id := range albumIDs {
+		album, ok := byID[id]
+		if !ok || seen[id] {
+			return nil, storage.ErrAlbumNotFound
+		}
+		seen[id] = true
+		ordered = append(ordered, album)
+	}
+	for _, album := range albums {
+		if !seen[album.ID] {
+			ordered = append(ordered, album)
+		}
+	}
+
+	for i, album := range ordered {
+		if album.Position == i {
+			continue
+		}
+		album.Position = i
+		if err := s.albums.PutAlbum(album); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// updateMembership 校验相册和图片的所有权后逐张修改图片所属的相册
+func (s *AlbumService) updateMembership(userID, albumID string, imageIDs []string, apply func(*storage.ImageInfo) bool) error {
+	if len(imageIDs) == 0 {
+		return fmt.Errorf("%w: 没有指定图片", ErrInvalidAlbum)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	album, err := s.GetAlbum(userID, albumID)
+	if err != nil {
+		return err
+	}
+
+	// 先全部校验再修改，避免只处理了一部分
+	images := make([]*storage.ImageInfo, 0, len(imageIDs))
+	for _, id := range imageIDs {
+		image, err := s.storage.Get(userID, id)
+		if err != nil {
+			return err
+		}
+		images = append(images, image)
+	}
+
+	changed := false
+	for _, image := range images {
+		if !apply(image) {
+			continue
+		}
+		if err := s.storage.Update(image); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if changed {
+		album.UpdatedAt = time.Now()
+		return s.albums.PutAlbum(album)
+	}
+	return nil
+}
+
+// albumCover 相册实际使用的封面：设置的封面仍在相册中时使用它，否则使用第一张图片
+func albumCover(album *storage.Album, images []*storage.ImageInfo) string {
+	for _, image := range images {
+		if image.ID == album.CoverID {
+			return image.ID
+		}
+	}
+	if len(images) > 0 {
+		return images[0].ID
+	}
+	return ""
+}
+
+// normalizeAlbumName 校验并整理相册名称
+func normalizeAlbumName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: 相册名称不能为空", ErrInvalidAlbum)
+	}
+	if utf8.RuneCountInString(name) > maxAlbumNameLength {
+		return "", fmt.Errorf("%w: 相册名称不能超过%d个字符", ErrInvalidAlbum, maxAlbumNameLength)
+	}
+	return name, nil
+}
@@ -0,0 +1,621 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"sort"
+
+	"go-image/internal/webp"
+)
+
+// errNotAnimated 图片不是动图（静态图片或只有一帧的 GIF）
+var errNotAnimated = errors.New("图片不是动图")
+
+// errAnimationTooLarge 动图的画布尺寸或帧数超过限制
+var errAnimationTooLarge = errors.New("动图尺寸过大")
+
+// 动图的尺寸限制，在分配画布之前检查，避免很小的文件声明巨大的画布或大量的帧耗尽内存
+const (
+	maxAnimationDimension = 16383   // 画布的最大边长
+	maxAnimationPixels    = 1 << 25 // 画布的像素数上限
+	maxAnimationBudget    = 1 << 27 // 画布像素数 × 帧数的上限
+)
+
+// checkAnimationSize 检查动图的画布尺寸和帧数是否在限制之内
+func checkAnimationSize(width, height, frames int) error {
+	if width > maxAnimationDimension || height > maxAnimationDimension {
+		return errAnimationTooLarge
+	}
+	pixels := width * height
+	if pixels > maxAnimationPixels || pixels*frames > maxAnimationBudget {
+		return errAnimationTooLarge
+	}
+	return nil
+}
+
+// errStopAnimation visit 返回该错误时提前结束逐帧解码
+var errStopAnimation = errors.New("停止解码动图")
+
+// animation 动图：每一帧都是按处置方式合成后的完整画面
+type animation struct {
+	width, height int           // 原图画布尺寸
+	frames        []image.Image // 缩放后的画面，解码时未要求保留画面则为空
+	delays        []int         // 每一帧的显示时长（毫秒）
+	loopCount     int           // 播放次数，0 表示无限循环
+}
+
+// duration 一轮播放的总时长（毫秒）
+func (a *animation) duration() int {
+	total := 0
+	for _, delay := range a.delays {
+		total += delay
+	}
+	return total
+}
+
+// decodeAnimation 解码 GIF 或 WebP 动图，每一帧合成后交给 transform 缩放
+//
+// transform 为 nil 时只统计帧数和时长，不保留画面。不是动图时返回 errNotAnimated。
+func decodeAnimation(data []byte, transform func(image.Image) image.Image) (*animation, error) {
+	anim := &animation{}
+	loopCount, err := walkAnimation(data, func(canvas *image.NRGBA, delay int) error {
+		anim.width, anim.height = canvas.Rect.Dx(), canvas.Rect.Dy()
+		anim.delays = append(anim.delays, delay)
+		if transform == nil {
+			return nil
+		}
+		frame := transform(canvas)
+		if frame == image.Image(canvas) {
+			// 画布会被下一帧覆盖，没有缩放时需要复制
+			frame = cloneNRGBA(canvas)
+		}
+		anim.frames = append(anim.frames, frame)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	anim.loopCount = loopCount
+	return anim, nil
+}
+
+// posterFrame 动图合成后的第一帧
+func posterFrame(data []byte) (image.Image, error) {
+	var poster *image.NRGBA
+	_, err := walkAnimation(data, func(canvas *image.NRGBA, delay int) error {
+		poster = cloneNRGBA(canvas)
+		return errStopAnimation
+	})
+	if err != nil {
+		return nil, err
+	}
+	return poster, nil
+}
+
+// walkAnimation 逐帧解码动图，按处置方式把每一帧合成到画布上后交给 visit
+//
+// 画布在 visit 返回后会被下一帧修改，需要保留时应先复制或缩放。
+func walkAnimation(data []byte, visit func(canvas *image.NRGBA, delay int) error) (loopCount int, err error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		loopCount, err = walkGIF(data, visit)
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		loopCount, err = walkWebP(data, visit)
+	default:
+		return 0, errNotAnimated
+	}
+	if errors.Is(err, errStopAnimation) {
+		err = nil
+	}
+	return loopCount, err
+}
+
+// walkGIF 逐帧合成 GIF 动图
+func walkGIF(data []byte, visit func(canvas *image.NRGBA, delay int) error) (int, error) {
+	// 先只读取逻辑屏幕尺寸，标准库解码时每一帧都不会超出逻辑屏幕
+	config, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if err := checkAnimationSize(config.Width, config.Height, 1); err != nil {
+		return 0, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if len(g.Image) < 2 {
+		return 0, errNotAnimated
+	}
+
+	// 逻辑屏幕尺寸缺失时使用所有帧的并集
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, frame := range g.Image {
+			bounds = bounds.Union(frame.Rect)
+		}
+		bounds.Min = image.Point{}
+	}
+	if err := checkAnimationSize(bounds.Dx(), bounds.Dy(), len(g.Image)); err != nil {
+		return 0, err
+	}
+	canvas := image.NewNRGBA(bounds)
+
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous []byte
+		if disposal == gif.DisposalPrevious {
+			previous = append([]byte(nil), canvas.Pix...)
+		}
+
+		drawPaletted(canvas, frame)
+		delay := 0
+		if i < len(g.Delay) {
+			delay = g.Delay[i] * 10
+		}
+		if err := visit(canvas, delay); err != nil {
+			return 0, err
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			// 浏览器把背景处理为透明
+			clearRect(canvas, frame.Rect)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous)
+		}
+	}
+
+	// GIF 的 LoopCount：0 表示无限循环，-1 表示只播放一次，n 表示额外重复 n 次
+	switch {
+	case g.LoopCount == 0:
+		return 0, nil
+	case g.LoopCount < 0:
+		return 1, nil
+	default:
+		return g.LoopCount + 1, nil
+	}
+}
+
+// walkWebP 逐帧合成 WebP 动图
+func walkWebP(data []byte, visit func(canvas *image.NRGBA, delay int) error) (int, error) {
+	anim, err := webp.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, webp.ErrNotAnimated) {
+			return 0, errNotAnimated
+		}
+		if errors.Is(err, webp.ErrTooLarge) {
+			return 0, errAnimationTooLarge
+		}
+		return 0, err
+	}
+	if err := checkAnimationSize(anim.Width, anim.Height, len(anim.Frames)); err != nil {
+		return 0, err
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, anim.Width, anim.Height))
+	for _, frame := range anim.Frames {
+		src, ok := frame.Image.(*image.NRGBA)
+		if !ok {
+			return 0, errors.New("动图帧格式不正确")
+		}
+		drawNRGBA(canvas, src, frame.Blend)
+		if err := visit(canvas, frame.Duration); err != nil {
+			return 0, err
+		}
+		if frame.Dispose {
+			clearRect(canvas, src.Rect)
+		}
+	}
+	return anim.LoopCount, nil
+}
+
+// drawPaletted 将 GIF 帧画到画布上，透明像素保留画布原有的内容
+func drawPaletted(dst *image.NRGBA, src *image.Paletted) {
+	palette := make([]color.NRGBA, len(src.Palette))
+	for i, c := range src.Palette {
+		palette[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+
+	r := src.Rect.Intersect(dst.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			index := int(src.Pix[src.PixOffset(x, y)])
+			if index >= len(palette) || palette[index].A == 0 {
+				continue
+			}
+			c := palette[index]
+			p := dst.Pix[dst.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// drawNRGBA 将 WebP 帧画到画布上，blend 为 false 时直接覆盖帧所在的区域
+func drawNRGBA(dst, src *image.NRGBA, blend bool) {
+	r := src.Rect.Intersect(dst.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			s := src.Pix[src.PixOffset(x, y):]
+			d := dst.Pix[dst.PixOffset(x, y):]
+			sa := uint32(s[3])
+			if !blend || sa == 0xff {
+				d[0], d[1], d[2], d[3] = s[0], s[1], s[2], s[3]
+				continue
+			}
+			if sa == 0 {
+				continue
+			}
+			// 非预乘颜色的 alpha 混合
+			da := uint32(d[3]) * (0xff - sa) / 0xff
+			a := sa + da
+			for c := 0; c < 3; c++ {
+				d[c] = uint8((uint32(s[c])*sa + uint32(d[c])*da) / a)
+			}
+			d[3] = uint8(a)
+		}
+	}
+}
+
+// clearRect 将画布上的区域清除为透明
+func clearRect(canvas *image.NRGBA, r image.Rectangle) {
+	r = r.Intersect(canvas.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := canvas.Pix[canvas.PixOffset(r.Min.X, y):canvas.PixOffset(r.Max.X, y)]
+		for i := range row {
+			row[i] = 0
+		}
+	}
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	clone := image.NewNRGBA(img.Rect)
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// toNRGBA 将图片转换为左上角位于原点的 NRGBA
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	switch src := img.(type) {
+	case *image.NRGBA:
+		if src.Rect.Min == (image.Point{}) {
+			return src
+		}
+	case *image.RGBA:
+		// 缩放结果通常是预乘的 RGBA，逐像素还原为非预乘颜色
+		for y := 0; y < bounds.Dy(); y++ {
+			s := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			d := dst.Pix[dst.PixOffset(0, y):]
+			for x := 0; x < bounds.Dx()*4; x += 4 {
+				a := uint32(s[x+3])
+				d[x+3] = uint8(a)
+				if a == 0 {
+					continue
+				}
+				for c := 0; c < 3; c++ {
+					d[x+c] = uint8((uint32(s[x+c])*0xff + a/2) / a)
+				}
+			}
+		}
+		return dst
+	}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			p := dst.Pix[dst.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return dst
+}
+
+// encodeAnimation 按指定格式编码动图，只支持 gif 和 webp
+//
+// 与上一帧相同的区域不再重复编码，画面没有变化的帧合并到上一帧的显示时长中。
+func encodeAnimation(w io.Writer, anim *animation, format string, quality int) error {
+	if len(anim.frames) == 0 {
+		return errors.New("动图没有任何帧")
+	}
+	frames := make([]*image.NRGBA, len(anim.frames))
+	for i, frame := range anim.frames {
+		frames[i] = toNRGBA(frame)
+	}
+
+	switch format {
+	case "gif":
+		return encodeAnimatedGIF(w, frames, anim.delays, anim.loopCount)
+	case "webp":
+		return encodeAnimatedWebP(w, frames, anim.delays, anim.loopCount, quality)
+	default:
+		return errors.New("不支持的动图格式 " + format)
+	}
+}
+
+// encodeAnimatedWebP 编码 WebP 动图，每一帧只覆盖与上一帧不同的区域
+func encodeAnimatedWebP(w io.Writer, frames []*image.NRGBA, delays []int, loopCount, quality int) error {
+	bounds := frames[0].Rect
+	anim := &webp.Animation{Width: bounds.Dx(), Height: bounds.Dy(), LoopCount: loopCount}
+	for i, frame := range frames {
+		r := bounds
+		if i > 0 {
+			r = diffRect(frames[i-1], frame, func(a, b []byte, offset int) bool {
+				return a[offset] != b[offset] || a[offset+1] != b[offset+1] || a[offset+2] != b[offset+2] || a[offset+3] != b[offset+3]
+			})
+			if r.Empty() {
+				anim.Frames[len(anim.Frames)-1].Duration += delays[i]
+				continue
+			}
+			// 帧的位置必须是偶数
+			r.Min.X &^= 1
+			r.Min.Y &^= 1
+		}
+		// 不混合：透明像素同样覆盖画布，不依赖处置方式
+		anim.Frames = append(anim.Frames, webp.Frame{
+			Image:    frame.SubImage(r),
+			Duration: delays[i],
+		})
+	}
+	return webp.EncodeAll(w, anim, &webp.Options{Quality: quality})
+}
+
+// encodeAnimatedGIF 编码 GIF 动图，所有帧共用一个调色板
+//
+// 不透明的动图每一帧只编码与上一帧不同的区域；带透明像素的动图每一帧都是完整画面，
+// 显示后清除为背景，保证变为透明的像素不会残留上一帧的内容。
+func encodeAnimatedGIF(w io.Writer, frames []*image.NRGBA, delays []int, loopCount int) error {
+	bounds := frames[0].Rect
+	palette, transparent := buildPalette(frames)
+	lookup := newPaletteLookup(palette)
+
+	// 所有帧按调色板量化，半透明像素按 50% 阈值处理
+	indexed := make([]*image.Paletted, len(frames))
+	for i, frame := range frames {
+		p := image.NewPaletted(bounds, palette)
+		for j := 0; j < len(p.Pix); j++ {
+			c := frame.Pix[j*4 : j*4+4]
+			if c[3] < 0x80 {
+				p.Pix[j] = uint8(transparent)
+				continue
+			}
+			p.Pix[j] = lookup.index(c[0], c[1], c[2])
+		}
+		indexed[i] = p
+	}
+
+	g := &gif.GIF{
+		Config: image.Config{ColorModel: palette, Width: bounds.Dx(), Height: bounds.Dy()},
+	}
+	switch loopCount {
+	case 0:
+		g.LoopCount = 0
+	case 1:
+		g.LoopCount = -1
+	default:
+		g.LoopCount = loopCount - 1
+	}
+
+	for i, frame := range indexed {
+		r := bounds
+		disposal := byte(gif.DisposalNone)
+		if transparent >= 0 {
+			disposal = gif.DisposalBackground
+		} else if i > 0 {
+			r = diffRect(indexed[i-1], frame, func(a, b []byte, offset int) bool {
+				return a[offset] != b[offset]
+			})
+			if r.Empty() {
+				g.Delay[len(g.Delay)-1] += (delays[i] + 5) / 10
+				continue
+			}
+		}
+		g.Image = append(g.Image, frame.SubImage(r).(*image.Paletted))
+		g.Delay = append(g.Delay, (delays[i]+5)/10)
+		g.Disposal = append(g.Disposal, disposal)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// diffRect 两帧中内容不同的像素所在的最小矩形，differ 比较 Pix 中指定偏移处的像素
+func diffRect(prev, cur image.Image, differ func(a, b []byte, offset int) bool) image.Rectangle {
+	var a, b []byte
+	var stride, bpp int
+	switch p := prev.(type) {
+	case *image.NRGBA:
+		a, b, stride, bpp = p.Pix, cur.(*image.NRGBA).Pix, p.Stride, 4
+	case *image.Paletted:
+		a, b, stride, bpp = p.Pix, cur.(*image.Paletted).Pix, p.Stride, 1
+	}
+
+	bounds := prev.Bounds()
+	r := image.Rectangle{}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if !differ(a, b, y*stride+x*bpp) {
+				continue
+			}
+			pixel := image.Rect(x, y, x+1, y+1).Add(bounds.Min)
+			if r.Empty() {
+				r = pixel
+			} else {
+				r = r.Union(pixel)
+			}
+		}
+	}
+	return r
+}
+
+// buildPalette 用中位切分法为所有帧生成共用的调色板
+//
+// 有透明像素时最后一项为透明色，返回它的下标；否则返回 -1。
+func buildPalette(frames []*image.NRGBA) (color.Palette, int) {
+	// 按每个分量 5 位统计颜色
+	var counts [1 << 15]uint32
+	var sums [1 << 15][3]uint64
+	hasTransparent := false
+	for _, frame := range frames {
+		for i := 0; i < len(frame.Pix); i += 4 {
+			c := frame.Pix[i : i+4]
+			if c[3] < 0x80 {
+				hasTransparent = true
+				continue
+			}
+			key := colorKey(c[0], c[1], c[2])
+			counts[key]++
+			sums[key][0] += uint64(c[0])
+			sums[key][1] += uint64(c[1])
+			sums[key][2] += uint64(c[2])
+		}
+	}
+
+	maxColors := 256
+	if hasTransparent {
+		maxColors = 255
+	}
+
+	var keys []int
+	for key, count := range counts {
+		if count > 0 {
+			keys = append(keys, key)
+		}
+	}
+	boxes := [][]int{}
+	if len(keys) > 0 {
+		boxes = append(boxes, keys)
+	}
+
+	// 每次切分像素数与颜色范围乘积最大的盒子
+	for len(boxes) < maxColors {
+		best, bestScore, bestAxis := -1, uint64(0), 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			axis, span := widestAxis(box)
+			var total uint64
+			for _, key := range box {
+				total += uint64(counts[key])
+			}
+			if score := total * uint64(span); score > bestScore {
+				best, bestScore, bestAxis = i, score, axis
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool {
+			return keyChannel(box[i], bestAxis) < keyChannel(box[j], bestAxis)
+		})
+		var total, half uint64
+		for _, key := range box {
+			total += uint64(counts[key])
+		}
+		split := 1
+		for i, key := range box[:len(box)-1] {
+			half += uint64(counts[key])
+			split = i + 1
+			if half*2 >= total {
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make(color.Palette, 0, len(boxes)+1)
+	for _, box := range boxes {
+		var n uint64
+		var sum [3]uint64
+		for _, key := range box {
+			n += uint64(counts[key])
+			for c := 0; c < 3; c++ {
+				sum[c] += sums[key][c]
+			}
+		}
+		palette = append(palette, color.NRGBA{
+			R: uint8(sum[0] / n), G: uint8(sum[1] / n), B: uint8(sum[2] / n), A: 0xff,
+		})
+	}
+	if len(palette) == 0 {
+		palette = append(palette, color.NRGBA{A: 0xff})
+	}
+	if !hasTransparent {
+		return palette, -1
+	}
+	palette = append(palette, color.NRGBA{})
+	return palette, len(palette) - 1
+}
+
+// widestAxis 盒子中范围最大的颜色分量
+func widestAxis(box []int) (axis, span int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := 31, 0
+		for _, key := range box {
+			v := keyChannel(key, c)
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > span {
+			axis, span = c, hi-lo
+		}
+	}
+	return axis, span
+}
+
+func colorKey(r, g, b uint8) int {
+	return int(r>>3)<<10 | int(g>>3)<<5 | int(b>>3)
+}
+
+func keyChannel(key, c int) int {
+	return key >> uint(10-5*c) & 0x1f
+}
+
+// paletteLookup 按 5 位颜色缓存最接近的调色板下标
+type paletteLookup struct {
+	palette color.Palette
+	cache   [1 << 15]int16
+}
+
+func newPaletteLookup(palette color.Palette) *paletteLookup {
+	l := &paletteLookup{palette: palette}
+	for i := range l.cache {
+		l.cache[i] = -1
+	}
+	return l
+}
+
+func (l *paletteLookup) index(r, g, b uint8) uint8 {
+	key := colorKey(r, g, b)
+	if i := l.cache[key]; i >= 0 {
+		return uint8(i)
+	}
+
+	best, bestDist := 0, -1
+	for i, c := range l.palette {
+		pc := c.(color.NRGBA)
+		if pc.A == 0 {
+			continue
+		}
+		dr, dg, db := int(pc.R)-int(r), int(pc.G)-int(g), int(pc.B)-int(b)
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	l.cache[key] = int16(best)
+	return uint8(best)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestWalkAnimationRejectsOversizedGIF(t *testing.T) {
+	// 只有文件头和逻辑屏幕描述，声明 60000x60000 的画布
+	data := []byte("GIF89a\x00\x00\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint16(data[6:], 60000)
+	binary.LittleEndian.PutUint16(data[8:], 60000)
+	if _, err := decodeAnimation(data, nil); !errors.Is(err, errAnimationTooLarge) {
+		t.Fatalf("err = %v, want errAnimationTooLarge", err)
+	}
+}
+
+func TestWalkAnimationRejectsFrameBudget(t *testing.T) {
+	// 4096x4096 的画布，帧数超过预算
+	g := &gif.GIF{Config: image.Config{Width: 4096, Height: 4096}}
+	palette := color.Palette{color.Black, color.White}
+	for i := 0; i < maxAnimationBudget/(4096*4096)+1; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeAnimation(buf.Bytes(), nil); !errors.Is(err, errAnimationTooLarge) {
+		t.Fatalf("err = %v, want errAnimationTooLarge", err)
+	}
+}
+
+func TestDecodeAnimationGIF(t *testing.T) {
+	g := &gif.GIF{LoopCount: 0}
+	palette := color.Palette{color.Transparent, color.White}
+	for i := 0; i < 3; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 8, 8), palette))
+		g.Delay = append(g.Delay, 5)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := decodeAnimation(buf.Bytes(), func(img image.Image) image.Image { return img })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim.width != 8 || anim.height != 8 || len(anim.frames) != 3 || anim.duration() != 150 {
+		t.Fatalf("got %dx%d frames=%d duration=%d", anim.width, anim.height, len(anim.frames), anim.duration())
+	}
+}
@@ -1,29 +1,43 @@
 package service
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // User 表示系统用户
 type User struct {
 	ID        string
 	Username  string
-	Password  string
+	Password  string // bcrypt 哈希；旧版本保存的明文密码会在下次登录成功时自动迁移
 	CreatedAt time.Time
 }
 
+// AuthConfig 认证配置
+type AuthConfig struct {
+	// BcryptCost 密码哈希的 bcrypt 计算成本，调整后旧哈希会在下次登录时自动重新计算
+	BcryptCost int
+}
+
 // AuthService 认证服务
 type AuthService struct {
-	users map[string]*User
-	mutex sync.RWMutex
+	users    map[string]*User
+	mutex    sync.RWMutex
 	userFile string // 用户数据文件路径
+	cost     int    // bcrypt 计算成本
+
+	// dummyHash 用户不存在时参与比较的哈希，使响应时间与用户存在时一致
+	dummyHash []byte
 }
 
 // loadUsers 从文件加载用户数据
@@ -62,11 +76,18 @@ func (s *AuthService) saveUsers() error {
 }
 
 // NewAuthService 创建一个新的认证服务实例
-func NewAuthService() *AuthService {
+func NewAuthService(cfg AuthConfig) *AuthService {
+	cost := cfg.BcryptCost
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+
 	service := &AuthService{
 		users: make(map[string]*User),
+		cost:  cost,
 	}
-	
+	service.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("go-image"), cost)
+
 	// 从文件加载用户数据
 	if err := service.loadUsers(); err != nil {
 		// 如果文件不存在，创建空的用户数据
@@ -81,16 +102,59 @@ func NewAuthService() *AuthService {
 // Authenticate 验证用户凭据
 func (s *AuthService) Authenticate(username, password string) (*User, error) {
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
 	user, exists := s.users[username]
-	if !exists || user.Password != password {
+	var stored string
+	if exists {
+		stored = user.Password
+	}
+	s.mutex.RUnlock()
+
+	if !exists {
+		// 即使用户不存在也执行一次哈希比较，避免通过响应时间枚举用户名
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return nil, errors.New("用户名或密码不正确")
+	}
+
+	cost, err := bcrypt.Cost([]byte(stored))
+	if err != nil {
+		// 旧版本保存的明文密码，使用常量时间比较
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+			return nil, errors.New("用户名或密码不正确")
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) != nil {
 		return nil, errors.New("用户名或密码不正确")
 	}
 
+	// 明文密码或计算成本与配置不一致时重新计算哈希
+	if err != nil || cost != s.cost {
+		if err := s.rehashPassword(username, stored, password); err != nil {
+			// 重新计算失败不影响本次登录
+			log.Printf("更新用户 %s 的密码哈希失败: %v", username, err)
+		}
+	}
+
 	return user, nil
 }
 
+// rehashPassword 使用当前配置的计算成本重新计算用户的密码哈希
+func (s *AuthService) rehashPassword(username, oldPassword, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[username]
+	if !exists || user.Password != oldPassword {
+		// 密码已被并发修改，放弃本次更新
+		return nil
+	}
+	user.Password = string(hash)
+	return s.saveUsers()
+}
+
 // GetUser 获取用户信息
 func (s *AuthService) GetUser(username string) (*User, error) {
 	s.mutex.RLock()
@@ -104,6 +168,32 @@ func (s *AuthService) GetUser(username string) (*User, error) {
 	return user, nil
 }
 
+// GetUserByID 根据用户ID获取用户信息
+func (s *AuthService) GetUserByID(id string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+
+	return nil, errors.New("用户不存在")
+}
+
+// ListUserIDs 列出所有用户的ID
+func (s *AuthService) ListUserIDs() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ids := make([]string, 0, len(s.users))
+	for _, user := range s.users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
 // Register 注册新用户
 func (s *AuthService) Register(username, password string) error {
 	s.mutex.Lock()
@@ -113,13 +203,26 @@ func (s *AuthService) Register(username, password string) error {
 		return errors.New("用户名已存在")
 	}
 
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return fmt.Errorf("密码加密失败: %w", err)
+	}
+
 	s.users[username] = &User{
 		ID:        uuid.New().String(),
 		Username:  username,
-		Password:  password, // 在实际应用中应该使用加密密码
+		Password:  string(hash),
 		CreatedAt: time.Now(),
 	}
 
 	// 保存用户数据到文件
 	return s.saveUsers()
 }
+
+// EnsureUser 用户不存在时创建该用户，用于初始化默认管理员账户
+func (s *AuthService) EnsureUser(username, password string) error {
+	if _, err := s.GetUser(username); err == nil {
+		return nil
+	}
+	return s.Register(username, password)
+}
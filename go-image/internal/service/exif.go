@@ -0,0 +1,636 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+	"time"
+
+	"go-image/internal/storage"
+	"go-image/internal/webp"
+)
+
+// EXIF 标签
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagLensModel          = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// errMalformedImage 图片的容器结构无法解析
+var errMalformedImage = errors.New("图片结构不完整")
+
+// decodeImage 解码图片，并按 EXIF 方向旋转或翻转为正确的显示方向
+//
+// maxPixels 大于 0 时先读取文件头中声明的尺寸，超过上限时不解码，返回 ErrTooManyPixels。
+func decodeImage(data []byte, maxPixels int64) (image.Image, string, error) {
+	if err := checkPixels(data, maxPixels); err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		// x/image/webp 不支持动图，动图 WebP 使用第一帧
+		if poster, posterErr := posterFrame(data); posterErr == nil {
+			return poster, "webp", nil
+		}
+		return nil, "", err
+	}
+	if format == "webp" {
+		img = webp.ConvertColors(img)
+	}
+	orientation := 1
+	if info := readExif(data); info != nil {
+		orientation = info.Orientation
+	}
+	return orientImage(img, orientation), format, nil
+}
+
+// checkPixels 按文件头中声明的尺寸检查像素数，无法读取尺寸时交给解码处理
+func checkPixels(data []byte, maxPixels int64) error {
+	if maxPixels <= 0 {
+		return nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return ErrTooManyPixels
+	}
+	return nil
+}
+
+// readExif 读取图片中的 EXIF 信息，没有 EXIF 或其中没有可用的信息时返回 nil
+//
+// 支持 JPEG（APP1）、PNG（eXIf）和 WebP（EXIF 块）。EXIF 损坏时尽量返回已经解析出的字段。
+func readExif(data []byte) *storage.ExifInfo {
+	raw := findExif(data)
+	if raw == nil {
+		return nil
+	}
+	r, ifd0, err := newTIFFReader(raw)
+	if err != nil {
+		return nil
+	}
+
+	info := &storage.ExifInfo{}
+	entries, _ := r.ifd(ifd0)
+	info.CameraMake = r.string(entries[tagMake])
+	info.CameraModel = r.string(entries[tagModel])
+	if orientation := r.uint(entries[tagOrientation]); orientation >= 1 && orientation <= 8 {
+		info.Orientation = int(orientation)
+	}
+
+	if offset := r.uint(entries[tagExifIFD]); offset != 0 {
+		exif, _ := r.ifd(offset)
+		info.LensModel = r.string(exif[tagLensModel])
+		info.TakenAt = exifTime(r.string(exif[tagDateTimeOriginal]), r.string(exif[tagOffsetTimeOriginal]))
+	}
+
+	if offset := r.uint(entries[tagGPSIFD]); offset != 0 {
+		gps, _ := r.ifd(offset)
+		info.GPS = gpsInfo(r, gps)
+	}
+
+	if *info == (storage.ExifInfo{}) {
+		return nil
+	}
+	return info
+}
+
+// exifTime 解析 EXIF 时间（2006:01:02 15:04:05），有时区偏移（+08:00）时使用该时区
+func exifTime(value, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	loc := time.Local
+	if offset != "" {
+		if t, err := time.Parse("-07:00", offset); err == nil {
+			loc = t.Location()
+		}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, loc)
+	if err != nil || t.Year() < 1900 {
+		return nil
+	}
+	return &t
+}
+
+// gpsInfo 从 GPS IFD 中读取经纬度和海拔
+func gpsInfo(r *tiffReader, entries map[uint16]tiffEntry) *storage.GPSInfo {
+	lat := degrees(r.rationals(entries[tagGPSLatitude]))
+	lon := degrees(r.rationals(entries[tagGPSLongitude]))
+	if math.IsNaN(lat) || math.IsNaN(lon) || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return nil
+	}
+	if strings.EqualFold(r.string(entries[tagGPSLatitudeRef]), "S") {
+		lat = -lat
+	}
+	if strings.EqualFold(r.string(entries[tagGPSLongitudeRef]), "W") {
+		lon = -lon
+	}
+
+	gps := &storage.GPSInfo{Latitude: lat, Longitude: lon}
+	if altitude := r.rationals(entries[tagGPSAltitude]); len(altitude) == 1 && !math.IsNaN(altitude[0]) {
+		// 海拔参考为 1 表示海平面以下
+		if ref := entries[tagGPSAltitudeRef]; len(ref.value) > 0 && ref.value[0] == 1 {
+			altitude[0] = -altitude[0]
+		}
+		gps.Altitude = &altitude[0]
+	}
+	return gps
+}
+
+// degrees 将度、分、秒转换为十进制度数
+func degrees(dms []float64) float64 {
+	if len(dms) != 3 {
+		return math.NaN()
+	}
+	return dms[0] + dms[1]/60 + dms[2]/3600
+}
+
+// orientImage 按 EXIF 方向（1-8）旋转或翻转图片，使其以正确的方向显示
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Rect, img, bounds.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 沿主对角线翻转
+				dx, dy = y, x
+			case 6: // 顺时针旋转 90°
+				dx, dy = h-1-y, x
+			case 7: // 沿副对角线翻转
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转 90°
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// stripMetadata 移除图片中的 EXIF、XMP 和 IPTC 信息
+//
+// 图片内容不重新编码。orientation 大于 1 时写入只包含方向的 EXIF，保证原图仍以正确的方向显示。
+// 不支持的格式（例如 GIF）原样返回。
+func stripMetadata(data []byte, mimeType string, orientation int) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(data, orientation)
+	case "image/png":
+		return stripPNG(data, orientation)
+	case "image/webp":
+		return stripWebP(data, orientation)
+	default:
+		return data, nil
+	}
+}
+
+// canStripMetadata 是否支持移除该类型图片的元数据
+func canStripMetadata(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
+
+// minimalExif 只包含方向的 EXIF（TIFF 格式，大端序）
+func minimalExif(orientation int) []byte {
+	buf := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1}
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], tagOrientation)
+	binary.BigEndian.PutUint16(entry[2:], 3) // SHORT
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], uint16(orientation))
+	buf = append(buf, entry...)
+	return append(buf, 0, 0, 0, 0) // 没有下一个 IFD
+}
+
+// findExif 根据文件头找出 EXIF 数据（从 TIFF 头开始）
+func findExif(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		segments, _, err := splitJPEG(data)
+		if err != nil {
+			return nil
+		}
+		for _, seg := range segments {
+			if seg.marker == 0xE1 && bytes.HasPrefix(seg.payload(), exifHeader) {
+				return seg.payload()[len(exifHeader):]
+			}
+		}
+	case bytes.HasPrefix(data, pngSignature):
+		chunks, err := splitPNG(data)
+		if err != nil {
+			return nil
+		}
+		for _, chunk := range chunks {
+			if chunk.kind == "eXIf" {
+				return chunk.payload()
+			}
+		}
+	case isWebP(data):
+		chunks, err := splitWebP(data)
+		if err != nil {
+			return nil
+		}
+		for _, chunk := range chunks {
+			if chunk.kind == "EXIF" {
+				// 部分软件写入的 EXIF 块带有 JPEG 的 Exif 头
+				return bytes.TrimPrefix(chunk.payload, exifHeader)
+			}
+		}
+	}
+	return nil
+}
+
+// tiffReader 读取 TIFF 格式的 EXIF 数据
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// tiffEntry IFD 中的一项
+type tiffEntry struct {
+	kind  uint16 // 数据类型
+	count uint32
+	value []byte
+}
+
+// tiffTypeSizes TIFF 各数据类型的字节数
+var tiffTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8,
+}
+
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, errMalformedImage
+	}
+	r := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, 0, errMalformedImage
+	}
+	if r.order.Uint16(data[2:]) != 42 {
+		return nil, 0, errMalformedImage
+	}
+	return r, r.order.Uint32(data[4:]), nil
+}
+
+// ifd 读取指定偏移处的 IFD
+func (r *tiffReader) ifd(offset uint32) (map[uint16]tiffEntry, error) {
+	entries := make(map[uint16]tiffEntry)
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return entries, errMalformedImage
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+	pos := int(offset) + 2
+	for i := 0; i < count; i++ {
+		if pos+12 > len(r.data) {
+			return entries, errMalformedImage
+		}
+		raw := r.data[pos : pos+12]
+		pos += 12
+
+		tag := r.order.Uint16(raw[0:])
+		kind := r.order.Uint16(raw[2:])
+		n := r.order.Uint32(raw[4:])
+		size, ok := tiffTypeSizes[kind]
+		if !ok {
+			continue
+		}
+		length := uint64(size) * uint64(n)
+
+		var value []byte
+		if length <= 4 {
+			value = raw[8 : 8+length]
+		} else {
+			start := uint64(r.order.Uint32(raw[8:]))
+			if start+length > uint64(len(r.data)) {
+				continue
+			}
+			value = r.data[start : start+length]
+		}
+		entries[tag] = tiffEntry{kind: kind, count: n, value: value}
+	}
+	return entries, nil
+}
+
+// string 读取 ASCII 类型的值
+func (r *tiffReader) string(e tiffEntry) string {
+	if e.kind != 2 {
+		return ""
+	}
+	value := e.value
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(strings.ToValidUTF8(string(value), ""))
+}
+
+// uint 读取 SHORT 或 LONG 类型的第一个值
+func (r *tiffReader) uint(e tiffEntry) uint32 {
+	switch {
+	case e.kind == 3 && len(e.value) >= 2:
+		return uint32(r.order.Uint16(e.value))
+	case e.kind == 4 && len(e.value) >= 4:
+		return r.order.Uint32(e.value)
+	}
+	return 0
+}
+
+// rationals 读取 RATIONAL 类型的所有值，分母为 0 的值为 NaN
+func (r *tiffReader) rationals(e tiffEntry) []float64 {
+	if e.kind != 5 {
+		return nil
+	}
+	values := make([]float64, 0, e.count)
+	for i := 0; i+8 <= len(e.value); i += 8 {
+		num, den := r.order.Uint32(e.value[i:]), r.order.Uint32(e.value[i+4:])
+		if den == 0 {
+			values = append(values, math.NaN())
+			continue
+		}
+		values = append(values, float64(num)/float64(den))
+	}
+	return values
+}
+
+// exifHeader JPEG APP1 段中 EXIF 数据的前缀
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegSegment JPEG 图像数据之前的一个标记段
+type jpegSegment struct {
+	marker byte
+	raw    []byte // 整个段，包括标记和长度
+}
+
+// payload 段的内容，不包括标记和长度
+func (s jpegSegment) payload() []byte {
+	if len(s.raw) < 4 {
+		return nil
+	}
+	return s.raw[4:]
+}
+
+// splitJPEG 将 JPEG 拆分为扫描数据之前的标记段和从 SOS 开始的其余部分
+func splitJPEG(data []byte) ([]jpegSegment, []byte, error) {
+	var segments []jpegSegment
+	i := 2
+	for i+2 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, nil, errMalformedImage
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // 填充字节
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9: // SOS、EOI
+			return segments, data[i:], nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // 没有长度的标记
+			segments = append(segments, jpegSegment{marker: marker, raw: data[i : i+2]})
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{marker: marker, raw: data[i:end]})
+		i = end
+	}
+	return nil, nil, errMalformedImage
+}
+
+// stripJPEG 移除 APP1（EXIF、XMP）、APP13（IPTC）和注释段
+func stripJPEG(data []byte, orientation int) ([]byte, error) {
+	segments, rest, err := splitJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var exif []byte
+	if orientation > 1 {
+		payload := append(append([]byte(nil), exifHeader...), minimalExif(orientation)...)
+		exif = []byte{0xFF, 0xE1, 0, 0}
+		binary.BigEndian.PutUint16(exif[2:], uint16(len(payload)+2))
+		exif = append(exif, payload...)
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	for _, seg := range segments {
+		// EXIF 段放在 JFIF 段之后、其他段之前
+		if exif != nil && seg.marker != 0xE0 {
+			out = append(out, exif...)
+			exif = nil
+		}
+		switch seg.marker {
+		case 0xE1, 0xED, 0xFE:
+			continue
+		}
+		out = append(out, seg.raw...)
+	}
+	out = append(out, exif...)
+	return append(out, rest...), nil
+}
+
+// pngSignature PNG 文件头
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunk PNG 数据块
+type pngChunk struct {
+	kind string
+	raw  []byte // 整个块，包括长度、类型和 CRC
+}
+
+// payload 块的内容
+func (c pngChunk) payload() []byte {
+	return c.raw[8 : len(c.raw)-4]
+}
+
+// splitPNG 将 PNG 拆分为数据块
+func splitPNG(data []byte) ([]pngChunk, error) {
+	var chunks []pngChunk
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+12 > len(data) {
+			return nil, errMalformedImage
+		}
+		end := uint64(i) + 12 + uint64(binary.BigEndian.Uint32(data[i:]))
+		if end > uint64(len(data)) {
+			return nil, errMalformedImage
+		}
+		chunk := pngChunk{kind: string(data[i+4 : i+8]), raw: data[i:end]}
+		chunks = append(chunks, chunk)
+		i = int(end)
+		if chunk.kind == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// newPNGChunk 构建 PNG 数据块
+func newPNGChunk(kind string, payload []byte) []byte {
+	raw := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(raw, uint32(len(payload)))
+	copy(raw[4:], kind)
+	raw = append(raw, payload...)
+	return binary.BigEndian.AppendUint32(raw, crc32.ChecksumIEEE(raw[4:]))
+}
+
+// stripPNG 移除 eXIf 和文本块（XMP 保存在 iTXt 块中）
+func stripPNG(data []byte, orientation int) ([]byte, error) {
+	chunks, err := splitPNG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	for _, chunk := range chunks {
+		switch chunk.kind {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			continue
+		}
+		out = append(out, chunk.raw...)
+		// eXIf 必须位于图像数据之前，紧跟在 IHDR 之后
+		if chunk.kind == "IHDR" && orientation > 1 {
+			out = append(out, newPNGChunk("eXIf", minimalExif(orientation))...)
+		}
+	}
+	return out, nil
+}
+
+// webpChunk WebP（RIFF）数据块
+type webpChunk struct {
+	kind    string
+	payload []byte
+}
+
+// isWebP 是否为 WebP 文件
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// splitWebP 将 WebP 拆分为数据块
+func splitWebP(data []byte) ([]webpChunk, error) {
+	if !isWebP(data) {
+		return nil, errMalformedImage
+	}
+	var chunks []webpChunk
+	i := 12
+	for i+8 <= len(data) {
+		size := uint64(binary.LittleEndian.Uint32(data[i+4:]))
+		end := uint64(i) + 8 + size
+		if end > uint64(len(data)) {
+			return nil, errMalformedImage
+		}
+		chunks = append(chunks, webpChunk{kind: string(data[i : i+4]), payload: data[i+8 : end]})
+		// 块的长度为奇数时后面有一个填充字节
+		i = int(end + size%2)
+	}
+	return chunks, nil
+}
+
+// VP8X 块中表示包含 EXIF 和 XMP 的标志位
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP 移除 EXIF 和 XMP 块，简单格式（没有 VP8X 块）的 WebP 不会包含元数据
+func stripWebP(data []byte, orientation int) ([]byte, error) {
+	chunks, err := splitWebP(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].kind != "VP8X" || len(chunks[0].payload) < 1 {
+		return data, nil
+	}
+
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	for _, chunk := range chunks {
+		payload := chunk.payload
+		switch chunk.kind {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			payload = append([]byte(nil), payload...)
+			payload[0] &^= webpFlagEXIF | webpFlagXMP
+			if orientation > 1 {
+				payload[0] |= webpFlagEXIF
+			}
+		}
+		out = appendWebPChunk(out, chunk.kind, payload)
+	}
+	// EXIF 块位于图像数据之后
+	if orientation > 1 {
+		out = appendWebPChunk(out, "EXIF", minimalExif(orientation))
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// appendWebPChunk 追加 WebP 数据块
+func appendWebPChunk(out []byte, kind string, payload []byte) []byte {
+	out = append(out, kind...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
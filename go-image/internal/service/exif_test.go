@@ -0,0 +1,359 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"go-image/internal/webp"
+)
+
+// testExif 构建大端序的 EXIF：IFD0 包含厂商、方向和指向 exifIFD 的偏移，
+// 偏移 56 处的 Exif IFD 包含拍摄时间
+func testExif(orientation int, exifIFD uint32) []byte {
+	b := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	entry := func(tag, kind uint16, count, value uint32) {
+		b = binary.BigEndian.AppendUint16(b, tag)
+		b = binary.BigEndian.AppendUint16(b, kind)
+		b = binary.BigEndian.AppendUint32(b, count)
+		if kind == 3 {
+			b = binary.BigEndian.AppendUint16(b, uint16(value))
+			b = append(b, 0, 0)
+		} else {
+			b = binary.BigEndian.AppendUint32(b, value)
+		}
+	}
+
+	b = binary.BigEndian.AppendUint16(b, 3)
+	entry(tagMake, 2, 6, 50)
+	entry(tagOrientation, 3, 1, uint32(orientation))
+	entry(tagExifIFD, 4, 1, exifIFD)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, "Canon\x00"...)
+
+	b = binary.BigEndian.AppendUint16(b, 1)
+	entry(tagDateTimeOriginal, 2, 20, 74)
+	b = append(b, 0, 0, 0, 0)
+	return append(b, "2023:05:01 12:00:00\x00"...)
+}
+
+// labelImage 3x2 的图片，每个像素的红色通道依次为 A-F
+func labelImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i, c := range "ABCDEF" {
+		img.SetNRGBA(i%3, i/3, color.NRGBA{R: uint8(c), A: 255})
+	}
+	return img
+}
+
+// labels 按行读出图片中的标签，行之间用 / 分隔
+func labels(img image.Image) string {
+	b := img.Bounds()
+	var rows []string
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row []byte
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			row = append(row, byte(r>>8))
+		}
+		rows = append(rows, string(row))
+	}
+	return strings.Join(rows, "/")
+}
+
+func TestOrientImage(t *testing.T) {
+	for orientation, want := range map[int]string{
+		0: "ABC/DEF", // 无效值不处理
+		1: "ABC/DEF",
+		2: "CBA/FED",  // 水平翻转
+		3: "FED/CBA",  // 旋转 180°
+		4: "DEF/ABC",  // 垂直翻转
+		5: "AD/BE/CF", // 沿主对角线翻转
+		6: "DA/EB/FC", // 顺时针旋转 90°
+		7: "FC/EB/DA", // 沿副对角线翻转
+		8: "CF/BE/AD", // 逆时针旋转 90°
+		9: "ABC/DEF",
+	} {
+		if got := labels(orientImage(labelImage(), orientation)); got != want {
+			t.Errorf("orientation %d: got %s, want %s", orientation, got, want)
+		}
+	}
+}
+
+func TestDecodeImageAppliesOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, labelImage()); err != nil {
+		t.Fatal(err)
+	}
+	// stripPNG 写入只包含方向的 EXIF
+	data, err := stripPNG(buf.Bytes(), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := decodeImage(data, 0)
+	if err != nil || format != "png" {
+		t.Fatalf("decodeImage: %s, %v", format, err)
+	}
+	if got := labels(img); got != "DA/EB/FC" {
+		t.Fatalf("got %s, want DA/EB/FC", got)
+	}
+}
+
+func TestDecodeImagePixelLimit(t *testing.T) {
+	data := testPNG(t, 100, 50)
+	if _, _, err := decodeImage(data, 100*50); err != nil {
+		t.Fatalf("at the limit: %v", err)
+	}
+	if _, _, err := decodeImage(data, 100*50-1); !errors.Is(err, ErrTooManyPixels) {
+		t.Fatalf("over the limit: err = %v, want ErrTooManyPixels", err)
+	}
+
+	// 只有文件头、声明尺寸巨大的 PNG 在解码之前被拒绝
+	ihdr := append([]byte(nil), data[16:29]...)
+	binary.BigEndian.PutUint32(ihdr[0:], 60000)
+	binary.BigEndian.PutUint32(ihdr[4:], 60000)
+	header := append(append([]byte(nil), pngSignature...), newPNGChunk("IHDR", ihdr)...)
+	if err := checkPixels(header, 1<<25); !errors.Is(err, ErrTooManyPixels) {
+		t.Fatalf("declared 60000x60000: err = %v, want ErrTooManyPixels", err)
+	}
+}
+
+func TestReadExif(t *testing.T) {
+	info := readExif(withJPEGExif(t, testExif(6, 56)))
+	if info == nil || info.CameraMake != "Canon" || info.Orientation != 6 || info.TakenAt == nil || info.TakenAt.Year() != 2023 {
+		t.Fatalf("readExif = %+v", info)
+	}
+}
+
+func TestTIFFReaderMalformed(t *testing.T) {
+	valid := testExif(6, 56)
+	cases := map[string]struct {
+		data   []byte
+		offset uint32
+	}{
+		"offset past the end":  {valid, uint32(len(valid))},
+		"offset near 2^32":     {valid, 0xFFFFFFFF},
+		"entry count too high": {valid[:30], 8},
+		"truncated entry":      {valid[:20], 8},
+	}
+	for name, c := range cases {
+		r, _, err := newTIFFReader(c.data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := r.ifd(c.offset); !errors.Is(err, errMalformedImage) {
+			t.Errorf("%s: err = %v, want errMalformedImage", name, err)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"short header":       valid[:7],
+		"unknown byte order": append([]byte("XX"), valid[2:]...),
+		"wrong magic":        append([]byte{'M', 'M', 0, 43}, valid[4:]...),
+	} {
+		if _, _, err := newTIFFReader(data); !errors.Is(err, errMalformedImage) {
+			t.Errorf("%s: err = %v, want errMalformedImage", name, err)
+		}
+	}
+}
+
+func TestReadExifMalformed(t *testing.T) {
+	// Exif IFD 指回 IFD0：只读取一层，不会循环
+	info := readExif(withJPEGExif(t, testExif(3, 8)))
+	if info == nil || info.Orientation != 3 || info.CameraMake != "Canon" {
+		t.Fatalf("looping IFD: %+v", info)
+	}
+
+	// 任意位置截断都不会 panic，值的偏移越界时忽略该项
+	valid := testExif(6, 56)
+	for n := 0; n < len(valid); n++ {
+		readExif(withJPEGExif(t, valid[:n]))
+	}
+	if info := readExif(withJPEGExif(t, valid[:60])); info == nil || info.Orientation != 6 || info.TakenAt != nil {
+		t.Fatalf("truncated Exif IFD: %+v", info)
+	}
+}
+
+// withJPEGExif 编码一张 JPEG，并在 SOI 之后插入包含 exif 的 APP1 段
+func withJPEGExif(t *testing.T, exif []byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, labelImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return insertJPEGSegment(data, 0xE1, append(append([]byte(nil), exifHeader...), exif...))
+}
+
+// insertJPEGSegment 在 SOI 之后插入一个标记段
+func insertJPEGSegment(data []byte, marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	out := append([]byte(nil), data[:2]...)
+	out = append(out, seg...)
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	data := withJPEGExif(t, testExif(6, 56))
+	data = insertJPEGSegment(data, 0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))
+	data = insertJPEGSegment(data, 0xFE, []byte("comment"))
+
+	for _, orientation := range []int{1, 6} {
+		stripped, err := stripMetadata(data, "image/jpeg", orientation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments, _, err := splitJPEG(stripped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app1 := 0
+		for _, seg := range segments {
+			switch seg.marker {
+			case 0xE1:
+				app1++
+			case 0xFE:
+				t.Errorf("orientation %d: comment segment kept", orientation)
+			}
+		}
+
+		info := readExif(stripped)
+		switch orientation {
+		case 1:
+			if app1 != 0 || info != nil {
+				t.Errorf("orientation 1: %d APP1 segments, exif %+v", app1, info)
+			}
+		default:
+			// 只保留方向
+			if app1 != 1 || info == nil || info.Orientation != 6 || info.CameraMake != "" || info.TakenAt != nil {
+				t.Errorf("orientation 6: %d APP1 segments, exif %+v", app1, info)
+			}
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+			t.Errorf("orientation %d: stripped JPEG does not decode: %v", orientation, err)
+		}
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, labelImage()); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := splitPNG(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IHDR 之后插入 eXIf 和文本块
+	data := append([]byte(nil), pngSignature...)
+	data = append(data, chunks[0].raw...)
+	data = append(data, newPNGChunk("eXIf", testExif(6, 56))...)
+	data = append(data, newPNGChunk("tEXt", []byte("Comment\x00hello"))...)
+	data = append(data, newPNGChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))...)
+	for _, chunk := range chunks[1:] {
+		data = append(data, chunk.raw...)
+	}
+	if info := readExif(data); info == nil || info.CameraMake != "Canon" {
+		t.Fatalf("test PNG exif = %+v", info)
+	}
+
+	stripped, err := stripMetadata(data, "image/png", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err = splitPNG(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		switch chunk.kind {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			t.Errorf("%s chunk kept", chunk.kind)
+		}
+	}
+	img, err := png.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped PNG does not decode: %v", err)
+	}
+	if got := labels(img); got != "ABC/DEF" {
+		t.Fatalf("pixels changed: %s", got)
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	for mimeType, data := range map[string][]byte{
+		"image/jpeg": {0xFF, 0xD8, 0xFF, 0xE1, 0x10, 0x00, 'E', 'x'},
+		"image/png":  append(append([]byte(nil), pngSignature...), 0, 0, 0, 100, 'I', 'H', 'D', 'R'),
+	} {
+		if _, err := stripMetadata(data, mimeType, 1); !errors.Is(err, errMalformedImage) {
+			t.Errorf("%s: err = %v, want errMalformedImage", mimeType, err)
+		}
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	// 带透明度时编码为扩展格式，之后追加 EXIF 和 XMP 块
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[20] |= webpFlagEXIF | webpFlagXMP
+	data = appendWebPChunk(data, "EXIF", testExif(6, 56))
+	data = appendWebPChunk(data, "XMP ", []byte("<x:xmpmeta/>"))
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	if info := readExif(data); info == nil || info.CameraMake != "Canon" {
+		t.Fatalf("test WebP exif = %+v", info)
+	}
+
+	for _, orientation := range []int{1, 6} {
+		stripped, err := stripMetadata(data, "image/webp", orientation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks, err := splitWebP(stripped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exifChunks := 0
+		for _, chunk := range chunks {
+			switch chunk.kind {
+			case "EXIF":
+				exifChunks++
+			case "XMP ":
+				t.Errorf("orientation %d: XMP chunk kept", orientation)
+			}
+		}
+		flags := chunks[0].payload[0]
+		if flags&webpFlagXMP != 0 || (flags&webpFlagEXIF != 0) != (orientation > 1) {
+			t.Errorf("orientation %d: VP8X flags %#x", orientation, flags)
+		}
+
+		info := readExif(stripped)
+		switch orientation {
+		case 1:
+			if exifChunks != 0 || info != nil {
+				t.Errorf("orientation 1: %d EXIF chunks, exif %+v", exifChunks, info)
+			}
+		default:
+			if exifChunks != 1 || info == nil || info.Orientation != 6 || info.CameraMake != "" {
+				t.Errorf("orientation 6: %d EXIF chunks, exif %+v", exifChunks, info)
+			}
+		}
+		decoded, format, err := image.Decode(bytes.NewReader(stripped))
+		if err != nil || format != "webp" || decoded.Bounds() != img.Bounds() {
+			t.Errorf("orientation %d: stripped WebP does not decode: %s, %v", orientation, format, err)
+		}
+	}
+}
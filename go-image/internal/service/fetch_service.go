@@ -0,0 +1,283 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"go-image/internal/storage"
+)
+
+// 从网址导入图片相关错误
+var (
+	ErrInvalidURL      = errors.New("图片地址不合法，仅支持 http 和 https")
+	ErrAddressBlocked  = errors.New("不允许访问内网或本机地址")
+	ErrTooManyRedirect = errors.New("重定向次数过多")
+	ErrFetchFailed     = errors.New("下载图片失败")
+)
+
+// FetchConfig 从网址导入图片的配置
+type FetchConfig struct {
+	// Timeout 下载的总超时时间，包括连接、重定向和读取内容
+	Timeout time.Duration
+	// MaxRedirects 最多跟随的重定向次数
+	MaxRedirects int
+	// AllowedNetworks 允许访问的内网地址段（CIDR），用于测试或导入内网图床中的图片
+	AllowedNetworks []*net.IPNet
+}
+
+// blockedNetworks 默认禁止访问的地址段：本机、内网、链路本地、运营商级 NAT、文档和保留地址等
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"100::/64",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// imageExtensions 图片类型对应的扩展名，第一个为默认扩展名
+var imageExtensions = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg", ".jpe"},
+	"image/png":  {".png"},
+	"image/gif":  {".gif"},
+	"image/webp": {".webp"},
+}
+
+// FetchService 下载网址中的图片并保存
+//
+// 所有连接在建立时检查实际连接的 IP，DNS 解析到内网地址或重定向到内网地址同样会被拒绝。
+// 下载的内容交给 ImageService.UploadFile 完成校验、保存和缩略图生成。
+type FetchService struct {
+	images *ImageService
+	client *http.Client
+	config FetchConfig
+}
+
+// NewFetchService 创建一个新的网址导入服务实例
+func NewFetchService(images *ImageService, cfg FetchConfig) *FetchService {
+	s := &FetchService{images: images, config: cfg}
+
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: s.checkConn,
+	}
+	s.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// 不使用环境变量中的代理，否则检查的是代理的地址
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: cfg.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return ErrTooManyRedirect
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidURL
+			}
+			return nil
+		},
+	}
+	return s
+}
+
+// checkConn 在建立连接之前检查目标地址，address 为解析后的 IP 和端口
+func (s *FetchService) checkConn(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.allowedIP(ip) {
+		return ErrAddressBlocked
+	}
+	return nil
+}
+
+// allowedIP 是否允许连接到 ip
+func (s *FetchService) allowedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range s.config.AllowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Import 下载 rawURL 中的图片并保存为用户的图片
+func (s *FetchService) Import(userID string, rawURL string, opts UploadOptions) (*storage.ImageInfo, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	if u.User != nil {
+		// 不转发地址中的用户名和密码
+		return nil, ErrInvalidURL
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("Accept", "image/*")
+	req.Header.Set("User-Agent", "Go-Image/1.0")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		for _, target := range []error{ErrAddressBlocked, ErrTooManyRedirect, ErrInvalidURL} {
+			if errors.Is(err, target) {
+				return nil, target
+			}
+		}
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w: 下载超时", ErrFetchFailed)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: 服务器返回 %s", ErrFetchFailed, resp.Status)
+	}
+
+	// 声明的大小超过限制时不再下载
+	maxSize := s.images.MaxSize()
+	if resp.ContentLength > 0 {
+		if err := s.images.CheckUpload(userID, resp.ContentLength); err != nil {
+			return nil, err
+		}
+	}
+
+	// 实际内容最多读取 maxSize+1 字节，超过时拒绝
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w: 下载超时", ErrFetchFailed)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	// 按内容判断类型，不信任服务器返回的 Content-Type
+	contentType := detectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, ErrUnsupportedType
+	}
+
+	filename := remoteFilename(resp, contentType)
+	return s.images.UploadFile(userID, filename, int64(len(data)), bytes.NewReader(data), opts)
+}
+
+// remoteFilename 图片的文件名：优先使用 Content-Disposition，其次使用最终地址中的文件名，
+// 扩展名与实际类型不一致时补上正确的扩展名
+func remoteFilename(resp *http.Response, contentType string) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	}
+	if name == "" || name == "." || name == "/" {
+		name = path.Base(resp.Request.URL.Path)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "image"
+	}
+
+	exts := imageExtensions[contentType]
+	if len(exts) == 0 {
+		return name
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range exts {
+		if ext == e {
+			return name
+		}
+	}
+	return name + exts[0]
+}
+
+// isTimeout 是否为超时错误
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// unwrapURLError 去掉 *url.Error 中的请求方法和地址，只保留原因
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// ParseCIDRs 解析 CIDR 列表，单个 IP 视为只包含该地址的地址段
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的地址 %q", v)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("无效的地址段 %q", v)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+func mustParseCIDRs(values ...string) []*net.IPNet {
+	networks, err := ParseCIDRs(values)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+// 拦截盗链请求的方式
+const (
+	HotlinkActionBlock       = "block"       // 返回 403
+	HotlinkActionPlaceholder = "placeholder" // 返回占位图
+	HotlinkActionRedirect    = "redirect"    // 重定向到指定的网址
+)
+
+// maxHotlinkDomains 允许和禁止列表各自的条目上限
+const maxHotlinkDomains = 100
+
+// hotlinkDomainPattern 来源域名的写法：example.com 或 *.example.com，也可以是 IPv4 地址
+var hotlinkDomainPattern = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// HotlinkSettings 防盗链设置
+//
+// 请求的来源取自 Referer 请求头，没有时使用 Origin。本站页面的请求始终允许；
+// 来源在禁止列表中时拦截；允许列表不为空时只允许列表中的来源。
+type HotlinkSettings struct {
+	// Custom 使用自己的防盗链设置，为 false 时使用服务器的默认设置（仅用于用户设置）
+	Custom bool `json:"custom"`
+	// Enabled 是否开启防盗链
+	Enabled bool `json:"enabled"`
+	// AllowEmptyReferer 允许没有来源的请求（直接打开链接、部分浏览器和应用）
+	AllowEmptyReferer bool `json:"allow_empty_referer"`
+	// Allowed 允许的来源域名，*.example.com 匹配所有子域名
+	Allowed []string `json:"allowed"`
+	// Denied 禁止的来源域名，优先于允许列表
+	Denied []string `json:"denied"`
+	// Action 拦截方式：block、placeholder 或 redirect
+	Action string `json:"action"`
+	// RedirectURL Action 为 redirect 时重定向到的网址
+	RedirectURL string `json:"redirect_url"`
+}
+
+// Validate 校验并整理防盗链设置，域名统一为小写并去掉重复的条目
+func (h *HotlinkSettings) Validate() error {
+	var err error
+	if h.Allowed, err = normalizeHotlinkDomains(h.Allowed); err != nil {
+		return err
+	}
+	if h.Denied, err = normalizeHotlinkDomains(h.Denied); err != nil {
+		return err
+	}
+
+	h.RedirectURL = strings.TrimSpace(h.RedirectURL)
+	switch h.Action {
+	case HotlinkActionBlock, HotlinkActionPlaceholder:
+	case HotlinkActionRedirect:
+		u, err := url.Parse(h.RedirectURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: 重定向网址必须是以 http:// 或 https:// 开头的完整网址", ErrInvalidSettings)
+		}
+	default:
+		return fmt.Errorf("%w: 防盗链拦截方式必须是 block、placeholder 或 redirect", ErrInvalidSettings)
+	}
+	return nil
+}
+
+// normalizeHotlinkDomains 校验来源域名列表
+func normalizeHotlinkDomains(domains []string) ([]string, error) {
+	if len(domains) > maxHotlinkDomains {
+		return nil, fmt.Errorf("%w: 来源域名不能超过 %d 个", ErrInvalidSettings, maxHotlinkDomains)
+	}
+
+	seen := make(map[string]bool, len(domains))
+	result := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain == "" || seen[domain] {
+			continue
+		}
+		if !hotlinkDomainPattern.MatchString(domain) {
+			return nil, fmt.Errorf("%w: 来源域名 %q 不合法，只填写域名，例如 example.com 或 *.example.com", ErrInvalidSettings, domain)
+		}
+		seen[domain] = true
+		result = append(result, domain)
+	}
+	return result, nil
+}
+
+// HotlinkConfig 防盗链服务配置
+type HotlinkConfig struct {
+	// Defaults 用户没有使用自己的设置时的防盗链设置
+	Defaults HotlinkSettings
+	// Placeholder 拦截时返回的占位图文件，为空时使用内置的占位图
+	Placeholder string
+}
+
+// HotlinkResult 防盗链检查的结果
+type HotlinkResult struct {
+	// Protected 图片开启了防盗链，响应内容与请求来源有关
+	Protected bool
+	// Allowed 是否允许访问
+	Allowed bool
+	// Action 不允许访问时的拦截方式
+	Action string
+	// RedirectURL Action 为 redirect 时重定向到的网址
+	RedirectURL string
+}
+
+// HotlinkProtector 按上传者的防盗链设置检查访问 /i/:id 的请求来源
+type HotlinkProtector struct {
+	defaults        HotlinkSettings
+	settings        *SettingsService
+	placeholder     []byte
+	placeholderType string
+}
+
+// NewHotlinkProtector 创建一个新的防盗链服务实例，配置的占位图无法读取时返回错误
+func NewHotlinkProtector(cfg HotlinkConfig, settings *SettingsService) (*HotlinkProtector, error) {
+	p := &HotlinkProtector{
+		defaults: cfg.Defaults,
+		settings: settings,
+	}
+
+	if cfg.Placeholder != "" {
+		data, err := os.ReadFile(cfg.Placeholder)
+		if err != nil {
+			return nil, fmt.Errorf("读取防盗链占位图失败: %w", err)
+		}
+		contentType := http.DetectContentType(data)
+		if !IsSupportedImageType(contentType) {
+			return nil, fmt.Errorf("防盗链占位图 %s 不是支持的图片格式", cfg.Placeholder)
+		}
+		p.placeholder, p.placeholderType = data, contentType
+		return p, nil
+	}
+
+	data, err := renderHotlinkPlaceholder()
+	if err != nil {
+		return nil, fmt.Errorf("生成防盗链占位图失败: %w", err)
+	}
+	p.placeholder, p.placeholderType = data, "image/png"
+	return p, nil
+}
+
+// Settings 用户实际使用的防盗链设置
+func (p *HotlinkProtector) Settings(userID string) HotlinkSettings {
+	if settings := p.settings.Get(userID).Hotlink; settings.Custom {
+		return settings
+	}
+	return p.defaults
+}
+
+// Check 检查访问 userID 的图片的请求来源，host 为请求的 Host，来自本站页面的请求始终允许
+func (p *HotlinkProtector) Check(userID, referer, origin, host string) HotlinkResult {
+	settings := p.Settings(userID)
+	if !settings.Enabled {
+		return HotlinkResult{Allowed: true}
+	}
+
+	result := HotlinkResult{
+		Protected:   true,
+		Action:      settings.Action,
+		RedirectURL: settings.RedirectURL,
+	}
+
+	source := referer
+	if source == "" {
+		source = origin
+	}
+	if source == "" {
+		result.Allowed = settings.AllowEmptyReferer
+		return result
+	}
+
+	// 无法解析的来源（例如 Origin: null）不匹配任何域名
+	sourceHost := ""
+	if u, err := url.Parse(source); err == nil {
+		sourceHost = strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	}
+	switch {
+	case sourceHost != "" && sourceHost == requestHostname(host):
+		result.Allowed = true
+	case matchHotlinkDomain(settings.Denied, sourceHost):
+		result.Allowed = false
+	default:
+		result.Allowed = len(settings.Allowed) == 0 || matchHotlinkDomain(settings.Allowed, sourceHost)
+	}
+	return result
+}
+
+// Placeholder 拦截时返回的占位图及其类型
+func (p *HotlinkProtector) Placeholder() ([]byte, string) {
+	return p.placeholder, p.placeholderType
+}
+
+// requestHostname 去掉 Host 中的端口
+func requestHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// matchHotlinkDomain host 是否匹配列表中的域名，*.example.com 只匹配子域名
+func matchHotlinkDomain(domains []string, host string) bool {
+	if host == "" {
+		return false
+	}
+	for _, domain := range domains {
+		if suffix, ok := strings.CutPrefix(domain, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// renderHotlinkPlaceholder 生成内置的占位图：灰色背景上居中显示提示文字
+func renderHotlinkPlaceholder() ([]byte, error) {
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	mark, err := renderWatermarkText(f, "Hotlinking not allowed")
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, 480, 270))
+	draw.Draw(canvas, canvas.Rect, image.NewUniform(color.RGBA{R: 0x95, G: 0xa5, B: 0xa6, A: 0xff}), image.Point{}, draw.Src)
+	img := drawWatermark(canvas, mark, WatermarkSettings{Position: "center", Opacity: 1, Scale: 0.8})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
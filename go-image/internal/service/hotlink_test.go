@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchHotlinkDomain(t *testing.T) {
+	domains := []string{"example.com", "*.cdn.example.org", "192.0.2.1"}
+	cases := map[string]bool{
+		"example.com":          true,
+		"www.example.com":      false, // 没有通配符时不匹配子域名
+		"badexample.com":       false,
+		"example.com.evil.net": false,
+		"img.cdn.example.org":  true,
+		"a.b.cdn.example.org":  true,
+		"cdn.example.org":      false, // *.cdn.example.org 只匹配子域名
+		"evilcdn.example.org":  false,
+		"192.0.2.1":            true,
+		"192.0.2.10":           false,
+		"":                     false,
+	}
+	for host, want := range cases {
+		if got := matchHotlinkDomain(domains, host); got != want {
+			t.Errorf("matchHotlinkDomain(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if matchHotlinkDomain(nil, "example.com") {
+		t.Error("empty list matched")
+	}
+}
+
+func TestNormalizeHotlinkDomains(t *testing.T) {
+	got, err := normalizeHotlinkDomains([]string{" Example.COM. ", "example.com", "", "*.CDN.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "example.com" || got[1] != "*.cdn.example.org" {
+		t.Fatalf("got %q", got)
+	}
+	for _, domain := range []string{"https://example.com", "example.com/path", "*example.com", "a.*.example.com", "example.com:8080"} {
+		if _, err := normalizeHotlinkDomains([]string{domain}); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("%q: err = %v, want ErrInvalidSettings", domain, err)
+		}
+	}
+}
+
+func TestHotlinkCheck(t *testing.T) {
+	p := &HotlinkProtector{
+		defaults: HotlinkSettings{
+			Enabled: true,
+			Allowed: []string{"*.example.com"},
+			Denied:  []string{"bad.example.com"},
+			Action:  HotlinkActionBlock,
+		},
+		settings: &SettingsService{settings: map[string]*UserSettings{}},
+	}
+
+	cases := []struct {
+		name            string
+		referer, origin string
+		host            string
+		want            bool
+	}{
+		{"same host", "https://img.test/page", "", "img.test", true},
+		{"same host with port", "http://IMG.test:8080/", "", "img.test:8080", true},
+		{"same host, different port", "http://img.test:9000/", "", "img.test:8080", true},
+		{"allowed subdomain", "https://www.example.com/post", "", "img.test", true},
+		{"denied before allowed", "https://bad.example.com/", "", "img.test", false},
+		{"not in allow list", "https://other.net/", "", "img.test", false},
+		{"origin when no referer", "", "https://www.example.com", "img.test", true},
+		{"unparsable origin", "", "null", "img.test", false},
+		{"empty referer not allowed", "", "", "img.test", false},
+		{"host only in path", "https://other.net/img.test", "", "img.test", false},
+	}
+	for _, c := range cases {
+		result := p.Check("u1", c.referer, c.origin, c.host)
+		if !result.Protected || result.Allowed != c.want {
+			t.Errorf("%s: result = %+v, want allowed=%v", c.name, result, c.want)
+		}
+	}
+
+	// 关闭防盗链时不检查来源
+	p.defaults.Enabled = false
+	if result := p.Check("u1", "https://other.net/", "", "img.test"); result.Protected || !result.Allowed {
+		t.Errorf("disabled: result = %+v", result)
+	}
+}
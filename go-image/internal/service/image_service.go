@@ -2,36 +2,200 @@ package service
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"image"
-	"image/gif"
 	"image/jpeg"
-	"image/png"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/nfnt/resize"
 	"go-image/internal/storage"
 )
 
+// ErrFileTooLarge 上传文件超过大小限制
+var ErrFileTooLarge = errors.New("文件大小超过限制")
+
+// ErrUnsupportedType 上传的文件不是允许的图片类型
+var ErrUnsupportedType = errors.New("不支持的文件类型，仅支持图片文件")
+
+// ErrTooManyPixels 图片声明的尺寸超过像素数上限
+var ErrTooManyPixels = errors.New("图片像素数超过限制")
+
+// ErrInvalidVisibility 可见性不合法
+var ErrInvalidVisibility = errors.New("可见性必须是 public、unlisted 或 private")
+
+// ErrInvalidMetadata 标签或描述不合法
+var ErrInvalidMetadata = errors.New("图片信息不合法")
+
+// MaxBatchSize 批量上传和批量删除一次最多处理的图片数量
+const MaxBatchSize = 50
+
+// ErrBatchTooLarge 批量操作的图片数量超过限制
+var ErrBatchTooLarge = fmt.Errorf("一次最多处理 %d 张图片", MaxBatchSize)
+
+// 标签和描述的长度限制（字符）
+const (
+	maxTags              = 20
+	maxTagLength         = 32
+	maxDescriptionLength = 1000
+)
+
+// supportedImageTypes 可以处理的图片类型
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ImageConfig 图片服务配置
+type ImageConfig struct {
+	MaxSize      int64    // 单个文件大小上限（字节）
+	MaxPixels    int64    // 单张图片的像素数上限，0 表示不限制
+	AllowedTypes []string // 允许上传的MIME类型
+	Thumbnail    ThumbnailConfig
+	Transform    TransformConfig
+	Similar      SimilarConfig
+	Watermark    WatermarkConfig
+	CacheControl CacheControlConfig
+	// DefaultVisibility 上传时未指定可见性的默认值
+	DefaultVisibility string
+	// TrashRetention 删除的图片在回收站中保留多久，之后自动永久删除
+	TrashRetention time.Duration
+}
+
+// CacheControlConfig /i/:id 响应的 Cache-Control，为空时不发送
+type CacheControlConfig struct {
+	// Public 公开图片，内容对所有访问者相同，可以由 CDN 等共享缓存保存
+	Public string `yaml:"public"`
+	// Private 仅链接可见和仅自己可见的图片，以及内容因访问者而不同的响应
+	Private string `yaml:"private"`
+}
+
+// DefaultCacheControlConfig 默认的缓存策略：公开图片缓存一天，其余图片每次向服务器确认
+func DefaultCacheControlConfig() CacheControlConfig {
+	return CacheControlConfig{
+		Public:  "public, max-age=86400",
+		Private: "private, no-cache",
+	}
+}
+
+// ThumbnailConfig 缩略图配置
+type ThumbnailConfig struct {
+	Enabled bool
+	Width   uint
+	Height  uint
+	// Animated 动图生成保留动画的缩略图
+	Animated bool
+}
+
 // ImageService 处理图片相关的业务逻辑
 type ImageService struct {
-	storage storage.Storage
+	storage      storage.Storage
+	quota        *QuotaService
+	settings     *SettingsService
+	maxSize      int64
+	maxPixels    int64
+	allowedTypes map[string]bool
+	thumbnail    ThumbnailConfig
+	transform    TransformConfig
+	similar      SimilarConfig
+	index        *searchIndex
+
+	defaultVisibility string
+	watermarkFont     *fontLoader
+	cacheControl      CacheControlConfig
+
+	trashRetention time.Duration
+	// trashMutex 串行化恢复和永久删除，避免永久删除刚刚恢复的图片
+	trashMutex sync.Mutex
 }
 
 // NewImageService 创建一个新的图片服务实例
-func NewImageService(storage storage.Storage) *ImageService {
+func NewImageService(storage storage.Storage, quota *QuotaService, settings *SettingsService, cfg ImageConfig) *ImageService {
+	allowedTypes := make(map[string]bool)
+	for _, t := range cfg.AllowedTypes {
+		allowedTypes[t] = true
+	}
+
 	return &ImageService{
-		storage: storage,
+		storage:      storage,
+		quota:        quota,
+		settings:     settings,
+		maxSize:      cfg.MaxSize,
+		maxPixels:    cfg.MaxPixels,
+		allowedTypes: allowedTypes,
+		thumbnail:    cfg.Thumbnail,
+		transform:    cfg.Transform,
+		similar:      cfg.Similar,
+		index:        newSearchIndex(storage),
+
+		defaultVisibility: cfg.DefaultVisibility,
+		watermarkFont:     &fontLoader{path: cfg.Watermark.Font},
+		cacheControl:      cfg.CacheControl,
+
+		trashRetention: cfg.TrashRetention,
+	}
+}
+
+// MaxSize 单个文件大小上限（字节）
+func (s *ImageService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// AllowedTypes 允许上传的MIME类型
+func (s *ImageService) AllowedTypes() []string {
+	types := make([]string, 0, len(s.allowedTypes))
+	for t := range s.allowedTypes {
+		types = append(types, t)
 	}
+	sort.Strings(types)
+	return types
+}
+
+// DefaultVisibility 上传时未指定可见性的默认值
+func (s *ImageService) DefaultVisibility() string {
+	return s.defaultVisibility
+}
+
+// UploadOptions 上传选项
+type UploadOptions struct {
+	// Visibility 图片可见性，为空时使用默认值
+	Visibility string
+	// Tags 标签
+	Tags []string
+	// Description 描述
+	Description string
+	// StripMetadata 是否移除原图中的 EXIF、XMP 和 IPTC 信息，为 nil 时使用用户的设置
+	StripMetadata *bool
+	// Watermark 图片单独的水印设置：on、off，为空时使用用户的设置
+	Watermark string
+}
+
+// StripMetadataDefault 用户上传时默认是否移除原图中的元数据
+func (s *ImageService) StripMetadataDefault(userID string) bool {
+	return s.settings.Get(userID).StripMetadata
+}
+
+// WatermarkDefault 用户上传的图片默认是否添加水印
+func (s *ImageService) WatermarkDefault(userID string) bool {
+	_, ok := s.effectiveWatermark(userID, "")
+	return ok
 }
 
 // UploadImage 处理图片上传
-func (s *ImageService) UploadImage(userID string, file *multipart.FileHeader) (*storage.ImageInfo, error) {
+func (s *ImageService) UploadImage(userID string, file *multipart.FileHeader, opts UploadOptions) (*storage.ImageInfo, error) {
 	// 打开上传的文件
 	src, err := file.Open()
 	if err != nil {
@@ -39,29 +203,241 @@ func (s *ImageService) UploadImage(userID string, file *multipart.FileHeader) (*
 	}
 	defer src.Close()
 
-	// 读取文件内容到内存
+	return s.UploadFile(userID, file.Filename, file.Size, src, opts)
+}
+
+// UploadResult 批量上传中单个文件的结果，Err 为 nil 时 Image 为保存的图片
+type UploadResult struct {
+	Filename string
+	Image    *storage.ImageInfo
+	Err      error
+}
+
+// UploadImages 批量上传图片，所有文件使用相同的上传选项
+//
+// 每个文件独立校验和保存，部分文件失败不影响其他文件，结果与 files 的顺序一致。
+// 上传选项不合法或文件数量超过限制时不保存任何文件，直接返回错误。
+func (s *ImageService) UploadImages(userID string, files []*multipart.FileHeader, opts UploadOptions) ([]UploadResult, error) {
+	if len(files) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	if err := s.normalizeUploadOptions(&opts); err != nil {
+		return nil, err
+	}
+
+	results := make([]UploadResult, 0, len(files))
+	for _, file := range files {
+		image, err := s.UploadImage(userID, file, opts)
+		results = append(results, UploadResult{Filename: file.Filename, Image: image, Err: err})
+	}
+	return results, nil
+}
+
+// normalizeUploadOptions 填充默认的可见性，校验并规范化标签和描述
+func (s *ImageService) normalizeUploadOptions(opts *UploadOptions) error {
+	if opts.Visibility == "" {
+		opts.Visibility = s.defaultVisibility
+	}
+	if !storage.IsValidVisibility(opts.Visibility) {
+		return ErrInvalidVisibility
+	}
+	tags, err := normalizeTags(opts.Tags)
+	if err != nil {
+		return err
+	}
+	description, err := normalizeDescription(opts.Description)
+	if err != nil {
+		return err
+	}
+	opts.Tags, opts.Description = tags, description
+	return nil
+}
+
+// CheckUpload 在接收文件内容之前检查大小限制和配额
+func (s *ImageService) CheckUpload(userID string, size int64) error {
+	if s.maxSize > 0 && size > s.maxSize {
+		return ErrFileTooLarge
+	}
+	return s.quota.Check(userID, size)
+}
+
+// reserveUpload 在接收文件内容之前检查大小限制并预占配额，
+// 之后通过 uploadReserved 保存或者调用 releaseUpload 归还
+func (s *ImageService) reserveUpload(userID string, size int64) error {
+	if s.maxSize > 0 && size > s.maxSize {
+		return ErrFileTooLarge
+	}
+	return s.quota.Reserve(userID, size)
+}
+
+// releaseUpload 归还 reserveUpload 预占的空间
+func (s *ImageService) releaseUpload(userID string, size int64) {
+	s.quota.Add(userID, -size)
+}
+
+// UploadFile 校验并保存图片内容，size 为调用方声明的文件大小
+//
+// 所有上传方式（表单、断点续传等）最终都通过这里写入存储。
+func (s *ImageService) UploadFile(userID string, filename string, size int64, content io.Reader, opts UploadOptions) (*storage.ImageInfo, error) {
+	// 检查文件大小
+	if s.maxSize > 0 && size > s.maxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	// 在写入存储之前预占配额
+	if err := s.quota.Reserve(userID, size); err != nil {
+		return nil, err
+	}
+
+	return s.uploadReserved(userID, filename, size, content, opts)
+}
+
+// uploadReserved 保存已经预占了 size 字节配额的上传，失败时归还预占的空间
+func (s *ImageService) uploadReserved(userID string, filename string, size int64, content io.Reader, opts UploadOptions) (*storage.ImageInfo, error) {
+	if err := s.normalizeUploadOptions(&opts); err != nil {
+		s.quota.Add(userID, -size)
+		return nil, err
+	}
+
+	imageInfo, err := s.saveUpload(userID, filename, content, opts)
+	if err != nil {
+		s.quota.Add(userID, -size)
+		return nil, err
+	}
+
+	// 按实际写入的大小修正预占的空间，并计入缩略图
+	s.quota.Add(userID, imageInfo.Size+imageInfo.ThumbSize-size)
+
+	return imageInfo, nil
+}
+
+// saveUpload 校验并保存上传的文件，同时生成缩略图
+func (s *ImageService) saveUpload(userID string, filename string, content io.Reader, opts UploadOptions) (*storage.ImageInfo, error) {
+	// 读取文件内容到内存，实际内容超过大小限制时拒绝
+	if s.maxSize > 0 {
+		content = io.LimitReader(content, s.maxSize+1)
+	}
 	buffer := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buffer, src); err != nil {
+	if _, err := io.Copy(buffer, content); err != nil {
 		return nil, fmt.Errorf("读取文件内容失败: %w", err)
 	}
+	if s.maxSize > 0 && int64(buffer.Len()) > s.maxSize {
+		return nil, ErrFileTooLarge
+	}
 
 	// 验证文件是否为图片
 	fileContent := buffer.Bytes()
 	contentType := detectContentType(fileContent)
-	if !isImageType(contentType) {
-		return nil, errors.New("不支持的文件类型，仅支持图片文件")
+	if !s.allowedTypes[contentType] {
+		return nil, ErrUnsupportedType
+	}
+	// 在解码之前按文件头中声明的尺寸拒绝像素数过多的图片
+	if err := checkPixels(fileContent, s.maxPixels); err != nil {
+		return nil, err
+	}
+
+	// 读取 EXIF，按需移除原图中的元数据（保留方向）
+	exif := readExif(fileContent)
+	strip := s.StripMetadataDefault(userID)
+	if opts.StripMetadata != nil {
+		strip = *opts.StripMetadata
+	}
+	strip = strip && canStripMetadata(contentType)
+	if strip {
+		orientation := 1
+		if exif != nil {
+			orientation = exif.Orientation
+		}
+		stripped, err := stripMetadata(fileContent, contentType, orientation)
+		if err != nil {
+			return nil, fmt.Errorf("移除图片元数据失败: %w", err)
+		}
+		fileContent = stripped
+
+		// 图片信息中同样只保留方向，不记录已移除的拍摄信息
+		exif = nil
+		if orientation > 1 {
+			exif = &storage.ExifInfo{Orientation: orientation}
+		}
+	}
+
+	// 上传时添加水印：保存的原图重新编码，已按 EXIF 方向校正
+	watermarked := false
+	if wm, ok := s.effectiveWatermark(userID, opts.Watermark); ok && wm.Mode == WatermarkModeUpload {
+		data, err := s.watermarkUpload(userID, fileContent, contentType, wm)
+		if err != nil {
+			return nil, fmt.Errorf("添加水印失败: %w", err)
+		}
+		fileContent = data
+		watermarked = true
+		if exif != nil {
+			exif.Orientation = 0
+			if *exif == (storage.ExifInfo{}) {
+				exif = nil
+			}
+		}
+	}
+
+	// 可见性、公开标识、标签等信息随原图一起保存，元数据只写入一次
+	imageInfo := &storage.ImageInfo{
+		UserID:           userID,
+		Filename:         filename,
+		MimeType:         contentType,
+		Visibility:       opts.Visibility,
+		Slug:             newSlug(),
+		Tags:             opts.Tags,
+		Description:      opts.Description,
+		Exif:             exif,
+		MetadataStripped: strip || watermarked,
+		Watermark:        opts.Watermark,
+		Watermarked:      watermarked,
+	}
+
+	// 按 EXIF 方向解码图片，用于记录尺寸、计算感知哈希和生成缩略图；无法解码的图片只保存原图
+	img, format, decodeErr := decodeImage(fileContent, s.maxPixels)
+	if decodeErr == nil {
+		imageInfo.Width, imageInfo.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		imageInfo.PHash = formatPerceptualHash(perceptualHash(img))
+	}
+
+	// 动图逐帧解码，记录帧数和总时长；需要动态缩略图时同时缩放每一帧
+	var anim *animation
+	if decodeErr == nil && (format == "gif" || format == "webp") {
+		var transform func(image.Image) image.Image
+		if s.thumbnail.Enabled && s.thumbnail.Animated {
+			transform = func(frame image.Image) image.Image {
+				return resize.Thumbnail(s.thumbnail.Width, s.thumbnail.Height, frame, resize.Lanczos3)
+			}
+		}
+		var err error
+		anim, err = decodeAnimation(fileContent, transform)
+		switch {
+		case err == nil:
+			imageInfo.Width, imageInfo.Height = anim.width, anim.height
+			imageInfo.Frames, imageInfo.Duration = len(anim.delays), anim.duration()
+		case !errors.Is(err, errNotAnimated):
+			log.Printf("解码动图失败: %v", err)
+		}
 	}
 
 	// 保存原始图片
-	imageInfo, err := s.storage.Save(userID, file.Filename, contentType, bytes.NewReader(fileContent))
-	if err != nil {
+	if err := s.storage.Save(imageInfo, bytes.NewReader(fileContent)); err != nil {
 		return nil, fmt.Errorf("保存图片失败: %w", err)
 	}
+	s.index.Put(imageInfo)
 
 	// 生成缩略图
-	if err := s.generateThumbnail(imageInfo, fileContent); err != nil {
-		// 如果生成缩略图失败，记录错误但不影响上传
-		fmt.Printf("生成缩略图失败: %v\n", err)
+	if s.thumbnail.Enabled && decodeErr == nil {
+		var err error
+		if anim != nil && len(anim.frames) > 0 {
+			err = s.generateAnimatedThumbnail(imageInfo, anim, format)
+		} else {
+			err = s.generateThumbnail(imageInfo, img, format)
+		}
+		if err != nil {
+			// 如果生成缩略图失败，记录错误但不影响上传
+			log.Printf("生成缩略图失败: %v", err)
+		}
 	}
 
 	return imageInfo, nil
@@ -72,78 +448,338 @@ func (s *ImageService) GetImage(userID string, id string) (*storage.ImageInfo, e
 	return s.storage.Get(userID, id)
 }
 
-// DeleteImage 删除图片
-func (s *ImageService) DeleteImage(userID string, id string) error {
-	return s.storage.Delete(userID, id)
+// PublicImage 按ID或公开标识获取允许访问者查看的图片
+//
+// viewerID 为空表示匿名访问。上传者始终可以访问自己的图片；其他访问者只能
+// 通过ID或公开标识访问公开图片，通过公开标识访问不公开列出的图片。
+// 无权访问时与图片不存在一样返回 storage.ErrImageNotFound，避免泄露图片是否存在。
+func (s *ImageService) PublicImage(ref string, viewerID string) (*storage.ImageInfo, error) {
+	bySlug := false
+	image, err := s.storage.Lookup(ref)
+	if err != nil {
+		if image, err = s.storage.LookupSlug(ref); err != nil {
+			return nil, storage.ErrImageNotFound
+		}
+		bySlug = true
+	}
+
+	if viewerID != "" && image.UserID == viewerID {
+		return image, nil
+	}
+
+	switch image.EffectiveVisibility() {
+	case storage.VisibilityPublic:
+		return image, nil
+	case storage.VisibilityUnlisted:
+		if bySlug {
+			return image, nil
+		}
+	}
+	return nil, storage.ErrImageNotFound
 }
 
-// ListImages 列出所有图片
-func (s *ImageService) ListImages(userID string) ([]*storage.ImageInfo, error) {
-	return s.storage.List(userID)
+// CacheControl 访问者访问图片时响应的 Cache-Control
+//
+// 只有公开图片并且访问者看到的内容与其他人相同时才允许共享缓存：上传者本人看到的是没有实时水印的原图。
+func (s *ImageService) CacheControl(image *storage.ImageInfo, viewerID string) string {
+	if image.EffectiveVisibility() == storage.VisibilityPublic && (viewerID == "" || !s.WatermarkOnServe(image)) {
+		return s.cacheControl.Public
+	}
+	return s.cacheControl.Private
 }
 
-// 生成缩略图
-func (s *ImageService) generateThumbnail(imageInfo *storage.ImageInfo, fileContent []byte) error {
-	// 解码图片
-	img, format, err := image.Decode(bytes.NewReader(fileContent))
+// ETag 图片原文件或缩略图的强 ETag，由内容哈希得到，旧版本上传的图片没有记录哈希时为空
+func (s *ImageService) ETag(image *storage.ImageInfo, thumb bool) string {
+	if image.Hash == "" {
+		return ""
+	}
+	if thumb && image.ThumbPath != "" {
+		// 缩略图在上传时生成，之后不会改变
+		return `"` + image.Hash + `-thumb"`
+	}
+	return `"` + image.Hash + `"`
+}
+
+// ImageUpdate 修改图片设置的请求，字段为 nil 表示不修改
+type ImageUpdate struct {
+	Visibility  *string   `json:"visibility"`
+	Tags        *[]string `json:"tags"`
+	Description *string   `json:"description"`
+	// Watermark 图片单独的水印设置：on、off 或 default（按个人设置），只影响访问时实时添加的水印
+	Watermark *string `json:"watermark"`
+}
+
+// SignedImage 按ID获取签名链接指向的图片，签名已经验证过，不再检查可见性
+func (s *ImageService) SignedImage(id string) (*storage.ImageInfo, error) {
+	return s.storage.Lookup(id)
+}
+
+// UpdateImage 修改图片的可见性、标签和描述
+func (s *ImageService) UpdateImage(userID string, id string, update ImageUpdate) (*storage.ImageInfo, error) {
+	if update.Visibility != nil && !storage.IsValidVisibility(*update.Visibility) {
+		return nil, ErrInvalidVisibility
+	}
+
+	var tags []string
+	var description, watermark string
+	var err error
+	if update.Watermark != nil {
+		if watermark, err = ParseWatermark(*update.Watermark); err != nil {
+			return nil, err
+		}
+	}
+	if update.Tags != nil {
+		if tags, err = normalizeTags(*update.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if update.Description != nil {
+		if description, err = normalizeDescription(*update.Description); err != nil {
+			return nil, err
+		}
+	}
+
+	image, err := s.storage.Get(userID, id)
 	if err != nil {
-		return fmt.Errorf("解码图片失败: %w", err)
+		return nil, err
 	}
 
-	// 调整图片大小
-	thumbnail := resize.Thumbnail(300, 300, img, resize.Lanczos3)
+	if update.Visibility != nil {
+		image.Visibility = *update.Visibility
+		// 旧版本上传的图片没有公开标识
+		if image.Slug == "" {
+			image.Slug = newSlug()
+		}
+	}
+	if update.Tags != nil {
+		image.Tags = tags
+	}
+	if update.Description != nil {
+		image.Description = description
+	}
+	if update.Watermark != nil {
+		image.Watermark = watermark
+	}
 
-	// 创建缩略图文件
-	thumbDir := filepath.Join("static/uploads/thumbnails")
-	if err := os.MkdirAll(thumbDir, 0755); err != nil {
-		return fmt.Errorf("创建缩略图目录失败: %w", err)
+	if err := s.storage.Update(image); err != nil {
+		return nil, err
 	}
+	s.index.Put(image)
+	return image, nil
+}
 
-	// 构建缩略图路径
-	extension := filepath.Ext(imageInfo.Path)
-	thumbPath := filepath.Join(thumbDir, fmt.Sprintf("%s_thumb%s", imageInfo.ID, extension))
-	thumbFile, err := os.Create(thumbPath)
+// DeleteImage 将图片移到回收站，超过保留时间后自动永久删除
+func (s *ImageService) DeleteImage(userID string, id string) error {
+	image, err := s.storage.Trash(userID, id)
 	if err != nil {
-		return fmt.Errorf("创建缩略图文件失败: %w", err)
+		return err
 	}
-	defer thumbFile.Close()
+	s.index.Remove(image)
+
+	// 变换版本随时可以重新生成，现在就清理；原图和缩略图在永久删除之前仍然计入配额
+	s.quota.Add(userID, -s.removeVariants(id))
+	return nil
+}
 
-	// 根据原始图片格式保存缩略图
-	switch format {
-	case "jpeg":
-		err = jpeg.Encode(thumbFile, thumbnail, nil)
-	case "png":
-		err = png.Encode(thumbFile, thumbnail)
-	case "gif":
-		err = gif.Encode(thumbFile, thumbnail, nil)
-	default:
-		// 默认使用JPEG格式
-		err = jpeg.Encode(thumbFile, thumbnail, nil)
+// DeleteResult 批量删除中单张图片的结果
+type DeleteResult struct {
+	ID  string
+	Err error
+}
+
+// DeleteImages 批量将图片移到回收站，重复的ID只处理一次，部分图片删除失败不影响其他图片
+func (s *ImageService) DeleteImages(userID string, ids []string) ([]DeleteResult, error) {
+	if len(ids) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]DeleteResult, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		results = append(results, DeleteResult{ID: id, Err: s.DeleteImage(userID, id)})
 	}
+	return results, nil
+}
 
+// ReconcileUsage 根据实际存储重新统计用户的空间使用量
+//
+// 统计原图、缩略图和缓存的变换版本，包括回收站中的图片。应在没有上传和删除进行时调用，例如启动时。
+func (s *ImageService) ReconcileUsage(userID string) (int64, error) {
+	list, err := s.storage.List(userID, storage.ListOptions{})
 	if err != nil {
+		return 0, err
+	}
+	trash, err := s.storage.ListTrash(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var used int64
+	for _, image := range append(list.Images, trash...) {
+		used += image.Size + s.variantsSize(image.ID)
+
+		thumbSize := image.ThumbSize
+		if thumbSize == 0 && image.ThumbPath != "" {
+			// 旧版本没有记录缩略图大小，读取缩略图获取实际大小
+			if thumb, err := s.storage.OpenThumbnail(image); err == nil {
+				thumbSize, _ = io.Copy(io.Discard, thumb)
+				thumb.Close()
+			}
+		}
+		used += thumbSize
+	}
+
+	return used, s.quota.Set(userID, used)
+}
+
+// StorageUsage 获取用户已使用的空间和配额（字节），配额为 0 表示不限制
+func (s *ImageService) StorageUsage(userID string) (used, limit int64) {
+	return s.quota.Usage(userID)
+}
+
+// OpenImage 打开图片文件，thumb 为 true 时优先打开缩略图
+func (s *ImageService) OpenImage(image *storage.ImageInfo, thumb bool) (io.ReadCloser, error) {
+	if thumb && image.ThumbPath != "" {
+		return s.storage.OpenThumbnail(image)
+	}
+	return s.storage.Open(image)
+}
+
+// ListImages 按搜索条件列出用户的图片，并按 opts 排序、过滤和分页
+//
+// 没有搜索条件时直接交给存储层处理；有搜索条件时先通过搜索索引找出匹配的图片，再在内存中分页。
+func (s *ImageService) ListImages(userID string, query SearchQuery, opts storage.ListOptions) (*storage.ImageList, error) {
+	if query.IsZero() {
+		return s.storage.List(userID, opts)
+	}
+
+	ids, err := s.index.Search(userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]*storage.ImageInfo, 0, len(ids))
+	for _, id := range ids {
+		image, err := s.storage.Get(userID, id)
+		if err != nil {
+			if errors.Is(err, storage.ErrImageNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return storage.PageImages(images, opts)
+}
+
+// 生成缩略图
+func (s *ImageService) generateThumbnail(imageInfo *storage.ImageInfo, img image.Image, format string) error {
+	// 调整图片大小
+	thumbnail := resize.Thumbnail(s.thumbnail.Width, s.thumbnail.Height, img, resize.Lanczos3)
+
+	// 根据原始图片格式编码缩略图，其他格式默认使用JPEG
+	format = formatFromMimeType("image/" + format)
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, thumbnail, format, jpeg.DefaultQuality); err != nil {
+		return fmt.Errorf("编码缩略图失败: %w", err)
+	}
+	return s.saveThumbnail(imageInfo, format, &buf)
+}
+
+// 生成动图缩略图，动画的帧已经在解码时缩放
+func (s *ImageService) generateAnimatedThumbnail(imageInfo *storage.ImageInfo, anim *animation, format string) error {
+	var buf bytes.Buffer
+	if err := encodeAnimation(&buf, anim, format, jpeg.DefaultQuality); err != nil {
 		return fmt.Errorf("编码缩略图失败: %w", err)
 	}
+	return s.saveThumbnail(imageInfo, format, &buf)
+}
 
-	// 更新图片信息，添加缩略图路径
-	imageInfo.ThumbPath = filepath.Base(thumbPath)
+// saveThumbnail 保存缩略图，并在图片信息中记录缩略图路径和大小，format 为缩略图的编码格式
+func (s *ImageService) saveThumbnail(imageInfo *storage.ImageInfo, format string, buf *bytes.Buffer) error {
+	imageInfo.ThumbSize = int64(buf.Len())
+	if err := s.storage.SaveThumbnail(imageInfo, "image/"+format, buf); err != nil {
+		imageInfo.ThumbSize = 0
+		return fmt.Errorf("保存缩略图失败: %w", err)
+	}
 
 	return nil
 }
 
+// normalizeTags 去掉标签两端的空白和重复的标签（不区分大小写），并检查数量和长度
+func normalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("%w: 标签不能超过%d个字符", ErrInvalidMetadata, maxTagLength)
+		}
+		if strings.ContainsAny(tag, ",，") {
+			return nil, fmt.Errorf("%w: 标签不能包含逗号", ErrInvalidMetadata)
+		}
+		seen[strings.ToLower(tag)] = true
+		result = append(result, tag)
+	}
+	if len(result) > maxTags {
+		return nil, fmt.Errorf("%w: 标签不能超过%d个", ErrInvalidMetadata, maxTags)
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// normalizeDescription 去掉描述两端的空白并检查长度
+func normalizeDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return "", fmt.Errorf("%w: 描述不能超过%d个字符", ErrInvalidMetadata, maxDescriptionLength)
+	}
+	return description, nil
+}
+
+// SplitTags 将逗号分隔的标签字符串拆分为标签列表，支持中英文逗号
+func SplitTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '，'
+	})
+}
+
+// ParseStripMetadata 解析上传时的 strip_metadata 参数，为空时返回 nil 表示使用用户的设置
+func ParseStripMetadata(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	strip, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: strip_metadata 必须是 true 或 false", ErrInvalidMetadata)
+	}
+	return &strip, nil
+}
+
+// newSlug 生成不可猜测的公开访问标识
+func newSlug() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
 // 检测内容类型
 func detectContentType(data []byte) string {
 	return http.DetectContentType(data)
 }
 
-// 检查是否为支持的图片类型
-func isImageType(contentType string) bool {
-	supportedTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/png":  true,
-		"image/gif":  true,
-		"image/webp": true,
-	}
-
-	return supportedTypes[contentType]
-}
\ No newline at end of file
+// IsSupportedImageType 检查是否为支持的图片类型
+func IsSupportedImageType(contentType string) bool {
+	return supportedImageTypes[contentType]
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image/internal/storage"
+)
+
+// newTestImageService 在临时目录中创建使用本地存储的图片服务
+//
+// 各个服务把数据保存在工作目录下的 data 中，测试期间切换到临时目录。
+func newTestImageService(t *testing.T, maxSize int64) *ImageService {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	meta, err := storage.NewBoltMetadataStore(filepath.Join(dir, "data", "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	store, err := storage.NewLocalStorage(filepath.Join(dir, "uploads"), "", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quota := NewQuotaService(QuotaConfig{}, NewAuthService(AuthConfig{}))
+	settings := NewSettingsService(UserSettings{})
+	return NewImageService(store, quota, settings, ImageConfig{
+		MaxSize:      maxSize,
+		AllowedTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+		Transform:    DefaultTransformConfig(),
+
+		DefaultVisibility: storage.VisibilityPublic,
+	})
+}
+
+// testPNG 编码一张 width x height 的 PNG
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrQuotaExceeded 超出用户存储空间配额
+var ErrQuotaExceeded = errors.New("超出存储空间限制")
+
+// QuotaConfig 存储配额配置
+type QuotaConfig struct {
+	// Default 默认每个用户的配额（字节），0 表示不限制
+	Default int64
+	// Users 按用户名覆盖默认配额（字节），0 表示不限制
+	Users map[string]int64
+}
+
+// QuotaService 按用户统计存储空间使用量并执行配额限制
+//
+// 使用量包括原图、缩略图和缓存的变换版本，保存在 data/usage.json 中。
+type QuotaService struct {
+	cfg       QuotaConfig
+	users     *AuthService
+	usage     map[string]int64 // userID -> 已使用字节数
+	mutex     sync.RWMutex
+	usageFile string
+
+	// stale 启动时没有使用量文件，需要根据实际存储重新统计
+	stale bool
+}
+
+// NewQuotaService 创建一个新的配额服务实例
+func NewQuotaService(cfg QuotaConfig, users *AuthService) *QuotaService {
+	service := &QuotaService{
+		cfg:       cfg,
+		users:     users,
+		usage:     make(map[string]int64),
+		usageFile: filepath.Join("data", "usage.json"),
+	}
+
+	// 从文件加载使用量数据
+	if err := service.loadUsage(); err != nil {
+		// 如果文件不存在，创建空的使用量数据
+		if os.IsNotExist(err) {
+			service.stale = true
+			service.saveUsage()
+		}
+	}
+
+	return service
+}
+
+// Stale 使用量数据是否需要重新统计
+func (s *QuotaService) Stale() bool {
+	return s.stale
+}
+
+// Limit 获取用户的配额（字节），0 表示不限制
+func (s *QuotaService) Limit(userID string) int64 {
+	if len(s.cfg.Users) > 0 {
+		if user, err := s.users.GetUserByID(userID); err == nil {
+			if limit, ok := s.cfg.Users[user.Username]; ok {
+				return limit
+			}
+		}
+	}
+	return s.cfg.Default
+}
+
+// Usage 获取用户已使用的空间和配额（字节）
+func (s *QuotaService) Usage(userID string) (used, limit int64) {
+	s.mutex.RLock()
+	used = s.usage[userID]
+	s.mutex.RUnlock()
+
+	return used, s.Limit(userID)
+}
+
+// Check 检查用户是否还有足够的空间，不预占
+func (s *QuotaService) Check(userID string, size int64) error {
+	limit := s.Limit(userID)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if limit > 0 && s.usage[userID]+size > limit {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Reserve 在写入文件之前预占空间，超出配额时返回 ErrQuotaExceeded
+//
+// 写入失败时调用方需要用负数调用 Add 归还预占的空间。
+func (s *QuotaService) Reserve(userID string, size int64) error {
+	limit := s.Limit(userID)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	used := s.usage[userID]
+	if limit > 0 && used+size > limit {
+		return ErrQuotaExceeded
+	}
+
+	s.usage[userID] = used + size
+	return s.saveUsage()
+}
+
+// Add 调整用户的使用量，不检查配额
+//
+// 用于缩略图、变换缓存等随原图产生的文件，以及删除时归还空间。
+func (s *QuotaService) Add(userID string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	used := s.usage[userID] + delta
+	if used < 0 {
+		used = 0
+	}
+	s.usage[userID] = used
+	return s.saveUsage()
+}
+
+// Set 直接设置用户的使用量，用于根据实际存储重新统计
+func (s *QuotaService) Set(userID string, used int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.usage[userID] = used
+	return s.saveUsage()
+}
+
+// loadUsage 从文件加载使用量数据
+func (s *QuotaService) loadUsage() error {
+	if err := os.MkdirAll(filepath.Dir(s.usageFile), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.usageFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(&s.usage)
+}
+
+// saveUsage 保存使用量数据到文件
+func (s *QuotaService) saveUsage() error {
+	file, err := os.Create(s.usageFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(s.usage)
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"go-image/internal/storage"
+)
+
+// SearchQuery 图片搜索条件，所有条件同时满足才算匹配
+//
+// 上传时间和类型等过滤条件由 storage.ListOptions 给出，与搜索条件同时生效。
+type SearchQuery struct {
+	// Text 关键词，匹配文件名、标签和描述，多个关键词之间是“且”的关系
+	Text string
+	// Tags 必须全部带有的标签，不区分大小写
+	Tags []string
+}
+
+// IsZero 是否没有任何搜索条件
+func (q SearchQuery) IsZero() bool {
+	return len(searchTerms(q.Text, true)) == 0 && len(q.Tags) == 0
+}
+
+// searchIndex 按用户划分的图片倒排索引
+//
+// 每个用户的索引在第一次搜索时从存储中加载，之后随上传、修改和删除增量更新，
+// 搜索时只需要查找词项和标签，不需要逐张扫描图片。索引同时保存图片的感知哈希，
+// 查找相似图片时只需在内存中比较哈希。
+type searchIndex struct {
+	storage storage.Storage
+
+	mutex sync.Mutex
+	users map[string]*userSearchIndex
+}
+
+// userSearchIndex 单个用户的索引
+type userSearchIndex struct {
+	terms  map[string]map[string]bool // 词项 -> 图片ID
+	sorted []string                   // 有序的词项，用于前缀匹配
+	tags   map[string]map[string]bool // 标签（小写） -> 图片ID
+	docs   map[string]*indexedImage   // 图片ID -> 已索引的内容，用于更新和删除
+}
+
+// indexedImage 已写入索引的图片内容
+type indexedImage struct {
+	terms []string
+	tags  []string
+	// phash 感知哈希，hashed 为 false 表示图片没有感知哈希
+	phash  uint64
+	hashed bool
+}
+
+func newSearchIndex(storage storage.Storage) *searchIndex {
+	return &searchIndex{
+		storage: storage,
+		users:   make(map[string]*userSearchIndex),
+	}
+}
+
+// Put 新增或更新图片的索引，用户的索引尚未加载时忽略，加载时会从存储中读到最新内容
+func (x *searchIndex) Put(image *storage.ImageInfo) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if idx := x.users[image.UserID]; idx != nil {
+		idx.remove(image.ID)
+		idx.add(image)
+	}
+}
+
+// Remove 从索引中删除图片
+func (x *searchIndex) Remove(image *storage.ImageInfo) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if idx := x.users[image.UserID]; idx != nil {
+		idx.remove(image.ID)
+	}
+}
+
+// Search 返回同时满足所有搜索条件的图片ID，顺序不固定
+func (x *searchIndex) Search(userID string, query SearchQuery) ([]string, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	idx, err := x.load(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// candidates 为 nil 表示尚未施加任何条件
+	var candidates map[string]bool
+	for _, tag := range query.Tags {
+		candidates = intersect(candidates, idx.tags[strings.ToLower(strings.TrimSpace(tag))])
+	}
+	for _, term := range searchTerms(query.Text, true) {
+		candidates = intersect(candidates, idx.prefixMatch(term))
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Similar 返回感知哈希与 hash 的汉明距离不超过 maxDistance 的图片ID及其距离
+func (x *searchIndex) Similar(userID string, hash uint64, maxDistance int) (map[string]int, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	idx, err := x.load(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(map[string]int)
+	for id, doc := range idx.docs {
+		if !doc.hashed {
+			continue
+		}
+		if distance := hashDistance(doc.phash, hash); distance <= maxDistance {
+			hits[id] = distance
+		}
+	}
+	return hits, nil
+}
+
+// load 获取用户的索引，第一次使用时从存储中构建，调用方需要持有锁
+func (x *searchIndex) load(userID string) (*userSearchIndex, error) {
+	if idx := x.users[userID]; idx != nil {
+		return idx, nil
+	}
+
+	list, err := x.storage.List(userID, storage.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	images := list.Images
+
+	idx := &userSearchIndex{
+		terms: make(map[string]map[string]bool),
+		tags:  make(map[string]map[string]bool),
+		docs:  make(map[string]*indexedImage, len(images)),
+	}
+	for _, image := range images {
+		idx.add(image)
+	}
+	x.users[userID] = idx
+	return idx, nil
+}
+
+// add 将图片写入索引
+func (idx *userSearchIndex) add(image *storage.ImageInfo) {
+	doc := &indexedImage{}
+
+	text := image.Filename + " " + image.Description + " " + strings.Join(image.Tags, " ")
+	seen := make(map[string]bool)
+	for _, term := range searchTerms(text, false) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		doc.terms = append(doc.terms, term)
+
+		ids := idx.terms[term]
+		if ids == nil {
+			ids = make(map[string]bool)
+			idx.terms[term] = ids
+			// 插入有序词项列表
+			i := sort.SearchStrings(idx.sorted, term)
+			idx.sorted = append(idx.sorted, "")
+			copy(idx.sorted[i+1:], idx.sorted[i:])
+			idx.sorted[i] = term
+		}
+		ids[image.ID] = true
+	}
+
+	for _, tag := range image.Tags {
+		tag = strings.ToLower(tag)
+		if idx.tags[tag] == nil {
+			idx.tags[tag] = make(map[string]bool)
+		}
+		idx.tags[tag][image.ID] = true
+		doc.tags = append(doc.tags, tag)
+	}
+
+	if hash, err := parsePerceptualHash(image.PHash); err == nil {
+		doc.phash, doc.hashed = hash, true
+	}
+
+	idx.docs[image.ID] = doc
+}
+
+// remove 从索引中删除图片，不再被任何图片使用的词项一并删除
+func (idx *userSearchIndex) remove(id string) {
+	doc := idx.docs[id]
+	if doc == nil {
+		return
+	}
+
+	for _, term := range doc.terms {
+		ids := idx.terms[term]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx.terms, term)
+			if i := sort.SearchStrings(idx.sorted, term); i < len(idx.sorted) && idx.sorted[i] == term {
+				idx.sorted = append(idx.sorted[:i], idx.sorted[i+1:]...)
+			}
+		}
+	}
+	for _, tag := range doc.tags {
+		ids := idx.tags[tag]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx.tags, tag)
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// prefixMatch 返回包含以 prefix 开头的词项的图片ID
+func (idx *userSearchIndex) prefixMatch(prefix string) map[string]bool {
+	matched := make(map[string]bool)
+	for i := sort.SearchStrings(idx.sorted, prefix); i < len(idx.sorted) && strings.HasPrefix(idx.sorted[i], prefix); i++ {
+		for id := range idx.terms[idx.sorted[i]] {
+			matched[id] = true
+		}
+	}
+	return matched
+}
+
+// intersect 求交集，a 为 nil 时表示全集
+func intersect(a, b map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	if a == nil {
+		for id := range b {
+			result[id] = true
+		}
+		return result
+	}
+	for id := range a {
+		if b[id] {
+			result[id] = true
+		}
+	}
+	return result
+}
+
+// searchTerms 将文本切分为小写的词项
+//
+// 字母和数字按连续的片段切分；汉字没有分隔符，建索引时同时写入单字和相邻两字，
+// 查询时连续两个及以上的汉字按相邻两字切分，这样任意位置的中文片段都能匹配。
+func searchTerms(text string, query bool) []string {
+	var terms []string
+	var word []rune
+	var han []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			terms = append(terms, string(word))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		switch {
+		case len(han) == 0:
+		case len(han) == 1:
+			terms = append(terms, string(han))
+		default:
+			for i := 0; i+1 < len(han); i++ {
+				if !query {
+					terms = append(terms, string(han[i]))
+				}
+				terms = append(terms, string(han[i:i+2]))
+			}
+			if !query {
+				terms = append(terms, string(han[len(han)-1]))
+			}
+		}
+		han = han[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return terms
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrInvalidSettings 设置不合法
+var ErrInvalidSettings = errors.New("设置不合法")
+
+// MaxWatermarkLogoSize 水印 Logo 的大小上限（字节）
+const MaxWatermarkLogoSize = 1 << 20
+
+// UserSettings 用户的个人设置，用户没有修改过的字段使用服务器配置的默认值
+type UserSettings struct {
+	// StripMetadata 上传时移除原图中的 EXIF、XMP 和 IPTC 信息（位置、相机等）
+	StripMetadata bool `json:"strip_metadata"`
+	// Watermark 水印设置
+	Watermark WatermarkSettings `json:"watermark"`
+	// Hotlink 防盗链设置
+	Hotlink HotlinkSettings `json:"hotlink"`
+}
+
+// SettingsService 保存用户的个人设置，数据保存在 data/settings.json 中
+type SettingsService struct {
+	defaults     UserSettings
+	settings     map[string]*UserSettings // userID -> 设置
+	mutex        sync.RWMutex
+	settingsFile string
+	logoDir      string // 水印 Logo 的保存目录
+}
+
+// NewSettingsService 创建一个新的设置服务实例，defaults 为用户没有保存设置时的默认值
+func NewSettingsService(defaults UserSettings) *SettingsService {
+	service := &SettingsService{
+		defaults:     defaults,
+		settings:     make(map[string]*UserSettings),
+		settingsFile: filepath.Join("data", "settings.json"),
+		logoDir:      filepath.Join("data", "watermarks"),
+	}
+
+	// 从文件加载设置数据
+	if err := service.loadSettings(); err != nil {
+		// 如果文件不存在，创建空的设置数据
+		if os.IsNotExist(err) {
+			service.saveSettings()
+		}
+	}
+
+	return service
+}
+
+// Get 获取用户的设置
+func (s *SettingsService) Get(userID string) UserSettings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.get(userID)
+}
+
+func (s *SettingsService) get(userID string) UserSettings {
+	settings := s.defaults
+	if saved, ok := s.settings[userID]; ok {
+		settings = *saved
+	}
+	// 复制其中的切片，调用者修改返回值（例如把请求解码到返回值上）时不影响保存的设置
+	settings.Hotlink.Allowed = append([]string{}, settings.Hotlink.Allowed...)
+	settings.Hotlink.Denied = append([]string{}, settings.Hotlink.Denied...)
+	return settings
+}
+
+// Update 校验并保存用户的设置，水印 Logo 只能通过 SetWatermarkLogo 修改
+func (s *SettingsService) Update(userID string, settings UserSettings) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings.Watermark.Logo = s.get(userID).Watermark.Logo
+	if err := settings.Watermark.validate(); err != nil {
+		return err
+	}
+	if err := settings.Hotlink.Validate(); err != nil {
+		return err
+	}
+	return s.put(userID, settings)
+}
+
+// SetWatermarkLogo 保存用户上传的水印 Logo，Logo 必须是 PNG 图片
+func (s *SettingsService) SetWatermarkLogo(userID string, data []byte) (UserSettings, error) {
+	if len(data) > MaxWatermarkLogoSize {
+		return UserSettings{}, fmt.Errorf("%w: Logo 不能超过 %dKB", ErrInvalidSettings, MaxWatermarkLogoSize/1024)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		return UserSettings{}, fmt.Errorf("%w: Logo 必须是 PNG 图片", ErrInvalidSettings)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 先写临时文件再重命名，避免读到不完整的 Logo
+	if err := os.MkdirAll(s.logoDir, 0755); err != nil {
+		return UserSettings{}, err
+	}
+	tmp, err := os.CreateTemp(s.logoDir, ".tmp-*")
+	if err != nil {
+		return UserSettings{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return UserSettings{}, err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.WatermarkLogoPath(userID)); err != nil {
+		return UserSettings{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	settings := s.get(userID)
+	settings.Watermark.Logo = hex.EncodeToString(sum[:])[:12]
+	if err := s.put(userID, settings); err != nil {
+		return UserSettings{}, err
+	}
+	return settings, nil
+}
+
+// RemoveWatermarkLogo 删除用户的水印 Logo，使用 Logo 水印时同时关闭默认水印
+func (s *SettingsService) RemoveWatermarkLogo(userID string) (UserSettings, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings := s.get(userID)
+	settings.Watermark.Logo = ""
+	if settings.Watermark.Type == WatermarkTypeImage {
+		settings.Watermark.Enabled = false
+	}
+	if err := s.put(userID, settings); err != nil {
+		return UserSettings{}, err
+	}
+	if err := os.Remove(s.WatermarkLogoPath(userID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除水印 Logo 失败: %v", err)
+	}
+	return settings, nil
+}
+
+// WatermarkLogoPath 用户水印 Logo 的文件路径
+func (s *SettingsService) WatermarkLogoPath(userID string) string {
+	return filepath.Join(s.logoDir, userID+".png")
+}
+
+// WatermarkLogo 读取并解码用户的水印 Logo
+func (s *SettingsService) WatermarkLogo(userID string) (image.Image, error) {
+	file, err := os.Open(s.WatermarkLogoPath(userID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// put 保存用户的设置，写入文件失败时恢复原来的设置，调用者需要持有写锁
+func (s *SettingsService) put(userID string, settings UserSettings) error {
+	old, existed := s.settings[userID]
+	s.settings[userID] = &settings
+	if err := s.saveSettings(); err != nil {
+		if existed {
+			s.settings[userID] = old
+		} else {
+			delete(s.settings, userID)
+		}
+		return err
+	}
+	return nil
+}
+
+// loadSettings 从文件加载设置数据
+//
+// 每个用户的设置先填入默认值再解码，新版本增加的设置项对已有用户同样使用默认值。
+func (s *SettingsService) loadSettings() error {
+	if err := os.MkdirAll(filepath.Dir(s.settingsFile), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.settingsFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(file).Decode(&raw); err != nil {
+		return err
+	}
+	for userID, data := range raw {
+		settings := s.defaults
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return err
+		}
+		s.settings[userID] = &settings
+	}
+	return nil
+}
+
+// saveSettings 保存设置数据到文件
+func (s *SettingsService) saveSettings() error {
+	file, err := os.Create(s.settingsFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(s.settings)
+}
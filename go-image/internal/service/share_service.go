@@ -0,0 +1,516 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"go-image/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 分享类型
+const (
+	ShareTypeImages = "images" // 指定的一张或多张图片
+	ShareTypeAlbum  = "album"  // 相册，访问时显示相册当前的图片
+)
+
+// 分享的状态
+const (
+	ShareStatusActive    = "active"    // 可以访问
+	ShareStatusExpired   = "expired"   // 已过期
+	ShareStatusExhausted = "exhausted" // 已达到访问次数上限
+)
+
+// 分享相关错误
+var (
+	ErrShareNotFound  = errors.New("分享不存在或已撤销")
+	ErrShareExpired   = errors.New("分享已过期")
+	ErrShareExhausted = errors.New("分享已达到访问次数上限")
+	ErrInvalidShare   = errors.New("分享参数不合法")
+	ErrWrongPassword  = errors.New("密码不正确")
+)
+
+// 分享的长度限制
+const (
+	maxShareTitleLength    = 100 // 标题（字符）
+	maxSharePasswordLength = 72  // 密码（字节），bcrypt 只使用前 72 个字节
+)
+
+// maxShareExpiry 分享的最长有效期，同时避免换算成 time.Duration 时溢出
+const maxShareExpiry = 10 * 365 * 24 * time.Hour
+
+// Share 分享链接，通过 /s/:token 访问
+type Share struct {
+	ID     string `json:"id"`
+	Token  string `json:"token"`
+	UserID string `json:"user_id"`
+	Type   string `json:"type"`
+	// ImageIDs 分享的图片，Type 为 images 时有效
+	ImageIDs []string `json:"image_ids,omitempty"`
+	// AlbumID 分享的相册，Type 为 album 时有效
+	AlbumID string `json:"album_id,omitempty"`
+	Title   string `json:"title"`
+	// Password 访问密码的 bcrypt 哈希，为空表示不需要密码
+	Password string `json:"password,omitempty"`
+	// Views 访问次数，同一个浏览器重复打开只计一次
+	Views int `json:"views"`
+	// MaxViews 访问次数上限，0 表示不限制
+	MaxViews     int        `json:"max_views"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastViewedAt *time.Time `json:"last_viewed_at,omitempty"`
+}
+
+// Status 分享当前的状态
+func (s *Share) Status() string {
+	switch {
+	case s.ExpiresAt != nil && !time.Now().Before(*s.ExpiresAt):
+		return ShareStatusExpired
+	case s.MaxViews > 0 && s.Views >= s.MaxViews:
+		return ShareStatusExhausted
+	}
+	return ShareStatusActive
+}
+
+// HasPassword 是否需要密码才能访问
+func (s *Share) HasPassword() bool {
+	return s.Password != ""
+}
+
+// AccessKey 访问凭证的版本，修改密码后之前输入过密码的访问者需要重新输入
+func (s *Share) AccessKey() string {
+	sum := sha256.Sum256([]byte(s.ID + "|" + s.Password))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ShareRequest 创建分享的请求，ImageIDs 和 AlbumID 只能指定一个
+type ShareRequest struct {
+	ImageIDs []string `json:"image_ids"`
+	AlbumID  string   `json:"album_id"`
+	Title    string   `json:"title"`
+	Password string   `json:"password"`
+	// ExpiresIn 有效期（秒），0 表示永不过期
+	ExpiresIn int64 `json:"expires_in"`
+	MaxViews  int   `json:"max_views"`
+}
+
+// ShareUpdate 修改分享的请求，字段为 nil 表示不修改
+type ShareUpdate struct {
+	Title *string `json:"title"`
+	// Password 新的访问密码，空字符串表示取消密码
+	Password *string `json:"password"`
+	// ExpiresIn 从现在起的有效期（秒），0 表示永不过期
+	ExpiresIn *int64 `json:"expires_in"`
+	MaxViews  *int   `json:"max_views"`
+}
+
+// ShareConfig 分享服务配置
+type ShareConfig struct {
+	// BcryptCost 访问密码的 bcrypt 计算成本
+	BcryptCost int
+}
+
+// ShareService 管理图片和相册的分享链接，数据保存在 data/shares.json 中
+type ShareService struct {
+	storage   storage.Storage
+	albums    storage.AlbumStore
+	cost      int
+	shares    map[string]*Share // id -> 分享
+	byToken   map[string]*Share // token -> 分享
+	mutex     sync.RWMutex
+	shareFile string
+}
+
+// NewShareService 创建一个新的分享服务实例
+func NewShareService(storage storage.Storage, cfg ShareConfig) *ShareService {
+	cost := cfg.BcryptCost
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+
+	service := &ShareService{
+		storage:   storage,
+		albums:    storage.Albums(),
+		cost:      cost,
+		shares:    make(map[string]*Share),
+		byToken:   make(map[string]*Share),
+		shareFile: filepath.Join("data", "shares.json"),
+	}
+
+	// 从文件加载分享数据
+	if err := service.loadShares(); err != nil {
+		// 如果文件不存在，创建空的分享数据
+		if os.IsNotExist(err) {
+			service.saveShares()
+		}
+	}
+
+	return service
+}
+
+// CreateShare 为用户自己的图片或相册创建分享
+func (s *ShareService) CreateShare(userID string, req ShareRequest) (*Share, error) {
+	share := &Share{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		MaxViews:  req.MaxViews,
+		CreatedAt: time.Now(),
+	}
+
+	switch {
+	case req.AlbumID != "" && len(req.ImageIDs) > 0:
+		return nil, fmt.Errorf("%w: 只能分享图片或相册中的一种", ErrInvalidShare)
+	case req.AlbumID != "":
+		album, err := s.albums.GetAlbum(req.AlbumID)
+		if err != nil || album.UserID != userID {
+			return nil, storage.ErrAlbumNotFound
+		}
+		share.Type, share.AlbumID = ShareTypeAlbum, album.ID
+		share.Title = album.Name
+	case len(req.ImageIDs) > 0:
+		ids, err := s.ownedImages(userID, req.ImageIDs)
+		if err != nil {
+			return nil, err
+		}
+		share.Type, share.ImageIDs = ShareTypeImages, ids
+	default:
+		return nil, fmt.Errorf("%w: 请选择要分享的图片或相册", ErrInvalidShare)
+	}
+
+	if title := strings.TrimSpace(req.Title); title != "" {
+		share.Title = title
+	}
+	if utf8.RuneCountInString(share.Title) > maxShareTitleLength {
+		return nil, fmt.Errorf("%w: 标题不能超过%d个字符", ErrInvalidShare, maxShareTitleLength)
+	}
+	if err := s.setPassword(share, req.Password); err != nil {
+		return nil, err
+	}
+	if err := setShareLimits(share, &req.ExpiresIn, req.MaxViews); err != nil {
+		return nil, err
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	share.Token = token
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.shares[share.ID] = share
+	s.byToken[share.Token] = share
+	if err := s.saveShares(); err != nil {
+		delete(s.shares, share.ID)
+		delete(s.byToken, share.Token)
+		return nil, err
+	}
+
+	shareCopy := *share
+	return &shareCopy, nil
+}
+
+// ListShares 按创建时间倒序列出用户的分享
+func (s *ShareService) ListShares(userID string) []*Share {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	shares := []*Share{}
+	for _, share := range s.shares {
+		if share.UserID == userID {
+			shareCopy := *share
+			shares = append(shares, &shareCopy)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].CreatedAt.After(shares[j].CreatedAt)
+	})
+	return shares
+}
+
+// GetShare 获取用户的分享
+func (s *ShareService) GetShare(userID, id string) (*Share, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	share, exists := s.shares[id]
+	if !exists || share.UserID != userID {
+		return nil, ErrShareNotFound
+	}
+	shareCopy := *share
+	return &shareCopy, nil
+}
+
+// UpdateShare 修改分享的标题、密码、有效期和访问次数上限
+func (s *ShareService) UpdateShare(userID, id string, update ShareUpdate) (*Share, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	share, exists := s.shares[id]
+	if !exists || share.UserID != userID {
+		return nil, ErrShareNotFound
+	}
+
+	updated := *share
+	if update.Title != nil {
+		title := strings.TrimSpace(*update.Title)
+		if utf8.RuneCountInString(title) > maxShareTitleLength {
+			return nil, fmt.Errorf("%w: 标题不能超过%d个字符", ErrInvalidShare, maxShareTitleLength)
+		}
+		updated.Title = title
+	}
+	if update.Password != nil {
+		if err := s.setPassword(&updated, *update.Password); err != nil {
+			return nil, err
+		}
+	}
+	maxViews := updated.MaxViews
+	if update.MaxViews != nil {
+		maxViews = *update.MaxViews
+	}
+	if err := setShareLimits(&updated, update.ExpiresIn, maxViews); err != nil {
+		return nil, err
+	}
+
+	original := *share
+	*share = updated
+	if err := s.saveShares(); err != nil {
+		*share = original
+		return nil, err
+	}
+
+	shareCopy := *share
+	return &shareCopy, nil
+}
+
+// DeleteShare 撤销分享，链接立即失效
+func (s *ShareService) DeleteShare(userID, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	share, exists := s.shares[id]
+	if !exists || share.UserID != userID {
+		return ErrShareNotFound
+	}
+
+	delete(s.shares, id)
+	delete(s.byToken, share.Token)
+	return s.saveShares()
+}
+
+// Lookup 按链接中的 token 获取分享
+//
+// 分享不存在时返回 ErrShareNotFound；已过期时返回分享和 ErrShareExpired。
+// 访问次数上限由 RecordView 检查，已经访问过的访问者可以继续查看。
+func (s *ShareService) Lookup(token string) (*Share, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	share, exists := s.byToken[token]
+	if !exists {
+		return nil, ErrShareNotFound
+	}
+	shareCopy := *share
+	if share.Status() == ShareStatusExpired {
+		return &shareCopy, ErrShareExpired
+	}
+	return &shareCopy, nil
+}
+
+// CheckPassword 校验访问密码
+func (s *ShareService) CheckPassword(share *Share, password string) error {
+	if !share.HasPassword() {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// RecordView 记录一次访问，达到访问次数上限时返回 ErrShareExhausted
+func (s *ShareService) RecordView(token string) (*Share, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	share, exists := s.byToken[token]
+	if !exists {
+		return nil, ErrShareNotFound
+	}
+	switch share.Status() {
+	case ShareStatusExpired:
+		return nil, ErrShareExpired
+	case ShareStatusExhausted:
+		return nil, ErrShareExhausted
+	}
+
+	now := time.Now()
+	share.Views++
+	share.LastViewedAt = &now
+	if err := s.saveShares(); err != nil {
+		// 保存失败不影响访问，访问次数在下次保存时一并写入
+		log.Printf("保存分享访问次数失败: %v", err)
+	}
+
+	shareCopy := *share
+	return &shareCopy, nil
+}
+
+// ShareImages 分享中当前可以查看的图片，已删除的图片和已移出相册的图片不再显示
+func (s *ShareService) ShareImages(share *Share) ([]*storage.ImageInfo, error) {
+	if share.Type == ShareTypeAlbum {
+		album, err := s.albums.GetAlbum(share.AlbumID)
+		if err != nil || album.UserID != share.UserID {
+			return []*storage.ImageInfo{}, nil
+		}
+		return s.albums.ListAlbumImages(album.ID)
+	}
+
+	images := make([]*storage.ImageInfo, 0, len(share.ImageIDs))
+	for _, id := range share.ImageIDs {
+		image, err := s.storage.Get(share.UserID, id)
+		if err != nil {
+			if errors.Is(err, storage.ErrImageNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// ShareImage 获取分享中的一张图片，图片不在分享中时返回 storage.ErrImageNotFound
+func (s *ShareService) ShareImage(share *Share, imageID string) (*storage.ImageInfo, error) {
+	switch share.Type {
+	case ShareTypeAlbum:
+		image, err := s.storage.Get(share.UserID, imageID)
+		if err != nil || !image.InAlbum(share.AlbumID) {
+			return nil, storage.ErrImageNotFound
+		}
+		return image, nil
+	default:
+		for _, id := range share.ImageIDs {
+			if id == imageID {
+				return s.storage.Get(share.UserID, imageID)
+			}
+		}
+	}
+	return nil, storage.ErrImageNotFound
+}
+
+// ownedImages 检查图片都属于用户，返回去重后的图片ID
+func (s *ShareService) ownedImages(userID string, ids []string) ([]string, error) {
+	if len(ids) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: 一次最多分享 %d 张图片", ErrInvalidShare, MaxBatchSize)
+	}
+
+	result := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, err := s.storage.Get(userID, id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+// setPassword 设置访问密码，password 为空时取消密码
+func (s *ShareService) setPassword(share *Share, password string) error {
+	if password == "" {
+		share.Password = ""
+		return nil
+	}
+	if len(password) > maxSharePasswordLength {
+		return fmt.Errorf("%w: 密码不能超过%d个字节", ErrInvalidShare, maxSharePasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return err
+	}
+	share.Password = string(hash)
+	return nil
+}
+
+// setShareLimits 设置有效期和访问次数上限，expiresIn 为 0 表示永不过期，为 nil 表示不修改有效期
+func setShareLimits(share *Share, expiresIn *int64, maxViews int) error {
+	if maxViews < 0 {
+		return fmt.Errorf("%w: 访问次数上限不能为负数", ErrInvalidShare)
+	}
+	share.MaxViews = maxViews
+
+	switch {
+	case expiresIn == nil:
+	case *expiresIn < 0:
+		return fmt.Errorf("%w: 有效期不能为负数", ErrInvalidShare)
+	case *expiresIn > int64(maxShareExpiry/time.Second):
+		return fmt.Errorf("%w: 有效期不能超过 %d 秒", ErrInvalidShare, int64(maxShareExpiry/time.Second))
+	case *expiresIn == 0:
+		share.ExpiresAt = nil
+	default:
+		expiresAt := time.Now().Add(time.Duration(*expiresIn) * time.Second)
+		share.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// newShareToken 生成分享链接中使用的随机 token
+func newShareToken() (string, error) {
+	raw := make([]byte, 9)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// loadShares 从文件加载分享数据
+func (s *ShareService) loadShares() error {
+	if err := os.MkdirAll(filepath.Dir(s.shareFile), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.shareFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&s.shares); err != nil {
+		return err
+	}
+	for _, share := range s.shares {
+		s.byToken[share.Token] = share
+	}
+	return nil
+}
+
+// saveShares 保存分享数据到文件
+func (s *ShareService) saveShares() error {
+	file, err := os.Create(s.shareFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(s.shares)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestShare 上传一张图片并按 req 创建分享
+func newTestShare(t *testing.T, req ShareRequest) (*ShareService, *Share) {
+	images := newTestImageService(t, 1<<20)
+	data := testPNG(t, 8, 8)
+	image, err := images.UploadFile("u1", "a.png", int64(len(data)), bytes.NewReader(data), UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewShareService(images.storage, ShareConfig{BcryptCost: bcrypt.MinCost})
+	req.ImageIDs = []string{image.ID}
+	share, err := s.CreateShare("u1", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, share
+}
+
+func TestSharePassword(t *testing.T) {
+	s, share := newTestShare(t, ShareRequest{Password: "secret"})
+	if !share.HasPassword() || share.Password == "secret" {
+		t.Fatalf("password stored as %q", share.Password)
+	}
+	if err := s.CheckPassword(share, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("wrong password: err = %v", err)
+	}
+	if err := s.CheckPassword(share, ""); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("empty password: err = %v", err)
+	}
+	if err := s.CheckPassword(share, "secret"); err != nil {
+		t.Fatalf("correct password: %v", err)
+	}
+
+	// 修改密码后之前的访问凭证失效
+	password := "changed"
+	updated, err := s.UpdateShare("u1", share.ID, ShareUpdate{Password: &password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.AccessKey() == share.AccessKey() {
+		t.Fatal("access key unchanged after password change")
+	}
+	if err := s.CheckPassword(updated, "secret"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("old password after change: err = %v", err)
+	}
+
+	// 取消密码
+	password = ""
+	updated, err = s.UpdateShare("u1", share.ID, ShareUpdate{Password: &password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.HasPassword() || s.CheckPassword(updated, "") != nil {
+		t.Fatal("password still required after removal")
+	}
+}
+
+func TestShareViewLimit(t *testing.T) {
+	s, share := newTestShare(t, ShareRequest{MaxViews: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := s.RecordView(share.Token); err != nil {
+			t.Fatalf("view %d: %v", i+1, err)
+		}
+	}
+	if _, err := s.RecordView(share.Token); !errors.Is(err, ErrShareExhausted) {
+		t.Fatalf("view 3: err = %v, want ErrShareExhausted", err)
+	}
+
+	// 已经访问过的访问者仍然可以通过 Lookup 继续查看
+	got, err := s.Lookup(share.Token)
+	if err != nil || got.Views != 2 || got.Status() != ShareStatusExhausted {
+		t.Fatalf("Lookup = %+v, %v", got, err)
+	}
+
+	// 提高上限后可以继续访问
+	maxViews := 3
+	if _, err := s.UpdateShare("u1", share.ID, ShareUpdate{MaxViews: &maxViews}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RecordView(share.Token); err != nil {
+		t.Fatalf("view after raising the limit: %v", err)
+	}
+}
+
+func TestShareExpiry(t *testing.T) {
+	s, share := newTestShare(t, ShareRequest{ExpiresIn: 3600})
+	if share.ExpiresAt == nil || time.Until(*share.ExpiresAt) > time.Hour {
+		t.Fatalf("ExpiresAt = %v", share.ExpiresAt)
+	}
+
+	s.mutex.Lock()
+	past := time.Now().Add(-time.Second)
+	s.shares[share.ID].ExpiresAt = &past
+	s.mutex.Unlock()
+	if _, err := s.Lookup(share.Token); !errors.Is(err, ErrShareExpired) {
+		t.Fatalf("Lookup: err = %v, want ErrShareExpired", err)
+	}
+	if _, err := s.RecordView(share.Token); !errors.Is(err, ErrShareExpired) {
+		t.Fatalf("RecordView: err = %v, want ErrShareExpired", err)
+	}
+
+	// 超出范围的有效期被拒绝，而不是换算时溢出
+	for _, expiresIn := range []int64{-1, int64(maxShareExpiry/time.Second) + 1, 1 << 62} {
+		expiresIn := expiresIn
+		if _, err := s.UpdateShare("u1", share.ID, ShareUpdate{ExpiresIn: &expiresIn}); !errors.Is(err, ErrInvalidShare) {
+			t.Errorf("ExpiresIn %d: err = %v, want ErrInvalidShare", expiresIn, err)
+		}
+	}
+}
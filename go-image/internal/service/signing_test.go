@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestSigner(active string, keys ...string) *URLSigner {
+	cfg := SigningConfig{
+		Keys:          map[string][]byte{},
+		ActiveKey:     active,
+		DefaultExpiry: time.Hour,
+		MaxExpiry:     24 * time.Hour,
+	}
+	for _, id := range keys {
+		cfg.Keys[id] = []byte("secret-" + id)
+	}
+	return NewURLSigner(cfg)
+}
+
+func TestURLSignerRoundTrip(t *testing.T) {
+	s := newTestSigner("k1", "k1")
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	query, err := s.Sign("img", url.Values{"w": {"800"}, "fmt": {"webp"}, "other": {"x"}}, expiresAt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("other") != "" {
+		t.Fatal("unsigned parameter copied into the signed query")
+	}
+	if !Signed(query) {
+		t.Fatal("Signed() = false")
+	}
+	got, err := s.Verify("img", query, "203.0.113.9")
+	if err != nil || !got.Equal(expiresAt) {
+		t.Fatalf("Verify = %v, %v", got, err)
+	}
+}
+
+func TestURLSignerRejectsTampering(t *testing.T) {
+	s := newTestSigner("k1", "k1")
+	query, err := s.Sign("img", url.Values{"w": {"800"}}, time.Now().Add(time.Hour), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]func(url.Values) (string, url.Values){
+		"other image":     func(q url.Values) (string, url.Values) { return "other", q },
+		"changed width":   func(q url.Values) (string, url.Values) { q.Set("w", "1920"); return "img", q },
+		"added parameter": func(q url.Values) (string, url.Values) { q.Set("h", "600"); return "img", q },
+		"later expiry":    func(q url.Values) (string, url.Values) { q.Set("exp", "9999999999"); return "img", q },
+		"duplicate width": func(q url.Values) (string, url.Values) { q.Add("w", "1920"); return "img", q },
+		"unknown key":     func(q url.Values) (string, url.Values) { q.Set("kid", "k2"); return "img", q },
+		"bad ip flag":     func(q url.Values) (string, url.Values) { q.Set("ip", "2"); return "img", q },
+	}
+	for name, tamper := range cases {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = append([]string(nil), v...)
+		}
+		id, q := tamper(q)
+		if _, err := s.Verify(id, q, "203.0.113.9"); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: err = %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
+
+func TestURLSignerExpired(t *testing.T) {
+	s := newTestSigner("k1", "k1")
+	query, err := s.Sign("img", nil, time.Now().Add(-time.Second), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify("img", query, ""); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("err = %v, want ErrSignatureExpired", err)
+	}
+}
+
+func TestURLSignerIPBinding(t *testing.T) {
+	s := newTestSigner("k1", "k1")
+	query, err := s.Sign("img", nil, time.Now().Add(time.Hour), "203.0.113.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("ip") != "1" || query.Get("sig") == "" {
+		t.Fatalf("query = %v", query)
+	}
+
+	for ip, want := range map[string]error{
+		"203.0.113.9":        nil,
+		"::ffff:203.0.113.9": nil,
+		"203.0.113.10":       ErrInvalidSignature,
+		"":                   ErrInvalidSignature,
+	} {
+		if _, err := s.Verify("img", query, ip); !errors.Is(err, want) {
+			t.Errorf("Verify from %q: err = %v, want %v", ip, err, want)
+		}
+	}
+}
+
+func TestURLSignerKeyRotation(t *testing.T) {
+	old := newTestSigner("k1", "k1")
+	query, err := old.Sign("img", nil, time.Now().Add(time.Hour), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新密钥生效后，旧密钥签名的链接仍然有效，直到旧密钥被移除
+	rotated := newTestSigner("k2", "k1", "k2")
+	if _, err := rotated.Verify("img", query, ""); err != nil {
+		t.Fatalf("old key after rotation: %v", err)
+	}
+	fresh, err := rotated.Sign("img", nil, time.Now().Add(time.Hour), "")
+	if err != nil || fresh.Get("kid") != "k2" {
+		t.Fatalf("Sign with new key = %v, %v", fresh, err)
+	}
+
+	removed := newTestSigner("k2", "k2")
+	if _, err := removed.Verify("img", query, ""); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("removed key: err = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestURLSignerDisabledAndExpiry(t *testing.T) {
+	s := newTestSigner("k1")
+	if s.Enabled() {
+		t.Fatal("Enabled() without keys")
+	}
+	if _, err := s.Sign("img", nil, time.Now().Add(time.Hour), ""); !errors.Is(err, ErrSigningDisabled) {
+		t.Fatalf("err = %v, want ErrSigningDisabled", err)
+	}
+
+	if d, err := s.Expiry(0); err != nil || d != time.Hour {
+		t.Fatalf("Expiry(0) = %v, %v", d, err)
+	}
+	for _, d := range []time.Duration{-time.Second, 25 * time.Hour} {
+		if _, err := s.Expiry(d); !errors.Is(err, ErrInvalidExpiry) {
+			t.Errorf("Expiry(%v) err = %v, want ErrInvalidExpiry", d, err)
+		}
+	}
+}
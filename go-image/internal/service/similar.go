@@ -0,0 +1,187 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"github.com/nfnt/resize"
+	"go-image/internal/storage"
+)
+
+// maxHashDistance 64 位感知哈希的最大汉明距离
+const maxHashDistance = 64
+
+// ErrInvalidDistance 相似度阈值不合法
+var ErrInvalidDistance = fmt.Errorf("距离必须在 0-%d 之间", maxHashDistance)
+
+// ErrNoPerceptualHash 图片无法解码，没有感知哈希
+var ErrNoPerceptualHash = errors.New("无法计算该图片的感知哈希")
+
+// SimilarConfig 相似图片检测配置
+type SimilarConfig struct {
+	// MaxDistance 默认的最大汉明距离，距离越小越相似
+	MaxDistance int `yaml:"max_distance"`
+	// WarnOnUpload 上传时列出已有的相似图片
+	WarnOnUpload bool `yaml:"warn_on_upload"`
+}
+
+// DefaultSimilarConfig 默认的相似图片检测配置
+func DefaultSimilarConfig() SimilarConfig {
+	return SimilarConfig{
+		MaxDistance:  10,
+		WarnOnUpload: true,
+	}
+}
+
+// SimilarImage 相似图片及其与原图的汉明距离
+type SimilarImage struct {
+	*storage.ImageInfo
+	Distance int `json:"distance"`
+}
+
+// SimilarImages 列出用户的图片中与指定图片相似的图片，按距离从近到远排列
+//
+// maxDistance 小于 0 时使用配置的默认值。旧版本上传的图片没有感知哈希，查询时补算并保存。
+func (s *ImageService) SimilarImages(userID string, id string, maxDistance int) ([]*SimilarImage, error) {
+	if maxDistance < 0 {
+		maxDistance = s.similar.MaxDistance
+	}
+	if maxDistance > maxHashDistance {
+		return nil, ErrInvalidDistance
+	}
+
+	imageInfo, err := s.storage.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if imageInfo.PHash == "" {
+		if err := s.backfillPerceptualHash(imageInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.findSimilar(imageInfo, maxDistance)
+}
+
+// UploadWarnings 刚上传的图片与已有图片相似时返回这些图片，未开启上传提示时返回 nil
+func (s *ImageService) UploadWarnings(image *storage.ImageInfo) []*SimilarImage {
+	if !s.similar.WarnOnUpload || image.PHash == "" {
+		return nil
+	}
+
+	similar, err := s.findSimilar(image, s.similar.MaxDistance)
+	if err != nil {
+		log.Printf("查找相似图片失败: %v", err)
+		return nil
+	}
+	return similar
+}
+
+// findSimilar 通过搜索索引查找与图片相似的其他图片
+func (s *ImageService) findSimilar(image *storage.ImageInfo, maxDistance int) ([]*SimilarImage, error) {
+	hash, err := parsePerceptualHash(image.PHash)
+	if err != nil {
+		return nil, err
+	}
+
+	hits, err := s.index.Similar(image.UserID, hash, maxDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	similar := make([]*SimilarImage, 0, len(hits))
+	for id, distance := range hits {
+		if id == image.ID {
+			continue
+		}
+		other, err := s.storage.Get(image.UserID, id)
+		if err != nil {
+			if errors.Is(err, storage.ErrImageNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		similar = append(similar, &SimilarImage{ImageInfo: other, Distance: distance})
+	}
+
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].UploadedAt.After(similar[j].UploadedAt)
+	})
+	return similar, nil
+}
+
+// backfillPerceptualHash 为没有感知哈希的图片补算并保存
+func (s *ImageService) backfillPerceptualHash(imageInfo *storage.ImageInfo) error {
+	file, err := s.storage.Open(imageInfo)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	img, _, err := decodeImage(data, s.maxPixels)
+	if err != nil {
+		return ErrNoPerceptualHash
+	}
+
+	imageInfo.PHash = formatPerceptualHash(perceptualHash(img))
+	if err := s.storage.Update(imageInfo); err != nil {
+		return err
+	}
+	s.index.Put(imageInfo)
+	return nil
+}
+
+// perceptualHash 计算图片的差值哈希（dHash）
+//
+// 将图片缩小为 9x8 的灰度图，逐行比较相邻像素的亮度，得到 64 位哈希。缩放、重新压缩
+// 和轻微调色后的图片哈希基本不变，两个哈希的汉明距离越小，图片越相似。
+func perceptualHash(img image.Image) uint64 {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(bounds.Min.X+x+1, bounds.Min.Y+y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// hashDistance 两个感知哈希的汉明距离
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// formatPerceptualHash 将感知哈希编码为16位十六进制字符串
+func formatPerceptualHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parsePerceptualHash 解析十六进制的感知哈希
+func parsePerceptualHash(value string) (uint64, error) {
+	hash, err := strconv.ParseUint(value, 16, 64)
+	if err != nil {
+		return 0, ErrNoPerceptualHash
+	}
+	return hash, nil
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// tokenPrefix 令牌前缀，便于识别和密钥扫描
+const tokenPrefix = "gi_"
+
+// lastUsedInterval 最近使用时间的落盘间隔，避免每次请求都重写文件
+const lastUsedInterval = time.Minute
+
+// APIToken 个人API令牌，只保存令牌的哈希值
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	Hint       string     `json:"hint"` // 令牌开头几位，用于在界面上区分
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired 令牌是否已过期
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// TokenService 个人API令牌服务
+type TokenService struct {
+	tokens    map[string]*APIToken // id -> token
+	byHash    map[string]*APIToken // hash -> token
+	mutex     sync.RWMutex
+	tokenFile string
+}
+
+// NewTokenService 创建一个新的令牌服务实例
+func NewTokenService() *TokenService {
+	service := &TokenService{
+		tokens:    make(map[string]*APIToken),
+		byHash:    make(map[string]*APIToken),
+		tokenFile: filepath.Join("data", "tokens.json"),
+	}
+
+	// 从文件加载令牌数据
+	if err := service.loadTokens(); err != nil {
+		// 如果文件不存在，创建空的令牌数据
+		if os.IsNotExist(err) {
+			service.saveTokens()
+		}
+	}
+
+	return service
+}
+
+// CreateToken 为用户创建令牌，返回的明文令牌只在创建时可见
+func (s *TokenService) CreateToken(userID, name string, expiresAt *time.Time) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.New("令牌名称不能为空")
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	plain := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	token := &APIToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashToken(plain),
+		Hint:      plain[:len(tokenPrefix)+4],
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tokens[token.ID] = token
+	s.byHash[token.Hash] = token
+	if err := s.saveTokens(); err != nil {
+		delete(s.tokens, token.ID)
+		delete(s.byHash, token.Hash)
+		return "", nil, err
+	}
+
+	tokenCopy := *token
+	return plain, &tokenCopy, nil
+}
+
+// ListTokens 按创建时间列出用户的令牌
+func (s *TokenService) ListTokens(userID string) []*APIToken {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var tokens []*APIToken
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			tokenCopy := *token
+			tokens = append(tokens, &tokenCopy)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// RevokeToken 吊销令牌
+func (s *TokenService) RevokeToken(userID, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, exists := s.tokens[id]
+	if !exists || token.UserID != userID {
+		return errors.New("令牌不存在")
+	}
+
+	delete(s.tokens, id)
+	delete(s.byHash, token.Hash)
+	return s.saveTokens()
+}
+
+// ValidateToken 校验明文令牌，成功时记录最近使用时间
+func (s *TokenService) ValidateToken(plain string) (*APIToken, error) {
+	hash := hashToken(plain)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, exists := s.byHash[hash]
+	if !exists {
+		return nil, errors.New("令牌无效")
+	}
+	if token.Expired() {
+		return nil, errors.New("令牌已过期")
+	}
+
+	now := time.Now()
+	persist := token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedInterval
+	token.LastUsedAt = &now
+	if persist {
+		s.saveTokens() // 忽略错误，最近使用时间不影响认证结果
+	}
+
+	tokenCopy := *token
+	return &tokenCopy, nil
+}
+
+// loadTokens 从文件加载令牌数据
+func (s *TokenService) loadTokens() error {
+	if err := os.MkdirAll(filepath.Dir(s.tokenFile), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.tokenFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&s.tokens); err != nil {
+		return err
+	}
+	for _, token := range s.tokens {
+		s.byHash[token.Hash] = token
+	}
+	return nil
+}
+
+// saveTokens 保存令牌数据到文件
+func (s *TokenService) saveTokens() error {
+	file, err := os.Create(s.tokenFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(s.tokens)
+}
+
+// hashToken 计算令牌的 SHA-256 哈希
+//
+// 令牌本身是 256 位随机数，不需要 bcrypt 这类慢哈希。
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,543 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+	"go-image/internal/storage"
+	"go-image/internal/webp"
+
+	// 注册 WebP 解码器，image.Decode 可以直接解码 WebP 图片
+	_ "golang.org/x/image/webp"
+)
+
+// 图片缩放模式
+const (
+	FitContain = "contain" // 等比缩放，完整显示在目标尺寸内
+	FitCover   = "cover"   // 等比缩放并居中裁剪，铺满目标尺寸
+	FitFill    = "fill"    // 拉伸到目标尺寸
+)
+
+// ErrInvalidTransform 变换参数不合法
+var ErrInvalidTransform = errors.New("图片变换参数不合法")
+
+// TransformConfig 图片变换配置
+type TransformConfig struct {
+	// AllowedWidths 允许的宽度列表，防止任意尺寸请求占满缓存
+	AllowedWidths []int `yaml:"allowed_widths"`
+	// AllowedHeights 允许的高度列表
+	AllowedHeights []int `yaml:"allowed_heights"`
+	// AllowedQualities 允许的 JPEG 和 WebP 质量列表，同样用于限制缓存的版本数
+	AllowedQualities []int `yaml:"allowed_qualities"`
+	// CacheDir 变换结果的缓存目录
+	CacheDir string `yaml:"cache_dir"`
+	// MaxVariants 每张图片最多缓存的变换版本数，超过时删除最久没有访问的版本，0 表示不限制
+	MaxVariants int `yaml:"max_variants"`
+	// AutoWebP 没有指定输出格式时，按 Accept 请求头为支持 WebP 的客户端返回 WebP 版本的变换和缩略图
+	AutoWebP bool `yaml:"auto_webp"`
+}
+
+// DefaultTransformConfig 默认图片变换配置
+func DefaultTransformConfig() TransformConfig {
+	return TransformConfig{
+		AllowedWidths:    []int{100, 200, 300, 400, 600, 800, 1024, 1280, 1600, 1920},
+		AllowedHeights:   []int{100, 200, 300, 400, 600, 800, 1024, 1280, 1600, 1920},
+		AllowedQualities: []int{50, 60, 70, 75, 80, 85, 90, 95},
+		CacheDir:         "data/variants",
+		MaxVariants:      20,
+		AutoWebP:         true,
+	}
+}
+
+// TransformOptions 图片变换参数
+type TransformOptions struct {
+	Width   int    // 目标宽度，0 表示按比例计算
+	Height  int    // 目标高度，0 表示按比例计算
+	Fit     string // 缩放模式
+	Quality int    // JPEG 和 WebP 质量（1-100）
+	Format  string // 输出格式：jpeg、png、gif、webp，为空时保持原格式
+	Poster  bool   // 动图只输出合成后的第一帧
+	// Watermark 按上传者的设置实时添加水印，不属于变换参数，只添加水印时同样会生成新的版本
+	Watermark bool
+
+	watermark WatermarkSettings // 实际使用的水印设置，由 OpenVariant 填充
+}
+
+// IsZero 是否没有任何变换
+func (o *TransformOptions) IsZero() bool {
+	return o.Width == 0 && o.Height == 0 && o.Quality == 0 && o.Format == "" && !o.Poster
+}
+
+// validate 校验变换参数并填充默认值
+func (o *TransformOptions) validate(cfg TransformConfig) error {
+	if o.Width < 0 || o.Height < 0 {
+		return fmt.Errorf("%w: 尺寸不能为负数", ErrInvalidTransform)
+	}
+	if o.Width != 0 && !containsInt(cfg.AllowedWidths, o.Width) {
+		return fmt.Errorf("%w: 不允许的宽度 %d", ErrInvalidTransform, o.Width)
+	}
+	if o.Height != 0 && !containsInt(cfg.AllowedHeights, o.Height) {
+		return fmt.Errorf("%w: 不允许的高度 %d", ErrInvalidTransform, o.Height)
+	}
+
+	switch o.Fit {
+	case "":
+		o.Fit = FitContain
+	case FitContain, FitCover, FitFill:
+	default:
+		return fmt.Errorf("%w: 不支持的缩放模式 %s", ErrInvalidTransform, o.Fit)
+	}
+
+	switch {
+	case o.Quality == 0:
+		o.Quality = jpeg.DefaultQuality
+	case !containsInt(cfg.AllowedQualities, o.Quality):
+		return fmt.Errorf("%w: 不允许的质量 %d", ErrInvalidTransform, o.Quality)
+	}
+
+	switch o.Format {
+	case "", "jpeg", "png", "gif", "webp":
+	case "jpg":
+		o.Format = "jpeg"
+	case "avif":
+		// 目前没有可用的纯 Go AVIF 编码器
+		return fmt.Errorf("%w: 暂不支持 AVIF 输出", ErrInvalidTransform)
+	default:
+		return fmt.Errorf("%w: 不支持的输出格式 %s", ErrInvalidTransform, o.Format)
+	}
+
+	return nil
+}
+
+// ValidateTransform 校验变换参数是否被允许，不修改 opts
+func (s *ImageService) ValidateTransform(opts TransformOptions) error {
+	return opts.validate(s.transform)
+}
+
+// cacheName 变换结果在缓存目录中的文件名
+func (o *TransformOptions) cacheName() string {
+	suffix := ""
+	if o.Poster {
+		suffix = "_poster"
+	}
+	if o.Watermark {
+		// 水印设置修改后生成新的缓存
+		suffix += "_wm" + o.watermark.key()
+	}
+	return fmt.Sprintf("%dx%d_%s_q%d%s.%s", o.Width, o.Height, o.Fit, o.Quality, suffix, o.Format)
+}
+
+// OpenVariant 打开图片的变换版本，结果会缓存在磁盘上
+//
+// 动图输出为 GIF 或 WebP 时逐帧变换并保留动画，输出为其他格式或指定 Poster 时使用合成后的第一帧。
+// 指定 Watermark 且图片需要实时添加水印时，在变换后的图片上添加水印。
+// 返回的文件支持 Seek，第二个返回值为变换后的 MIME 类型。
+func (s *ImageService) OpenVariant(imageInfo *storage.ImageInfo, opts TransformOptions) (*os.File, string, error) {
+	quality := opts.Quality
+	if opts.Watermark {
+		opts.watermark, opts.Watermark = s.serveWatermark(imageInfo)
+	}
+	if err := opts.validate(s.transform); err != nil {
+		return nil, "", err
+	}
+	if opts.Watermark && quality == 0 {
+		// 只添加水印的原图尽量保持原来的画质，不受允许的质量列表限制
+		opts.Quality = watermarkQuality
+	}
+	if opts.Format == "" {
+		opts.Format = formatFromMimeType(imageInfo.MimeType)
+	}
+
+	return s.openCached(imageInfo, opts.cacheName(), opts.Format, func() ([]byte, error) {
+		// 读取原图
+		src, err := s.storage.Open(imageInfo)
+		if err != nil {
+			return nil, fmt.Errorf("打开原图失败: %w", err)
+		}
+		data, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取原图失败: %w", err)
+		}
+
+		process := func(img image.Image) image.Image {
+			return transformImage(img, opts)
+		}
+		if opts.Watermark {
+			mark, err := s.renderWatermark(imageInfo.UserID, opts.watermark)
+			if err != nil {
+				return nil, fmt.Errorf("生成水印失败: %w", err)
+			}
+			process = func(img image.Image) image.Image {
+				return drawWatermark(transformImage(img, opts), mark, opts.watermark)
+			}
+		}
+
+		var buf bytes.Buffer
+		if imageInfo.Frames > 1 && !opts.Poster && (opts.Format == "gif" || opts.Format == "webp") {
+			anim, err := decodeAnimation(data, process)
+			if err != nil {
+				return nil, fmt.Errorf("解码动图失败: %w", err)
+			}
+			if err := encodeAnimation(&buf, anim, opts.Format, opts.Quality); err != nil {
+				return nil, fmt.Errorf("编码动图失败: %w", err)
+			}
+			return buf.Bytes(), nil
+		}
+
+		var img image.Image
+		if imageInfo.Frames > 1 {
+			img, err = posterFrame(data)
+		} else {
+			img, _, err = decodeImage(data, s.maxPixels)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解码图片失败: %w", err)
+		}
+
+		if err := encodeImage(&buf, process(img), opts.Format, opts.Quality); err != nil {
+			return nil, fmt.Errorf("编码图片失败: %w", err)
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// OpenThumbnailAs 打开转换为指定格式或添加了水印的缩略图，结果与变换一起缓存
+//
+// format 为空时保持缩略图原来的格式；watermark 为 true 且图片需要实时添加水印时添加水印，动图缩略图逐帧添加。
+func (s *ImageService) OpenThumbnailAs(imageInfo *storage.ImageInfo, format string, watermark bool) (*os.File, string, error) {
+	if imageInfo.ThumbPath == "" {
+		return nil, "", errors.New("图片没有缩略图")
+	}
+	if format == "" {
+		format = formatFromMimeType(imageInfo.MimeType)
+	}
+	var wm WatermarkSettings
+	if watermark {
+		wm, watermark = s.serveWatermark(imageInfo)
+	}
+	name := "thumb." + format
+	if watermark {
+		name = "thumb_wm" + wm.key() + "." + format
+	}
+
+	return s.openCached(imageInfo, name, format, func() ([]byte, error) {
+		src, err := s.storage.OpenThumbnail(imageInfo)
+		if err != nil {
+			return nil, fmt.Errorf("打开缩略图失败: %w", err)
+		}
+		data, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取缩略图失败: %w", err)
+		}
+
+		process := func(img image.Image) image.Image { return img }
+		if watermark {
+			mark, err := s.renderWatermark(imageInfo.UserID, wm)
+			if err != nil {
+				return nil, fmt.Errorf("生成水印失败: %w", err)
+			}
+			process = func(img image.Image) image.Image {
+				return drawWatermark(img, mark, wm)
+			}
+		}
+
+		var buf bytes.Buffer
+		if imageInfo.Frames > 1 && (format == "gif" || format == "webp") {
+			anim, err := decodeAnimation(data, process)
+			switch {
+			case err == nil:
+				if err := encodeAnimation(&buf, anim, format, webp.DefaultQuality); err != nil {
+					return nil, fmt.Errorf("编码缩略图失败: %w", err)
+				}
+				return buf.Bytes(), nil
+			case !errors.Is(err, errNotAnimated):
+				return nil, fmt.Errorf("解码缩略图失败: %w", err)
+			}
+		}
+
+		img, _, err := decodeImage(data, s.maxPixels)
+		if err != nil {
+			return nil, fmt.Errorf("解码缩略图失败: %w", err)
+		}
+		if err := encodeImage(&buf, process(img), format, webp.DefaultQuality); err != nil {
+			return nil, fmt.Errorf("编码缩略图失败: %w", err)
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// WebPNegotiable 图片的变换和缩略图是否按 Accept 请求头协商为 WebP
+//
+// GIF 可能是动图，WebP 本身不需要再转换，这两种图片始终保持原格式。
+func (s *ImageService) WebPNegotiable(imageInfo *storage.ImageInfo) bool {
+	if !s.transform.AutoWebP {
+		return false
+	}
+	return imageInfo.MimeType != "image/gif" && imageInfo.MimeType != "image/webp"
+}
+
+// openCached 打开缓存目录中的文件，不存在时调用 build 生成并写入缓存
+//
+// 返回的文件支持 Seek，第二个返回值为文件的 MIME 类型。
+func (s *ImageService) openCached(imageInfo *storage.ImageInfo, name, format string, build func() ([]byte, error)) (*os.File, string, error) {
+	cacheDir := filepath.Join(s.transform.CacheDir, imageInfo.ID)
+	cachePath := filepath.Join(cacheDir, name)
+	mimeType := "image/" + format
+
+	// 命中缓存直接返回，同时更新修改时间，淘汰缓存时按修改时间判断最近是否访问过
+	if file, err := os.Open(cachePath); err == nil {
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		return file, mimeType, nil
+	}
+
+	data, err := build()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 先写入临时文件再重命名，避免并发请求读到不完整的缓存
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, "", fmt.Errorf("创建缓存目录失败: %w", err)
+	}
+	tmp, err := os.CreateTemp(cacheDir, ".tmp-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("创建缓存文件失败: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", fmt.Errorf("写入缓存文件失败: %w", err)
+	}
+	tmp.Close()
+	if err := s.commitVariant(imageInfo.UserID, tmp.Name(), cachePath, int64(len(data))); err != nil {
+		return nil, "", fmt.Errorf("写入缓存文件失败: %w", err)
+	}
+	s.evictVariants(imageInfo, name)
+
+	file, err := os.Open(cachePath)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, mimeType, nil
+}
+
+// VariantETag 变换版本的强 ETag，variant 为 OpenVariant 或 OpenThumbnailAs 打开的缓存文件
+//
+// 缓存文件名包含了变换参数、输出格式和水印设置，相同的原图内容和文件名总是对应相同的内容。
+func (s *ImageService) VariantETag(imageInfo *storage.ImageInfo, variant *os.File) string {
+	if imageInfo.Hash == "" {
+		return ""
+	}
+	return `"` + imageInfo.Hash + "-" + filepath.Base(variant.Name()) + `"`
+}
+
+// commitVariant 将临时文件移动到缓存位置，并把缓存占用的空间计入图片所属用户
+//
+// 使用硬链接保证同一变换被并发生成时只计入一次。
+func (s *ImageService) commitVariant(userID, tmpPath, cachePath string, size int64) error {
+	defer os.Remove(tmpPath)
+
+	err := os.Link(tmpPath, cachePath)
+	switch {
+	case err == nil:
+	case os.IsExist(err):
+		// 其他请求已经生成了相同的变换
+		return nil
+	default:
+		// 文件系统不支持硬链接时退回到重命名
+		if err := os.Rename(tmpPath, cachePath); err != nil {
+			return err
+		}
+	}
+
+	s.quota.Add(userID, size)
+	return nil
+}
+
+// evictVariants 清理图片的缓存变换并从配额中扣除释放的空间，keep 为刚刚生成的版本，不会被删除
+//
+// 按已经修改或关闭的水印设置生成的版本不会再被访问，直接删除；其余版本超过 transform.max_variants
+// 时删除最久没有访问的版本。变换可以由任何访问者触发，限制数量避免缓存无限占用上传者的配额。
+func (s *ImageService) evictVariants(imageInfo *storage.ImageInfo, keep string) {
+	cacheDir := filepath.Join(s.transform.CacheDir, imageInfo.ID)
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+
+	current := ""
+	if wm, ok := s.serveWatermark(imageInfo); ok {
+		current = "_wm" + wm.key() + "."
+	}
+
+	var freed int64
+	remove := func(info os.FileInfo) {
+		if os.Remove(filepath.Join(cacheDir, info.Name())) == nil {
+			freed += info.Size()
+		}
+	}
+	var variants []os.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".tmp-") || name == keep {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if strings.Contains(name, "_wm") && (current == "" || !strings.Contains(name, current)) {
+			remove(info)
+			continue
+		}
+		variants = append(variants, info)
+	}
+
+	// 加上刚生成的版本后不超过上限
+	if max := s.transform.MaxVariants; max > 0 && len(variants) >= max {
+		sort.Slice(variants, func(i, j int) bool {
+			return variants[i].ModTime().Before(variants[j].ModTime())
+		})
+		for _, info := range variants[:len(variants)-max+1] {
+			remove(info)
+		}
+	}
+
+	if freed > 0 {
+		s.quota.Add(imageInfo.UserID, -freed)
+	}
+}
+
+// variantsSize 统计图片所有缓存变换占用的空间
+func (s *ImageService) variantsSize(id string) int64 {
+	entries, err := os.ReadDir(filepath.Join(s.transform.CacheDir, id))
+	if err != nil {
+		return 0
+	}
+
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// removeVariants 删除图片的所有缓存变换，返回释放的空间
+func (s *ImageService) removeVariants(id string) int64 {
+	size := s.variantsSize(id)
+	os.RemoveAll(filepath.Join(s.transform.CacheDir, id))
+	return size
+}
+
+// transformImage 按参数缩放和裁剪图片
+func transformImage(img image.Image, opts TransformOptions) image.Image {
+	if opts.Width == 0 && opts.Height == 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	switch opts.Fit {
+	case FitFill:
+		// 任一边为 0 时 resize 会按比例计算
+		return resize.Resize(uint(opts.Width), uint(opts.Height), img, resize.Lanczos3)
+
+	case FitCover:
+		if opts.Width == 0 || opts.Height == 0 {
+			return resize.Resize(uint(opts.Width), uint(opts.Height), img, resize.Lanczos3)
+		}
+		// 按较大的缩放比例缩放，使图片铺满目标尺寸
+		scaleW := float64(opts.Width) / float64(srcW)
+		scaleH := float64(opts.Height) / float64(srcH)
+		scale := scaleW
+		if scaleH > scale {
+			scale = scaleH
+		}
+		w := int(float64(srcW)*scale + 0.5)
+		h := int(float64(srcH)*scale + 0.5)
+		if w < opts.Width {
+			w = opts.Width
+		}
+		if h < opts.Height {
+			h = opts.Height
+		}
+		resized := resize.Resize(uint(w), uint(h), img, resize.Lanczos3)
+
+		// 居中裁剪
+		offset := image.Pt((w-opts.Width)/2, (h-opts.Height)/2)
+		dst := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+		draw.Draw(dst, dst.Bounds(), resized, resized.Bounds().Min.Add(offset), draw.Src)
+		return dst
+
+	default:
+		// contain：等比缩放到目标尺寸以内，不放大
+		maxW, maxH := opts.Width, opts.Height
+		if maxW == 0 {
+			maxW = srcW
+		}
+		if maxH == 0 {
+			maxH = srcH
+		}
+		return resize.Thumbnail(uint(maxW), uint(maxH), img, resize.Lanczos3)
+	}
+}
+
+// encodeImage 按指定格式编码图片
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "webp":
+		return webp.Encode(w, img, &webp.Options{Quality: quality})
+	default:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+}
+
+// formatFromMimeType 根据 MIME 类型获取可编码的输出格式
+func formatFromMimeType(mimeType string) string {
+	switch mimeType {
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	default:
+		// 其他格式默认使用JPEG
+		return "jpeg"
+	}
+}
+
+func containsInt(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"go-image/internal/storage"
+)
+
+// 删除的图片先移到回收站（见 DeleteImage），可以在保留时间内恢复；超过保留时间、
+// 手动永久删除或清空回收站时才删除文件，并归还占用的空间。
+
+// TrashRetention 回收站中的图片保留多久
+func (s *ImageService) TrashRetention() time.Duration {
+	return s.trashRetention
+}
+
+// PurgeAt 回收站中的图片将被自动永久删除的时间
+func (s *ImageService) PurgeAt(image *storage.ImageInfo) time.Time {
+	return image.DeletedAt.Add(s.trashRetention)
+}
+
+// ListTrash 按移到回收站的时间降序列出用户回收站中的图片
+func (s *ImageService) ListTrash(userID string) ([]*storage.ImageInfo, error) {
+	return s.storage.ListTrash(userID)
+}
+
+// TrashedImage 获取用户回收站中的图片
+func (s *ImageService) TrashedImage(userID string, id string) (*storage.ImageInfo, error) {
+	return s.storage.GetTrashed(userID, id)
+}
+
+// RestoreImage 从回收站恢复图片
+func (s *ImageService) RestoreImage(userID string, id string) (*storage.ImageInfo, error) {
+	s.trashMutex.Lock()
+	defer s.trashMutex.Unlock()
+
+	image, err := s.storage.Restore(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	s.index.Put(image)
+	return image, nil
+}
+
+// PurgeImage 永久删除回收站中的图片
+func (s *ImageService) PurgeImage(userID string, id string) error {
+	s.trashMutex.Lock()
+	defer s.trashMutex.Unlock()
+
+	return s.purge(userID, id)
+}
+
+// EmptyTrash 永久删除用户回收站中的所有图片，返回删除的数量
+func (s *ImageService) EmptyTrash(userID string) (int, error) {
+	s.trashMutex.Lock()
+	defer s.trashMutex.Unlock()
+
+	images, err := s.storage.ListTrash(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, image := range images {
+		if err := s.purge(userID, image.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// PurgeExpiredTrash 永久删除在回收站中超过保留时间的图片，返回删除的数量
+func (s *ImageService) PurgeExpiredTrash() int {
+	s.trashMutex.Lock()
+	defer s.trashMutex.Unlock()
+
+	images, err := s.storage.ListExpiredTrash(time.Now().Add(-s.trashRetention))
+	if err != nil {
+		log.Printf("列出回收站中过期的图片失败: %v", err)
+		return 0
+	}
+
+	purged := 0
+	for _, image := range images {
+		if err := s.purge(image.UserID, image.ID); err != nil {
+			log.Printf("永久删除图片 %s 失败: %v", image.ID, err)
+			continue
+		}
+		purged++
+	}
+	return purged
+}
+
+// StartTrashPurge 在后台定期清理回收站中过期的图片
+func (s *ImageService) StartTrashPurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n := s.PurgeExpiredTrash(); n > 0 {
+				log.Printf("永久删除了回收站中 %d 张过期的图片", n)
+			}
+		}
+	}()
+}
+
+// purge 永久删除回收站中的图片，归还原图、缩略图和变换版本占用的空间，调用方需要持有 trashMutex
+//
+// 重新读取图片信息，已经恢复的图片不会被删除。
+func (s *ImageService) purge(userID string, id string) error {
+	image, err := s.storage.GetTrashed(userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.storage.Delete(userID, id); err != nil {
+		return err
+	}
+
+	freed := image.Size + image.ThumbSize + s.removeVariants(id)
+	s.quota.Add(userID, -freed)
+	return nil
+}
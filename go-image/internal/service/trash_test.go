@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"go-image/internal/storage"
+)
+
+// uploadTestImages 上传 n 张图片，返回图片和它们一共占用的空间
+func uploadTestImages(t *testing.T, s *ImageService, n int) ([]*storage.ImageInfo, int64) {
+	var images []*storage.ImageInfo
+	var size int64
+	for i := 0; i < n; i++ {
+		data := testPNG(t, 8+i, 8)
+		image, err := s.UploadFile("u1", "a.png", int64(len(data)), bytes.NewReader(data), UploadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, image)
+		size += image.Size + image.ThumbSize
+	}
+	return images, size
+}
+
+// usedSpace 测试用户 u1 已使用的空间
+func usedSpace(s *ImageService) int64 {
+	used, _ := s.StorageUsage("u1")
+	return used
+}
+
+func TestTrashRestore(t *testing.T) {
+	s := newTestImageService(t, 1<<20)
+	images, size := uploadTestImages(t, s, 1)
+	image := images[0]
+
+	if err := s.DeleteImage("u1", image.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetImage("u1", image.ID); !errors.Is(err, storage.ErrImageNotFound) {
+		t.Fatalf("GetImage after delete: err = %v", err)
+	}
+	if list, err := s.ListImages("u1", SearchQuery{}, storage.ListOptions{}); err != nil || len(list.Images) != 0 {
+		t.Fatalf("ListImages after delete = %+v, %v", list, err)
+	}
+	trash, err := s.ListTrash("u1")
+	if err != nil || len(trash) != 1 || trash[0].ID != image.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("ListTrash = %+v, %v", trash, err)
+	}
+	// 回收站中的图片仍然计入配额
+	if used := usedSpace(s); used != size {
+		t.Fatalf("used %d after delete, want %d", used, size)
+	}
+	if err := s.DeleteImage("u1", image.ID); err == nil {
+		t.Fatal("deleted a trashed image twice")
+	}
+
+	restored, err := s.RestoreImage("u1", image.ID)
+	if err != nil || restored.DeletedAt != nil {
+		t.Fatalf("RestoreImage = %+v, %v", restored, err)
+	}
+	if _, err := s.GetImage("u1", image.ID); err != nil {
+		t.Fatalf("GetImage after restore: %v", err)
+	}
+	if trash, _ := s.ListTrash("u1"); len(trash) != 0 {
+		t.Fatalf("%d images left in trash", len(trash))
+	}
+	if _, err := s.RestoreImage("u1", image.ID); err == nil {
+		t.Fatal("restored an image that is not in the trash")
+	}
+	if used := usedSpace(s); used != size {
+		t.Fatalf("used %d after restore, want %d", used, size)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	s := newTestImageService(t, 1<<20)
+	images, size := uploadTestImages(t, s, 3)
+
+	// 只能永久删除回收站中的图片
+	if err := s.PurgeImage("u1", images[0].ID); err == nil {
+		t.Fatal("purged an image that is not in the trash")
+	}
+	for _, image := range images[:2] {
+		if err := s.DeleteImage("u1", image.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 其他用户不能永久删除
+	if err := s.PurgeImage("u2", images[0].ID); err == nil {
+		t.Fatal("purged another user's image")
+	}
+
+	if err := s.PurgeImage("u1", images[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	size -= images[0].Size + images[0].ThumbSize
+	if used := usedSpace(s); used != size {
+		t.Fatalf("used %d after purge, want %d", used, size)
+	}
+	if _, err := s.RestoreImage("u1", images[0].ID); err == nil {
+		t.Fatal("restored a purged image")
+	}
+
+	if n, err := s.EmptyTrash("u1"); err != nil || n != 1 {
+		t.Fatalf("EmptyTrash = %d, %v", n, err)
+	}
+	size -= images[1].Size + images[1].ThumbSize
+	if used := usedSpace(s); used != size {
+		t.Fatalf("used %d after emptying the trash, want %d", used, size)
+	}
+	if _, err := s.GetImage("u1", images[2].ID); err != nil {
+		t.Fatalf("image outside the trash removed: %v", err)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	s := newTestImageService(t, 1<<20)
+	s.trashRetention = time.Hour
+	images, size := uploadTestImages(t, s, 2)
+	for _, image := range images {
+		if err := s.DeleteImage("u1", image.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第一张已经在回收站中超过保留时间
+	expired, err := s.TrashedImage("u1", images[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletedAt := time.Now().Add(-2 * time.Hour)
+	expired.DeletedAt = &deletedAt
+	if err := s.storage.Update(expired); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.PurgeAt(expired); !got.Equal(deletedAt.Add(time.Hour)) {
+		t.Fatalf("PurgeAt = %v", got)
+	}
+
+	if n := s.PurgeExpiredTrash(); n != 1 {
+		t.Fatalf("PurgeExpiredTrash = %d, want 1", n)
+	}
+	if _, err := s.TrashedImage("u1", images[0].ID); err == nil {
+		t.Fatal("expired image still in trash")
+	}
+	if _, err := s.TrashedImage("u1", images[1].ID); err != nil {
+		t.Fatalf("image within retention purged: %v", err)
+	}
+	size -= images[0].Size + images[0].ThumbSize
+	if used := usedSpace(s); used != size {
+		t.Fatalf("used %d after purging expired images, want %d", used, size)
+	}
+	if n := s.PurgeExpiredTrash(); n != 0 {
+		t.Fatalf("second PurgeExpiredTrash = %d, want 0", n)
+	}
+}
@@ -0,0 +1,400 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go-image/internal/storage"
+)
+
+// 断点续传相关错误
+var (
+	ErrUploadNotFound  = errors.New("上传不存在或已过期")
+	ErrOffsetMismatch  = errors.New("上传偏移量与服务端不一致")
+	ErrUploadLocked    = errors.New("上传正在被其他请求写入")
+	ErrUploadCompleted = errors.New("上传已完成")
+)
+
+// TusConfig 断点续传配置
+type TusConfig struct {
+	// Dir 未完成上传的临时目录
+	Dir string
+	// Expiration 上传创建后多久未完成即被清理
+	Expiration time.Duration
+}
+
+// TusUpload 一次断点续传上传的状态，保存在 <id>.info 中，文件内容保存在 <id>.bin 中
+type TusUpload struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id"`
+	Size      int64             `json:"size"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+
+	// ImageID 上传完成后生成的图片ID
+	ImageID string `json:"image_id,omitempty"`
+	// ImagePath 上传完成后图片的访问路径
+	ImagePath string `json:"image_path,omitempty"`
+}
+
+// Completed 是否已经完成并保存为图片
+func (u *TusUpload) Completed() bool {
+	return u.ImageID != ""
+}
+
+// Expired 是否已经过期
+func (u *TusUpload) Expired() bool {
+	return time.Now().After(u.ExpiresAt)
+}
+
+// TusService 处理 tus 1.0 断点续传上传
+//
+// 分块写入磁盘上的临时文件，全部接收后交给 ImageService 完成校验、保存和缩略图生成。
+type TusService struct {
+	images     *ImageService
+	dir        string
+	expiration time.Duration
+
+	mutex  sync.Mutex
+	locked map[string]bool // 正在写入的上传
+}
+
+// NewTusService 创建一个新的断点续传服务实例
+func NewTusService(images *ImageService, cfg TusConfig) (*TusService, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建上传临时目录失败: %w", err)
+	}
+
+	return &TusService{
+		images:     images,
+		dir:        cfg.Dir,
+		expiration: cfg.Expiration,
+		locked:     make(map[string]bool),
+	}, nil
+}
+
+// MaxSize 单个上传的大小上限（字节）
+func (s *TusService) MaxSize() int64 {
+	return s.images.MaxSize()
+}
+
+// Create 创建上传，检查大小限制并按声明的大小预占配额
+//
+// 预占的空间在上传完成时按实际保存的大小修正，终止或过期清理时归还。
+func (s *TusService) Create(userID string, size int64, metadata map[string]string) (*TusUpload, error) {
+	if visibility := metadata["visibility"]; visibility != "" && !storage.IsValidVisibility(visibility) {
+		return nil, ErrInvalidVisibility
+	}
+	if _, err := normalizeTags(SplitTags(metadata["tags"])); err != nil {
+		return nil, err
+	}
+	if _, err := normalizeDescription(metadata["description"]); err != nil {
+		return nil, err
+	}
+	if _, err := ParseStripMetadata(metadata["strip_metadata"]); err != nil {
+		return nil, err
+	}
+	if _, err := ParseWatermark(metadata["watermark"]); err != nil {
+		return nil, err
+	}
+	if err := s.images.reserveUpload(userID, size); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := &TusUpload{
+		ID:        strings.ReplaceAll(uuid.New().String(), "-", ""),
+		UserID:    userID,
+		Size:      size,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.expiration),
+	}
+
+	// 先保存状态再创建数据文件，清理时没有状态的数据文件都可以直接删除
+	if err := s.saveUpload(upload); err != nil {
+		s.images.releaseUpload(userID, size)
+		return nil, err
+	}
+	file, err := os.Create(s.binPath(upload.ID))
+	if err != nil {
+		s.remove(upload.ID)
+		s.images.releaseUpload(userID, size)
+		return nil, fmt.Errorf("创建上传文件失败: %w", err)
+	}
+	file.Close()
+
+	return upload, nil
+}
+
+// Get 获取用户的上传状态
+func (s *TusService) Get(userID string, id string) (*TusUpload, error) {
+	upload, err := s.loadUpload(id)
+	if err != nil || upload.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// WriteChunk 从 offset 处追加写入一块数据
+//
+// 写入中断时已经收到的部分仍然保留，客户端可以通过 HEAD 获取偏移量后继续上传。
+// 全部数据接收完成后保存为图片，返回的上传状态中包含图片ID。
+func (s *TusService) WriteChunk(userID string, id string, offset int64, chunk io.Reader) (*TusUpload, error) {
+	if !s.lock(id) {
+		return nil, ErrUploadLocked
+	}
+	defer s.unlock(id)
+
+	upload, err := s.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Completed() {
+		return nil, ErrUploadCompleted
+	}
+	if offset != upload.Offset {
+		return nil, ErrOffsetMismatch
+	}
+
+	file, err := os.OpenFile(s.binPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// 超出声明大小的数据直接丢弃
+	n, copyErr := io.Copy(file, io.LimitReader(chunk, upload.Size-offset))
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	upload.Offset += n
+	if err := s.saveUpload(upload); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return upload, fmt.Errorf("写入上传数据失败: %w", copyErr)
+	}
+
+	if upload.Offset == upload.Size {
+		if err := s.finish(upload); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+// Terminate 终止上传，删除已接收的数据并归还预占的配额
+func (s *TusService) Terminate(userID string, id string) error {
+	if !s.lock(id) {
+		return ErrUploadLocked
+	}
+	defer s.unlock(id)
+
+	upload, err := s.Get(userID, id)
+	if err != nil {
+		return err
+	}
+	s.discard(upload)
+	return nil
+}
+
+// CleanupExpired 清理过期的上传，返回清理的数量
+func (s *TusService) CleanupExpired() int {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0
+	}
+
+	ids := make(map[string]bool)
+	for _, entry := range entries {
+		id, _, _ := strings.Cut(entry.Name(), ".")
+		ids[id] = true
+	}
+
+	removed := 0
+	for id := range ids {
+		if !s.lock(id) {
+			continue
+		}
+		// 过期、状态损坏或只剩数据文件的上传都会被删除
+		upload, err := s.readUpload(id)
+		switch {
+		case err != nil:
+			s.remove(id)
+			removed++
+		case upload.Expired():
+			s.discard(upload)
+			removed++
+		}
+		s.unlock(id)
+	}
+	return removed
+}
+
+// StartCleanup 在后台定期清理过期的上传
+func (s *TusService) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n := s.CleanupExpired(); n > 0 {
+				log.Printf("清理了 %d 个过期的上传", n)
+			}
+		}
+	}()
+}
+
+// finish 将接收完成的文件保存为图片，创建时预占的配额转为图片实际占用的空间
+//
+// 保存失败（例如文件不是图片）时上传被删除并归还配额，需要客户端重新上传。
+func (s *TusService) finish(upload *TusUpload) error {
+	file, err := os.Open(s.binPath(upload.ID))
+	if err != nil {
+		s.discard(upload)
+		return err
+	}
+
+	filename := upload.Metadata["filename"]
+	if filename == "" {
+		filename = upload.Metadata["name"]
+	}
+	strip, _ := ParseStripMetadata(upload.Metadata["strip_metadata"]) // 创建时已经校验
+	watermark, _ := ParseWatermark(upload.Metadata["watermark"])
+	imageInfo, err := s.images.uploadReserved(upload.UserID, filename, upload.Size, file, UploadOptions{
+		Visibility:    upload.Metadata["visibility"],
+		Tags:          SplitTags(upload.Metadata["tags"]),
+		Description:   upload.Metadata["description"],
+		StripMetadata: strip,
+		Watermark:     watermark,
+	})
+	file.Close()
+	if err != nil {
+		s.remove(upload.ID)
+		return err
+	}
+
+	// 删除文件内容，保留状态直到过期，便于客户端查询结果
+	os.Remove(s.binPath(upload.ID))
+	upload.ImageID = imageInfo.ID
+	upload.ImagePath = imageInfo.PublicPath()
+	return s.saveUpload(upload)
+}
+
+// lock 标记上传正在写入，已被其他请求占用时返回 false
+func (s *TusService) lock(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.locked[id] {
+		return false
+	}
+	s.locked[id] = true
+	return true
+}
+
+func (s *TusService) unlock(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.locked, id)
+}
+
+// loadUpload 读取未过期的上传状态
+func (s *TusService) loadUpload(id string) (*TusUpload, error) {
+	upload, err := s.readUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Expired() {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// readUpload 读取上传状态，不检查是否过期
+func (s *TusService) readUpload(id string) (*TusUpload, error) {
+	if !isUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	var upload TusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("解析上传状态失败: %w", err)
+	}
+	return &upload, nil
+}
+
+// saveUpload 保存上传状态，先写临时文件再重命名，避免中断时留下不完整的状态
+func (s *TusService) saveUpload(upload *TusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.infoPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("保存上传状态失败: %w", err)
+	}
+	if err := os.Rename(tmp, s.infoPath(upload.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("保存上传状态失败: %w", err)
+	}
+	return nil
+}
+
+// discard 删除上传，未完成时归还创建时预占的配额
+func (s *TusService) discard(upload *TusUpload) {
+	s.remove(upload.ID)
+	if !upload.Completed() {
+		s.images.releaseUpload(upload.UserID, upload.Size)
+	}
+}
+
+// remove 删除上传的状态和数据
+func (s *TusService) remove(id string) {
+	os.Remove(s.infoPath(id))
+	os.Remove(s.infoPath(id) + ".tmp")
+	os.Remove(s.binPath(id))
+}
+
+func (s *TusService) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+func (s *TusService) binPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+// isUploadID 上传ID只包含十六进制字符，防止路径穿越
+func isUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
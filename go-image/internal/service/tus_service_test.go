@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestTusService 创建断点续传服务，quota 为每个用户的配额（字节），0 表示不限制
+func newTestTusService(t *testing.T, quota int64) *TusService {
+	images := newTestImageService(t, 1<<20)
+	images.quota.cfg.Default = quota
+	s, err := NewTusService(images, TusConfig{Dir: filepath.Join(t.TempDir(), "tus"), Expiration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// expire 将上传的过期时间改为已经过去
+func expire(t *testing.T, s *TusService, upload *TusUpload) {
+	upload, err := s.readUpload(upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.ExpiresAt = time.Now().Add(-time.Second)
+	if err := s.saveUpload(upload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingReader 读取时返回错误，模拟连接中断
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestTusUpload(t *testing.T) {
+	s := newTestTusService(t, 0)
+	data := testPNG(t, 16, 16)
+	upload, err := s.Create("u1", int64(len(data)), map[string]string{"filename": "a.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Offset != 0 || upload.Completed() {
+		t.Fatalf("new upload = %+v", upload)
+	}
+
+	// 第一块写到一半时连接中断，已收到的数据保留
+	half := int64(len(data) / 2)
+	chunk := io.MultiReader(bytes.NewReader(data[:half]), failingReader{})
+	if _, err := s.WriteChunk("u1", upload.ID, 0, chunk); err == nil {
+		t.Fatal("interrupted chunk: no error")
+	}
+
+	// HEAD 返回服务端的偏移量，客户端从这里继续
+	got, err := s.Get("u1", upload.ID)
+	if err != nil || got.Offset != half {
+		t.Fatalf("Get = %+v, %v, want offset %d", got, err, half)
+	}
+	for _, offset := range []int64{0, half - 1, half + 1} {
+		if _, err := s.WriteChunk("u1", upload.ID, offset, bytes.NewReader(data[offset:])); !errors.Is(err, ErrOffsetMismatch) {
+			t.Errorf("offset %d: err = %v, want ErrOffsetMismatch", offset, err)
+		}
+	}
+
+	// 超出声明大小的数据被丢弃，接收完成后保存为图片
+	rest := append(append([]byte(nil), data[half:]...), "trailing"...)
+	upload, err = s.WriteChunk("u1", upload.ID, half, bytes.NewReader(rest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Offset != int64(len(data)) || !upload.Completed() {
+		t.Fatalf("after last chunk: %+v", upload)
+	}
+	image, err := s.images.GetImage("u1", upload.ImageID)
+	if err != nil || image.Filename != "a.png" || image.Size != int64(len(data)) {
+		t.Fatalf("GetImage = %+v, %v", image, err)
+	}
+
+	// 完成后仍然可以查询结果，但不能继续写入
+	if got, err := s.Get("u1", upload.ID); err != nil || got.ImageID != image.ID {
+		t.Fatalf("Get after completion = %+v, %v", got, err)
+	}
+	if _, err := s.WriteChunk("u1", upload.ID, upload.Offset, bytes.NewReader(nil)); !errors.Is(err, ErrUploadCompleted) {
+		t.Fatalf("write after completion: err = %v", err)
+	}
+}
+
+func TestTusUploadAccess(t *testing.T) {
+	s := newTestTusService(t, 0)
+	upload, err := s.Create("u1", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, id := range map[string]string{
+		"unknown id":     "0123456789abcdef0123456789abcdef",
+		"path traversal": "../../data/metadata.db",
+	} {
+		if _, err := s.Get("u1", id); !errors.Is(err, ErrUploadNotFound) {
+			t.Errorf("%s: err = %v, want ErrUploadNotFound", name, err)
+		}
+	}
+	if _, err := s.Get("u2", upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("other user: err = %v", err)
+	}
+	if _, err := s.WriteChunk("u2", upload.ID, 0, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("other user write: err = %v", err)
+	}
+	if err := s.Terminate("u2", upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("other user terminate: err = %v", err)
+	}
+
+	// 同一个上传同时只能有一个请求写入
+	if !s.lock(upload.ID) {
+		t.Fatal("lock failed")
+	}
+	if _, err := s.WriteChunk("u1", upload.ID, 0, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrUploadLocked) {
+		t.Fatalf("concurrent write: err = %v", err)
+	}
+	s.unlock(upload.ID)
+
+	// 过期后不能继续上传
+	expire(t, s, upload)
+	if _, err := s.Get("u1", upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expired: err = %v", err)
+	}
+}
+
+func TestTusQuota(t *testing.T) {
+	s := newTestTusService(t, 1000)
+	used := func() int64 {
+		used, _ := s.images.StorageUsage("u1")
+		return used
+	}
+
+	// 创建时按声明的大小预占配额
+	first, err := s.Create("u1", 800, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used() != 800 {
+		t.Fatalf("after create: used %d, want 800", used())
+	}
+	if _, err := s.Create("u1", 300, nil); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("over quota: err = %v, want ErrQuotaExceeded", err)
+	}
+
+	// 终止后归还
+	if err := s.Terminate("u1", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if used() != 0 {
+		t.Fatalf("after terminate: used %d, want 0", used())
+	}
+
+	// 过期清理时归还
+	second, err := s.Create("u1", 300, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expire(t, s, second)
+	if n := s.CleanupExpired(); n != 1 {
+		t.Fatalf("CleanupExpired = %d, want 1", n)
+	}
+	if used() != 0 {
+		t.Fatalf("after cleanup: used %d, want 0", used())
+	}
+
+	// 内容不是图片时上传被删除并归还
+	third, err := s.Create("u1", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteChunk("u1", third.ID, 0, bytes.NewReader([]byte("text"))); err == nil {
+		t.Fatal("non-image upload accepted")
+	}
+	if _, err := s.Get("u1", third.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("failed upload kept: err = %v", err)
+	}
+	if used() != 0 {
+		t.Fatalf("after failed upload: used %d, want 0", used())
+	}
+
+	// 完成后预占转为图片实际占用的空间，过期清理时不再归还
+	data := testPNG(t, 16, 16)
+	fourth, err := s.Create("u1", int64(len(data)), map[string]string{"filename": "a.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth, err = s.WriteChunk("u1", fourth.ID, 0, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := s.images.GetImage("u1", fourth.ImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := image.Size + image.ThumbSize
+	if used() != want {
+		t.Fatalf("after completion: used %d, want %d", used(), want)
+	}
+	expire(t, s, fourth)
+	s.CleanupExpired()
+	if used() != want {
+		t.Fatalf("completed upload cleaned up: used %d, want %d", used(), want)
+	}
+}
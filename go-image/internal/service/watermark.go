@@ -0,0 +1,320 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nfnt/resize"
+	"go-image/internal/storage"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// 水印的添加时机
+const (
+	WatermarkModeUpload = "upload" // 上传时加到保存的原图上
+	WatermarkModeServe  = "serve"  // 匿名访问 /i/:id 时实时添加，原图不变
+)
+
+// 水印类型
+const (
+	WatermarkTypeText  = "text"  // 文字
+	WatermarkTypeImage = "image" // 上传的 PNG Logo
+)
+
+// watermarkPositions 水印可以放置的位置
+var watermarkPositions = map[string]bool{
+	"top-left":     true,
+	"top-right":    true,
+	"bottom-left":  true,
+	"bottom-right": true,
+	"center":       true,
+}
+
+// maxWatermarkTextLength 水印文字的长度上限（字符）
+const maxWatermarkTextLength = 100
+
+// watermarkFontSize 渲染文字水印时使用的字号，之后再按图片尺寸缩放
+const watermarkFontSize = 64
+
+// watermarkQuality 添加水印后重新编码 JPEG 和 WebP 使用的质量
+const watermarkQuality = 90
+
+// WatermarkConfig 水印配置
+type WatermarkConfig struct {
+	// Font 渲染文字水印的字体文件（TTF/OTF），为空时使用内置的 Go 字体（不包含中文字符）
+	Font string `yaml:"font"`
+}
+
+// WatermarkSettings 用户的水印设置
+type WatermarkSettings struct {
+	// Enabled 默认为图片添加水印，单张图片可以单独开启或关闭
+	Enabled bool `json:"enabled"`
+	// Mode 添加时机：upload 或 serve
+	Mode string `json:"mode"`
+	// Type 水印类型：text 或 image
+	Type string `json:"type"`
+	// Text 文字水印的内容
+	Text string `json:"text"`
+	// Logo 已上传 Logo 的版本，没有上传时为空；只能通过上传接口修改
+	Logo string `json:"logo"`
+	// Position 位置：top-left、top-right、bottom-left、bottom-right 或 center
+	Position string `json:"position"`
+	// Opacity 不透明度，0-1
+	Opacity float64 `json:"opacity"`
+	// Scale 水印宽度占图片宽度的比例，0-1
+	Scale float64 `json:"scale"`
+}
+
+// DefaultWatermarkSettings 默认的水印设置（不添加水印）
+func DefaultWatermarkSettings() WatermarkSettings {
+	return WatermarkSettings{
+		Mode:     WatermarkModeServe,
+		Type:     WatermarkTypeText,
+		Position: "bottom-right",
+		Opacity:  0.5,
+		Scale:    0.2,
+	}
+}
+
+// validate 校验水印设置
+func (w *WatermarkSettings) validate() error {
+	w.Text = strings.TrimSpace(w.Text)
+	switch {
+	case w.Mode != WatermarkModeUpload && w.Mode != WatermarkModeServe:
+		return fmt.Errorf("%w: 水印添加时机必须是 upload 或 serve", ErrInvalidSettings)
+	case w.Type != WatermarkTypeText && w.Type != WatermarkTypeImage:
+		return fmt.Errorf("%w: 水印类型必须是 text 或 image", ErrInvalidSettings)
+	case !watermarkPositions[w.Position]:
+		return fmt.Errorf("%w: 不支持的水印位置 %s", ErrInvalidSettings, w.Position)
+	case w.Opacity <= 0 || w.Opacity > 1:
+		return fmt.Errorf("%w: 水印不透明度必须在 0-1 之间", ErrInvalidSettings)
+	case w.Scale <= 0 || w.Scale > 1:
+		return fmt.Errorf("%w: 水印大小必须在 0-1 之间", ErrInvalidSettings)
+	case utf8.RuneCountInString(w.Text) > maxWatermarkTextLength:
+		return fmt.Errorf("%w: 水印文字不能超过%d个字符", ErrInvalidSettings, maxWatermarkTextLength)
+	case w.Enabled && w.Type == WatermarkTypeText && w.Text == "":
+		return fmt.Errorf("%w: 请填写水印文字", ErrInvalidSettings)
+	case w.Enabled && w.Type == WatermarkTypeImage && w.Logo == "":
+		return fmt.Errorf("%w: 请先上传水印 Logo", ErrInvalidSettings)
+	}
+	return nil
+}
+
+// usable 水印内容是否完整
+func (w *WatermarkSettings) usable() bool {
+	if w.Type == WatermarkTypeImage {
+		return w.Logo != ""
+	}
+	return w.Text != ""
+}
+
+// key 水印外观的摘要，用于区分不同水印设置下缓存的变换
+func (w *WatermarkSettings) key() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%g|%g", w.Type, w.Text, w.Logo, w.Position, w.Opacity, w.Scale)))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ParseWatermark 解析上传或修改图片时的 watermark 参数：on、off，或者为空（default）表示按个人设置
+func ParseWatermark(value string) (string, error) {
+	switch value {
+	case "", "default":
+		return "", nil
+	case storage.WatermarkOn, storage.WatermarkOff:
+		return value, nil
+	}
+	return "", fmt.Errorf("%w: watermark 必须是 on、off 或 default", ErrInvalidMetadata)
+}
+
+// effectiveWatermark 图片实际使用的水印设置，override 为图片单独的设置；ok 为 false 表示不添加水印
+func (s *ImageService) effectiveWatermark(userID, override string) (wm WatermarkSettings, ok bool) {
+	wm = s.settings.Get(userID).Watermark
+	switch override {
+	case storage.WatermarkOn:
+		return wm, wm.usable()
+	case storage.WatermarkOff:
+		return wm, false
+	}
+	return wm, wm.Enabled && wm.usable()
+}
+
+// WatermarkOnServe 匿名访问时是否需要为图片实时添加水印
+func (s *ImageService) WatermarkOnServe(imageInfo *storage.ImageInfo) bool {
+	if imageInfo.Watermarked {
+		// 上传时已经加过水印
+		return false
+	}
+	wm, ok := s.effectiveWatermark(imageInfo.UserID, imageInfo.Watermark)
+	return ok && wm.Mode == WatermarkModeServe
+}
+
+// serveWatermark 实时添加水印使用的设置
+func (s *ImageService) serveWatermark(imageInfo *storage.ImageInfo) (WatermarkSettings, bool) {
+	if !s.WatermarkOnServe(imageInfo) {
+		return WatermarkSettings{}, false
+	}
+	return s.effectiveWatermark(imageInfo.UserID, imageInfo.Watermark)
+}
+
+// watermarkUpload 为上传的图片添加水印，返回重新编码后的内容
+//
+// 动图逐帧添加水印。重新编码后原图中的 EXIF 等元数据不再保留，图片已按 EXIF 方向校正。
+func (s *ImageService) watermarkUpload(userID string, data []byte, contentType string, wm WatermarkSettings) ([]byte, error) {
+	mark, err := s.renderWatermark(userID, wm)
+	if err != nil {
+		return nil, err
+	}
+	format := formatFromMimeType(contentType)
+
+	var buf bytes.Buffer
+	if format == "gif" || format == "webp" {
+		anim, err := decodeAnimation(data, func(frame image.Image) image.Image {
+			return drawWatermark(frame, mark, wm)
+		})
+		switch {
+		case err == nil:
+			if err := encodeAnimation(&buf, anim, format, watermarkQuality); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		case !errors.Is(err, errNotAnimated):
+			return nil, err
+		}
+	}
+
+	img, _, err := decodeImage(data, s.maxPixels)
+	if err != nil {
+		return nil, err
+	}
+	if err := encodeImage(&buf, drawWatermark(img, mark, wm), format, watermarkQuality); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderWatermark 生成未缩放的水印图像
+func (s *ImageService) renderWatermark(userID string, wm WatermarkSettings) (image.Image, error) {
+	if wm.Type == WatermarkTypeImage {
+		logo, err := s.settings.WatermarkLogo(userID)
+		if err != nil {
+			return nil, fmt.Errorf("读取水印 Logo 失败: %w", err)
+		}
+		return logo, nil
+	}
+	return renderWatermarkText(s.watermarkFont.load(), wm.Text)
+}
+
+// fontLoader 第一次使用时加载文字水印的字体，配置的字体无法加载时退回到内置的 Go 字体
+type fontLoader struct {
+	path string
+	once sync.Once
+	font *opentype.Font
+}
+
+func (l *fontLoader) load() *opentype.Font {
+	l.once.Do(func() {
+		if l.path != "" {
+			data, err := os.ReadFile(l.path)
+			if err == nil {
+				l.font, err = opentype.Parse(data)
+			}
+			if err == nil {
+				return
+			}
+			log.Printf("加载水印字体 %s 失败，使用内置字体: %v", l.path, err)
+		}
+		l.font, _ = opentype.Parse(goregular.TTF)
+	})
+	return l.font
+}
+
+// renderWatermarkText 渲染白色带阴影的文字水印
+func renderWatermarkText(f *opentype.Font, text string) (image.Image, error) {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: watermarkFontSize, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	drawer := &font.Drawer{Face: face}
+	bounds, _ := drawer.BoundString(text)
+	const padding, shadow = 4, 3
+	width := (bounds.Max.X - bounds.Min.X).Ceil() + padding*2 + shadow
+	height := (bounds.Max.Y - bounds.Min.Y).Ceil() + padding*2 + shadow
+	if width <= padding*2+shadow || height <= padding*2+shadow {
+		return nil, errors.New("水印文字无法显示")
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	origin := fixed.Point26_6{X: fixed.I(padding) - bounds.Min.X, Y: fixed.I(padding) - bounds.Min.Y}
+	drawer.Dst = dst
+
+	// 先画半透明的黑色阴影，在浅色背景上同样清晰
+	drawer.Src = image.NewUniform(color.NRGBA{A: 0x99})
+	drawer.Dot = origin.Add(fixed.P(shadow, shadow))
+	drawer.DrawString(text)
+
+	drawer.Src = image.White
+	drawer.Dot = origin
+	drawer.DrawString(text)
+	return dst, nil
+}
+
+// drawWatermark 按设置的位置、不透明度和大小把水印画到图片上，返回新的图片
+func drawWatermark(img image.Image, mark image.Image, wm WatermarkSettings) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Rect, img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	margin := w
+	if h < margin {
+		margin = h
+	}
+	margin = margin * 3 / 100
+
+	// 水印宽度按图片宽度的比例计算，同时不能超出图片高度
+	markW, markH := mark.Bounds().Dx(), mark.Bounds().Dy()
+	targetW := int(float64(w)*wm.Scale + 0.5)
+	targetH := targetW * markH / markW
+	if maxH := h - margin*2; targetH > maxH {
+		targetH = maxH
+		targetW = targetH * markW / markH
+	}
+	if targetW < 1 || targetH < 1 {
+		return dst
+	}
+	scaled := resize.Resize(uint(targetW), uint(targetH), mark, resize.Lanczos3)
+
+	var pt image.Point
+	switch wm.Position {
+	case "top-left":
+		pt = image.Pt(margin, margin)
+	case "top-right":
+		pt = image.Pt(w-margin-targetW, margin)
+	case "bottom-left":
+		pt = image.Pt(margin, h-margin-targetH)
+	case "center":
+		pt = image.Pt((w-targetW)/2, (h-targetH)/2)
+	default:
+		pt = image.Pt(w-margin-targetW, h-margin-targetH)
+	}
+
+	opacity := image.NewUniform(color.Alpha{A: uint8(wm.Opacity*0xff + 0.5)})
+	draw.DrawMask(dst, image.Rectangle{Min: pt, Max: pt.Add(image.Pt(targetW, targetH))},
+		scaled, scaled.Bounds().Min, opacity, image.Point{}, draw.Over)
+	return dst
+}
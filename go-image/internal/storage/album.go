@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrAlbumNotFound 相册不存在
+var ErrAlbumNotFound = errors.New("相册不存在")
+
+// Album 相册，图片所属的相册记录在 ImageInfo.Albums 中
+type Album struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CoverID     string    `json:"cover_id,omitempty"` // 封面图片ID，为空时使用相册中的第一张图片
+	Position    int       `json:"position"`           // 在用户相册列表中的顺序，从小到大排列
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AlbumStore 相册存储接口
+type AlbumStore interface {
+	// PutAlbum 新增或更新相册
+	PutAlbum(album *Album) error
+
+	// GetAlbum 根据ID获取相册
+	GetAlbum(id string) (*Album, error)
+
+	// DeleteAlbum 删除相册，并从所有图片中移除该相册，图片本身不受影响
+	DeleteAlbum(id string) error
+
+	// ListAlbums 按顺序列出用户的所有相册
+	ListAlbums(userID string) ([]*Album, error)
+
+	// ListAlbumImages 按上传时间升序列出相册中的图片
+	ListAlbumImages(albumID string) ([]*ImageInfo, error)
+}
+
+// InAlbum 图片是否属于指定相册
+func (i *ImageInfo) InAlbum(albumID string) bool {
+	for _, id := range i.Albums {
+		if id == albumID {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveAlbum 从图片的相册列表中移除相册
+func (i *ImageInfo) RemoveAlbum(albumID string) {
+	albums := i.Albums[:0]
+	for _, id := range i.Albums {
+		if id != albumID {
+			albums = append(albums, id)
+		}
+	}
+	i.Albums = albums
+}
+
+// sortAlbums 按顺序和创建时间排列相册
+func sortAlbums(albums []*Album) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		if albums[i].Position != albums[j].Position {
+			return albums[i].Position < albums[j].Position
+		}
+		return albums[i].CreatedAt.Before(albums[j].CreatedAt)
+	})
+}
@@ -0,0 +1,32 @@
+package storage
+
+import "path"
+
+// 图片原文件按内容寻址保存：相同内容只保存一份，不同用户上传的相同图片也共用同一个文件。
+//
+// 引用计数不单独保存，而是由元数据存储中的内容哈希索引给出（引用该内容的图片数量），
+// 与图片元数据在同一次写入中维护，不会出现计数与元数据不一致的情况。删除图片时只有
+// 最后一个引用被删除后才会删除文件。
+
+// blobPath 内容文件的相对路径：blobs/<哈希前两位>/<哈希><扩展名>
+//
+// 扩展名由检测出的MIME类型决定，相同的内容总是得到相同的路径。
+func blobPath(hash string, mimeType string) string {
+	return path.Join("blobs", hash[:2], hash+mimeExtension(mimeType))
+}
+
+// mimeExtension 根据MIME类型获取默认扩展名
+func mimeExtension(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".bin"
+	}
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// PutAlbum 新增或更新相册
+func (s *BoltMetadataStore) PutAlbum(album *Album) error {
+	data, err := json.Marshal(album)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketAlbums).Put([]byte(album.ID), data); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketUserAlbumIndex).Put(userAlbumIndexKey(album.UserID, album.ID), nil)
+	})
+}
+
+// GetAlbum 根据ID获取相册
+func (s *BoltMetadataStore) GetAlbum(id string) (*Album, error) {
+	var album *Album
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		album, err = getAlbum(tx, id)
+		return err
+	})
+	return album, err
+}
+
+// DeleteAlbum 删除相册，并在同一事务中从所有图片中移除该相册
+func (s *BoltMetadataStore) DeleteAlbum(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		album, err := getAlbum(tx, id)
+		if err != nil {
+			return err
+		}
+
+		// 先收集图片ID，遍历索引时不能修改索引
+		var imageIDs []string
+		prefix := append([]byte(id), 0)
+		c := tx.Bucket(bucketAlbumImageIndex).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			imageIDs = append(imageIDs, string(k[len(prefix)+8:]))
+		}
+
+		for _, imageID := range imageIDs {
+			image, err := getImage(tx, imageID)
+			if err != nil {
+				return err
+			}
+			image.RemoveAlbum(id)
+			if err := putImage(tx, image); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(bucketUserAlbumIndex).Delete(userAlbumIndexKey(album.UserID, id)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketAlbums).Delete([]byte(id))
+	})
+}
+
+// ListAlbums 按顺序列出用户的所有相册
+func (s *BoltMetadataStore) ListAlbums(userID string) ([]*Album, error) {
+	var albums []*Album
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := append([]byte(userID), 0)
+		c := tx.Bucket(bucketUserAlbumIndex).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			album, err := getAlbum(tx, string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			albums = append(albums, album)
+		}
+		return nil
+	})
+	sortAlbums(albums)
+	return albums, err
+}
+
+// ListAlbumImages 按上传时间升序列出相册中的图片
+func (s *BoltMetadataStore) ListAlbumImages(albumID string) ([]*ImageInfo, error) {
+	var images []*ImageInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := append([]byte(albumID), 0)
+		c := tx.Bucket(bucketAlbumImageIndex).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			image, err := getImage(tx, string(k[len(prefix)+8:]))
+			if err != nil {
+				return err
+			}
+			images = append(images, image)
+		}
+		return nil
+	})
+	return images, err
+}
+
+// getAlbum 读取相册
+func getAlbum(tx *bolt.Tx, id string) (*Album, error) {
+	data := tx.Bucket(bucketAlbums).Get([]byte(id))
+	if data == nil {
+		return nil, ErrAlbumNotFound
+	}
+
+	var album Album
+	if err := json.Unmarshal(data, &album); err != nil {
+		return nil, fmt.Errorf("解析相册失败: %w", err)
+	}
+	return &album, nil
+}
+
+// userAlbumIndexKey 构建用户相册索引键
+func userAlbumIndexKey(userID, albumID string) []byte {
+	key := make([]byte, 0, len(userID)+1+len(albumID))
+	key = append(key, userID...)
+	key = append(key, 0)
+	return append(key, albumID...)
+}
@@ -0,0 +1,534 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// bucketImages 图片元数据：id -> ImageInfo(JSON)
+	bucketImages = []byte("images")
+	// bucketUserIndex 用户索引：userID + 0x00 + uploadedAt + id -> uploadedAt + mimeType
+	bucketUserIndex = []byte("idx_user_uploaded_at")
+	// bucketUserSizeIndex 按大小排序的用户索引：userID + 0x00 + size + id -> uploadedAt + mimeType
+	bucketUserSizeIndex = []byte("idx_user_size")
+	// bucketUserFilenameIndex 按文件名排序的用户索引：userID + 0x00 + 小写文件名 + 0x00 + id -> uploadedAt + mimeType
+	bucketUserFilenameIndex = []byte("idx_user_filename")
+	// bucketTimeIndex 上传时间索引：uploadedAt + id -> 空
+	bucketTimeIndex = []byte("idx_uploaded_at")
+	// bucketSlugIndex 公开标识索引：slug -> id
+	bucketSlugIndex = []byte("idx_slug")
+	// bucketHashIndex 内容哈希索引：hash + 0x00 + id -> 空，同一内容的项数即为其引用数
+	bucketHashIndex = []byte("idx_hash")
+	// bucketAlbums 相册：id -> Album(JSON)
+	bucketAlbums = []byte("albums")
+	// bucketUserAlbumIndex 用户相册索引：userID + 0x00 + albumID -> 空
+	bucketUserAlbumIndex = []byte("idx_user_album")
+	// bucketAlbumImageIndex 相册图片索引：albumID + 0x00 + uploadedAt + id -> 空
+	bucketAlbumImageIndex = []byte("idx_album_uploaded_at")
+	// bucketUserTrashIndex 用户回收站索引：userID + 0x00 + deletedAt + id -> 空
+	bucketUserTrashIndex = []byte("idx_user_trash")
+	// bucketTrashIndex 回收站索引：deletedAt + id -> 空，用于清理过期的图片
+	bucketTrashIndex = []byte("idx_deleted_at")
+	// bucketMeta 数据库自身的信息，例如索引结构的版本
+	bucketMeta = []byte("meta")
+)
+
+// boltSchemaVersion 索引结构的版本，低于此版本的数据库在打开时重建所有图片索引
+const boltSchemaVersion = 3
+
+// imageIndexBuckets 由图片元数据派生、可以随时重建的索引
+var imageIndexBuckets = [][]byte{
+	bucketUserIndex, bucketUserSizeIndex, bucketUserFilenameIndex,
+	bucketTimeIndex, bucketSlugIndex, bucketHashIndex, bucketAlbumImageIndex,
+	bucketUserTrashIndex, bucketTrashIndex,
+}
+
+// userSortIndexes 各排序字段对应的用户索引
+var userSortIndexes = map[string][]byte{
+	SortUploadedAt: bucketUserIndex,
+	SortSize:       bucketUserSizeIndex,
+	SortFilename:   bucketUserFilenameIndex,
+}
+
+// BoltMetadataStore 基于 bbolt 嵌入式数据库的元数据存储
+type BoltMetadataStore struct {
+	db *bolt.DB
+}
+
+// NewBoltMetadataStore 打开（或创建）bolt 元数据库
+func NewBoltMetadataStore(path string) (*BoltMetadataStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建元数据目录失败: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开元数据库失败: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			bucketImages, bucketUserIndex, bucketUserSizeIndex, bucketUserFilenameIndex,
+			bucketTimeIndex, bucketSlugIndex, bucketHashIndex, bucketAlbums, bucketUserAlbumIndex,
+			bucketAlbumImageIndex, bucketUserTrashIndex, bucketTrashIndex, bucketMeta,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return migrateSchema(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化元数据库失败: %w", err)
+	}
+
+	return &BoltMetadataStore{db: db}, nil
+}
+
+// Put 新增或更新图片元数据
+func (s *BoltMetadataStore) Put(image *ImageInfo) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putImage(tx, image)
+	})
+}
+
+// Get 根据ID获取图片元数据
+func (s *BoltMetadataStore) Get(id string) (*ImageInfo, error) {
+	var image *ImageInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		image, err = getImage(tx, id)
+		return err
+	})
+	return image, err
+}
+
+// GetBySlug 根据公开标识获取图片元数据
+func (s *BoltMetadataStore) GetBySlug(slug string) (*ImageInfo, error) {
+	var image *ImageInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(bucketSlugIndex).Get([]byte(slug))
+		if id == nil {
+			return ErrImageNotFound
+		}
+		var err error
+		image, err = getImage(tx, string(id))
+		return err
+	})
+	return image, err
+}
+
+// Delete 删除图片元数据
+func (s *BoltMetadataStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		image, err := getImage(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := deleteIndexes(tx, image); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketImages).Delete([]byte(id))
+	})
+}
+
+// HashRefs 引用指定内容哈希的图片数量
+func (s *BoltMetadataStore) HashRefs(hash string) (int, error) {
+	refs := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := append([]byte(hash), 0)
+		c := tx.Bucket(bucketHashIndex).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			refs++
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// Query 按条件分页列出用户的图片
+//
+// 按排序字段对应的索引顺序遍历，过滤条件只需要读取索引中保存的上传时间和类型，
+// 只有放入当前页的图片才会读取完整的元数据。
+func (s *BoltMetadataStore) Query(userID string, opts ListOptions) (*ImageList, error) {
+	p, err := newPager(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := append([]byte(userID), 0)
+	start, end := prefix, prefixEnd(prefix)
+	// 按上传时间排序时，时间范围可以直接转换为索引键的范围
+	if p.sortBy == SortUploadedAt {
+		if !p.from.IsZero() {
+			start = append(append([]byte(nil), prefix...), timeKey(p.from)...)
+		}
+		if !p.to.IsZero() {
+			end = append(append([]byte(nil), prefix...), timeKey(p.to)...)
+		}
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(userSortIndexes[p.sortBy]).Cursor()
+
+		var k, v []byte
+		if p.desc {
+			// 定位到范围内的最后一项
+			if k, v = c.Seek(end); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Seek(start)
+		}
+
+		for ; k != nil && bytes.Compare(k, start) >= 0 && bytes.Compare(k, end) < 0; k, v = step(c, p.desc) {
+			uploadedAt, mimeType := decodeIndexValue(v)
+			if !p.match(uploadedAt, mimeType) {
+				continue
+			}
+
+			key := k[len(prefix):]
+			if !p.offer(key) {
+				continue
+			}
+			image, err := getImage(tx, indexKeyID(p.sortBy, key))
+			if err != nil {
+				return err
+			}
+			p.list.Images = append(p.list.Images, image)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.finish(), nil
+}
+
+// ListByUser 按上传时间升序列出用户的所有图片
+func (s *BoltMetadataStore) ListByUser(userID string) ([]*ImageInfo, error) {
+	var images []*ImageInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := append([]byte(userID), 0)
+		c := tx.Bucket(bucketUserIndex).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			id := string(k[len(prefix)+8:])
+			image, err := getImage(tx, id)
+			if err != nil {
+				return err
+			}
+			images = append(images, image)
+		}
+		return nil
+	})
+	return images, err
+}
+
+// ListTrash 按移到回收站的时间降序列出用户回收站中的图片
+func (s *BoltMetadataStore) ListTrash(userID string) ([]*ImageInfo, error) {
+	images := []*ImageInfo{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := append([]byte(userID), 0)
+		c := tx.Bucket(bucketUserTrashIndex).Cursor()
+		k, _ := c.Seek(prefixEnd(prefix))
+		if k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+			image, err := getImage(tx, string(k[len(prefix)+8:]))
+			if err != nil {
+				return err
+			}
+			images = append(images, image)
+		}
+		return nil
+	})
+	return images, err
+}
+
+// ListTrashedBefore 列出所有用户在 before 之前移到回收站的图片
+func (s *BoltMetadataStore) ListTrashedBefore(before time.Time) ([]*ImageInfo, error) {
+	var images []*ImageInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		end := timeKey(before)
+		c := tx.Bucket(bucketTrashIndex).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			image, err := getImage(tx, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			images = append(images, image)
+		}
+		return nil
+	})
+	return images, err
+}
+
+// Close 关闭数据库
+func (s *BoltMetadataStore) Close() error {
+	return s.db.Close()
+}
+
+// importImages 在一个事务中批量导入图片元数据
+func (s *BoltMetadataStore) importImages(images []*ImageInfo) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, image := range images {
+			if err := putImage(tx, image); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateSchema 索引结构升级后重建所有图片的索引
+func migrateSchema(tx *bolt.Tx) error {
+	meta := tx.Bucket(bucketMeta)
+	if version := meta.Get([]byte("version")); version != nil && binary.BigEndian.Uint32(version) >= boltSchemaVersion {
+		return nil
+	}
+
+	for _, name := range imageIndexBuckets {
+		if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+
+	err := tx.Bucket(bucketImages).ForEach(func(k, v []byte) error {
+		var image ImageInfo
+		if err := json.Unmarshal(v, &image); err != nil {
+			return fmt.Errorf("解析元数据失败: %w", err)
+		}
+		return putIndexes(tx, &image)
+	})
+	if err != nil {
+		return err
+	}
+
+	version := make([]byte, 4)
+	binary.BigEndian.PutUint32(version, boltSchemaVersion)
+	return meta.Put([]byte("version"), version)
+}
+
+// putImage 写入图片元数据并维护索引
+func putImage(tx *bolt.Tx, image *ImageInfo) error {
+	// 如果是更新，先移除旧的索引项
+	if old, err := getImage(tx, image.ID); err == nil {
+		if err := deleteIndexes(tx, old); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketImages).Put([]byte(image.ID), data); err != nil {
+		return err
+	}
+	return putIndexes(tx, image)
+}
+
+// putIndexes 写入图片的索引项
+func putIndexes(tx *bolt.Tx, image *ImageInfo) error {
+	if image.Trashed() {
+		return putTrashIndexes(tx, image)
+	}
+
+	value := indexValue(image)
+	for sortBy, bucket := range userSortIndexes {
+		if err := tx.Bucket(bucket).Put(userSortKey(image, sortBy), value); err != nil {
+			return err
+		}
+	}
+	if image.Slug != "" {
+		if err := tx.Bucket(bucketSlugIndex).Put([]byte(image.Slug), []byte(image.ID)); err != nil {
+			return err
+		}
+	}
+	if image.Hash != "" {
+		if err := tx.Bucket(bucketHashIndex).Put(hashIndexKey(image), nil); err != nil {
+			return err
+		}
+	}
+	for _, albumID := range image.Albums {
+		if err := tx.Bucket(bucketAlbumImageIndex).Put(albumImageIndexKey(albumID, image), nil); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(bucketTimeIndex).Put(timeIndexKey(image), nil)
+}
+
+// putTrashIndexes 写入回收站中图片的索引项
+//
+// 回收站中的图片不写入用户、公开标识和相册索引，不会出现在列表中；内容哈希索引保留到永久删除。
+func putTrashIndexes(tx *bolt.Tx, image *ImageInfo) error {
+	if image.Hash != "" {
+		if err := tx.Bucket(bucketHashIndex).Put(hashIndexKey(image), nil); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(bucketUserTrashIndex).Put(userTrashKey(image), nil); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketTrashIndex).Put(trashIndexKey(image), nil); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketTimeIndex).Put(timeIndexKey(image), nil)
+}
+
+// getImage 读取图片元数据
+func getImage(tx *bolt.Tx, id string) (*ImageInfo, error) {
+	data := tx.Bucket(bucketImages).Get([]byte(id))
+	if data == nil {
+		return nil, ErrImageNotFound
+	}
+
+	var image ImageInfo
+	if err := json.Unmarshal(data, &image); err != nil {
+		return nil, fmt.Errorf("解析元数据失败: %w", err)
+	}
+	return &image, nil
+}
+
+// deleteIndexes 删除图片的索引项
+func deleteIndexes(tx *bolt.Tx, image *ImageInfo) error {
+	if image.Trashed() {
+		return deleteTrashIndexes(tx, image)
+	}
+
+	for sortBy, bucket := range userSortIndexes {
+		if err := tx.Bucket(bucket).Delete(userSortKey(image, sortBy)); err != nil {
+			return err
+		}
+	}
+	if image.Slug != "" {
+		if err := tx.Bucket(bucketSlugIndex).Delete([]byte(image.Slug)); err != nil {
+			return err
+		}
+	}
+	if image.Hash != "" {
+		if err := tx.Bucket(bucketHashIndex).Delete(hashIndexKey(image)); err != nil {
+			return err
+		}
+	}
+	for _, albumID := range image.Albums {
+		if err := tx.Bucket(bucketAlbumImageIndex).Delete(albumImageIndexKey(albumID, image)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(bucketTimeIndex).Delete(timeIndexKey(image))
+}
+
+// deleteTrashIndexes 删除回收站中图片的索引项
+func deleteTrashIndexes(tx *bolt.Tx, image *ImageInfo) error {
+	if image.Hash != "" {
+		if err := tx.Bucket(bucketHashIndex).Delete(hashIndexKey(image)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(bucketUserTrashIndex).Delete(userTrashKey(image)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketTrashIndex).Delete(trashIndexKey(image)); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketTimeIndex).Delete(timeIndexKey(image))
+}
+
+// userSortKey 构建用户排序索引键，同一用户的图片按排序字段排列
+func userSortKey(image *ImageInfo, sortBy string) []byte {
+	key := append([]byte(image.UserID), 0)
+	return append(key, sortKey(image, sortBy)...)
+}
+
+// indexKeyID 从排序键中取出图片ID
+func indexKeyID(sortBy string, key []byte) string {
+	switch sortBy {
+	case SortFilename:
+		return string(key[bytes.LastIndexByte(key, 0)+1:])
+	default:
+		return string(key[8:])
+	}
+}
+
+// indexValue 用户索引项的值：上传时间和类型，用于在不读取元数据的情况下过滤
+func indexValue(image *ImageInfo) []byte {
+	return append(timeKey(image.UploadedAt), image.MimeType...)
+}
+
+func decodeIndexValue(value []byte) (time.Time, string) {
+	if len(value) < 8 {
+		return time.Time{}, ""
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value[:8]))), string(value[8:])
+}
+
+// prefixEnd 返回比所有以 prefix 开头的键都大的最小键
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	end[len(end)-1]++
+	return end
+}
+
+// step 按方向移动游标
+func step(c *bolt.Cursor, desc bool) ([]byte, []byte) {
+	if desc {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+// albumImageIndexKey 构建相册图片索引键，同一相册的图片按上传时间排序
+func albumImageIndexKey(albumID string, image *ImageInfo) []byte {
+	key := make([]byte, 0, len(albumID)+1+8+len(image.ID))
+	key = append(key, albumID...)
+	key = append(key, 0)
+	key = append(key, timeKey(image.UploadedAt)...)
+	return append(key, image.ID...)
+}
+
+// hashIndexKey 构建内容哈希索引键
+func hashIndexKey(image *ImageInfo) []byte {
+	key := append([]byte(image.Hash), 0)
+	return append(key, image.ID...)
+}
+
+// userTrashKey 构建用户回收站索引键，同一用户的图片按移到回收站的时间排序
+func userTrashKey(image *ImageInfo) []byte {
+	key := append([]byte(image.UserID), 0)
+	return append(key, trashIndexKey(image)...)
+}
+
+// trashIndexKey 构建回收站索引键
+func trashIndexKey(image *ImageInfo) []byte {
+	return append(timeKey(*image.DeletedAt), image.ID...)
+}
+
+// timeIndexKey 构建上传时间索引键
+func timeIndexKey(image *ImageInfo) []byte {
+	return append(timeKey(image.UploadedAt), image.ID...)
+}
+
+// timeKey 将时间编码为可按字节排序的8字节大端序
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestBoltStore 在临时目录中创建 bolt 元数据库
+func newTestBoltStore(t *testing.T) (*BoltMetadataStore, string) {
+	dir := t.TempDir()
+	store, err := NewBoltMetadataStore(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, dir
+}
+
+// imageIDs 图片ID列表，用于比较顺序
+func imageIDs(images []*ImageInfo) []string {
+	ids := make([]string, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMigrateJSONMetadata(t *testing.T) {
+	store, dir := newTestBoltStore(t)
+	base := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// 旧版文件以ID为键，部分条目本身没有记录ID
+	legacy := map[string]*ImageInfo{
+		"b": {ID: "b", UserID: "u1", Filename: "b.png", UploadedAt: base.Add(time.Minute)},
+		"a": {UserID: "u1", Filename: "a.png", UploadedAt: base},
+		"c": {ID: "c", UserID: "u2", Filename: "c.png", UploadedAt: base},
+	}
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(dir, "metadata.json")
+	if err := os.WriteFile(jsonPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateJSONMetadata(store, jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	images, err := store.ListByUser("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := imageIDs(images); !equalIDs(ids, []string{"a", "b"}) {
+		t.Fatalf("ListByUser(u1) = %v", ids)
+	}
+	if image, err := store.Get("a"); err != nil || image.Filename != "a.png" || !image.UploadedAt.Equal(base) {
+		t.Fatalf("Get(a) = %+v, %v", image, err)
+	}
+
+	// 导入后原文件被重命名，再次迁移不会重复导入
+	if _, err := os.Stat(jsonPath); !os.IsNotExist(err) {
+		t.Fatalf("metadata.json still present: %v", err)
+	}
+	if _, err := os.Stat(jsonPath + ".migrated"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateJSONMetadata(store, jsonPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateJSONMetadataInvalid(t *testing.T) {
+	store, dir := newTestBoltStore(t)
+	jsonPath := filepath.Join(dir, "metadata.json")
+	if err := os.WriteFile(jsonPath, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateJSONMetadata(store, jsonPath); err == nil {
+		t.Fatal("invalid metadata.json accepted")
+	}
+	// 解析失败时保留原文件，修复后可以重新迁移
+	if _, err := os.Stat(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltMetadataUpdatesIndexes(t *testing.T) {
+	store, _ := newTestBoltStore(t)
+	base := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c"} {
+		if err := store.Put(&ImageInfo{ID: id, UserID: "u1", UploadedAt: base.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 更新时移除旧的索引项，图片只出现一次并按新的时间排序
+	if err := store.Put(&ImageInfo{ID: "a", UserID: "u1", UploadedAt: base.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	images, err := store.ListByUser("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := imageIDs(images); !equalIDs(ids, []string{"b", "c", "a"}) {
+		t.Fatalf("after update: %v", ids)
+	}
+
+	if err := store.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("b"); !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("Get after delete: err = %v", err)
+	}
+	if err := store.Delete("b"); !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("second delete: err = %v", err)
+	}
+	images, err = store.ListByUser("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := imageIDs(images); !equalIDs(ids, []string{"c", "a"}) {
+		t.Fatalf("after delete: %v", ids)
+	}
+	if images, _ := store.ListByUser("u2"); len(images) != 0 {
+		t.Fatalf("other user sees %d images", len(images))
+	}
+}
+
+func TestBoltMetadataRebuildsIndexes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metadata.db")
+	store, err := NewBoltMetadataStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c"} {
+		image := &ImageInfo{ID: id, UserID: "u1", Filename: id + ".png", Size: int64(3 - i), UploadedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := store.Put(image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟旧版本的数据库：索引丢失，版本号较低
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range imageIndexBuckets {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketMeta).Delete([]byte("version"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := store.Query("u1", ListOptions{}); len(list.Images) != 0 {
+		t.Fatalf("indexes not cleared: %v", imageIDs(list.Images))
+	}
+	store.Close()
+
+	// 重新打开时按元数据重建所有索引
+	store, err = NewBoltMetadataStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for sortBy, want := range map[string][]string{
+		SortUploadedAt: {"a", "b", "c"},
+		SortSize:       {"c", "b", "a"},
+		SortFilename:   {"a", "b", "c"},
+	} {
+		list, err := store.Query("u1", ListOptions{SortBy: sortBy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := imageIDs(list.Images); !equalIDs(ids, want) || list.Total != 3 {
+			t.Errorf("%s: %v (total %d), want %v", sortBy, ids, list.Total, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 图片列表的排序字段
+const (
+	SortUploadedAt = "uploaded_at"
+	SortSize       = "size"
+	SortFilename   = "filename"
+)
+
+// ErrInvalidCursor 分页游标无效，或者与排序字段不匹配
+var ErrInvalidCursor = errors.New("分页游标无效")
+
+// ErrInvalidSort 不支持的排序字段
+var ErrInvalidSort = errors.New("排序字段必须是 uploaded_at、size 或 filename")
+
+// ListOptions 列出图片的排序、过滤和分页条件
+type ListOptions struct {
+	// SortBy 排序字段，为空时按上传时间排序
+	SortBy string
+	// Desc 是否降序
+	Desc bool
+	// MimeTypes 只列出这些类型的图片，为空表示不限
+	MimeTypes []string
+	// From 上传时间下限（含），零值表示不限
+	From time.Time
+	// To 上传时间上限（不含），零值表示不限
+	To time.Time
+	// Cursor 上一页返回的 NextCursor，为空表示第一页
+	Cursor string
+	// Limit 每页数量，0 表示不分页
+	Limit int
+}
+
+// ImageList 一页图片
+type ImageList struct {
+	Images []*ImageInfo `json:"images"`
+	// Total 满足过滤条件的图片总数（不受分页影响）
+	Total int `json:"total"`
+	// NextCursor 下一页的游标，没有更多图片时为空
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// IsValidSort 检查是否为支持的排序字段
+func IsValidSort(sortBy string) bool {
+	switch sortBy {
+	case "", SortUploadedAt, SortSize, SortFilename:
+		return true
+	}
+	return false
+}
+
+// PageImages 在内存中对图片排序、过滤和分页
+//
+// 用于无法在存储层完成这些操作的元数据存储，以及搜索结果的分页。游标格式与 bolt
+// 索引一致，两种方式得到的游标可以互相使用。
+func PageImages(images []*ImageInfo, opts ListOptions) (*ImageList, error) {
+	p, err := newPager(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		key   []byte
+		image *ImageInfo
+	}
+	entries := make([]entry, 0, len(images))
+	for _, image := range images {
+		entries = append(entries, entry{key: sortKey(image, p.sortBy), image: image})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	for i := range entries {
+		e := entries[i]
+		if p.desc {
+			e = entries[len(entries)-1-i]
+		}
+		if !p.match(e.image.UploadedAt, e.image.MimeType) {
+			continue
+		}
+		if p.offer(e.key) {
+			p.list.Images = append(p.list.Images, e.image)
+		}
+	}
+	return p.finish(), nil
+}
+
+// pager 按排序顺序逐个接收图片，统计总数并收集游标之后的一页
+type pager struct {
+	sortBy    string
+	desc      bool
+	mimeTypes map[string]bool
+	from, to  time.Time
+	after     []byte // 游标位置，nil 表示从头开始
+	limit     int
+
+	list    *ImageList
+	lastKey []byte
+	more    bool
+}
+
+func newPager(opts ListOptions) (*pager, error) {
+	if !IsValidSort(opts.SortBy) {
+		return nil, ErrInvalidSort
+	}
+	sortBy := opts.SortBy
+	if sortBy == "" {
+		sortBy = SortUploadedAt
+	}
+
+	p := &pager{
+		sortBy: sortBy,
+		desc:   opts.Desc,
+		from:   opts.From,
+		to:     opts.To,
+		limit:  opts.Limit,
+		list:   &ImageList{Images: []*ImageInfo{}},
+	}
+	if len(opts.MimeTypes) > 0 {
+		p.mimeTypes = make(map[string]bool, len(opts.MimeTypes))
+		for _, mimeType := range opts.MimeTypes {
+			p.mimeTypes[mimeType] = true
+		}
+	}
+	if opts.Cursor != "" {
+		after, err := decodeCursor(sortBy, opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		p.after = after
+	}
+	return p, nil
+}
+
+// match 检查图片是否满足过滤条件
+func (p *pager) match(uploadedAt time.Time, mimeType string) bool {
+	if p.mimeTypes != nil && !p.mimeTypes[mimeType] {
+		return false
+	}
+	if !p.from.IsZero() && uploadedAt.Before(p.from) {
+		return false
+	}
+	if !p.to.IsZero() && !uploadedAt.Before(p.to) {
+		return false
+	}
+	return true
+}
+
+// offer 计入一张满足条件的图片，返回是否应放入当前页
+func (p *pager) offer(key []byte) bool {
+	p.list.Total++
+
+	if p.after != nil {
+		cmp := bytes.Compare(key, p.after)
+		if (!p.desc && cmp <= 0) || (p.desc && cmp >= 0) {
+			return false
+		}
+	}
+	if p.limit > 0 && len(p.list.Images) >= p.limit {
+		p.more = true
+		return false
+	}
+
+	p.lastKey = append(p.lastKey[:0], key...)
+	return true
+}
+
+// finish 生成结果，还有更多图片时给出下一页的游标
+func (p *pager) finish() *ImageList {
+	if p.more {
+		p.list.NextCursor = encodeCursor(p.sortBy, p.lastKey)
+	}
+	return p.list
+}
+
+// sortKey 图片在指定排序字段下的排序键，末尾附加ID保证唯一
+//
+// 上传时间和大小编码为8字节大端序，文件名不区分大小写，后面用 0x00 与ID隔开。
+func sortKey(image *ImageInfo, sortBy string) []byte {
+	var key []byte
+	switch sortBy {
+	case SortSize:
+		key = make([]byte, 8, 8+len(image.ID))
+		binary.BigEndian.PutUint64(key, uint64(image.Size))
+	case SortFilename:
+		key = append([]byte(strings.ToLower(image.Filename)), 0)
+	default:
+		key = timeKey(image.UploadedAt)
+	}
+	return append(key, image.ID...)
+}
+
+// encodeCursor 将排序字段和排序键编码为不透明的游标
+func encodeCursor(sortBy string, key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(append([]byte(sortBy+":"), key...))
+}
+
+// decodeCursor 解码游标，排序字段不一致时返回 ErrInvalidCursor
+func decodeCursor(sortBy string, cursor string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	field, key, ok := bytes.Cut(data, []byte(":"))
+	if !ok || string(field) != sortBy || len(key) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return key, nil
+}
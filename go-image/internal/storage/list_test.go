@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testImages 生成一组图片，上传时间、大小和文件名都有重复，用于检查并列时的顺序
+func testImages() []*ImageInfo {
+	base := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	images := make([]*ImageInfo, 0, 10)
+	for i := 0; i < 10; i++ {
+		mimeType := "image/png"
+		if i%3 == 0 {
+			mimeType = "image/jpeg"
+		}
+		images = append(images, &ImageInfo{
+			ID:         fmt.Sprintf("id-%02d", i),
+			UserID:     "u1",
+			Filename:   fmt.Sprintf("Photo-%d.png", i%4),
+			Size:       int64(100 * (i % 3)),
+			MimeType:   mimeType,
+			UploadedAt: base.Add(time.Duration(i/2) * time.Minute),
+		})
+	}
+	return images
+}
+
+// listAll 逐页列出所有图片，检查每页不超过 limit，返回图片ID
+func listAll(t *testing.T, list func(ListOptions) (*ImageList, error), opts ListOptions) []string {
+	t.Helper()
+	var ids []string
+	for page := 0; ; page++ {
+		result, err := list(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Images) > opts.Limit {
+			t.Fatalf("page %d has %d images, limit %d", page, len(result.Images), opts.Limit)
+		}
+		ids = append(ids, imageIDs(result.Images)...)
+		if result.NextCursor == "" {
+			return ids
+		}
+		if page > 20 {
+			t.Fatal("pagination does not terminate")
+		}
+		opts.Cursor = result.NextCursor
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := []byte("user\x00key\x00with\xffbytes")
+	cursor := encodeCursor(SortFilename, key)
+	got, err := decodeCursor(SortFilename, cursor)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("decodeCursor = %q, %v", got, err)
+	}
+
+	for name, c := range map[string]string{
+		"other sort field": encodeCursor(SortSize, key),
+		"not base64":       "%%%",
+		"no field":         "a2V5",
+		"empty key":        encodeCursor(SortFilename, nil),
+	} {
+		if _, err := decodeCursor(SortFilename, c); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: err = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestPageImagesStable(t *testing.T) {
+	images := testImages()
+	for _, sortBy := range []string{SortUploadedAt, SortSize, SortFilename} {
+		for _, desc := range []bool{false, true} {
+			all, err := PageImages(images, ListOptions{SortBy: sortBy, Desc: desc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := imageIDs(all.Images)
+			for _, limit := range []int{1, 3, 4} {
+				opts := ListOptions{SortBy: sortBy, Desc: desc, Limit: limit}
+				got := listAll(t, func(opts ListOptions) (*ImageList, error) { return PageImages(images, opts) }, opts)
+				if !equalIDs(got, want) {
+					t.Errorf("%s desc=%v limit=%d: %v, want %v", sortBy, desc, limit, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestPageImagesFilters(t *testing.T) {
+	images := testImages()
+	base := images[0].UploadedAt
+	list, err := PageImages(images, ListOptions{
+		MimeTypes: []string{"image/png"},
+		From:      base.Add(time.Minute),
+		To:        base.Add(4 * time.Minute),
+		Limit:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第 2 到 7 张中的 PNG：2、4、5、7
+	if ids := imageIDs(list.Images); !equalIDs(ids, []string{"id-02", "id-04"}) || list.Total != 4 || list.NextCursor == "" {
+		t.Fatalf("got %v total=%d cursor=%q", ids, list.Total, list.NextCursor)
+	}
+
+	if _, err := PageImages(images, ListOptions{SortBy: "width"}); !errors.Is(err, ErrInvalidSort) {
+		t.Fatalf("invalid sort: err = %v", err)
+	}
+	if _, err := PageImages(images, ListOptions{SortBy: SortSize, Cursor: list.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("cursor of another sort field: err = %v", err)
+	}
+}
+
+// TestPaginationAcrossChanges 翻页期间新增和删除图片时，已有的图片不会重复或遗漏
+func TestPaginationAcrossChanges(t *testing.T) {
+	store, _ := newTestBoltStore(t)
+	images := testImages()
+	for _, image := range images {
+		if err := store.Put(image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := store.Query("u1", ListOptions{Limit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 翻页之间上传了一张更早的图片，并删除了下一页中的一张
+	early := &ImageInfo{ID: "id-early", UserID: "u1", MimeType: "image/png", UploadedAt: images[0].UploadedAt.Add(-time.Hour)}
+	if err := store.Put(early); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("id-05"); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(opts ListOptions) (*ImageList, error) { return store.Query("u1", opts) }
+	rest := listAll(t, query, ListOptions{Limit: 4, Cursor: first.NextCursor})
+	got := append(imageIDs(first.Images), rest...)
+	want := []string{"id-00", "id-01", "id-02", "id-03", "id-04", "id-06", "id-07", "id-08", "id-09"}
+	if !equalIDs(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestBoltQueryMatchesPageImages 数据库索引与内存分页的结果和游标一致
+func TestBoltQueryMatchesPageImages(t *testing.T) {
+	store, _ := newTestBoltStore(t)
+	images := testImages()
+	for _, image := range images {
+		if err := store.Put(image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, sortBy := range []string{SortUploadedAt, SortSize, SortFilename} {
+		for _, desc := range []bool{false, true} {
+			opts := ListOptions{SortBy: sortBy, Desc: desc, MimeTypes: []string{"image/png"}, Limit: 3}
+			fromStore, err := store.Query("u1", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inMemory, err := PageImages(images, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalIDs(imageIDs(fromStore.Images), imageIDs(inMemory.Images)) ||
+				fromStore.Total != inMemory.Total || fromStore.NextCursor != inMemory.NextCursor {
+				t.Errorf("%s desc=%v: store %v/%d/%q, memory %v/%d/%q", sortBy, desc,
+					imageIDs(fromStore.Images), fromStore.Total, fromStore.NextCursor,
+					imageIDs(inMemory.Images), inMemory.Total, inMemory.NextCursor)
+			}
+
+			// 内存分页得到的游标可以继续在数据库中翻页
+			opts.Cursor = inMemory.NextCursor
+			next, err := store.Query("u1", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := PageImages(images, opts)
+			if !equalIDs(imageIDs(next.Images), imageIDs(want.Images)) {
+				t.Errorf("%s desc=%v page 2: %v, want %v", sortBy, desc, imageIDs(next.Images), imageIDs(want.Images))
+			}
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package storage
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -18,197 +19,251 @@ import (
 
 // LocalStorage 实现本地文件系统存储
 type LocalStorage struct {
-	basePath string
-	images   map[string]*ImageInfo
-	mu       sync.RWMutex
-	metaFile string
+	basePath  string
+	thumbPath string
+	meta      MetadataStore
+
+	// blobMutex 串行化内容文件的写入和删除，避免删除最后一个引用时与相同内容的上传交错
+	blobMutex sync.Mutex
 }
 
-// NewLocalStorage 创建一个新的本地存储实例
-func NewLocalStorage(basePath string) (*LocalStorage, error) {
+// NewLocalStorage 创建一个新的本地存储实例，thumbPath 为空时使用 basePath 下的 thumbnails 目录
+func NewLocalStorage(basePath string, thumbPath string, meta MetadataStore) (*LocalStorage, error) {
 	// 确保基础目录存在
 	if err := os.MkdirAll(basePath, 0755); err != nil {
 		return nil, fmt.Errorf("创建存储目录失败: %w", err)
 	}
 
 	// 确保缩略图目录存在
-	thumbPath := filepath.Join(basePath, "thumbnails")
+	if thumbPath == "" {
+		thumbPath = filepath.Join(basePath, "thumbnails")
+	}
 	if err := os.MkdirAll(thumbPath, 0755); err != nil {
 		return nil, fmt.Errorf("创建缩略图目录失败: %w", err)
 	}
 
 	// 创建存储实例
 	storage := &LocalStorage{
-		basePath: basePath,
-		images:   make(map[string]*ImageInfo),
-		metaFile: filepath.Join(basePath, "metadata.json"),
+		basePath:  basePath,
+		thumbPath: thumbPath,
+		meta:      meta,
 	}
 
-	// 加载元数据
-	if err := storage.loadMetadata(); err != nil {
-		// 如果文件不存在，不返回错误
-		if !os.IsNotExist(err) {
-			return nil, fmt.Errorf("加载元数据失败: %w", err)
-		}
+	// 迁移旧版 metadata.json
+	if err := migrateJSONMetadata(meta, filepath.Join(basePath, "metadata.json")); err != nil {
+		return nil, fmt.Errorf("迁移元数据失败: %w", err)
 	}
 
 	return storage, nil
 }
 
 // Save 保存图片到本地存储
-func (s *LocalStorage) Save(userID string, filename string, mimeType string, content io.Reader) (*ImageInfo, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	// 生成唯一ID
-	id := uuid.New().String()
-
-	// 创建用户目录
-	userDir := filepath.Join(s.basePath, userID)
-	if err := os.MkdirAll(userDir, 0755); err != nil {
-		return nil, fmt.Errorf("创建用户目录失败: %w", err)
-	}
-
-	// 确保文件名安全
-	safeFilename := sanitizeFilename(filename)
-
-	// 构建文件路径
-	extension := filepath.Ext(safeFilename)
-	if extension == "" {
-		// 根据MIME类型添加默认扩展名
-		switch mimeType {
-		case "image/jpeg":
-			extension = ".jpg"
-		case "image/png":
-			extension = ".png"
-		case "image/gif":
-			extension = ".gif"
-		case "image/webp":
-			extension = ".webp"
-		default:
-			extension = ".bin"
-		}
+//
+// 内容边写入临时文件边计算 SHA-256，已经存在相同内容的文件时丢弃临时文件，只新增元数据。
+func (s *LocalStorage) Save(imageInfo *ImageInfo, content io.Reader) error {
+	// 写入临时文件并计算哈希
+	blobDir := filepath.Join(s.basePath, "blobs")
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return fmt.Errorf("创建存储目录失败: %w", err)
 	}
-
-	// 构建存储路径
-	relativePath := filepath.Join(userID, fmt.Sprintf("%s%s", id, extension))
-	fullPath := filepath.Join(s.basePath, relativePath)
-
-	// 创建文件
-	file, err := os.Create(fullPath)
+	file, err := os.CreateTemp(blobDir, ".upload-*")
 	if err != nil {
-		return nil, fmt.Errorf("创建文件失败: %w", err)
+		return fmt.Errorf("创建文件失败: %w", err)
 	}
-	defer file.Close()
+	tempPath := file.Name()
+	defer os.Remove(tempPath) // 已经移动到内容路径时忽略错误
 
-	// 写入内容
-	size, err := io.Copy(file, content)
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hasher), content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		// 如果写入失败，删除文件
-		os.Remove(fullPath)
-		return nil, fmt.Errorf("写入文件失败: %w", err)
+		return fmt.Errorf("写入文件失败: %w", err)
 	}
-
-	// 创建图片信息
-	imageInfo := &ImageInfo{
-		ID:         id,
-		UserID:     userID,
-		Filename:   safeFilename,
-		Size:       size,
-		MimeType:   mimeType,
-		Path:       relativePath,
-		UploadedAt: time.Now(),
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	// 补全图片信息
+	imageInfo.ID = uuid.New().String()
+	imageInfo.Filename = sanitizeFilename(imageInfo.Filename) // 确保文件名安全
+	imageInfo.Size = size
+	imageInfo.Path = blobPath(hash, imageInfo.MimeType)
+	imageInfo.Hash = hash
+	imageInfo.UploadedAt = time.Now()
+
+	s.blobMutex.Lock()
+	defer s.blobMutex.Unlock()
+
+	fullPath := filepath.Join(s.basePath, imageInfo.Path)
+	created := false
+	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return fmt.Errorf("创建存储目录失败: %w", err)
+		}
+		if err := os.Rename(tempPath, fullPath); err != nil {
+			return fmt.Errorf("保存文件失败: %w", err)
+		}
+		created = true
+	} else if err != nil {
+		return fmt.Errorf("检查文件失败: %w", err)
 	}
 
-	// 保存到内存映射
-	s.images[id] = imageInfo
-
 	// 保存元数据
-	if err := s.saveMetadata(); err != nil {
-		return nil, fmt.Errorf("保存元数据失败: %w", err)
+	if err := s.meta.Put(imageInfo); err != nil {
+		if created {
+			os.Remove(fullPath)
+		}
+		return fmt.Errorf("保存元数据失败: %w", err)
 	}
 
-	return imageInfo, nil
+	return nil
 }
 
-// Get 获取图片信息
+// Get 获取图片信息，回收站中的图片视为不存在
 func (s *LocalStorage) Get(userID string, id string) (*ImageInfo, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	image, err := s.meta.Get(id)
+	if err != nil || image.UserID != userID || image.Trashed() {
+		return nil, ErrImageNotFound
+	}
+	return image, nil
+}
 
-	image, exists := s.images[id]
-	if !exists || image.UserID != userID {
-		return nil, errors.New("图片不存在")
+// Lookup 根据ID获取图片信息，不校验所有者
+func (s *LocalStorage) Lookup(id string) (*ImageInfo, error) {
+	image, err := s.meta.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if image.Trashed() {
+		return nil, ErrImageNotFound
 	}
 	return image, nil
 }
 
-// Delete 删除图片
+// LookupSlug 根据公开标识获取图片信息
+func (s *LocalStorage) LookupSlug(slug string) (*ImageInfo, error) {
+	return s.meta.GetBySlug(slug)
+}
+
+// Update 更新图片元数据
+func (s *LocalStorage) Update(image *ImageInfo) error {
+	return s.meta.Put(image)
+}
+
+// Albums 相册存储
+func (s *LocalStorage) Albums() AlbumStore {
+	return s.meta
+}
+
+// Delete 永久删除图片，包括回收站中的图片
 func (s *LocalStorage) Delete(userID string, id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	image, exists := s.images[id]
-	if !exists || image.UserID != userID {
-		return errors.New("图片不存在")
+	image, err := ownImage(s.meta, userID, id)
+	if err != nil {
+		return err
 	}
 
-	// 删除文件
-	fullPath := filepath.Join(s.basePath, image.Path)
-	err := os.Remove(fullPath)
-	if err != nil {
+	s.blobMutex.Lock()
+	defer s.blobMutex.Unlock()
+
+	// 先删除元数据，即使随后删除文件失败也不会留下指向不存在文件的记录
+	if err := s.meta.Delete(id); err != nil {
+		return fmt.Errorf("删除元数据失败: %w", err)
+	}
+
+	// 删除文件，内容仍被其他图片引用时保留
+	if err := s.releaseBlob(image); err != nil {
 		return fmt.Errorf("删除文件失败: %w", err)
 	}
 
 	// 如果有缩略图，也删除
 	if image.ThumbPath != "" {
-		thumbPath := filepath.Join(s.basePath, "thumbnails", image.ThumbPath)
+		thumbPath := filepath.Join(s.thumbPath, image.ThumbPath)
 		os.Remove(thumbPath) // 忽略错误，因为缩略图可能不存在
 	}
 
-	// 从内存映射中删除
-	delete(s.images, id)
+	return nil
+}
 
-	// 保存元数据
-	if err := s.saveMetadata(); err != nil {
-		return fmt.Errorf("保存元数据失败: %w", err)
+// releaseBlob 删除图片的原文件，按内容保存的文件只有在不再被引用时才删除，调用方需要持有 blobMutex
+func (s *LocalStorage) releaseBlob(image *ImageInfo) error {
+	if image.Hash != "" {
+		refs, err := s.meta.HashRefs(image.Hash)
+		if err != nil || refs > 0 {
+			return err
+		}
 	}
 
+	if err := os.Remove(filepath.Join(s.basePath, image.Path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
-// List 列出所有图片
-func (s *LocalStorage) List(userID string) ([]*ImageInfo, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// List 按条件分页列出用户的图片
+func (s *LocalStorage) List(userID string, opts ListOptions) (*ImageList, error) {
+	return s.meta.Query(userID, opts)
+}
+
+// Trash 将图片移到回收站，文件保留到永久删除
+func (s *LocalStorage) Trash(userID string, id string) (*ImageInfo, error) {
+	return trashImage(s.meta, userID, id)
+}
 
-	var images []*ImageInfo
-	for _, image := range s.images {
-		if image.UserID == userID {
-			images = append(images, image)
-		}
-	}
-	return images, nil
+// Restore 从回收站恢复图片
+func (s *LocalStorage) Restore(userID string, id string) (*ImageInfo, error) {
+	return restoreImage(s.meta, userID, id)
 }
 
-// sanitizeFilename 清理文件名，移除不安全字符
-// saveMetadata 保存元数据到文件
-func (s *LocalStorage) saveMetadata() error {
-	data, err := json.MarshalIndent(s.images, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(s.metaFile, data, 0644)
+// GetTrashed 获取回收站中的图片信息
+func (s *LocalStorage) GetTrashed(userID string, id string) (*ImageInfo, error) {
+	return trashedImage(s.meta, userID, id)
 }
 
-// loadMetadata 从文件加载元数据
-func (s *LocalStorage) loadMetadata() error {
-	data, err := os.ReadFile(s.metaFile)
+// ListTrash 按移到回收站的时间降序列出用户回收站中的图片
+func (s *LocalStorage) ListTrash(userID string) ([]*ImageInfo, error) {
+	return s.meta.ListTrash(userID)
+}
+
+// ListExpiredTrash 列出所有用户在 before 之前移到回收站的图片
+func (s *LocalStorage) ListExpiredTrash(before time.Time) ([]*ImageInfo, error) {
+	return s.meta.ListTrashedBefore(before)
+}
+
+// SaveThumbnail 保存缩略图到本地存储
+func (s *LocalStorage) SaveThumbnail(image *ImageInfo, mimeType string, content io.Reader) error {
+	thumbName := fmt.Sprintf("%s_thumb%s", image.ID, mimeExtension(mimeType))
+	thumbPath := filepath.Join(s.thumbPath, thumbName)
+
+	file, err := os.Create(thumbPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("创建缩略图文件失败: %w", err)
 	}
+	defer file.Close()
 
-	return json.Unmarshal(data, &s.images)
+	if _, err := io.Copy(file, content); err != nil {
+		os.Remove(thumbPath)
+		return fmt.Errorf("写入缩略图失败: %w", err)
+	}
+
+	// 更新图片信息，添加缩略图路径
+	image.ThumbPath = thumbName
+	return s.meta.Put(image)
+}
+
+// Open 打开图片原文件
+func (s *LocalStorage) Open(image *ImageInfo) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.basePath, image.Path))
 }
 
+// OpenThumbnail 打开缩略图文件
+func (s *LocalStorage) OpenThumbnail(image *ImageInfo) (io.ReadCloser, error) {
+	if image.ThumbPath == "" {
+		return nil, errors.New("缩略图不存在")
+	}
+	return os.Open(filepath.Join(s.thumbPath, image.ThumbPath))
+}
+
+// sanitizeFilename 清理文件名，移除不安全字符
 func sanitizeFilename(filename string) string {
 	// 移除路径分隔符和其他不安全字符
 	safe := strings.ReplaceAll(filename, "/", "")
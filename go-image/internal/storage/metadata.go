@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrImageNotFound 图片不存在
+var ErrImageNotFound = errors.New("图片不存在")
+
+// MetadataStore 图片元数据存储接口，相册与图片元数据保存在一起
+type MetadataStore interface {
+	AlbumStore
+
+	// Put 新增或更新图片元数据
+	Put(image *ImageInfo) error
+
+	// Get 根据ID获取图片元数据
+	Get(id string) (*ImageInfo, error)
+
+	// GetBySlug 根据公开标识获取图片元数据，不包括回收站中的图片
+	GetBySlug(slug string) (*ImageInfo, error)
+
+	// Delete 删除图片元数据
+	Delete(id string) error
+
+	// ListByUser 按上传时间升序列出用户的所有图片，不包括回收站中的图片
+	ListByUser(userID string) ([]*ImageInfo, error)
+
+	// HashRefs 引用指定内容哈希的图片数量（包括所有用户和回收站中的图片）
+	HashRefs(hash string) (int, error)
+
+	// Query 按条件分页列出用户的图片，不包括回收站中的图片
+	Query(userID string, opts ListOptions) (*ImageList, error)
+
+	// ListTrash 按移到回收站的时间降序列出用户回收站中的图片
+	ListTrash(userID string) ([]*ImageInfo, error)
+
+	// ListTrashedBefore 列出所有用户在 before 之前移到回收站的图片
+	ListTrashedBefore(before time.Time) ([]*ImageInfo, error)
+
+	// Close 关闭存储
+	Close() error
+}
+
+// MetadataConfig 元数据存储配置
+type MetadataConfig struct {
+	// Type 元数据存储类型：bolt（嵌入式数据库）或 s3（与图片一起保存在 bucket 中）
+	Type string `yaml:"type"`
+	// Path bolt 数据库文件路径
+	Path string `yaml:"path"`
+}
+
+// NewMetadataStore 根据配置创建元数据存储
+func NewMetadataStore(cfg Config) (MetadataStore, error) {
+	switch cfg.Metadata.Type {
+	case "", "bolt":
+		path := cfg.Metadata.Path
+		if path == "" {
+			path = "data/metadata.db"
+		}
+		return NewBoltMetadataStore(path)
+	case "s3":
+		client, err := newS3Client(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		return newS3MetadataStore(client, s3Prefix(cfg.S3.Prefix)), nil
+	default:
+		return nil, fmt.Errorf("不支持的元数据存储类型: %s", cfg.Metadata.Type)
+	}
+}
+
+// migrateJSONMetadata 将旧版 metadata.json 中的数据一次性导入元数据存储
+//
+// 导入成功后原文件会被重命名为 metadata.json.migrated，避免重复导入。
+func migrateJSONMetadata(store MetadataStore, jsonPath string) error {
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	images := make(map[string]*ImageInfo)
+	if err := json.Unmarshal(data, &images); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", jsonPath, err)
+	}
+
+	list := make([]*ImageInfo, 0, len(images))
+	for id, image := range images {
+		if image.ID == "" {
+			image.ID = id
+		}
+		list = append(list, image)
+	}
+
+	if importer, ok := store.(interface{ importImages([]*ImageInfo) error }); ok {
+		// 支持批量导入的存储在一个事务中完成迁移
+		if err := importer.importImages(list); err != nil {
+			return fmt.Errorf("导入元数据失败: %w", err)
+		}
+	} else {
+		for _, image := range list {
+			if err := store.Put(image); err != nil {
+				return fmt.Errorf("导入元数据失败: %w", err)
+			}
+		}
+	}
+
+	return os.Rename(jsonPath, jsonPath+".migrated")
+}
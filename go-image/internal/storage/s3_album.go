@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// 相册在 bucket 中的布局（均位于配置的前缀之下）：
+//
+//	albums/<id>.json               相册
+//	albums-index/<userID>/<id>     用户相册索引（空对象）
+//
+// 相册中的图片通过图片元数据中的 albums 字段筛选，不单独维护索引。
+
+// PutAlbum 新增或更新相册
+func (s *s3MetadataStore) PutAlbum(album *Album) error {
+	data, err := json.Marshal(album)
+	if err != nil {
+		return err
+	}
+	if err := s.client.putObject(s.albumKey(album.ID), "application/json", data); err != nil {
+		return err
+	}
+	return s.client.putObject(s.albumIndexKey(album.UserID, album.ID), "", nil)
+}
+
+// GetAlbum 根据ID获取相册
+func (s *s3MetadataStore) GetAlbum(id string) (*Album, error) {
+	resp, err := s.client.getObject(s.albumKey(id))
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil, ErrAlbumNotFound
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var album Album
+	if err := json.NewDecoder(resp.Body).Decode(&album); err != nil {
+		return nil, fmt.Errorf("解析相册失败: %w", err)
+	}
+	return &album, nil
+}
+
+// DeleteAlbum 删除相册，并从所有图片中移除该相册
+func (s *s3MetadataStore) DeleteAlbum(id string) error {
+	album, err := s.GetAlbum(id)
+	if err != nil {
+		return err
+	}
+
+	images, err := s.ListAlbumImages(id)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		image.RemoveAlbum(id)
+		if err := s.Put(image); err != nil {
+			return err
+		}
+	}
+
+	if err := s.client.deleteObject(s.albumKey(id)); err != nil {
+		return err
+	}
+	return s.client.deleteObject(s.albumIndexKey(album.UserID, id))
+}
+
+// ListAlbums 按顺序列出用户的所有相册
+func (s *s3MetadataStore) ListAlbums(userID string) ([]*Album, error) {
+	prefix := s.albumIndexKey(userID, "")
+	keys, err := s.client.listObjects(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("列出相册失败: %w", err)
+	}
+
+	var albums []*Album
+	for _, key := range keys {
+		album, err := s.GetAlbum(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			// 索引存在但相册已被删除，跳过
+			continue
+		}
+		albums = append(albums, album)
+	}
+
+	sortAlbums(albums)
+	return albums, nil
+}
+
+// ListAlbumImages 按上传时间升序列出相册中的图片
+func (s *s3MetadataStore) ListAlbumImages(albumID string) ([]*ImageInfo, error) {
+	album, err := s.GetAlbum(albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := s.ListByUser(album.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ImageInfo
+	for _, image := range images {
+		if image.InAlbum(albumID) {
+			result = append(result, image)
+		}
+	}
+	return result, nil
+}
+
+func (s *s3MetadataStore) albumKey(id string) string {
+	return s.prefix + path.Join("albums", id+".json")
+}
+
+func (s *s3MetadataStore) albumIndexKey(userID string, id string) string {
+	return s.prefix + "albums-index/" + userID + "/" + id
+}
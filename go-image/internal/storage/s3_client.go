@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errObjectNotFound 对象不存在
+var errObjectNotFound = errors.New("对象不存在")
+
+// s3Client 一个最小化的 S3 兼容 API 客户端，使用 AWS Signature V4 签名
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	http      *http.Client
+}
+
+// s3ListResult ListObjectsV2 的响应
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3Error S3 错误响应
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// newS3Client 根据配置创建 S3 客户端
+func newS3Client(cfg S3Config) (*s3Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("S3 endpoint 不能为空")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket 不能为空")
+	}
+
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("解析 S3 endpoint 失败: %w", err)
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Client{
+		endpoint:  u,
+		region:    region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		http:      &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// putObject 上传对象
+func (c *s3Client) putObject(key string, contentType string, data []byte) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := c.do(http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getObject 下载对象，调用方负责关闭返回的 Body
+func (c *s3Client) getObject(key string) (*http.Response, error) {
+	return c.do(http.MethodGet, key, nil, nil, nil)
+}
+
+// getObjectFrom 从 offset 处开始下载对象，调用方负责关闭返回的 Body
+func (c *s3Client) getObjectFrom(key string, offset int64) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := c.do(http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 不支持 Range 的服务会忽略请求头并返回整个对象
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("对象存储不支持 Range 请求，状态码 %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// deleteObject 删除对象，对象不存在时不返回错误
+func (c *s3Client) deleteObject(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listObjects 列出指定前缀下的所有对象键
+func (c *s3Client) listObjects(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("解析对象列表失败: %w", err)
+		}
+
+		for _, item := range result.Contents {
+			keys = append(keys, item.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return keys, nil
+}
+
+// do 发送签名后的请求，非 2xx 响应会被转换为错误
+func (c *s3Client) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	reqURL := c.objectURL(key)
+	if query != nil {
+		reqURL.RawQuery = canonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(method, reqURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求 S3 失败: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errObjectNotFound
+	}
+
+	var s3Err s3Error
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		return nil, fmt.Errorf("S3 错误 %s: %s", s3Err.Code, s3Err.Message)
+	}
+	return nil, fmt.Errorf("S3 请求失败，状态码 %d", resp.StatusCode)
+}
+
+// objectURL 构建对象的访问地址，支持 path-style 和 virtual-hosted-style
+func (c *s3Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + c.endpoint.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	return &u
+}
+
+// sign 使用 AWS Signature V4 为请求签名
+func (c *s3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// 参与签名的请求头
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = append(signedHeaders, "content-type")
+	}
+	sort.Strings(signedHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, h := range signedHeaders {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	signingKey = hmacSHA256(signingKey, c.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, strings.Join(signedHeaders, ";"), signature,
+	))
+}
+
+// canonicalQuery 按 SigV4 规范对查询参数排序并编码
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode 按 SigV4 规范进行 URI 编码
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || (ch == '/' && !encodeSlash) {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
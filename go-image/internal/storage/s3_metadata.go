@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3MetadataStore 将图片元数据以 JSON 对象的形式保存在 bucket 中
+//
+// 对象布局（均位于配置的前缀之下）：
+//
+//	meta/<id>.json                 图片元数据
+//	meta-index/<userID>/<id>       用户索引（空对象）
+//	meta-slug/<slug>               公开标识索引（内容为图片ID）
+//	meta-hash/<hash>/<id>          内容哈希索引（空对象），用于统计内容的引用数
+//	meta-trash/<userID>/<id>       回收站索引（空对象），回收站中的图片不在用户索引和公开标识索引中
+type s3MetadataStore struct {
+	client *s3Client
+	prefix string
+}
+
+func newS3MetadataStore(client *s3Client, prefix string) *s3MetadataStore {
+	return &s3MetadataStore{client: client, prefix: prefix}
+}
+
+// Put 新增或更新图片元数据
+func (s *s3MetadataStore) Put(image *ImageInfo) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	if err := s.client.putObject(s.metaKey(image.ID), "application/json", data); err != nil {
+		return err
+	}
+	if image.Hash != "" {
+		if err := s.client.putObject(s.hashKey(image.Hash, image.ID), "", nil); err != nil {
+			return err
+		}
+	}
+
+	if image.Trashed() {
+		if image.Slug != "" {
+			if err := s.client.deleteObject(s.slugKey(image.Slug)); err != nil {
+				return err
+			}
+		}
+		if err := s.client.deleteObject(s.indexKey(image.UserID, image.ID)); err != nil {
+			return err
+		}
+		return s.client.putObject(s.trashKey(image.UserID, image.ID), "", nil)
+	}
+
+	if image.Slug != "" {
+		if err := s.client.putObject(s.slugKey(image.Slug), "text/plain", []byte(image.ID)); err != nil {
+			return err
+		}
+	}
+	if err := s.client.putObject(s.indexKey(image.UserID, image.ID), "", nil); err != nil {
+		return err
+	}
+	// 不知道图片之前是否在回收站中，回收站索引不存在时删除也不会出错
+	return s.client.deleteObject(s.trashKey(image.UserID, image.ID))
+}
+
+// Get 根据ID获取图片元数据
+func (s *s3MetadataStore) Get(id string) (*ImageInfo, error) {
+	resp, err := s.client.getObject(s.metaKey(id))
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var image ImageInfo
+	if err := json.NewDecoder(resp.Body).Decode(&image); err != nil {
+		return nil, fmt.Errorf("解析元数据失败: %w", err)
+	}
+	return &image, nil
+}
+
+// GetBySlug 根据公开标识获取图片元数据
+func (s *s3MetadataStore) GetBySlug(slug string) (*ImageInfo, error) {
+	resp, err := s.client.getObject(s.slugKey(slug))
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	id, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := s.Get(string(id))
+	if err != nil {
+		return nil, err
+	}
+	// 索引可能已经过期
+	if image.Slug != slug || image.Trashed() {
+		return nil, ErrImageNotFound
+	}
+	return image, nil
+}
+
+// Delete 删除图片元数据
+func (s *s3MetadataStore) Delete(id string) error {
+	image, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := s.client.deleteObject(s.metaKey(id)); err != nil {
+		return err
+	}
+	if image.Slug != "" {
+		if err := s.client.deleteObject(s.slugKey(image.Slug)); err != nil {
+			return err
+		}
+	}
+	if image.Hash != "" {
+		if err := s.client.deleteObject(s.hashKey(image.Hash, id)); err != nil {
+			return err
+		}
+	}
+	if image.Trashed() {
+		if err := s.client.deleteObject(s.trashKey(image.UserID, id)); err != nil {
+			return err
+		}
+	}
+	return s.client.deleteObject(s.indexKey(image.UserID, id))
+}
+
+// HashRefs 引用指定内容哈希的图片数量
+func (s *s3MetadataStore) HashRefs(hash string) (int, error) {
+	keys, err := s.client.listObjects(s.hashKey(hash, ""))
+	if err != nil {
+		return 0, fmt.Errorf("列出元数据失败: %w", err)
+	}
+	return len(keys), nil
+}
+
+// Query 按条件分页列出用户的图片
+//
+// 对象存储无法按条件查询，读取用户的所有元数据后在内存中排序和分页。
+func (s *s3MetadataStore) Query(userID string, opts ListOptions) (*ImageList, error) {
+	images, err := s.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return PageImages(images, opts)
+}
+
+// ListByUser 按上传时间升序列出用户的所有图片
+func (s *s3MetadataStore) ListByUser(userID string) ([]*ImageInfo, error) {
+	prefix := s.indexKey(userID, "")
+	keys, err := s.client.listObjects(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("列出元数据失败: %w", err)
+	}
+
+	var images []*ImageInfo
+	for _, key := range keys {
+		image, err := s.Get(strings.TrimPrefix(key, prefix))
+		if err != nil || image.Trashed() {
+			// 索引存在但元数据已被删除或已移到回收站，跳过
+			continue
+		}
+		images = append(images, image)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].UploadedAt.Before(images[j].UploadedAt)
+	})
+	return images, nil
+}
+
+// ListTrash 按移到回收站的时间降序列出用户回收站中的图片
+func (s *s3MetadataStore) ListTrash(userID string) ([]*ImageInfo, error) {
+	images, err := s.listTrash(s.trashKey(userID, ""))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].DeletedAt.After(*images[j].DeletedAt)
+	})
+	return images, nil
+}
+
+// ListTrashedBefore 列出所有用户在 before 之前移到回收站的图片
+//
+// 需要读取所有用户回收站中图片的元数据，只适合定期在后台执行。
+func (s *s3MetadataStore) ListTrashedBefore(before time.Time) ([]*ImageInfo, error) {
+	trashed, err := s.listTrash(s.prefix + "meta-trash/")
+	if err != nil {
+		return nil, err
+	}
+
+	var images []*ImageInfo
+	for _, image := range trashed {
+		if image.DeletedAt.Before(before) {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// listTrash 读取回收站索引中 prefix 之下的所有图片
+func (s *s3MetadataStore) listTrash(prefix string) ([]*ImageInfo, error) {
+	keys, err := s.client.listObjects(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("列出元数据失败: %w", err)
+	}
+
+	images := []*ImageInfo{}
+	for _, key := range keys {
+		image, err := s.Get(path.Base(key))
+		if err != nil || !image.Trashed() {
+			// 索引存在但元数据已被删除或已恢复，跳过
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// Close 无需释放资源
+func (s *s3MetadataStore) Close() error {
+	return nil
+}
+
+func (s *s3MetadataStore) metaKey(id string) string {
+	return s.prefix + path.Join("meta", id+".json")
+}
+
+func (s *s3MetadataStore) indexKey(userID string, id string) string {
+	return s.prefix + "meta-index/" + userID + "/" + id
+}
+
+func (s *s3MetadataStore) slugKey(slug string) string {
+	return s.prefix + "meta-slug/" + slug
+}
+
+func (s *s3MetadataStore) trashKey(userID string, id string) string {
+	return s.prefix + "meta-trash/" + userID + "/" + id
+}
+
+func (s *s3MetadataStore) hashKey(hash string, id string) string {
+	return s.prefix + "meta-hash/" + hash + "/" + id
+}
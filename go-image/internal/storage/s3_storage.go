@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// S3Storage 实现 S3 兼容对象存储
+//
+// 对象布局（均位于配置的前缀之下）：
+//
+//	blobs/<xx>/<sha256><ext>    图片原文件，按内容寻址，相同内容只保存一份
+//	<userID>/<id><ext>          旧版本按图片保存的原文件
+//	thumbnails/<id>_thumb<ext>  缩略图
+//	meta-gc/<sha256>            等待删除的内容对象（JSON），实例退出后仍然保留
+//
+// 配合 s3 类型的元数据存储时，所有状态都保存在 bucket 中，多个实例可以共享同一个 bucket。
+//
+// 多个实例之间没有互斥，内容对象的写入和删除按以下顺序保证不会留下指向不存在对象的图片：
+// 上传时先写入元数据再上传对象，即使相同内容已经存在也重新上传（内容相同，覆盖没有影响）；
+// 删除最后一个引用后不立即删除对象，而是先写入 meta-gc 记录，等待 blobDeleteDelay 后重新检查引用，
+// 仍然没有引用时才删除。记录保存在 bucket 中，实例在等待期间退出时由之后的定期检查（见 StartBlobSweep）完成删除。
+type S3Storage struct {
+	client *s3Client
+	prefix string
+	meta   MetadataStore
+
+	// deleteDelay 删除最后一个引用后等待多久再删除内容对象
+	deleteDelay time.Duration
+}
+
+// blobDeleteDelay 删除最后一个引用后延迟删除内容对象的时间，远长于一次上传所需的时间
+const blobDeleteDelay = 10 * time.Minute
+
+// blobSweepInterval 检查到期的待删除记录的间隔
+const blobSweepInterval = 5 * time.Minute
+
+// pendingDelete 等待删除的内容对象，保存在 meta-gc/<hash> 中
+type pendingDelete struct {
+	Hash     string    `json:"hash"`
+	Path     string    `json:"path"`
+	DeleteAt time.Time `json:"delete_at"`
+}
+
+// NewS3Storage 创建一个新的 S3 存储实例
+func NewS3Storage(cfg S3Config, meta MetadataStore) (*S3Storage, error) {
+	client, err := newS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Storage{
+		client:      client,
+		prefix:      s3Prefix(cfg.Prefix),
+		meta:        meta,
+		deleteDelay: blobDeleteDelay,
+	}, nil
+}
+
+// Save 保存图片到对象存储
+func (s *S3Storage) Save(imageInfo *ImageInfo, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("读取图片内容失败: %w", err)
+	}
+	hash := sha256Hex(data)
+
+	// 补全图片信息
+	imageInfo.ID = uuid.New().String()
+	imageInfo.Filename = sanitizeFilename(imageInfo.Filename) // 确保文件名安全
+	imageInfo.Size = int64(len(data))
+	imageInfo.Path = blobPath(hash, imageInfo.MimeType)
+	imageInfo.Hash = hash
+	imageInfo.UploadedAt = time.Now()
+
+	// 先写入元数据登记对内容的引用，其他实例随后的延迟删除会看到这个引用。
+	// 之后总是上传对象：相同内容可能正在被其他实例删除，不能依据引用计数跳过上传
+	if err := s.meta.Put(imageInfo); err != nil {
+		return fmt.Errorf("保存元数据失败: %w", err)
+	}
+	if err := s.client.putObject(s.key(imageInfo.Path), imageInfo.MimeType, data); err != nil {
+		// 对象可能仍被其他图片引用，只删除元数据
+		s.meta.Delete(imageInfo.ID)
+		return fmt.Errorf("上传图片失败: %w", err)
+	}
+
+	return nil
+}
+
+// Get 获取图片信息，回收站中的图片视为不存在
+func (s *S3Storage) Get(userID string, id string) (*ImageInfo, error) {
+	image, err := s.meta.Get(id)
+	if err != nil || image.UserID != userID || image.Trashed() {
+		return nil, ErrImageNotFound
+	}
+	return image, nil
+}
+
+// Lookup 根据ID获取图片信息，不校验所有者
+func (s *S3Storage) Lookup(id string) (*ImageInfo, error) {
+	image, err := s.meta.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if image.Trashed() {
+		return nil, ErrImageNotFound
+	}
+	return image, nil
+}
+
+// LookupSlug 根据公开标识获取图片信息
+func (s *S3Storage) LookupSlug(slug string) (*ImageInfo, error) {
+	return s.meta.GetBySlug(slug)
+}
+
+// Update 更新图片元数据
+func (s *S3Storage) Update(image *ImageInfo) error {
+	return s.meta.Put(image)
+}
+
+// Albums 相册存储
+func (s *S3Storage) Albums() AlbumStore {
+	return s.meta
+}
+
+// Delete 永久删除图片，包括回收站中的图片
+func (s *S3Storage) Delete(userID string, id string) error {
+	image, err := ownImage(s.meta, userID, id)
+	if err != nil {
+		return err
+	}
+
+	// 先删除元数据，即使随后删除对象失败也不会留下指向不存在对象的记录
+	if err := s.meta.Delete(id); err != nil {
+		return fmt.Errorf("删除元数据失败: %w", err)
+	}
+
+	// 删除原文件，内容仍被其他图片引用时保留
+	if err := s.releaseBlob(image); err != nil {
+		return fmt.Errorf("删除文件失败: %w", err)
+	}
+
+	// 如果有缩略图，也删除
+	if image.ThumbPath != "" {
+		s.client.deleteObject(s.key(path.Join("thumbnails", image.ThumbPath))) // 忽略错误，因为缩略图可能不存在
+	}
+
+	return nil
+}
+
+// releaseBlob 删除图片的原文件，调用前需要已经删除图片的元数据
+//
+// 按内容保存的对象不再被引用时延迟删除，见 S3Storage 的说明。
+func (s *S3Storage) releaseBlob(image *ImageInfo) error {
+	if image.Hash == "" {
+		return s.client.deleteObject(s.key(image.Path))
+	}
+
+	refs, err := s.meta.HashRefs(image.Hash)
+	if err != nil || refs > 0 {
+		return err
+	}
+
+	// 先持久化待删除记录，本实例到期后直接处理，退出时由定期检查处理
+	pending := pendingDelete{Hash: image.Hash, Path: image.Path, DeleteAt: time.Now().Add(s.deleteDelay)}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	if err := s.client.putObject(s.gcKey(image.Hash), "application/json", data); err != nil {
+		return err
+	}
+	time.AfterFunc(s.deleteDelay, func() {
+		if err := s.collectBlob(image.Hash); err != nil {
+			log.Printf("删除图片内容 %s 失败: %v", image.Hash, err)
+		}
+	})
+	return nil
+}
+
+// collectBlob 处理内容的待删除记录：到期后重新检查引用，仍然没有图片引用时删除对象，之后删除记录
+//
+// 同一内容再次释放时记录被覆盖，未到新的删除时间时不处理。
+func (s *S3Storage) collectBlob(hash string) error {
+	resp, err := s.client.getObject(s.gcKey(hash))
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil
+		}
+		return err
+	}
+	var pending pendingDelete
+	err = json.NewDecoder(resp.Body).Decode(&pending)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("解析待删除记录失败: %w", err)
+	}
+	if time.Now().Before(pending.DeleteAt) {
+		return nil
+	}
+
+	refs, err := s.meta.HashRefs(hash)
+	if err != nil {
+		return err
+	}
+	if refs == 0 {
+		if err := s.client.deleteObject(s.key(pending.Path)); err != nil {
+			return err
+		}
+	}
+	return s.client.deleteObject(s.gcKey(hash))
+}
+
+// SweepBlobs 处理所有到期的待删除记录，包括已经退出的实例留下的记录
+func (s *S3Storage) SweepBlobs() error {
+	keys, err := s.client.listObjects(s.prefix + "meta-gc/")
+	if err != nil {
+		return fmt.Errorf("列出待删除记录失败: %w", err)
+	}
+
+	var errs []error
+	for _, key := range keys {
+		hash := path.Base(key)
+		if err := s.collectBlob(hash); err != nil {
+			errs = append(errs, fmt.Errorf("删除图片内容 %s 失败: %w", hash, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StartBlobSweep 立即处理一次到期的待删除记录，之后在后台定期处理
+func (s *S3Storage) StartBlobSweep(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.SweepBlobs(); err != nil {
+				log.Printf("清理图片内容失败: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// List 按条件分页列出用户的图片
+func (s *S3Storage) List(userID string, opts ListOptions) (*ImageList, error) {
+	return s.meta.Query(userID, opts)
+}
+
+// Trash 将图片移到回收站，文件保留到永久删除
+func (s *S3Storage) Trash(userID string, id string) (*ImageInfo, error) {
+	return trashImage(s.meta, userID, id)
+}
+
+// Restore 从回收站恢复图片
+func (s *S3Storage) Restore(userID string, id string) (*ImageInfo, error) {
+	return restoreImage(s.meta, userID, id)
+}
+
+// GetTrashed 获取回收站中的图片信息
+func (s *S3Storage) GetTrashed(userID string, id string) (*ImageInfo, error) {
+	return trashedImage(s.meta, userID, id)
+}
+
+// ListTrash 按移到回收站的时间降序列出用户回收站中的图片
+func (s *S3Storage) ListTrash(userID string) ([]*ImageInfo, error) {
+	return s.meta.ListTrash(userID)
+}
+
+// ListExpiredTrash 列出所有用户在 before 之前移到回收站的图片
+func (s *S3Storage) ListExpiredTrash(before time.Time) ([]*ImageInfo, error) {
+	return s.meta.ListTrashedBefore(before)
+}
+
+// SaveThumbnail 保存缩略图到对象存储
+func (s *S3Storage) SaveThumbnail(image *ImageInfo, mimeType string, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("读取缩略图失败: %w", err)
+	}
+
+	thumbName := fmt.Sprintf("%s_thumb%s", image.ID, mimeExtension(mimeType))
+	if err := s.client.putObject(s.key(path.Join("thumbnails", thumbName)), mimeType, data); err != nil {
+		return fmt.Errorf("上传缩略图失败: %w", err)
+	}
+
+	// 更新图片信息，添加缩略图路径
+	image.ThumbPath = thumbName
+	return s.meta.Put(image)
+}
+
+// Open 打开图片原文件，返回的对象支持 Seek
+func (s *S3Storage) Open(image *ImageInfo) (io.ReadCloser, error) {
+	return s.openObject(s.key(image.Path), image.Size)
+}
+
+// OpenThumbnail 打开缩略图，返回的对象支持 Seek
+func (s *S3Storage) OpenThumbnail(image *ImageInfo) (io.ReadCloser, error) {
+	if image.ThumbPath == "" {
+		return nil, errors.New("缩略图不存在")
+	}
+	return s.openObject(s.key(path.Join("thumbnails", image.ThumbPath)), image.ThumbSize)
+}
+
+// openObject 开始下载对象，size 为元数据中记录的大小，响应中没有对象大小时使用
+//
+// 立即发出第一个请求，对象不存在等错误在打开时就能返回。
+func (s *S3Storage) openObject(key string, size int64) (*s3Object, error) {
+	resp, err := s.client.getObject(key)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength >= 0 {
+		size = resp.ContentLength
+	} else if size <= 0 {
+		size = -1
+	}
+	return &s3Object{client: s.client, key: key, size: size, body: resp.Body}, nil
+}
+
+// s3Object 支持 Seek 的对象读取器，供 http.ServeContent 处理 Range 请求
+//
+// Seek 只记录位置，之后的 Read 与当前下载流的位置不一致时，用 Range 请求从新的位置重新下载。
+type s3Object struct {
+	client *s3Client
+	key    string
+	size   int64 // 对象大小，未知时为 -1
+	offset int64 // 下一次 Read 的位置
+
+	body       io.ReadCloser // 当前的下载流
+	bodyOffset int64         // 下载流的当前位置
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.body != nil && o.bodyOffset != o.offset {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.body == nil {
+		if o.size >= 0 && o.offset >= o.size {
+			return 0, io.EOF
+		}
+		resp, err := o.client.getObjectFrom(o.key, o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body, o.bodyOffset = resp.Body, o.offset
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		if o.size < 0 {
+			return 0, errors.New("对象大小未知")
+		}
+		offset += o.size
+	default:
+		return 0, errors.New("无效的 whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("无效的读取位置")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// key 为相对路径加上配置的前缀
+func (s *S3Storage) key(relativePath string) string {
+	return s.prefix + relativePath
+}
+
+// gcKey 内容的待删除记录的对象键
+func (s *S3Storage) gcKey(hash string) string {
+	return s.prefix + "meta-gc/" + hash
+}
+
+// s3Prefix 规范化对象键前缀
+func s3Prefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
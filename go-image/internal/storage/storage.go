@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"io"
 	"time"
 )
@@ -12,22 +13,203 @@ type ImageInfo struct {
 	Filename   string    `json:"filename"`
 	Size       int64     `json:"size"`
 	MimeType   string    `json:"mime_type"`
+	Hash       string    `json:"hash,omitempty"`  // 内容的 SHA-256（十六进制），旧版本上传的图片为空
+	PHash      string    `json:"phash,omitempty"` // 感知哈希（64 位 dHash 的十六进制），用于查找相似图片
 	Path       string    `json:"path"`
 	ThumbPath  string    `json:"thumb_path,omitempty"`
+	ThumbSize  int64     `json:"thumb_size,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
+	Visibility string    `json:"visibility,omitempty"`
+	Slug       string    `json:"slug,omitempty"`   // 不可猜测的公开访问标识
+	Albums     []string  `json:"albums,omitempty"` // 所属相册ID
+
+	Tags        []string `json:"tags,omitempty"`        // 用户自定义标签
+	Description string   `json:"description,omitempty"` // 图片描述
+
+	Width  int       `json:"width,omitempty"`  // 按 EXIF 方向校正后的宽度（像素）
+	Height int       `json:"height,omitempty"` // 按 EXIF 方向校正后的高度（像素）
+	Exif   *ExifInfo `json:"exif,omitempty"`   // 上传时从 EXIF 中提取的拍摄信息
+	// Frames 和 Duration 记录 GIF、WebP 动图的帧数和一轮播放的总时长（毫秒），静态图片为 0
+	Frames   int `json:"frames,omitempty"`
+	Duration int `json:"duration,omitempty"`
+	// MetadataStripped 保存的原图已移除 EXIF/XMP/IPTC（只保留方向）
+	MetadataStripped bool `json:"metadata_stripped,omitempty"`
+	// Watermark 图片单独的水印设置：on、off，为空时按上传者的个人设置
+	Watermark string `json:"watermark,omitempty"`
+	// Watermarked 保存的原图在上传时已经加上了水印
+	Watermarked bool `json:"watermarked,omitempty"`
+	// DeletedAt 移到回收站的时间，为 nil 表示不在回收站中
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ExifInfo 从 EXIF 中提取的拍摄信息，只对图片所有者可见
+type ExifInfo struct {
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	LensModel   string     `json:"lens_model,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty"`    // 拍摄时间，EXIF 没有记录时区时按服务器时区解释
+	Orientation int        `json:"orientation,omitempty"` // EXIF 方向（1-8），缩略图和变换版本已按此校正
+	GPS         *GPSInfo   `json:"gps,omitempty"`
+}
+
+// GPSInfo 拍摄地点
+type GPSInfo struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"` // 海拔（米）
+}
+
+// 图片可见性
+const (
+	VisibilityPublic   = "public"   // 任何人都可以通过ID或公开标识访问
+	VisibilityUnlisted = "unlisted" // 只能通过公开标识访问
+	VisibilityPrivate  = "private"  // 只有上传者可以访问
+)
+
+// 图片单独的水印设置
+const (
+	WatermarkOn  = "on"  // 总是添加水印
+	WatermarkOff = "off" // 不添加水印
+)
+
+// IsValidVisibility 检查是否为合法的可见性
+func IsValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// EffectiveVisibility 图片的实际可见性，旧版本没有记录可见性的图片视为公开
+func (i *ImageInfo) EffectiveVisibility() string {
+	if i.Visibility == "" {
+		return VisibilityPublic
+	}
+	return i.Visibility
+}
+
+// Trashed 图片是否在回收站中
+func (i *ImageInfo) Trashed() bool {
+	return i.DeletedAt != nil
+}
+
+// PublicPath 图片的分享路径，不公开列出的图片使用公开标识
+func (i *ImageInfo) PublicPath() string {
+	if i.EffectiveVisibility() == VisibilityUnlisted && i.Slug != "" {
+		return "/i/" + i.Slug
+	}
+	return "/i/" + i.ID
 }
 
 // Storage 存储接口
 type Storage interface {
-	// Save 保存图片
-	Save(userID string, filename string, mimeType string, content io.Reader) (*ImageInfo, error)
+	// Save 保存图片，image 中预先填写所有者、文件名、类型以及可见性等上传时确定的信息，
+	// ID、大小、路径和上传时间由存储填写；元数据只写入一次，失败时不留下任何内容
+	Save(image *ImageInfo, content io.Reader) error
 
-	// Get 获取图片信息
+	// Get 获取图片信息，不包括回收站中的图片
 	Get(userID string, id string) (*ImageInfo, error)
 
-	// Delete 删除图片
+	// Lookup 根据ID获取图片信息，不校验所有者，用于公开访问；不包括回收站中的图片
+	Lookup(id string) (*ImageInfo, error)
+
+	// LookupSlug 根据公开标识获取图片信息
+	LookupSlug(slug string) (*ImageInfo, error)
+
+	// Update 更新图片元数据
+	Update(image *ImageInfo) error
+
+	// Albums 相册存储
+	Albums() AlbumStore
+
+	// Delete 永久删除图片，包括回收站中的图片
 	Delete(userID string, id string) error
 
-	// List 列出用户的所有图片
-	List(userID string) ([]*ImageInfo, error)
-}
\ No newline at end of file
+	// Trash 将图片移到回收站
+	Trash(userID string, id string) (*ImageInfo, error)
+
+	// Restore 从回收站恢复图片
+	Restore(userID string, id string) (*ImageInfo, error)
+
+	// GetTrashed 获取回收站中的图片信息
+	GetTrashed(userID string, id string) (*ImageInfo, error)
+
+	// ListTrash 按移到回收站的时间降序列出用户回收站中的图片
+	ListTrash(userID string) ([]*ImageInfo, error)
+
+	// ListExpiredTrash 列出所有用户在 before 之前移到回收站的图片
+	ListExpiredTrash(before time.Time) ([]*ImageInfo, error)
+
+	// List 按条件分页列出用户的图片，opts 为零值时按上传时间升序返回所有图片；不包括回收站中的图片
+	List(userID string, opts ListOptions) (*ImageList, error)
+
+	// SaveThumbnail 保存图片的缩略图，并记录到图片信息中；mimeType 为缩略图本身的类型，决定扩展名
+	SaveThumbnail(image *ImageInfo, mimeType string, content io.Reader) error
+
+	// Open 打开图片原文件
+	Open(image *ImageInfo) (io.ReadCloser, error)
+
+	// OpenThumbnail 打开图片的缩略图
+	OpenThumbnail(image *ImageInfo) (io.ReadCloser, error)
+}
+
+// Config 存储配置
+type Config struct {
+	// Type 存储类型：local 或 s3
+	Type string `yaml:"type"`
+	// Path 本地存储的根目录
+	Path string `yaml:"-"`
+	// ThumbnailPath 本地存储的缩略图目录，为空时使用根目录下的 thumbnails
+	ThumbnailPath string `yaml:"-"`
+	// S3 S3 兼容对象存储的配置
+	S3 S3Config `yaml:"s3"`
+	// Metadata 图片元数据存储的配置
+	Metadata MetadataConfig `yaml:"metadata"`
+}
+
+// S3Config S3 兼容对象存储配置
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// PathStyle 使用 path-style 访问（MinIO 等自建服务通常需要开启）
+	PathStyle bool `yaml:"path_style"`
+	// Prefix 所有对象键的前缀，便于多个实例共享同一个 bucket
+	Prefix string `yaml:"prefix"`
+}
+
+// New 根据配置创建存储实例
+func New(cfg Config) (Storage, error) {
+	meta, err := NewMetadataStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var store Storage
+	switch cfg.Type {
+	case "", "local":
+		path := cfg.Path
+		if path == "" {
+			path = "static/uploads"
+		}
+		store, err = NewLocalStorage(path, cfg.ThumbnailPath, meta)
+	case "s3":
+		var s3Storage *S3Storage
+		if s3Storage, err = NewS3Storage(cfg.S3, meta); err == nil {
+			// 定期删除不再被引用的内容对象，包括之前的实例退出时没有完成的删除
+			s3Storage.StartBlobSweep(blobSweepInterval)
+			store = s3Storage
+		}
+	default:
+		err = fmt.Errorf("不支持的存储类型: %s", cfg.Type)
+	}
+
+	if err != nil {
+		meta.Close()
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// 删除的图片先移到回收站：元数据中记录移入的时间（ImageInfo.DeletedAt），原文件和缩略图保持不变。
+//
+// 回收站中的图片不出现在图片列表和相册中，也不能通过 Get、Lookup 或公开标识获取；内容哈希索引中的
+// 引用保留到永久删除（Storage.Delete），相同内容的其他图片被删除时不会删掉回收站中图片的文件。
+
+// ownImage 获取用户的图片，包括回收站中的图片
+func ownImage(meta MetadataStore, userID string, id string) (*ImageInfo, error) {
+	image, err := meta.Get(id)
+	if err != nil || image.UserID != userID {
+		return nil, ErrImageNotFound
+	}
+	return image, nil
+}
+
+// trashImage 将用户的图片移到回收站
+func trashImage(meta MetadataStore, userID string, id string) (*ImageInfo, error) {
+	image, err := ownImage(meta, userID, id)
+	if err != nil || image.Trashed() {
+		return nil, ErrImageNotFound
+	}
+
+	now := time.Now()
+	image.DeletedAt = &now
+	if err := meta.Put(image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// restoreImage 从回收站恢复图片，图片在回收站期间被删除的相册不再保留
+func restoreImage(meta MetadataStore, userID string, id string) (*ImageInfo, error) {
+	image, err := trashedImage(meta, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	albums := image.Albums[:0]
+	for _, albumID := range image.Albums {
+		if _, err := meta.GetAlbum(albumID); err != nil {
+			if errors.Is(err, ErrAlbumNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		albums = append(albums, albumID)
+	}
+	image.Albums = albums
+	image.DeletedAt = nil
+
+	if err := meta.Put(image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// trashedImage 获取用户回收站中的图片
+func trashedImage(meta MetadataStore, userID string, id string) (*ImageInfo, error) {
+	image, err := ownImage(meta, userID, id)
+	if err != nil || !image.Trashed() {
+		return nil, ErrImageNotFound
+	}
+	return image, nil
+}
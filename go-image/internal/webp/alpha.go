@@ -0,0 +1,389 @@
+package webp
+
+import (
+	"container/heap"
+	"math/bits"
+)
+
+// 透明通道使用 WebP 无损格式（VP8L）压缩：不使用变换和颜色缓存，透明度保存在绿色分量中，
+// 只通过与左侧像素或上方像素的反向引用压缩连续的相同透明度。
+
+// 各前缀码的字母表大小：绿色分量包含 256 个字面值和 24 个长度前缀
+const (
+	numLiteralCodes  = 256
+	numLengthCodes   = 24
+	numDistanceCodes = 40
+)
+
+// maxCodeLength 前缀码的最大长度，码长的码长最大为 7
+const (
+	maxCodeLength       = 15
+	maxCodeLengthLength = 7
+)
+
+// maxMatchLength 一次反向引用的最大长度
+const maxMatchLength = 4096
+
+// minMatchLength 短于这个长度的重复直接写字面值
+const minMatchLength = 3
+
+// codeLengthOrder 码长的码长的写入顺序
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// alphaSymbol 一个字面值或一次反向引用
+type alphaSymbol struct {
+	literal  uint8
+	length   int // 为 0 时表示字面值
+	distCode int // 距离码（从 1 开始），1 表示上方像素，2 表示左侧像素
+}
+
+// encodeAlpha 压缩透明度，返回 ALPH 块中压缩方式之后的数据
+func encodeAlpha(alpha []byte, width int) []byte {
+	symbols := matchAlpha(alpha, width)
+
+	var green [numLiteralCodes + numLengthCodes]uint32
+	var dist [numDistanceCodes]uint32
+	for _, s := range symbols {
+		if s.length == 0 {
+			green[s.literal]++
+			continue
+		}
+		prefix, _, _ := prefixEncode(s.length)
+		green[numLiteralCodes+prefix]++
+		prefix, _, _ = prefixEncode(s.distCode)
+		dist[prefix]++
+	}
+
+	w := &bitWriter{}
+	w.writeBits(0, 1) // 没有变换
+	w.writeBits(0, 1) // 不使用颜色缓存
+	w.writeBits(0, 1) // 只有一组前缀码
+
+	greenCode := buildCode(green[:], maxCodeLength)
+	w.writeCode(greenCode)
+	// 红、蓝、透明分量恒为 0，使用只有一个符号的简单前缀码，不占用任何位
+	for i := 0; i < 3; i++ {
+		w.writeBits(1, 1)
+		w.writeBits(0, 1)
+		w.writeBits(0, 1)
+		w.writeBits(0, 1)
+	}
+	distCode := buildCode(dist[:], maxCodeLength)
+	w.writeCode(distCode)
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			w.writeSymbol(greenCode, int(s.literal))
+			continue
+		}
+		prefix, extraBits, extra := prefixEncode(s.length)
+		w.writeSymbol(greenCode, numLiteralCodes+prefix)
+		w.writeBits(extra, extraBits)
+		prefix, extraBits, extra = prefixEncode(s.distCode)
+		w.writeSymbol(distCode, prefix)
+		w.writeBits(extra, extraBits)
+	}
+	return w.finish()
+}
+
+// matchAlpha 将透明度拆分为字面值和反向引用，只尝试左侧像素和上方像素两个距离
+func matchAlpha(alpha []byte, width int) []alphaSymbol {
+	var symbols []alphaSymbol
+	for i := 0; i < len(alpha); {
+		best, bestCode := 0, 0
+		for _, candidate := range [2]struct{ dist, code int }{{1, 2}, {width, 1}} {
+			if i < candidate.dist {
+				continue
+			}
+			n := 0
+			for i+n < len(alpha) && n < maxMatchLength && alpha[i+n] == alpha[i+n-candidate.dist] {
+				n++
+			}
+			if n > best {
+				best, bestCode = n, candidate.code
+			}
+		}
+		if best >= minMatchLength {
+			symbols = append(symbols, alphaSymbol{length: best, distCode: bestCode})
+			i += best
+			continue
+		}
+		symbols = append(symbols, alphaSymbol{literal: alpha[i]})
+		i++
+	}
+	return symbols
+}
+
+// prefixEncode 将长度或距离码编码为前缀和附加位
+func prefixEncode(v int) (prefix int, extraBits uint, extra uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	highest := bits.Len(uint(v)) - 1
+	second := (v >> uint(highest-1)) & 1
+	extraBits = uint(highest - 1)
+	return 2*highest + second, extraBits, uint32(v) & (1<<extraBits - 1)
+}
+
+// prefixCode 规范前缀码，codes 中的码字已经按位反转，可以直接以低位在前的顺序写入
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint32
+	single  bool // 只有一个符号，使用简单前缀码
+	symbol  uint32
+}
+
+// buildCode 根据符号出现次数构建长度不超过 maxLength 的规范前缀码
+func buildCode(counts []uint32, maxLength int) *prefixCode {
+	used := 0
+	for _, c := range counts {
+		if c > 0 {
+			used++
+		}
+	}
+	code := &prefixCode{lengths: make([]uint8, len(counts)), codes: make([]uint32, len(counts))}
+	if used <= 1 {
+		// 只有一个符号（或没有符号）且可以用 8 位表示时使用简单前缀码，写入符号时不占用任何位
+		symbol := 0
+		for i, c := range counts {
+			if c > 0 {
+				symbol = i
+			}
+		}
+		if symbol < 256 {
+			code.single = true
+			code.symbol = uint32(symbol)
+			return code
+		}
+		// 否则补充一个符号，使普通前缀码中至少有两个符号
+		counts = append([]uint32(nil), counts...)
+		if symbol == 0 {
+			counts[1] = 1
+		} else {
+			counts[0] = 1
+		}
+	}
+
+	// 码长超过限制时把出现次数减半后重新计算，稀有符号的码长随之变短
+	scaled := append([]uint32(nil), counts...)
+	for {
+		huffmanLengths(scaled, code.lengths)
+		longest := uint8(0)
+		for _, l := range code.lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if int(longest) <= maxLength {
+			break
+		}
+		for i, c := range scaled {
+			if c > 0 {
+				scaled[i] = c/2 + 1
+			}
+		}
+	}
+
+	// 与 DEFLATE 相同的规范码分配方式
+	var countPerLength [maxCodeLength + 1]uint32
+	for _, l := range code.lengths {
+		countPerLength[l]++
+	}
+	countPerLength[0] = 0
+	var next [maxCodeLength + 2]uint32
+	c := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		c = (c + countPerLength[l-1]) << 1
+		next[l] = c
+	}
+	for symbol, l := range code.lengths {
+		if l == 0 {
+			continue
+		}
+		code.codes[symbol] = reverseBits(next[l], uint(l))
+		next[l]++
+	}
+	return code
+}
+
+// huffmanLengths 计算霍夫曼码长，出现次数为 0 的符号码长为 0
+func huffmanLengths(counts []uint32, lengths []uint8) {
+	for i := range lengths {
+		lengths[i] = 0
+	}
+
+	h := &nodeHeap{}
+	var parents []int
+	for _, c := range counts {
+		if c > 0 {
+			*h = append(*h, huffmanNode{count: c, id: len(parents)})
+			parents = append(parents, -1)
+		}
+	}
+	leaves := len(parents)
+	heap.Init(h)
+	for h.Len() > 1 {
+		a := heap.Pop(h).(huffmanNode)
+		b := heap.Pop(h).(huffmanNode)
+		id := len(parents)
+		parents = append(parents, -1)
+		parents[a.id], parents[b.id] = id, id
+		heap.Push(h, huffmanNode{count: a.count + b.count, id: id})
+	}
+
+	leaf := 0
+	for symbol, c := range counts {
+		if c == 0 {
+			continue
+		}
+		depth := 0
+		for n := leaf; parents[n] >= 0; n = parents[n] {
+			depth++
+		}
+		if leaves == 1 {
+			depth = 1
+		}
+		lengths[symbol] = uint8(depth)
+		leaf++
+	}
+}
+
+type huffmanNode struct {
+	count uint32
+	id    int
+}
+
+type nodeHeap []huffmanNode
+
+func (h nodeHeap) Len() int { return len(h) }
+func (h nodeHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].id < h[j].id
+}
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(huffmanNode)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func reverseBits(v uint32, n uint) uint32 {
+	return bits.Reverse32(v) >> (32 - n)
+}
+
+// bitWriter VP8L 的位写入器，低位在前
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits uint
+}
+
+func (w *bitWriter) writeBits(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nBits -= 8
+	}
+}
+
+func (w *bitWriter) finish() []byte {
+	if w.nBits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nBits = 0, 0
+	}
+	return w.buf
+}
+
+func (w *bitWriter) writeSymbol(code *prefixCode, symbol int) {
+	if code.single {
+		return
+	}
+	w.writeBits(code.codes[symbol], uint(code.lengths[symbol]))
+}
+
+// writeCode 写入前缀码：简单前缀码直接写出符号，普通前缀码写出经过游程编码的码长
+func (w *bitWriter) writeCode(code *prefixCode) {
+	if code.single {
+		symbol := code.symbol
+		w.writeBits(1, 1) // 简单前缀码
+		w.writeBits(0, 1) // 一个符号
+		if symbol < 2 {
+			w.writeBits(0, 1)
+			w.writeBits(symbol, 1)
+		} else {
+			w.writeBits(1, 1)
+			w.writeBits(symbol, 8)
+		}
+		return
+	}
+
+	// 码长序列的游程编码：17 表示 3-10 个 0，18 表示 11-138 个 0
+	type lengthToken struct {
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}
+	var tokens []lengthToken
+	var counts [19]uint32
+	lengths := code.lengths
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, lengthToken{symbol: int(lengths[i])})
+			counts[lengths[i]]++
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens = append(tokens, lengthToken{symbol: 18, extra: uint32(run - 11), extraBits: 7})
+			counts[18]++
+		case run >= 3:
+			tokens = append(tokens, lengthToken{symbol: 17, extra: uint32(run - 3), extraBits: 3})
+			counts[17]++
+		default:
+			run = 1
+			tokens = append(tokens, lengthToken{symbol: 0})
+			counts[0]++
+		}
+		i += run
+	}
+
+	lengthCode := buildCode(counts[:], maxCodeLengthLength)
+	if lengthCode.single {
+		// 码长的前缀码至少需要两个符号，补充一个不会用到的符号
+		c := counts
+		if c[0] == 0 {
+			c[0] = 1
+		} else {
+			c[1] = 1
+		}
+		lengthCode = buildCode(c[:], maxCodeLengthLength)
+	}
+
+	n := len(codeLengthOrder)
+	for n > 4 && lengthCode.lengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	w.writeBits(0, 1) // 普通前缀码
+	w.writeBits(uint32(n-4), 4)
+	for _, symbol := range codeLengthOrder[:n] {
+		w.writeBits(uint32(lengthCode.lengths[symbol]), 3)
+	}
+	w.writeBits(0, 1) // 写出全部符号的码长
+
+	for _, t := range tokens {
+		w.writeSymbol(lengthCode, t.symbol)
+		w.writeBits(t.extra, t.extraBits)
+	}
+}
@@ -0,0 +1,271 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+
+	xwebp "golang.org/x/image/webp"
+)
+
+// ErrNotAnimated 图片不是动图
+var ErrNotAnimated = errors.New("webp: 不是动图")
+
+// errInvalidFormat 文件结构不是合法的 WebP
+var errInvalidFormat = errors.New("webp: 文件格式不正确")
+
+// maxFramePixels 动图所有帧解码后的像素总数上限，在分配内存之前检查，
+// 避免很小的文件声明大量巨大的帧耗尽内存
+const maxFramePixels = 1 << 27
+
+// VP8X 块中的标志位
+const (
+	flagAnimation = 0x02
+	flagAlpha     = 0x10
+)
+
+// Animation 动图 WebP
+type Animation struct {
+	Width, Height int // 画布尺寸
+	// LoopCount 播放次数，0 表示无限循环
+	LoopCount int
+	Frames    []Frame
+}
+
+// Frame 动图中的一帧
+type Frame struct {
+	// Image 帧图像，Bounds 为帧在画布中的位置，左上角坐标必须是偶数
+	Image image.Image
+	// Duration 显示时长（毫秒）
+	Duration int
+	// Blend 为 true 时按透明度叠加到画布上，否则直接覆盖帧所在的区域
+	Blend bool
+	// Dispose 为 true 时显示完成后把帧所在的区域清除为透明
+	Dispose bool
+}
+
+// DecodeAll 解码动图 WebP 的所有帧，静态图片返回 ErrNotAnimated
+//
+// 每一帧按原始数据解码，不与画布合成。
+func DecodeAll(r io.Reader) (*Animation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := readChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].fourCC != "VP8X" || len(chunks[0].data) < 10 || chunks[0].data[0]&flagAnimation == 0 {
+		return nil, ErrNotAnimated
+	}
+
+	anim := &Animation{
+		Width:  int(uint24(chunks[0].data[4:])) + 1,
+		Height: int(uint24(chunks[0].data[7:])) + 1,
+	}
+	if anim.Width > maxDimension || anim.Height > maxDimension {
+		return nil, ErrTooLarge
+	}
+	canvas := image.Rect(0, 0, anim.Width, anim.Height)
+	pixels := 0
+	for _, c := range chunks[1:] {
+		switch c.fourCC {
+		case "ANIM":
+			if len(c.data) < 6 {
+				return nil, errInvalidFormat
+			}
+			anim.LoopCount = int(binary.LittleEndian.Uint16(c.data[4:]))
+		case "ANMF":
+			if len(c.data) < 16 {
+				return nil, errInvalidFormat
+			}
+			// 先按帧头中的尺寸检查，帧必须位于画布之内
+			r := frameRect(c.data)
+			if !r.In(canvas) {
+				return nil, errInvalidFormat
+			}
+			if pixels += r.Dx() * r.Dy(); pixels > maxFramePixels {
+				return nil, ErrTooLarge
+			}
+			frame, err := decodeFrame(c.data)
+			if err != nil {
+				return nil, err
+			}
+			anim.Frames = append(anim.Frames, frame)
+		}
+	}
+	if len(anim.Frames) == 0 {
+		return nil, errInvalidFormat
+	}
+	return anim, nil
+}
+
+// decodeFrame 解码 ANMF 块：帧数据组装成独立的 WebP 文件后交给 x/image/webp 解码
+func decodeFrame(data []byte) (Frame, error) {
+	if len(data) < 16 {
+		return Frame{}, errInvalidFormat
+	}
+	r := frameRect(data)
+	x, y, width, height := r.Min.X, r.Min.Y, r.Dx(), r.Dy()
+	frame := Frame{
+		Duration: int(uint24(data[12:])),
+		Blend:    data[15]&0x02 == 0,
+		Dispose:  data[15]&0x01 != 0,
+	}
+
+	sub, err := readChunks(append([]byte("RIFF\x00\x00\x00\x00WEBP"), data[16:]...))
+	if err != nil {
+		return Frame{}, err
+	}
+	var body []byte
+	hasAlpha := false
+	for _, c := range sub {
+		switch c.fourCC {
+		case "ALPH":
+			hasAlpha = true
+			body = appendChunk(body, c.fourCC, c.data)
+		case "VP8 ", "VP8L":
+			body = appendChunk(body, c.fourCC, c.data)
+		}
+	}
+	if hasAlpha {
+		body = append(vp8xChunk(flagAlpha, width, height), body...)
+	}
+
+	img, err := xwebp.Decode(bytes.NewReader(riff(body)))
+	if err != nil {
+		return Frame{}, err
+	}
+	if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+		return Frame{}, errInvalidFormat
+	}
+
+	// 平移到帧在画布中的位置
+	img = ConvertColors(img)
+	dst := image.NewNRGBA(image.Rect(x, y, x+width, y+height))
+	draw.Draw(dst, dst.Rect, img, img.Bounds().Min, draw.Src)
+	frame.Image = dst
+	return frame, nil
+}
+
+// frameRect ANMF 块头中记录的帧在画布中的位置，data 至少 16 字节
+func frameRect(data []byte) image.Rectangle {
+	x := int(uint24(data[0:])) * 2
+	y := int(uint24(data[3:])) * 2
+	width := int(uint24(data[6:])) + 1
+	height := int(uint24(data[9:])) + 1
+	return image.Rect(x, y, x+width, y+height)
+}
+
+// EncodeAll 将动图编码为 WebP，每一帧都使用有损压缩
+func EncodeAll(w io.Writer, anim *Animation, o *Options) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("webp: 动图没有任何帧")
+	}
+	if anim.Width < 1 || anim.Height < 1 || anim.Width > maxDimension || anim.Height > maxDimension {
+		return ErrTooLarge
+	}
+
+	quality := DefaultQuality
+	if o != nil && o.Quality >= 1 && o.Quality <= 100 {
+		quality = o.Quality
+	}
+
+	flags := byte(flagAnimation)
+	var frames []byte
+	for _, frame := range anim.Frames {
+		bounds := frame.Image.Bounds()
+		if bounds.Min.X%2 != 0 || bounds.Min.Y%2 != 0 || !bounds.In(image.Rect(0, 0, anim.Width, anim.Height)) {
+			return errors.New("webp: 帧的位置必须是偶数且位于画布以内")
+		}
+
+		data, alpha, err := encodeFrame(frame.Image, quality)
+		if err != nil {
+			return err
+		}
+		if alpha {
+			flags |= flagAlpha
+		}
+
+		header := make([]byte, 16)
+		putUint24(header[0:], uint32(bounds.Min.X/2))
+		putUint24(header[3:], uint32(bounds.Min.Y/2))
+		putUint24(header[6:], uint32(bounds.Dx()-1))
+		putUint24(header[9:], uint32(bounds.Dy()-1))
+		duration := frame.Duration
+		if duration > 1<<24-1 {
+			duration = 1<<24 - 1
+		}
+		putUint24(header[12:], uint32(duration))
+		if !frame.Blend {
+			header[15] |= 0x02
+		}
+		if frame.Dispose {
+			header[15] |= 0x01
+		}
+		frames = appendChunk(frames, "ANMF", append(header, data...))
+	}
+
+	// ANIM：背景色（BGRA，透明）和播放次数
+	animChunk := make([]byte, 6)
+	loopCount := anim.LoopCount
+	if loopCount < 0 || loopCount > 0xffff {
+		loopCount = 0
+	}
+	binary.LittleEndian.PutUint16(animChunk[4:], uint16(loopCount))
+
+	chunks := vp8xChunk(flags, anim.Width, anim.Height)
+	chunks = appendChunk(chunks, "ANIM", animChunk)
+	chunks = append(chunks, frames...)
+	_, err := w.Write(riff(chunks))
+	return err
+}
+
+// chunk RIFF 块
+type chunk struct {
+	fourCC string
+	data   []byte
+}
+
+// readChunks 解析 RIFF WEBP 文件中的顶层块
+func readChunks(data []byte) ([]chunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errInvalidFormat
+	}
+	var chunks []chunk
+	for p := 12; p+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[p+4:]))
+		if size < 0 || p+8+size > len(data) {
+			return nil, errInvalidFormat
+		}
+		chunks = append(chunks, chunk{fourCC: string(data[p : p+4]), data: data[p+8 : p+8+size]})
+		p += 8 + size + size%2
+	}
+	return chunks, nil
+}
+
+// vp8xChunk 扩展格式头
+func vp8xChunk(flags byte, width, height int) []byte {
+	vp8x := make([]byte, 10)
+	vp8x[0] = flags
+	putUint24(vp8x[4:], uint32(width-1))
+	putUint24(vp8x[7:], uint32(height-1))
+	return appendChunk(nil, "VP8X", vp8x)
+}
+
+// riff 为块加上 RIFF WEBP 文件头
+func riff(chunks []byte) []byte {
+	header := make([]byte, 12, 12+len(chunks))
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+len(chunks)))
+	copy(header[8:], "WEBP")
+	return append(header, chunks...)
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
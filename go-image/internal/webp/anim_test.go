@@ -0,0 +1,82 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// animFile 组装只有帧头、没有帧数据的动图，用于测试解码前的尺寸检查
+func animFile(width, height int, frames ...image.Rectangle) []byte {
+	chunks := vp8xChunk(flagAnimation, width, height)
+	chunks = appendChunk(chunks, "ANIM", make([]byte, 6))
+	for _, r := range frames {
+		header := make([]byte, 16)
+		putUint24(header[0:], uint32(r.Min.X/2))
+		putUint24(header[3:], uint32(r.Min.Y/2))
+		putUint24(header[6:], uint32(r.Dx()-1))
+		putUint24(header[9:], uint32(r.Dy()-1))
+		chunks = appendChunk(chunks, "ANMF", header)
+	}
+	return riff(chunks)
+}
+
+func TestDecodeAllRoundTrip(t *testing.T) {
+	anim := &Animation{Width: 32, Height: 24, LoopCount: 3}
+	for i, c := range []color.NRGBA{{255, 0, 0, 255}, {0, 0, 255, 255}} {
+		frame := image.NewNRGBA(image.Rect(i*8, i*4, i*8+16, i*4+16))
+		for p := 0; p < len(frame.Pix); p += 4 {
+			frame.Pix[p], frame.Pix[p+1], frame.Pix[p+2], frame.Pix[p+3] = c.R, c.G, c.B, c.A
+		}
+		anim.Frames = append(anim.Frames, Frame{Image: frame, Duration: 100 * (i + 1), Blend: i == 0})
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, anim, &Options{Quality: 90}); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+	got, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAll: %v", err)
+	}
+	if got.Width != 32 || got.Height != 24 || got.LoopCount != 3 || len(got.Frames) != 2 {
+		t.Fatalf("got %dx%d loop=%d frames=%d", got.Width, got.Height, got.LoopCount, len(got.Frames))
+	}
+	for i, frame := range got.Frames {
+		want := anim.Frames[i]
+		if frame.Image.Bounds() != want.Image.Bounds() || frame.Duration != want.Duration || frame.Blend != want.Blend {
+			t.Errorf("frame %d: bounds=%v duration=%d blend=%v", i, frame.Image.Bounds(), frame.Duration, frame.Blend)
+		}
+	}
+	r, _, b, _ := got.Frames[1].Image.At(12, 8).RGBA()
+	if r>>8 > 40 || b>>8 < 200 {
+		t.Errorf("frame 1 color = %v", got.Frames[1].Image.At(12, 8))
+	}
+}
+
+func TestDecodeAllRejectsOversizedCanvas(t *testing.T) {
+	data := animFile(1<<24, 1<<24, image.Rect(0, 0, 16, 16))
+	if _, err := DecodeAll(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestDecodeAllRejectsFrameOutsideCanvas(t *testing.T) {
+	data := animFile(64, 64, image.Rect(32, 32, 96, 96))
+	if _, err := DecodeAll(bytes.NewReader(data)); !errors.Is(err, errInvalidFormat) {
+		t.Fatalf("err = %v, want errInvalidFormat", err)
+	}
+}
+
+func TestDecodeAllRejectsFramePixelBudget(t *testing.T) {
+	frames := make([]image.Rectangle, maxFramePixels/(maxDimension*maxDimension)+1)
+	for i := range frames {
+		frames[i] = image.Rect(0, 0, maxDimension, maxDimension)
+	}
+	data := animFile(maxDimension, maxDimension, frames...)
+	if _, err := DecodeAll(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,85 @@
+package webp
+
+import "math/bits"
+
+// boolWriter VP8 的布尔算术编码器（RFC 6386 第 7 章）
+//
+// 与解码器一样以 range-1 表示区间大小；输出 0xff 时需要等待后续的进位，
+// 因此连续的 0xff 先计数，确定不会进位后再写出。
+type boolWriter struct {
+	buf     []byte
+	rangeM1 uint32
+	value   uint32
+	nBits   int // value 中已经移出但尚未写出的位数减 8
+	run     int // 等待写出的 0xff 个数
+}
+
+func newBoolWriter() *boolWriter {
+	return &boolWriter{rangeM1: 254, nBits: -8}
+}
+
+// putBit 以 prob/256 为 bit 等于 0 的概率写入一位
+func (w *boolWriter) putBit(bit bool, prob uint8) {
+	split := (w.rangeM1 * uint32(prob)) >> 8
+	if bit {
+		w.value += split + 1
+		w.rangeM1 -= split + 1
+	} else {
+		w.rangeM1 = split
+	}
+	if w.rangeM1 < 127 {
+		// 重新归一化，使区间大小回到 [128, 255]
+		shift := 7 - (bits.Len32(w.rangeM1+1) - 1)
+		w.rangeM1 = (w.rangeM1+1)<<uint(shift) - 1
+		w.value <<= uint(shift)
+		w.nBits += shift
+		if w.nBits > 0 {
+			w.flush()
+		}
+	}
+}
+
+// putLiteral 以均匀概率写入 n 位无符号整数，高位在前
+func (w *boolWriter) putLiteral(v uint32, n int) {
+	for n > 0 {
+		n--
+		w.putBit(v>>uint(n)&1 == 1, 128)
+	}
+}
+
+// putFlag 以均匀概率写入一个标志位
+func (w *boolWriter) putFlag(flag bool) {
+	w.putBit(flag, 128)
+}
+
+// flush 写出 value 中已经确定的一个字节
+func (w *boolWriter) flush() {
+	s := 8 + w.nBits
+	b := w.value >> uint(s)
+	w.value -= b << uint(s)
+	w.nBits -= 8
+	if b&0xff == 0xff {
+		w.run++
+		return
+	}
+	if b&0x100 != 0 && len(w.buf) > 0 {
+		// 进位传递到已经写出的字节
+		w.buf[len(w.buf)-1]++
+	}
+	pending := byte(0xff)
+	if b&0x100 != 0 {
+		pending = 0
+	}
+	for ; w.run > 0; w.run-- {
+		w.buf = append(w.buf, pending)
+	}
+	w.buf = append(w.buf, byte(b))
+}
+
+// finish 写出剩余的位并返回编码结果
+func (w *boolWriter) finish() []byte {
+	w.putLiteral(0, 9-w.nBits)
+	w.nBits = 0
+	w.flush()
+	return w.buf
+}
@@ -0,0 +1,832 @@
+package webp
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// 16x16 亮度和 8x8 色度的预测模式（RFC 6386 第 12 章）
+const (
+	predDC = iota
+	predVE
+	predHE
+	predTM
+	numModes
+)
+
+// 量化时的舍入偏置（单位为 1/256 个量化步长），小于 0.5 的偏置使接近 0 的系数被量化为 0
+const (
+	dcRoundBias = 110
+	acRoundBias = 100
+)
+
+// maxLevel 量化后系数的最大绝对值（DCT_CAT6 能表示的范围）
+const maxLevel = 2048
+
+// 宏块中非零系数上下文的下标：0-3 为亮度块的列（行），4-5 为 U，6-7 为 V，8 为 Y2
+const (
+	nzU  = 4
+	nzV  = 6
+	nzY2 = 8
+)
+
+// quantMatrix 一个平面的量化步长，q[0] 用于直流分量，q[1] 用于交流分量
+type quantMatrix [2]int32
+
+// quantize 量化一个 4x4 块的系数（按行排列），返回量化后的级别
+func (q quantMatrix) quantize(in *[16]int32, out *[16]int16) {
+	for i, c := range in {
+		step, bias := q[1], int32(acRoundBias)
+		if i == 0 {
+			step, bias = q[0], dcRoundBias
+		}
+		sign := int32(1)
+		if c < 0 {
+			sign, c = -1, -c
+		}
+		level := (c*256 + step*bias) / (step * 256)
+		if level > maxLevel {
+			level = maxLevel
+		}
+		out[i] = int16(sign * level)
+	}
+}
+
+// dequantize 反量化，与解码器的计算方式一致
+func (q quantMatrix) dequantize(in *[16]int16, out *[16]int32) {
+	for i, level := range in {
+		if i == 0 {
+			out[i] = int32(level) * q[0]
+		} else {
+			out[i] = int32(level) * q[1]
+		}
+	}
+}
+
+// macroblock 一个宏块的编码结果
+type macroblock struct {
+	yMode, uvMode uint8
+	skip          bool // 所有系数都为 0，不写入系数
+}
+
+// vp8Encoder 只包含关键帧的 VP8 编码器
+//
+// 为了保持实现简单，只使用 16x16 亮度预测和 8x8 色度预测，不使用分段；
+// 每个宏块按预测误差最小的原则选择预测模式。系数概率根据实际的系数分布更新。
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+
+	// 源图像和重建图像的 YUV 平面，尺寸按宏块对齐
+	y, u, v    []uint8
+	ry, ru, rv []uint8
+	yStride    int
+	uvStride   int
+
+	qIndex      int
+	y1, y2, uvQ quantMatrix
+
+	mbs []macroblock
+	// 宏块上方和左侧的非零系数标志
+	topNZ  [][9]uint8
+	leftNZ [9]uint8
+
+	tokens     []uint16 // 系数的编码记录，所有宏块处理完后按更新后的概率统一编码
+	counts     [numPlanes][numBands][numContexts][numProbs][2]uint32
+	coeffProbs [numPlanes][numBands][numContexts][numProbs]uint8
+}
+
+// token 记录的格式：最低位为要写入的位，其余位为概率的下标；
+// 下标小于 numCoeffProbs 时表示系数概率表中的一项，否则减去 numCoeffProbs 后为固定概率。
+const numCoeffProbs = numPlanes * numBands * numContexts * numProbs
+
+func newVP8Encoder(width, height, quality int) *vp8Encoder {
+	e := &vp8Encoder{
+		width:  width,
+		height: height,
+		mbw:    (width + 15) / 16,
+		mbh:    (height + 15) / 16,
+	}
+	e.yStride = e.mbw * 16
+	e.uvStride = e.mbw * 8
+	e.y = make([]uint8, e.yStride*e.mbh*16)
+	e.u = make([]uint8, e.uvStride*e.mbh*8)
+	e.v = make([]uint8, e.uvStride*e.mbh*8)
+	e.ry = make([]uint8, len(e.y))
+	e.ru = make([]uint8, len(e.u))
+	e.rv = make([]uint8, len(e.v))
+	e.mbs = make([]macroblock, e.mbw*e.mbh)
+	e.topNZ = make([][9]uint8, e.mbw)
+	e.coeffProbs = defaultCoeffProbs
+
+	e.qIndex = qualityToIndex(quality)
+	q := e.qIndex
+	e.y1 = quantMatrix{int32(dcQuantTable[q]), int32(acQuantTable[q])}
+	e.y2 = quantMatrix{int32(dcQuantTable[q]) * 2, int32(acQuantTable[q]) * 155 / 100}
+	if e.y2[1] < 8 {
+		e.y2[1] = 8
+	}
+	uvDC := q
+	if uvDC > 117 {
+		uvDC = 117
+	}
+	e.uvQ = quantMatrix{int32(dcQuantTable[uvDC]), int32(acQuantTable[q])}
+	return e
+}
+
+// qualityToIndex 将 1-100 的质量换算为量化索引，换算方式与 libwebp 相同，
+// 使同样的 q 参数得到的文件大小和画质接近 cwebp 的结果
+func qualityToIndex(quality int) int {
+	c := float64(quality) / 100
+	if c < 0.75 {
+		c = c * 2 / 3
+	} else {
+		c = 2*c - 1
+	}
+	index := int(127 * (1 - math.Cbrt(c)))
+	if index < 0 {
+		index = 0
+	}
+	if index > 127 {
+		index = 127
+	}
+	return index
+}
+
+// filterLevel 根据量化索引选择环路滤波强度，量化越粗滤波越强
+func (e *vp8Encoder) filterLevel() int {
+	level := e.qIndex * 5 / 8
+	if level > 63 {
+		level = 63
+	}
+	return level
+}
+
+// encode 编码所有宏块，返回 VP8 帧数据（不含 RIFF 容器）
+func (e *vp8Encoder) encode() []byte {
+	for mby := 0; mby < e.mbh; mby++ {
+		e.leftNZ = [9]uint8{}
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+
+	updates := e.updateProbs()
+	first := e.writeFirstPartition(updates)
+	second := e.writeTokens()
+
+	frame := make([]byte, 10, 10+len(first)+len(second))
+	// 帧标签：关键帧、版本 0、显示帧，以及第一个分区的大小
+	tag := uint32(1<<4) | uint32(len(first))<<5
+	frame[0] = byte(tag)
+	frame[1] = byte(tag >> 8)
+	frame[2] = byte(tag >> 16)
+	frame[3], frame[4], frame[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(frame[6:], uint16(e.width))
+	binary.LittleEndian.PutUint16(frame[8:], uint16(e.height))
+	frame = append(frame, first...)
+	return append(frame, second...)
+}
+
+// encodeMacroblock 预测、变换、量化并重建一个宏块，系数写入 token 记录
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	mb := &e.mbs[mby*e.mbw+mbx]
+
+	// 亮度：选择预测模式
+	var yTop, yLeft [16]uint8
+	yCorner := e.edges(e.ry, e.yStride, 16, mbx, mby, yTop[:], yLeft[:])
+	yOff := mby*16*e.yStride + mbx*16
+	var yPred [16 * 16]uint8
+	mb.yMode = choosePrediction(e.y[yOff:], e.yStride, 16, mbx, mby, yTop[:], yLeft[:], yCorner, yPred[:])
+
+	// 亮度：每个 4x4 块做 DCT，直流分量再做 WHT
+	var yCoeffs [16][16]int32
+	var dc [16]int32
+	for n := 0; n < 16; n++ {
+		bx, by := n%4*4, n/4*4
+		forwardDCT(e.y[yOff+by*e.yStride+bx:], e.yStride, yPred[by*16+bx:], 16, &yCoeffs[n])
+		dc[n] = yCoeffs[n][0]
+	}
+	var whtCoeffs [16]int32
+	forwardWHT(&dc, &whtCoeffs)
+
+	var y2Levels [16]int16
+	e.y2.quantize(&whtCoeffs, &y2Levels)
+	var yLevels [16][16]int16
+	for n := range yCoeffs {
+		e.y1.quantize(&yCoeffs[n], &yLevels[n])
+		yLevels[n][0] = 0
+	}
+
+	// 亮度：按解码器的方式重建
+	var y2Dequant [16]int32
+	e.y2.dequantize(&y2Levels, &y2Dequant)
+	var dcOut [16]int32
+	inverseWHT(&y2Dequant, &dcOut)
+	for n := 0; n < 16; n++ {
+		bx, by := n%4*4, n/4*4
+		var coeffs [16]int32
+		e.y1.dequantize(&yLevels[n], &coeffs)
+		coeffs[0] = dcOut[n]
+		inverseDCT(&coeffs, yPred[by*16+bx:], 16, e.ry[yOff+by*e.yStride+bx:], e.yStride)
+	}
+
+	// 色度：U 和 V 使用相同的预测模式
+	var uTop, uLeft, vTop, vLeft [8]uint8
+	uCorner := e.edges(e.ru, e.uvStride, 8, mbx, mby, uTop[:], uLeft[:])
+	vCorner := e.edges(e.rv, e.uvStride, 8, mbx, mby, vTop[:], vLeft[:])
+	uvOff := mby*8*e.uvStride + mbx*8
+	var uPred, vPred [8 * 8]uint8
+	mb.uvMode = chooseChromaPrediction(e.u[uvOff:], e.v[uvOff:], e.uvStride, mbx, mby,
+		uTop[:], uLeft[:], uCorner, vTop[:], vLeft[:], vCorner, uPred[:], vPred[:])
+
+	var uvLevels [8][16]int16
+	for n := 0; n < 8; n++ {
+		src, recon, pred := e.u, e.ru, uPred[:]
+		if n >= 4 {
+			src, recon, pred = e.v, e.rv, vPred[:]
+		}
+		bx, by := n%2*4, n%4/2*4
+		var coeffs [16]int32
+		forwardDCT(src[uvOff+by*e.uvStride+bx:], e.uvStride, pred[by*8+bx:], 8, &coeffs)
+		e.uvQ.quantize(&coeffs, &uvLevels[n])
+		e.uvQ.dequantize(&uvLevels[n], &coeffs)
+		inverseDCT(&coeffs, pred[by*8+bx:], 8, recon[uvOff+by*e.uvStride+bx:], e.uvStride)
+	}
+
+	// 所有系数都为 0 时跳过整个宏块，非零系数上下文清零
+	mb.skip = allZero(y2Levels[:]) && allZero(uvLevelsFlat(&uvLevels)) && allZeroBlocks(&yLevels)
+	top := &e.topNZ[mbx]
+	if mb.skip {
+		*top = [9]uint8{}
+		e.leftNZ = [9]uint8{}
+		return
+	}
+
+	// 记录系数：Y2、16 个亮度块、4 个 U 块、4 个 V 块
+	nz := e.putCoeffs(planeY2, top[nzY2]+e.leftNZ[nzY2], &y2Levels, 0)
+	top[nzY2], e.leftNZ[nzY2] = nz, nz
+	for by := 0; by < 4; by++ {
+		for bx := 0; bx < 4; bx++ {
+			nz := e.putCoeffs(planeY1WithY2, top[bx]+e.leftNZ[by], &yLevels[by*4+bx], 1)
+			top[bx], e.leftNZ[by] = nz, nz
+		}
+	}
+	for _, base := range []int{nzU, nzV} {
+		for by := 0; by < 2; by++ {
+			for bx := 0; bx < 2; bx++ {
+				n := (base-nzU)*2 + by*2 + bx
+				nz := e.putCoeffs(planeUV, top[base+bx]+e.leftNZ[base+by], &uvLevels[n], 0)
+				top[base+bx], e.leftNZ[base+by] = nz, nz
+			}
+		}
+	}
+}
+
+// edges 取出宏块上方一行、左侧一列和左上角的重建像素
+//
+// 图像边缘之外的像素按规范取固定值：上方为 127，左侧为 129。
+func (e *vp8Encoder) edges(plane []uint8, stride, size, mbx, mby int, top, left []uint8) uint8 {
+	x0, y0 := mbx*size, mby*size
+	var corner uint8
+	switch {
+	case mby == 0:
+		corner = 127
+	case mbx == 0:
+		corner = 129
+	default:
+		corner = plane[(y0-1)*stride+x0-1]
+	}
+	for i := 0; i < size; i++ {
+		if mby == 0 {
+			top[i] = 127
+		} else {
+			top[i] = plane[(y0-1)*stride+x0+i]
+		}
+		if mbx == 0 {
+			left[i] = 129
+		} else {
+			left[i] = plane[(y0+i)*stride+x0-1]
+		}
+	}
+	return corner
+}
+
+// predict 按模式生成 size x size 的预测块
+func predict(mode uint8, size, mbx, mby int, top, left []uint8, corner uint8, out []uint8) {
+	switch mode {
+	case predDC:
+		// 边缘的宏块只使用存在的一侧，左上角的宏块固定为 128
+		sum, count := 0, 0
+		if mby > 0 {
+			for _, p := range top[:size] {
+				sum += int(p)
+			}
+			count += size
+		}
+		if mbx > 0 {
+			for _, p := range left[:size] {
+				sum += int(p)
+			}
+			count += size
+		}
+		avg := uint8(128)
+		if count > 0 {
+			avg = uint8((sum + count/2) / count)
+		}
+		for i := range out[:size*size] {
+			out[i] = avg
+		}
+	case predVE:
+		for y := 0; y < size; y++ {
+			copy(out[y*size:(y+1)*size], top[:size])
+		}
+	case predHE:
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				out[y*size+x] = left[y]
+			}
+		}
+	case predTM:
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				out[y*size+x] = clip8(int32(left[y]) + int32(top[x]) - int32(corner))
+			}
+		}
+	}
+}
+
+// choosePrediction 选择预测误差（平方和）最小的模式，预测结果写入 out
+func choosePrediction(src []uint8, stride, size, mbx, mby int, top, left []uint8, corner uint8, out []uint8) uint8 {
+	best, bestErr := uint8(predDC), int64(-1)
+	pred := make([]uint8, size*size)
+	for mode := uint8(0); mode < numModes; mode++ {
+		predict(mode, size, mbx, mby, top, left, corner, pred)
+		err := sse(src, stride, pred, size)
+		if bestErr < 0 || err < bestErr {
+			best, bestErr = mode, err
+			copy(out, pred)
+		}
+	}
+	return best
+}
+
+// chooseChromaPrediction 为 U 和 V 选择共同的预测模式
+func chooseChromaPrediction(u, v []uint8, stride, mbx, mby int, uTop, uLeft []uint8, uCorner uint8,
+	vTop, vLeft []uint8, vCorner uint8, uOut, vOut []uint8) uint8 {
+	best, bestErr := uint8(predDC), int64(-1)
+	var uPred, vPred [64]uint8
+	for mode := uint8(0); mode < numModes; mode++ {
+		predict(mode, 8, mbx, mby, uTop, uLeft, uCorner, uPred[:])
+		predict(mode, 8, mbx, mby, vTop, vLeft, vCorner, vPred[:])
+		err := sse(u, stride, uPred[:], 8) + sse(v, stride, vPred[:], 8)
+		if bestErr < 0 || err < bestErr {
+			best, bestErr = mode, err
+			copy(uOut, uPred[:])
+			copy(vOut, vPred[:])
+		}
+	}
+	return best
+}
+
+func sse(src []uint8, stride int, pred []uint8, size int) int64 {
+	var sum int64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := int64(src[y*stride+x]) - int64(pred[y*size+x])
+			sum += d * d
+		}
+	}
+	return sum
+}
+
+// forwardDCT 对源图像与预测之差做 4x4 DCT，输出按行排列（与 libwebp 的 FTransform 相同）
+func forwardDCT(src []uint8, srcStride int, pred []uint8, predStride int, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		s, p := src[i*srcStride:], pred[i*predStride:]
+		d0 := int32(s[0]) - int32(p[0])
+		d1 := int32(s[1]) - int32(p[1])
+		d2 := int32(s[2]) - int32(p[2])
+		d3 := int32(s[3]) - int32(p[3])
+		a0, a1, a2, a3 := d0+d3, d1+d2, d1-d2, d0-d3
+		tmp[0+i*4] = (a0 + a1) * 8
+		tmp[1+i*4] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[2+i*4] = (a0 - a1) * 8
+		tmp[3+i*4] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[12+i]
+		a1 := tmp[4+i] + tmp[8+i]
+		a2 := tmp[4+i] - tmp[8+i]
+		a3 := tmp[0+i] - tmp[12+i]
+		out[0+i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+}
+
+// inverseDCT 反变换并与预测相加，计算方式与解码器完全一致
+func inverseDCT(in *[16]int32, pred []uint8, predStride int, out []uint8, outStride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := in[i] + in[8+i]
+		b := in[i] - in[8+i]
+		c := (in[4+i]*c2)>>16 - (in[12+i]*c1)>>16
+		d := (in[4+i]*c1)>>16 + (in[12+i]*c2)>>16
+		m[i][0] = a + d
+		m[i][1] = b + c
+		m[i][2] = b - c
+		m[i][3] = a - d
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		p, o := pred[j*predStride:], out[j*outStride:]
+		o[0] = clip8(int32(p[0]) + (a+d)>>3)
+		o[1] = clip8(int32(p[1]) + (b+c)>>3)
+		o[2] = clip8(int32(p[2]) + (b-c)>>3)
+		o[3] = clip8(int32(p[3]) + (a-d)>>3)
+	}
+}
+
+// forwardWHT 对 16 个亮度块的直流分量做 Walsh-Hadamard 变换
+func forwardWHT(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		r := in[i*4:]
+		a0 := r[0] + r[2]
+		a1 := r[1] + r[3]
+		a2 := r[1] - r[3]
+		a3 := r[0] - r[2]
+		tmp[0+i*4] = a0 + a1
+		tmp[1+i*4] = a3 + a2
+		tmp[2+i*4] = a3 - a2
+		tmp[3+i*4] = a0 - a1
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[8+i]
+		a1 := tmp[4+i] + tmp[12+i]
+		a2 := tmp[4+i] - tmp[12+i]
+		a3 := tmp[0+i] - tmp[8+i]
+		out[0+i] = (a0 + a1) >> 1
+		out[4+i] = (a3 + a2) >> 1
+		out[8+i] = (a3 - a2) >> 1
+		out[12+i] = (a0 - a1) >> 1
+	}
+}
+
+// inverseWHT 反变换出 16 个亮度块的直流分量，计算方式与解码器完全一致
+func inverseWHT(in, out *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[0+i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[0+i] - in[12+i]
+		m[0+i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[0+i*4] + 3
+		a0 := dc + m[3+i*4]
+		a1 := m[1+i*4] + m[2+i*4]
+		a2 := m[1+i*4] - m[2+i*4]
+		a3 := dc - m[3+i*4]
+		out[i*4+0] = (a0 + a1) >> 3
+		out[i*4+1] = (a3 + a2) >> 3
+		out[i*4+2] = (a0 - a1) >> 3
+		out[i*4+3] = (a3 - a2) >> 3
+	}
+}
+
+func clip8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+func allZero(levels []int16) bool {
+	for _, level := range levels {
+		if level != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func allZeroBlocks(blocks *[16][16]int16) bool {
+	for i := range blocks {
+		if !allZero(blocks[i][:]) {
+			return false
+		}
+	}
+	return true
+}
+
+func uvLevelsFlat(blocks *[8][16]int16) []int16 {
+	flat := make([]int16, 0, 8*16)
+	for i := range blocks {
+		flat = append(flat, blocks[i][:]...)
+	}
+	return flat
+}
+
+// putCoeffs 按扫描顺序记录一个 4x4 块的系数，first 为起始位置（使用 Y2 的亮度块从 1 开始）
+//
+// 返回块中是否有非零系数，用作相邻块的上下文。
+func (e *vp8Encoder) putCoeffs(plane int, ctx uint8, levels *[16]int16, first int) uint8 {
+	last := -1
+	for n := 15; n >= first; n-- {
+		if levels[zigzag[n]] != 0 {
+			last = n
+			break
+		}
+	}
+
+	n := first
+	band, c := int(coeffBands[n]), int(ctx)
+	if last < 0 {
+		e.putToken(plane, band, c, 0, false)
+		return 0
+	}
+	e.putToken(plane, band, c, 0, true)
+
+	for n < 16 {
+		level := int32(levels[zigzag[n]])
+		n++
+		if level == 0 {
+			e.putToken(plane, band, c, 1, false)
+			band, c = int(coeffBands[n]), 0
+			continue
+		}
+		e.putToken(plane, band, c, 1, true)
+
+		sign := level < 0
+		if sign {
+			level = -level
+		}
+		if level == 1 {
+			e.putToken(plane, band, c, 2, false)
+			band, c = int(coeffBands[n]), 1
+		} else {
+			e.putToken(plane, band, c, 2, true)
+			e.putLargeLevel(plane, band, c, level)
+			band, c = int(coeffBands[n]), 2
+		}
+		e.putFixed(sign, 128)
+
+		if n == 16 {
+			break
+		}
+		more := n <= last
+		e.putToken(plane, band, c, 0, more)
+		if !more {
+			break
+		}
+	}
+	return 1
+}
+
+// putLargeLevel 记录绝对值大于 1 的系数（RFC 6386 第 13.2 节的 token 树）
+func (e *vp8Encoder) putLargeLevel(plane, band, c int, level int32) {
+	switch {
+	case level <= 4:
+		e.putToken(plane, band, c, 3, false)
+		if level == 2 {
+			e.putToken(plane, band, c, 4, false)
+		} else {
+			e.putToken(plane, band, c, 4, true)
+			e.putToken(plane, band, c, 5, level == 4)
+		}
+	case level <= 10:
+		e.putToken(plane, band, c, 3, true)
+		e.putToken(plane, band, c, 6, false)
+		if level <= 6 {
+			// DCT_CAT1：5-6
+			e.putToken(plane, band, c, 7, false)
+			e.putFixed(level == 6, 159)
+		} else {
+			// DCT_CAT2：7-10
+			e.putToken(plane, band, c, 7, true)
+			e.putFixed((level-7)&2 != 0, 165)
+			e.putFixed((level-7)&1 != 0, 145)
+		}
+	default:
+		e.putToken(plane, band, c, 3, true)
+		e.putToken(plane, band, c, 6, true)
+		// DCT_CAT3 到 DCT_CAT6：11-18、19-34、35-66、67-2114
+		cat := 3
+		switch {
+		case level <= 18:
+			cat = 0
+		case level <= 34:
+			cat = 1
+		case level <= 66:
+			cat = 2
+		}
+		e.putToken(plane, band, c, 8, cat >= 2)
+		e.putToken(plane, band, c, 9+cat/2, cat&1 == 1)
+		extra := level - 3 - int32(8<<uint(cat))
+		probs := catExtraProbs[cat][:]
+		nBits := 0
+		for probs[nBits] != 0 {
+			nBits++
+		}
+		for i := 0; i < nBits; i++ {
+			e.putFixed(extra>>uint(nBits-1-i)&1 == 1, probs[i])
+		}
+	}
+}
+
+// putToken 记录一个使用系数概率表的位，并统计各个概率的实际分布
+func (e *vp8Encoder) putToken(plane, band, ctx, prob int, bit bool) {
+	index := ((plane*numBands+band)*numContexts+ctx)*numProbs + prob
+	b := uint16(0)
+	if bit {
+		b = 1
+	}
+	e.tokens = append(e.tokens, uint16(index)<<1|b)
+	e.counts[plane][band][ctx][prob][b]++
+}
+
+// putFixed 记录一个使用固定概率的位
+func (e *vp8Encoder) putFixed(bit bool, prob uint8) {
+	b := uint16(0)
+	if bit {
+		b = 1
+	}
+	e.tokens = append(e.tokens, uint16(numCoeffProbs+int(prob))<<1|b)
+}
+
+// updateProbs 根据统计结果决定需要更新的系数概率，返回每一项是否更新
+func (e *vp8Encoder) updateProbs() *[numPlanes][numBands][numContexts][numProbs]bool {
+	var updates [numPlanes][numBands][numContexts][numProbs]bool
+	for i := range e.counts {
+		for j := range e.counts[i] {
+			for k := range e.counts[i][j] {
+				for l := range e.counts[i][j][k] {
+					n0, n1 := e.counts[i][j][k][l][0], e.counts[i][j][k][l][1]
+					if n0+n1 == 0 {
+						continue
+					}
+					old := defaultCoeffProbs[i][j][k][l]
+					updated := uint8(clampInt(int((uint64(n0)*256+uint64(n0+n1)/2)/uint64(n0+n1)), 1, 255))
+					updateProb := coeffUpdateProbs[i][j][k][l]
+					// 更新需要额外写入 8 位新概率和更新标志
+					saving := bitCost(n0, n1, old) - bitCost(n0, n1, updated) -
+						8 - (bitCost(0, 1, updateProb) - bitCost(1, 0, updateProb))
+					if saving > 0 {
+						updates[i][j][k][l] = true
+						e.coeffProbs[i][j][k][l] = updated
+					}
+				}
+			}
+		}
+	}
+	return &updates
+}
+
+// bitCost 以概率 prob 编码 n0 个 0 和 n1 个 1 需要的位数
+func bitCost(n0, n1 uint32, prob uint8) float64 {
+	p := float64(prob) / 256
+	return -float64(n0)*math.Log2(p) - float64(n1)*math.Log2(1-p)
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// writeFirstPartition 写出帧头和每个宏块的预测模式
+func (e *vp8Encoder) writeFirstPartition(updates *[numPlanes][numBands][numContexts][numProbs]bool) []byte {
+	w := newBoolWriter()
+	w.putFlag(false) // 色彩空间
+	w.putFlag(false) // 像素值需要截断
+	w.putFlag(false) // 不使用分段
+
+	// 环路滤波：普通滤波器，不按参考帧和模式调整强度
+	w.putFlag(false)
+	w.putLiteral(uint32(e.filterLevel()), 6)
+	w.putLiteral(0, 3)
+	w.putFlag(false)
+
+	w.putLiteral(0, 2) // 只有一个系数分区
+
+	// 量化索引，各平面不做调整
+	w.putLiteral(uint32(e.qIndex), 7)
+	for i := 0; i < 5; i++ {
+		w.putFlag(false)
+	}
+
+	w.putFlag(false) // refresh_entropy_probs
+
+	for i := range updates {
+		for j := range updates[i] {
+			for k := range updates[i][j] {
+				for l := range updates[i][j][k] {
+					update := updates[i][j][k][l]
+					w.putBit(update, coeffUpdateProbs[i][j][k][l])
+					if update {
+						w.putLiteral(uint32(e.coeffProbs[i][j][k][l]), 8)
+					}
+				}
+			}
+		}
+	}
+
+	// 跳过宏块的概率
+	skipped := 0
+	for _, mb := range e.mbs {
+		if mb.skip {
+			skipped++
+		}
+	}
+	skipProb := uint8(clampInt((len(e.mbs)-skipped)*256/len(e.mbs), 1, 254))
+	w.putFlag(true)
+	w.putLiteral(uint32(skipProb), 8)
+
+	for _, mb := range e.mbs {
+		w.putBit(mb.skip, skipProb)
+		w.putBit(true, 145) // 16x16 亮度预测
+		switch mb.yMode {
+		case predDC:
+			w.putBit(false, 156)
+			w.putBit(false, 163)
+		case predVE:
+			w.putBit(false, 156)
+			w.putBit(true, 163)
+		case predHE:
+			w.putBit(true, 156)
+			w.putBit(false, 128)
+		case predTM:
+			w.putBit(true, 156)
+			w.putBit(true, 128)
+		}
+		switch mb.uvMode {
+		case predDC:
+			w.putBit(false, 142)
+		case predVE:
+			w.putBit(true, 142)
+			w.putBit(false, 114)
+		case predHE:
+			w.putBit(true, 142)
+			w.putBit(true, 114)
+			w.putBit(false, 183)
+		case predTM:
+			w.putBit(true, 142)
+			w.putBit(true, 114)
+			w.putBit(true, 183)
+		}
+	}
+	return w.finish()
+}
+
+// writeTokens 按最终的系数概率编码所有系数
+func (e *vp8Encoder) writeTokens() []byte {
+	w := newBoolWriter()
+	probs := flattenProbs(&e.coeffProbs)
+	for _, t := range e.tokens {
+		index, bit := int(t>>1), t&1 == 1
+		if index < numCoeffProbs {
+			w.putBit(bit, probs[index])
+		} else {
+			w.putBit(bit, uint8(index-numCoeffProbs))
+		}
+	}
+	return w.finish()
+}
+
+func flattenProbs(p *[numPlanes][numBands][numContexts][numProbs]uint8) []uint8 {
+	flat := make([]uint8, 0, numCoeffProbs)
+	for i := range p {
+		for j := range p[i] {
+			for k := range p[i][j] {
+				flat = append(flat, p[i][j][k][:]...)
+			}
+		}
+	}
+	return flat
+}
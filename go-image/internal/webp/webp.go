@@ -0,0 +1,206 @@
+// Package webp 实现有损 WebP 图片（包括动图）的编码和动图的解码
+//
+// 静态图片的解码使用 golang.org/x/image/webp。编码使用纯 Go 实现的 VP8 关键帧编码器，
+// 带透明通道的图片额外写入无损压缩的 ALPH 块。编码器追求实现简单而不是极致的压缩率，
+// 同样的质量参数下文件通常比 cwebp 大一些，但仍明显小于 JPEG。
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// DefaultQuality 默认的编码质量
+const DefaultQuality = 75
+
+// maxDimension WebP 支持的最大宽度和高度
+const maxDimension = 16383
+
+// ErrTooLarge 图片尺寸超过 WebP 的限制
+var ErrTooLarge = errors.New("webp: 图片尺寸超过 16383x16383")
+
+// Options 编码参数
+type Options struct {
+	// Quality 编码质量，1-100，越大画质越好、文件越大
+	Quality int
+}
+
+// Encode 将图片编码为有损 WebP，o 为 nil 时使用默认质量
+func Encode(w io.Writer, img image.Image, o *Options) error {
+	quality := DefaultQuality
+	if o != nil && o.Quality >= 1 && o.Quality <= 100 {
+		quality = o.Quality
+	}
+
+	frame, alpha, err := encodeFrame(img, quality)
+	if err != nil {
+		return err
+	}
+	if alpha {
+		// 扩展格式：VP8X 声明带透明通道
+		bounds := img.Bounds()
+		frame = append(vp8xChunk(flagAlpha, bounds.Dx(), bounds.Dy()), frame...)
+	}
+	_, err = w.Write(riff(frame))
+	return err
+}
+
+// encodeFrame 编码一帧图像，返回 ALPH（图片带透明度时）和 VP8 块
+func encodeFrame(img image.Image, quality int) ([]byte, bool, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return nil, false, ErrTooLarge
+	}
+
+	// 统一转换为非预乘的 NRGBA，透明像素的颜色按原值编码
+	rgba, ok := img.(*image.NRGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		rgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	}
+
+	enc := newVP8Encoder(width, height, quality)
+	enc.importRGBA(rgba)
+
+	var chunks []byte
+	alpha := alphaPlane(rgba)
+	if alpha != nil {
+		// 压缩方式 1：透明度使用无损格式压缩
+		chunks = appendChunk(chunks, "ALPH", append([]byte{1}, encodeAlpha(alpha, width)...))
+	}
+	chunks = appendChunk(chunks, "VP8 ", enc.encode())
+	return chunks, alpha != nil, nil
+}
+
+// importRGBA 将图片转换为 BT.601 有限范围的 YUV 4:2:0，与 libwebp 的转换方式相同
+//
+// 宏块对齐时超出图片的部分复制边缘像素，避免在边缘产生多余的高频分量。
+func (e *vp8Encoder) importRGBA(img *image.NRGBA) {
+	pixel := func(x, y int) (r, g, b int32) {
+		if x >= e.width {
+			x = e.width - 1
+		}
+		if y >= e.height {
+			y = e.height - 1
+		}
+		i := y*img.Stride + x*4
+		return int32(img.Pix[i]), int32(img.Pix[i+1]), int32(img.Pix[i+2])
+	}
+
+	for y := 0; y < e.mbh*16; y++ {
+		for x := 0; x < e.mbw*16; x++ {
+			r, g, b := pixel(x, y)
+			e.y[y*e.yStride+x] = uint8((16839*r + 33059*g + 6420*b + 16<<16 + 1<<15) >> 16)
+		}
+	}
+	for y := 0; y < e.mbh*8; y++ {
+		for x := 0; x < e.mbw*8; x++ {
+			// 色度取 2x2 像素的平均值
+			var r, g, b int32
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					pr, pg, pb := pixel(2*x+dx, 2*y+dy)
+					r, g, b = r+pr, g+pg, b+pb
+				}
+			}
+			// 四个像素之和，系数相应地缩小 4 倍（额外保留 2 位精度后舍入）
+			u := -9719*r - 19081*g + 28800*b
+			v := 28800*r - 24116*g - 4684*b
+			e.u[y*e.uvStride+x] = clip8((u + 128<<18 + 1<<17) >> 18)
+			e.v[y*e.uvStride+x] = clip8((v + 128<<18 + 1<<17) >> 18)
+		}
+	}
+}
+
+// alphaPlane 返回图片的透明度，图片完全不透明时返回 nil
+func alphaPlane(img *image.NRGBA) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	opaque := true
+	alpha := make([]byte, width*height)
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			a := row[x*4+3]
+			alpha[y*width+x] = a
+			if a != 0xff {
+				opaque = false
+			}
+		}
+	}
+	if opaque {
+		return nil
+	}
+	return alpha
+}
+
+// appendChunk 追加一个 RIFF 块，奇数长度的块补齐一个字节
+func appendChunk(dst []byte, fourCC string, data []byte) []byte {
+	header := make([]byte, 8)
+	copy(header, fourCC)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	dst = append(dst, header...)
+	dst = append(dst, data...)
+	if len(data)%2 == 1 {
+		dst = append(dst, 0)
+	}
+	return dst
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+// ConvertColors 将 x/image/webp 解码出的有损图片转换为 NRGBA
+//
+// x/image/webp 返回的 YCbCr 在转换为 RGB 时使用 JPEG 的全范围公式，而 WebP 使用 BT.601 有限范围，
+// 直接使用会使颜色偏淡。这里按 libwebp 的公式转换；其他类型的图片（无损 WebP）原样返回。
+func ConvertColors(img image.Image) image.Image {
+	var ycc *image.YCbCr
+	var alpha []byte
+	var alphaStride int
+	switch m := img.(type) {
+	case *image.YCbCr:
+		ycc = m
+	case *image.NYCbCrA:
+		ycc, alpha, alphaStride = &m.YCbCr, m.A, m.AStride
+	default:
+		return img
+	}
+
+	bounds := ycc.Rect
+	dst := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			yy := int32(ycc.Y[ycc.YOffset(x, y)])
+			ci := ycc.COffset(x, y)
+			u, v := int32(ycc.Cb[ci]), int32(ycc.Cr[ci])
+			p := dst.Pix[dst.PixOffset(x, y):]
+			luma := yy * 19077 >> 8
+			p[0] = clipYUV(luma + v*26149>>8 - 14234)
+			p[1] = clipYUV(luma - u*6419>>8 - v*13320>>8 + 8708)
+			p[2] = clipYUV(luma + u*33050>>8 - 17685)
+			p[3] = 0xff
+			if alpha != nil {
+				p[3] = alpha[(y-bounds.Min.Y)*alphaStride+(x-bounds.Min.X)]
+			}
+		}
+	}
+	return dst
+}
+
+// clipYUV 将 6 位定点数的颜色分量限制在 0-255
+func clipYUV(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v >= 256<<6 {
+		return 0xff
+	}
+	return uint8(v >> 6)
+}
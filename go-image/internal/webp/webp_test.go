@@ -0,0 +1,132 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	xwebp "golang.org/x/image/webp"
+)
+
+// testImage 生成带渐变和色块的测试图片，alpha 为 true 时左半部分半透明
+func testImage(width, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{
+				R: uint8(x * 255 / width),
+				G: uint8(y * 255 / height),
+				B: 128,
+				A: 255,
+			}
+			if (x/16+y/16)%2 == 0 {
+				c.B = 220
+			}
+			if alpha && x < width/2 {
+				c.A = uint8(y * 255 / height)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// roundTrip 编码后用 x/image/webp 解码
+func roundTrip(t *testing.T, img image.Image, quality int) (image.Image, int) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Quality: quality}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	size := buf.Len()
+	decoded, err := xwebp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return ConvertColors(decoded), size
+}
+
+// psnr 不透明像素 RGB 通道的峰值信噪比
+func psnr(a, b image.Image) float64 {
+	var sum float64
+	var n int
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca := color.NRGBAModel.Convert(a.At(x, y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(x, y)).(color.NRGBA)
+			if ca.A != 255 {
+				continue
+			}
+			for _, d := range []float64{
+				float64(ca.R) - float64(cb.R),
+				float64(ca.G) - float64(cb.G),
+				float64(ca.B) - float64(cb.B),
+			} {
+				sum += d * d
+				n++
+			}
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(n)))
+}
+
+func TestEncodeDecodes(t *testing.T) {
+	// 包括宽高不是 16 的倍数和只有一个像素的图片
+	for _, size := range []image.Point{{1, 1}, {17, 13}, {64, 48}, {333, 200}} {
+		img := testImage(size.X, size.Y, false)
+		decoded, _ := roundTrip(t, img, 90)
+		if decoded.Bounds() != img.Bounds() {
+			t.Errorf("%v: bounds = %v", size, decoded.Bounds())
+			continue
+		}
+		if p := psnr(img, decoded); p < 30 {
+			t.Errorf("%v: PSNR = %.1f dB, want >= 30", size, p)
+		}
+	}
+}
+
+func TestEncodeQuality(t *testing.T) {
+	img := testImage(256, 256, false)
+	low, lowSize := roundTrip(t, img, 20)
+	high, highSize := roundTrip(t, img, 95)
+	if lowSize >= highSize {
+		t.Errorf("size at q=20 (%d) >= size at q=95 (%d)", lowSize, highSize)
+	}
+	if psnr(img, low) >= psnr(img, high) {
+		t.Errorf("PSNR at q=20 (%.1f) >= PSNR at q=95 (%.1f)", psnr(img, low), psnr(img, high))
+	}
+}
+
+func TestEncodeAlpha(t *testing.T) {
+	img := testImage(50, 40, true)
+	decoded, _ := roundTrip(t, img, 75)
+
+	// 透明度无损保存
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 50; x++ {
+			want := img.NRGBAAt(x, y).A
+			got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA).A
+			if got != want {
+				t.Fatalf("alpha at (%d,%d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, maxDimension+1, 1))
+	if err := Encode(&buf, img, nil); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want ErrTooLarge", err)
+	}
+	if err := Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 0)), nil); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("empty image: err = %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,111 @@
+// 相册列表页面和相册详情页面的JavaScript功能
+document.addEventListener('DOMContentLoaded', function() {
+    const albumForm = document.getElementById('album-form');
+    const albumGrid = document.getElementById('album-grid');
+    const albumView = document.getElementById('album-view');
+
+    // 发送JSON请求，失败时抛出服务端返回的错误信息
+    function request(method, url, body) {
+        const options = { method: method, headers: {} };
+        if (body !== undefined) {
+            options.headers['Content-Type'] = 'application/json';
+            options.body = JSON.stringify(body);
+        }
+        return fetch(url, options)
+            .then(response => response.json().then(data => {
+                if (!response.ok) {
+                    throw new Error(data.error || '操作失败');
+                }
+                return data;
+            }));
+    }
+
+    // 创建相册
+    if (albumForm) {
+        albumForm.addEventListener('submit', function(e) {
+            e.preventDefault();
+
+            request('POST', '/albums', {
+                name: document.getElementById('album-name').value,
+                description: document.getElementById('album-description').value
+            })
+            .then(() => location.reload())
+            .catch(error => alert('创建失败：' + error.message));
+        });
+    }
+
+    // 相册列表：重命名、删除、调整顺序
+    if (albumGrid) {
+        albumGrid.addEventListener('click', function(e) {
+            const button = e.target.closest('button');
+            if (!button) return;
+
+            const card = button.closest('.album-card');
+            const albumId = card.getAttribute('data-id');
+
+            if (button.classList.contains('rename-btn')) {
+                const name = prompt('新的相册名称', button.getAttribute('data-name'));
+                if (name === null) return;
+
+                request('PATCH', `/albums/${albumId}`, { name: name })
+                    .then(album => {
+                        card.querySelector('.image-name a').textContent = album.name;
+                        button.setAttribute('data-name', album.name);
+                    })
+                    .catch(error => alert('重命名失败：' + error.message));
+            } else if (button.classList.contains('delete-album-btn')) {
+                if (!confirm('删除相册不会删除其中的图片，确定要删除吗？')) return;
+
+                request('DELETE', `/albums/${albumId}`)
+                    .then(() => {
+                        card.remove();
+                        if (!albumGrid.querySelector('.album-card')) {
+                            location.reload();
+                        }
+                    })
+                    .catch(error => alert('删除失败：' + error.message));
+            } else if (button.classList.contains('move-up-btn') || button.classList.contains('move-down-btn')) {
+                const up = button.classList.contains('move-up-btn');
+                const sibling = up ? card.previousElementSibling : card.nextElementSibling;
+                if (!sibling) return;
+
+                // 先在页面上移动，再把完整顺序提交给服务端
+                if (up) {
+                    albumGrid.insertBefore(card, sibling);
+                } else {
+                    albumGrid.insertBefore(sibling, card);
+                }
+                const ids = Array.from(albumGrid.querySelectorAll('.album-card'))
+                    .map(item => item.getAttribute('data-id'));
+
+                request('PUT', '/albums/order', { album_ids: ids })
+                    .catch(error => {
+                        alert('调整顺序失败：' + error.message);
+                        location.reload();
+                    });
+            }
+        });
+    }
+
+    // 相册详情：设置封面、移出图片
+    if (albumView) {
+        const albumId = albumView.getAttribute('data-id');
+
+        albumView.addEventListener('click', function(e) {
+            const button = e.target.closest('button');
+            if (!button) return;
+
+            const imageId = button.closest('.image-card').getAttribute('data-id');
+
+            if (button.classList.contains('set-cover-btn')) {
+                request('PATCH', `/albums/${albumId}`, { cover_id: imageId })
+                    .then(() => location.reload())
+                    .catch(error => alert('设置封面失败：' + error.message));
+            } else if (button.classList.contains('remove-btn')) {
+                request('DELETE', `/albums/${albumId}/images/${imageId}`)
+                    .then(() => location.reload())
+                    .catch(error => alert('移出失败：' + error.message));
+            }
+        });
+    }
+});
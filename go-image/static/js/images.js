@@ -9,11 +9,35 @@ document.addEventListener('DOMContentLoaded', function() {
     const modalImageDate = document.getElementById('modal-image-date');
     const modalImageUrl = document.getElementById('modal-image-url');
     const modalMarkdownUrl = document.getElementById('modal-markdown-url');
+    const modalVisibility = document.getElementById('modal-image-visibility');
+    const modalWatermark = document.getElementById('modal-image-watermark');
+    const modalWatermarked = document.getElementById('modal-image-watermarked');
+    const modalTags = document.getElementById('modal-image-tags');
+    const modalDescription = document.getElementById('modal-image-description');
+    const modalSaveInfoBtn = document.getElementById('modal-save-info');
+    const modalAlbumSelect = document.getElementById('modal-album-select');
+    const modalAddAlbumBtn = document.getElementById('modal-add-album');
+    const modalSignedUrl = document.getElementById('modal-signed-url');
     const deleteModal = document.getElementById('delete-modal');
+    // 删除的图片在回收站中保留的天数
+    const trashDays = deleteModal.dataset.trashDays;
     const closeButtons = document.querySelectorAll('.close');
     const confirmDeleteBtn = document.getElementById('confirm-delete');
     const cancelDeleteBtn = document.getElementById('cancel-delete');
+    const deleteMessage = document.getElementById('delete-message');
+    const selectAll = document.getElementById('select-all');
+    const selectedCount = document.getElementById('selected-count');
+    const deleteSelectedBtn = document.getElementById('delete-selected');
+    const shareSelectedBtn = document.getElementById('share-selected');
+    const shareModal = document.getElementById('share-modal');
+    const shareSource = document.getElementById('share-source');
+    const shareSourceImages = document.getElementById('share-source-images');
+    const shareList = document.getElementById('share-list');
     let currentImageId = null;
+    // 批量删除时要删除的图片ID，删除单张图片时为 null
+    let batchDeleteIds = null;
+    // 创建分享时要分享的图片ID，分享相册时为空
+    let shareImageIds = [];
 
     // 复制链接功能
     function copyToClipboard(text) {
@@ -67,15 +91,161 @@ document.addEventListener('DOMContentLoaded', function() {
             modalImagePreview.src = `/i/${imageId}`;
             modalImageName.textContent = imageName;
             modalImageDate.textContent = imageDate;
+            modalVisibility.value = imageCard.getAttribute('data-visibility');
+            modalTags.value = imageCard.getAttribute('data-tags').split(',').filter(Boolean).join(', ');
+            modalDescription.value = imageCard.getAttribute('data-description');
+            modalWatermark.value = imageCard.getAttribute('data-watermark') || 'default';
+            // 上传时已经加过水印的图片不能再修改
+            const watermarked = imageCard.getAttribute('data-watermarked') === 'true';
+            modalWatermark.disabled = watermarked;
+            modalWatermarked.hidden = !watermarked;
             
-            const imageUrl = getFullUrl(`/i/${imageId}`);
+            const imageUrl = getFullUrl(imageCard.getAttribute('data-url'));
             modalImageUrl.value = imageUrl;
             modalMarkdownUrl.value = `![${imageName}](${imageUrl})`;
+            if (modalSignedUrl) {
+                modalSignedUrl.value = '';
+            }
             
             imageModal.style.display = 'block';
         });
     });
 
+    // 修改可见性
+    const visibilityLabels = {
+        public: '公开',
+        unlisted: '仅链接可见',
+        private: '仅自己可见'
+    };
+
+    modalVisibility.addEventListener('change', function() {
+        const imageId = currentImageId;
+        const visibility = this.value;
+
+        fetch(`/images/${imageId}`, {
+            method: 'PATCH',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ visibility: visibility })
+        })
+        .then(response => response.json().then(data => {
+            if (!response.ok) {
+                throw new Error(data.error || '修改失败');
+            }
+            return data;
+        }))
+        .then(data => {
+            // 不公开列出的图片使用分享标识作为链接
+            const path = data.visibility === 'unlisted' ? `/i/${data.slug}` : `/i/${data.id}`;
+            const imageCard = document.querySelector(`.image-card[data-id="${imageId}"]`);
+            if (imageCard) {
+                imageCard.setAttribute('data-url', path);
+                imageCard.setAttribute('data-visibility', data.visibility);
+                imageCard.querySelector('.copy-btn').setAttribute('data-url', path);
+                const label = imageCard.querySelector('.image-visibility');
+                label.className = `image-visibility ${data.visibility}`;
+                label.textContent = visibilityLabels[data.visibility];
+            }
+
+            const imageUrl = getFullUrl(path);
+            modalImageUrl.value = imageUrl;
+            modalMarkdownUrl.value = `![${data.filename}](${imageUrl})`;
+        })
+        .catch(error => {
+            alert(error.message);
+        });
+    });
+
+    // 修改水印设置
+    modalWatermark.addEventListener('change', function() {
+        const imageId = currentImageId;
+
+        fetch(`/images/${imageId}`, {
+            method: 'PATCH',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ watermark: this.value })
+        })
+        .then(response => response.json().then(data => {
+            if (!response.ok) {
+                throw new Error(data.error || '修改失败');
+            }
+            return data;
+        }))
+        .then(data => {
+            const imageCard = document.querySelector(`.image-card[data-id="${imageId}"]`);
+            if (imageCard) {
+                imageCard.setAttribute('data-watermark', data.watermark || '');
+            }
+        })
+        .catch(error => {
+            alert(error.message);
+        });
+    });
+
+    // 修改标签和描述
+    modalSaveInfoBtn.addEventListener('click', function() {
+        const imageId = currentImageId;
+        const tags = modalTags.value.split(/[,，]/).map(tag => tag.trim()).filter(Boolean);
+
+        fetch(`/images/${imageId}`, {
+            method: 'PATCH',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ tags: tags, description: modalDescription.value })
+        })
+        .then(response => response.json().then(data => {
+            if (!response.ok) {
+                throw new Error(data.error || '保存失败');
+            }
+            return data;
+        }))
+        .then(data => {
+            const imageCard = document.querySelector(`.image-card[data-id="${imageId}"]`);
+            if (imageCard) {
+                const savedTags = data.tags || [];
+                imageCard.setAttribute('data-tags', savedTags.join(','));
+                imageCard.setAttribute('data-description', data.description || '');
+                renderTags(imageCard.querySelector('.image-tags'), savedTags);
+            }
+            alert('已保存');
+        })
+        .catch(error => {
+            alert(error.message);
+        });
+    });
+
+    // 显示图片卡片上的标签，点击标签按该标签筛选
+    function renderTags(container, tags) {
+        container.innerHTML = '';
+        tags.forEach(tag => {
+            const link = document.createElement('a');
+            link.className = 'image-tag';
+            link.href = '/images?tag=' + encodeURIComponent(tag);
+            link.textContent = tag;
+            container.appendChild(link);
+        });
+    }
+
+    // 加入相册
+    modalAddAlbumBtn.addEventListener('click', function() {
+        const albumId = modalAlbumSelect.value;
+        if (!albumId || !currentImageId) return;
+
+        fetch(`/albums/${albumId}/images`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ image_ids: [currentImageId] })
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (data.error) {
+                throw new Error(data.error);
+            }
+            alert('已加入相册“' + modalAlbumSelect.selectedOptions[0].textContent + '”');
+        })
+        .catch(error => {
+            alert('加入相册失败：' + error.message);
+        });
+    });
+
     // 模态框中的复制功能
     modalCopyUrlBtn.addEventListener('click', function() {
         copyToClipboard(modalImageUrl.value);
@@ -85,34 +255,314 @@ document.addEventListener('DOMContentLoaded', function() {
         copyToClipboard(modalMarkdownUrl.value);
     });
 
+    // 多选图片
+    function selectedIds() {
+        return Array.from(document.querySelectorAll('.image-select:checked')).map(input => input.value);
+    }
+
+    function updateSelection() {
+        const boxes = document.querySelectorAll('.image-select');
+        const ids = selectedIds();
+        boxes.forEach(input => {
+            input.closest('.image-card').classList.toggle('selected', input.checked);
+        });
+        if (selectAll) {
+            selectAll.checked = boxes.length > 0 && ids.length === boxes.length;
+            selectAll.indeterminate = ids.length > 0 && ids.length < boxes.length;
+            selectedCount.textContent = ids.length > 0 ? `已选择 ${ids.length} 张` : '';
+            deleteSelectedBtn.disabled = ids.length === 0;
+            shareSelectedBtn.disabled = ids.length === 0;
+        }
+    }
+
+    document.querySelectorAll('.image-select').forEach(input => {
+        input.addEventListener('change', updateSelection);
+    });
+
+    if (selectAll) {
+        selectAll.addEventListener('change', function() {
+            document.querySelectorAll('.image-select').forEach(input => {
+                input.checked = this.checked;
+            });
+            updateSelection();
+        });
+
+        // 删除选中的图片
+        deleteSelectedBtn.addEventListener('click', function() {
+            const ids = selectedIds();
+            if (ids.length === 0) return;
+            batchDeleteIds = ids;
+            deleteMessage.textContent = `您确定要删除选中的 ${ids.length} 张图片吗？图片将移到回收站，${trashDays} 天内可以恢复。`;
+            deleteModal.style.display = 'block';
+        });
+
+        // 分享选中的图片
+        shareSelectedBtn.addEventListener('click', function() {
+            const ids = selectedIds();
+            if (ids.length === 0) return;
+            openShareModal(ids);
+        });
+    }
+
+    // 删除图片后移除卡片，没有图片时刷新页面
+    function removeCards(ids) {
+        ids.forEach(id => {
+            const imageCard = document.querySelector(`.image-card[data-id="${id}"]`);
+            if (imageCard) {
+                imageCard.remove();
+            }
+        });
+        updateSelection();
+        // 检查是否还有图片
+        const remainingImages = document.querySelectorAll('.image-card');
+        if (remainingImages.length === 0) {
+            location.reload(); // 刷新页面显示"暂无图片"提示
+        }
+    }
+
+    // 生成限时链接，没有配置签名密钥时页面上没有这部分
+    if (modalSignedUrl) {
+        document.getElementById('modal-sign-url').addEventListener('click', function() {
+            const imageId = currentImageId;
+
+            fetch(`/images/${imageId}/signed-url`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    expires_in: parseInt(document.getElementById('modal-signed-expiry').value, 10),
+                    bind_ip: document.getElementById('modal-signed-bind-ip').checked
+                })
+            })
+            .then(response => response.json().then(data => {
+                if (!response.ok) {
+                    throw new Error(data.error || '生成失败');
+                }
+                return data;
+            }))
+            .then(data => {
+                modalSignedUrl.value = data.url;
+                modalSignedUrl.title = '有效期至 ' + new Date(data.expires_at).toLocaleString();
+            })
+            .catch(error => {
+                alert(error.message);
+            });
+        });
+
+        document.getElementById('modal-copy-signed-url').addEventListener('click', function() {
+            if (modalSignedUrl.value) {
+                copyToClipboard(modalSignedUrl.value);
+            }
+        });
+    }
+
+    // 分享页面：ids 为要分享的图片，为空时只能选择相册
+    function openShareModal(ids) {
+        shareImageIds = ids;
+        shareSourceImages.textContent = ids.length === 1 ? '当前图片' : `选中的 ${ids.length} 张图片`;
+        shareSourceImages.hidden = ids.length === 0;
+        shareSourceImages.disabled = ids.length === 0;
+        if (ids.length > 0) {
+            shareSource.value = '';
+        } else if (shareSource.value === '') {
+            const album = shareSource.querySelector('optgroup option');
+            shareSource.value = album ? album.value : '';
+        }
+        document.getElementById('share-title').value = '';
+        document.getElementById('share-password').value = '';
+        document.getElementById('share-expires').value = '0';
+        document.getElementById('share-max-views').value = '0';
+        document.getElementById('share-created').hidden = true;
+        shareModal.style.display = 'block';
+    }
+
+    document.getElementById('modal-share').addEventListener('click', function() {
+        if (!currentImageId) return;
+        imageModal.style.display = 'none';
+        openShareModal([currentImageId]);
+    });
+
+    document.getElementById('share-album').addEventListener('click', function() {
+        openShareModal([]);
+    });
+
+    document.getElementById('share-create').addEventListener('click', function() {
+        const request = {
+            title: document.getElementById('share-title').value.trim(),
+            password: document.getElementById('share-password').value,
+            expires_in: parseInt(document.getElementById('share-expires').value, 10),
+            max_views: parseInt(document.getElementById('share-max-views').value, 10) || 0
+        };
+        if (shareSource.value) {
+            request.album_id = shareSource.value;
+        } else {
+            request.image_ids = shareImageIds;
+        }
+
+        fetch('/shares', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(request)
+        })
+        .then(response => response.json().then(data => {
+            if (!response.ok) {
+                throw new Error(data.error || '创建失败');
+            }
+            return data;
+        }))
+        .then(data => {
+            document.getElementById('share-url').value = data.url;
+            document.getElementById('share-created').hidden = false;
+            loadShares();
+        })
+        .catch(error => {
+            alert('创建分享失败：' + error.message);
+        });
+    });
+
+    document.getElementById('share-copy-url').addEventListener('click', function() {
+        copyToClipboard(document.getElementById('share-url').value);
+    });
+
+    // 我的分享
+    const shareStatusLabels = {
+        active: '有效',
+        expired: '已过期',
+        exhausted: '次数已用完'
+    };
+
+    function albumName(id) {
+        const option = shareSource.querySelector(`optgroup option[value="${id}"]`);
+        return option ? option.textContent : '已删除的相册';
+    }
+
+    function shareCell(row, text) {
+        const cell = document.createElement('td');
+        cell.textContent = text;
+        row.appendChild(cell);
+        return cell;
+    }
+
+    function renderShares(shares) {
+        shareList.innerHTML = '';
+        if (shares.length === 0) {
+            const row = document.createElement('tr');
+            row.className = 'empty-row';
+            shareCell(row, '还没有创建任何分享').colSpan = 7;
+            shareList.appendChild(row);
+            return;
+        }
+
+        shares.forEach(share => {
+            const row = document.createElement('tr');
+            row.setAttribute('data-id', share.id);
+            const title = shareCell(row, '');
+            const link = document.createElement('a');
+            link.href = share.url;
+            link.target = '_blank';
+            link.textContent = share.title || share.token;
+            title.appendChild(link);
+            shareCell(row, share.type === 'album' ? '相册：' + albumName(share.album_id) : `${share.image_ids.length} 张图片`);
+            shareCell(row, share.has_password ? '有' : '无');
+            shareCell(row, share.max_views ? `${share.views} / ${share.max_views}` : `${share.views}`);
+            shareCell(row, share.expires_at ? new Date(share.expires_at).toLocaleString() : '永不过期');
+            shareCell(row, shareStatusLabels[share.status] || share.status);
+
+            const actions = shareCell(row, '');
+            const copyBtn = document.createElement('button');
+            copyBtn.className = 'btn small';
+            copyBtn.textContent = '复制链接';
+            copyBtn.addEventListener('click', function() {
+                copyToClipboard(share.url);
+            });
+            const revokeBtn = document.createElement('button');
+            revokeBtn.className = 'btn small danger';
+            revokeBtn.textContent = '撤销';
+            revokeBtn.addEventListener('click', function() {
+                if (!confirm('撤销后分享链接将无法访问，确定撤销吗？')) return;
+                fetch(`/shares/${share.id}`, { method: 'DELETE' })
+                .then(response => response.json().then(data => {
+                    if (!response.ok) {
+                        throw new Error(data.error || '撤销失败');
+                    }
+                    loadShares();
+                }))
+                .catch(error => {
+                    alert(error.message);
+                });
+            });
+            actions.appendChild(copyBtn);
+            actions.appendChild(revokeBtn);
+            shareList.appendChild(row);
+        });
+    }
+
+    function loadShares() {
+        fetch('/shares')
+        .then(response => response.json().then(data => {
+            if (!response.ok) {
+                throw new Error(data.error || '获取分享失败');
+            }
+            renderShares(data);
+        }))
+        .catch(error => {
+            shareList.innerHTML = '';
+            const row = document.createElement('tr');
+            row.className = 'empty-row';
+            shareCell(row, error.message).colSpan = 7;
+            shareList.appendChild(row);
+        });
+    }
+
+    loadShares();
+
     // 删除图片功能
     const deleteButtons = document.querySelectorAll('.delete-btn');
     deleteButtons.forEach(button => {
         button.addEventListener('click', function() {
             currentImageId = this.getAttribute('data-id');
+            batchDeleteIds = null;
+            deleteMessage.textContent = `您确定要删除这张图片吗？图片将移到回收站，${trashDays} 天内可以恢复。`;
             deleteModal.style.display = 'block';
         });
     });
 
     // 确认删除
     confirmDeleteBtn.addEventListener('click', function() {
-        if (currentImageId) {
+        if (batchDeleteIds) {
+            fetch('/images/batch-delete', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ ids: batchDeleteIds })
+            })
+            .then(response => response.json().then(data => {
+                if (!response.ok) {
+                    throw new Error(data.error || '删除失败');
+                }
+                return data;
+            }))
+            .then(data => {
+                const results = data.results || [];
+                deleteModal.style.display = 'none';
+                batchDeleteIds = null;
+                removeCards(results.filter(result => result.deleted).map(result => result.id));
+                // 部分图片删除失败时保留选中状态，方便重试
+                const failed = results.filter(result => !result.deleted);
+                if (failed.length > 0) {
+                    alert(`${failed.length} 张图片删除失败：\n` + failed.map(result => `${result.id}：${result.error}`).join('\n'));
+                }
+            })
+            .catch(error => {
+                alert('删除失败：' + error.message);
+            });
+        } else if (currentImageId) {
             fetch(`/images/${currentImageId}`, {
                 method: 'DELETE',
             })
             .then(response => response.json())
             .then(data => {
                 if (data.message === '删除成功') {
-                    const imageCard = document.querySelector(`.image-card[data-id="${currentImageId}"]`);
-                    if (imageCard) {
-                        imageCard.remove();
-                    }
                     deleteModal.style.display = 'none';
-                    // 检查是否还有图片
-                    const remainingImages = document.querySelectorAll('.image-card');
-                    if (remainingImages.length === 0) {
-                        location.reload(); // 刷新页面显示"暂无图片"提示
-                    }
+                    removeCards([currentImageId]);
                 } else {
                     alert('删除失败：' + data.error);
                 }
@@ -16,8 +16,9 @@ document.addEventListener('DOMContentLoaded', function() {
             oldCanvas.remove();
         }
 
-        const usedStorage = parseFloat(document.getElementById('used-storage').textContent);
-        const totalStorage = parseFloat(document.getElementById('total-storage').textContent);
+        // 页面上的数值单位为字节，总容量为 0 表示不限制
+        const usedStorage = parseFloat(document.getElementById('used-storage').textContent) || 0;
+        const totalStorage = parseFloat(document.getElementById('total-storage').textContent) || 0;
         
         // 计算使用百分比
         const usagePercentage = totalStorage > 0 ? (usedStorage / totalStorage) * 100 : 0;
@@ -29,7 +30,7 @@ document.addEventListener('DOMContentLoaded', function() {
         // 创建进度条
         const progressBar = document.createElement('div');
         progressBar.className = 'progress-bar';
-        progressBar.style.width = `${usagePercentage}%`;
+        progressBar.style.width = `${Math.min(usagePercentage, 100)}%`;
         
         // 创建百分比文本
         const percentageText = document.createElement('div');
@@ -46,7 +47,7 @@ document.addEventListener('DOMContentLoaded', function() {
 
         // 更新显示的存储信息
         document.getElementById('used-storage').textContent = formatFileSize(usedStorage);
-        document.getElementById('total-storage').textContent = formatFileSize(totalStorage);
+        document.getElementById('total-storage').textContent = totalStorage > 0 ? formatFileSize(totalStorage) : '不限';
     }
 
     // 初始化图片列表页面功能
@@ -63,6 +64,7 @@ document.addEventListener('DOMContentLoaded', function() {
         const modalImageName = document.getElementById('modal-image-name');
         const modalImageDate = document.getElementById('modal-image-date');
         const modalImageSize = document.getElementById('modal-image-size');
+        const modalImageExif = document.getElementById('modal-image-exif');
         const modalImageUrl = document.getElementById('modal-image-url');
         const modalMarkdownUrl = document.getElementById('modal-markdown-url');
         const modalCopyUrl = document.getElementById('modal-copy-url');
@@ -142,6 +144,25 @@ document.addEventListener('DOMContentLoaded', function() {
                     searchImages(searchInput.value);
                 }
             });
+
+            // 修改排序和类型后立即刷新列表
+            ['sort-select', 'type-select'].forEach(id => {
+                const select = document.getElementById(id);
+                if (select) {
+                    select.addEventListener('change', function() {
+                        searchImages(searchInput.value);
+                    });
+                }
+            });
+
+            // 回到第一页：保留搜索和排序条件，去掉分页游标
+            const firstPage = document.getElementById('first-page');
+            if (firstPage) {
+                firstPage.addEventListener('click', function(e) {
+                    e.preventDefault();
+                    searchImages(searchInput.value);
+                });
+            }
         }
         
         // 模态框中的复制按钮
@@ -170,13 +191,18 @@ document.addEventListener('DOMContentLoaded', function() {
                     // 设置模态框内容
                     const baseUrl = window.location.origin;
                     const imageFullUrl = `${baseUrl}/i/${data.id}`;
+                    // 不公开列出的图片使用分享标识作为链接
+                    const shareUrl = data.visibility === 'unlisted' ? `${baseUrl}/i/${data.slug}` : imageFullUrl;
                     
                     modalImagePreview.src = imageFullUrl;
                     modalImageName.textContent = data.filename;
                     modalImageDate.textContent = `上传时间: ${formatDate(data.uploaded_at)}`;
-                    modalImageSize.textContent = `文件大小: ${formatFileSize(data.size)}`;
-                    modalImageUrl.value = imageFullUrl;
-                    modalMarkdownUrl.value = `![${data.filename}](${imageFullUrl})`;
+                    modalImageSize.textContent = `文件大小: ${formatFileSize(data.size)}` +
+                        (data.width ? `，尺寸: ${data.width} × ${data.height}` : '') +
+                        (data.frames ? `，动图: ${data.frames} 帧 / ${(data.duration / 1000).toFixed(1)} 秒` : '');
+                    modalImageExif.textContent = formatExif(data);
+                    modalImageUrl.value = shareUrl;
+                    modalMarkdownUrl.value = `![${data.filename}](${shareUrl})`;
                     
                     // 显示模态框
                     imageModal.style.display = 'block';
@@ -228,46 +254,33 @@ document.addEventListener('DOMContentLoaded', function() {
                 });
         }
         
-        // 搜索图片
+        // 搜索图片：由服务端按文件名、标签、描述、上传日期和类型检索，并按所选方式排序
         function searchImages(query) {
-            // 简单的前端过滤，实际项目中可以改为后端搜索
-            const imageCards = document.querySelectorAll('.image-card');
-            const normalizedQuery = query.toLowerCase().trim();
-            
-            let hasVisibleImages = false;
-            
-            imageCards.forEach(card => {
-                const imageName = card.querySelector('.image-name').textContent.toLowerCase();
-                if (normalizedQuery === '' || imageName.includes(normalizedQuery)) {
-                    card.style.display = 'block';
-                    hasVisibleImages = true;
-                } else {
-                    card.style.display = 'none';
-                }
-            });
-            
-            // 显示或隐藏空状态
-            let noImagesElement = document.querySelector('.no-images');
-            
-            if (!hasVisibleImages) {
-                if (!noImagesElement) {
-                    noImagesElement = document.createElement('div');
-                    noImagesElement.className = 'no-images';
-                    noImagesElement.innerHTML = `
-                        <p>没有找到匹配的图片</p>
-                        <button class="btn secondary" id="reset-search">重置搜索</button>
-                    `;
-                    imageGrid.appendChild(noImagesElement);
-                    
-                    // 添加重置搜索按钮事件
-                    document.getElementById('reset-search').addEventListener('click', function() {
-                        searchInput.value = '';
-                        searchImages('');
-                    });
-                }
-            } else if (noImagesElement) {
-                noImagesElement.remove();
+            const params = new URLSearchParams();
+            if (query.trim() !== '') {
+                params.set('q', query.trim());
+            }
+            const from = document.getElementById('search-from');
+            const to = document.getElementById('search-to');
+            if (from && from.value) {
+                params.set('from', from.value);
+            }
+            if (to && to.value) {
+                params.set('to', to.value);
+            }
+            const type = document.getElementById('type-select');
+            if (type && type.value) {
+                params.set('type', type.value);
             }
+            const sort = document.getElementById('sort-select');
+            if (sort && sort.value !== 'uploaded_at:desc') {
+                const [field, order] = sort.value.split(':');
+                params.set('sort', field);
+                params.set('order', order);
+            }
+
+            const search = params.toString();
+            window.location.href = '/images' + (search ? '?' + search : '');
         }
     }
     
@@ -303,14 +316,37 @@ document.addEventListener('DOMContentLoaded', function() {
         });
     }
     
+    // 格式化拍摄信息（相机、拍摄时间和位置）
+    function formatExif(data) {
+        const exif = data.exif;
+        if (!exif) {
+            return data.metadata_stripped ? '拍摄信息已移除' : '';
+        }
+
+        const parts = [];
+        const camera = [exif.camera_make, exif.camera_model].filter(Boolean).join(' ');
+        if (camera) {
+            parts.push(`相机: ${camera}`);
+        }
+        if (exif.taken_at) {
+            parts.push(`拍摄时间: ${formatDate(exif.taken_at)}`);
+        }
+        if (exif.gps) {
+            parts.push(`位置: ${exif.gps.latitude.toFixed(6)}, ${exif.gps.longitude.toFixed(6)}`);
+        }
+        return parts.join('，');
+    }
+    
     // 格式化文件大小
     function formatFileSize(bytes) {
         if (bytes < 1024) {
             return bytes + ' B';
         } else if (bytes < 1024 * 1024) {
             return (bytes / 1024).toFixed(2) + ' KB';
-        } else {
+        } else if (bytes < 1024 * 1024 * 1024) {
             return (bytes / (1024 * 1024)).toFixed(2) + ' MB';
+        } else {
+            return (bytes / (1024 * 1024 * 1024)).toFixed(2) + ' GB';
         }
     }
 });
\ No newline at end of file
@@ -0,0 +1,126 @@
+// 个人设置页面的JavaScript功能
+document.addEventListener('DOMContentLoaded', function() {
+    const settingsForm = document.getElementById('settings-form');
+    const settingsStatus = document.getElementById('settings-status');
+    const hotlinkCustom = document.getElementById('settings-hotlink-custom');
+    const hotlinkFields = document.getElementById('settings-hotlink-fields');
+
+    // 每行一个域名
+    function domainList(id) {
+        return document.getElementById(id).value.split('\n').map(line => line.trim()).filter(Boolean);
+    }
+
+    // 使用服务器默认设置时不能修改防盗链的各项设置
+    hotlinkCustom.addEventListener('change', function() {
+        hotlinkFields.disabled = !this.checked;
+    });
+
+    // 保存设置
+    settingsForm.addEventListener('submit', function(e) {
+        e.preventDefault();
+
+        const payload = {
+            strip_metadata: document.getElementById('settings-strip-metadata').checked,
+            watermark: {
+                enabled: document.getElementById('settings-watermark-enabled').checked,
+                mode: document.getElementById('settings-watermark-mode').value,
+                type: document.getElementById('settings-watermark-type').value,
+                text: document.getElementById('settings-watermark-text').value,
+                position: document.getElementById('settings-watermark-position').value,
+                opacity: parseFloat(document.getElementById('settings-watermark-opacity').value),
+                scale: parseFloat(document.getElementById('settings-watermark-scale').value)
+            },
+            hotlink: {
+                custom: hotlinkCustom.checked,
+                enabled: document.getElementById('settings-hotlink-enabled').checked,
+                allow_empty_referer: document.getElementById('settings-hotlink-allow-empty').checked,
+                allowed: domainList('settings-hotlink-allowed'),
+                denied: domainList('settings-hotlink-denied'),
+                action: document.getElementById('settings-hotlink-action').value,
+                redirect_url: document.getElementById('settings-hotlink-redirect').value
+            }
+        };
+
+        fetch('/settings', {
+            method: 'PATCH',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload)
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (data.error) {
+                throw new Error(data.error);
+            }
+
+            settingsStatus.textContent = '已保存';
+            setTimeout(() => {
+                settingsStatus.textContent = '';
+            }, 1500);
+        })
+        .catch(error => {
+            alert('保存失败：' + error.message);
+        });
+    });
+
+    // 上传水印 Logo，选择文件后立即上传
+    const logoInput = document.getElementById('settings-watermark-logo');
+    const logoPreview = document.getElementById('settings-watermark-logo-preview');
+    const logoRemove = document.getElementById('settings-watermark-logo-remove');
+
+    function showLogo(version) {
+        if (version) {
+            logoPreview.src = '/settings/watermark/logo?v=' + encodeURIComponent(version);
+            logoPreview.hidden = false;
+            logoRemove.hidden = false;
+        } else {
+            logoPreview.removeAttribute('src');
+            logoPreview.hidden = true;
+            logoRemove.hidden = true;
+        }
+    }
+
+    logoInput.addEventListener('change', function() {
+        if (!logoInput.files.length) {
+            return;
+        }
+        const formData = new FormData();
+        formData.append('logo', logoInput.files[0]);
+
+        fetch('/settings/watermark/logo', {
+            method: 'PUT',
+            body: formData
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (data.error) {
+                throw new Error(data.error);
+            }
+            showLogo(data.watermark.logo);
+        })
+        .catch(error => {
+            alert('上传 Logo 失败：' + error.message);
+        })
+        .finally(() => {
+            logoInput.value = '';
+        });
+    });
+
+    logoRemove.addEventListener('click', function() {
+        if (!confirm('确定要删除水印 Logo 吗？')) {
+            return;
+        }
+
+        fetch('/settings/watermark/logo', { method: 'DELETE' })
+        .then(response => response.json())
+        .then(data => {
+            if (data.error) {
+                throw new Error(data.error);
+            }
+            showLogo('');
+            document.getElementById('settings-watermark-enabled').checked = data.watermark.enabled;
+        })
+        .catch(error => {
+            alert('删除 Logo 失败：' + error.message);
+        });
+    });
+});
@@ -0,0 +1,109 @@
+// API令牌管理页面的JavaScript功能
+document.addEventListener('DOMContentLoaded', function() {
+    const tokenForm = document.getElementById('token-form');
+    const tokenCreated = document.getElementById('token-created');
+    const tokenValue = document.getElementById('token-value');
+    const copyToken = document.getElementById('copy-token');
+
+    // 创建令牌
+    tokenForm.addEventListener('submit', function(e) {
+        e.preventDefault();
+
+        const payload = {
+            name: document.getElementById('token-name').value,
+            expires_in_days: parseInt(document.getElementById('token-expires').value, 10)
+        };
+
+        fetch('/tokens', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload)
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (data.error) {
+                throw new Error(data.error);
+            }
+
+            // 显示只出现一次的明文令牌
+            tokenValue.value = data.token;
+            tokenCreated.style.display = 'block';
+            tokenForm.reset();
+
+            appendTokenRow(data.info);
+        })
+        .catch(error => {
+            alert('创建失败：' + error.message);
+        });
+    });
+
+    // 复制令牌
+    copyToken.addEventListener('click', function() {
+        tokenValue.select();
+        document.execCommand('copy');
+
+        const originalText = this.textContent;
+        this.textContent = '已复制';
+        setTimeout(() => {
+            this.textContent = originalText;
+        }, 1500);
+    });
+
+    // 吊销令牌
+    document.getElementById('token-list').addEventListener('click', function(e) {
+        const button = e.target.closest('.revoke-btn');
+        if (!button) return;
+
+        if (!confirm('吊销后使用该令牌的工具将无法继续访问，确定要吊销吗？')) {
+            return;
+        }
+
+        const id = button.getAttribute('data-id');
+        fetch(`/tokens/${id}`, { method: 'DELETE' })
+            .then(response => response.json())
+            .then(data => {
+                if (data.error) {
+                    throw new Error(data.error);
+                }
+                button.closest('tr').remove();
+            })
+            .catch(error => {
+                alert('吊销失败：' + error.message);
+            });
+    });
+
+    // 在列表中添加新创建的令牌
+    function appendTokenRow(token) {
+        const list = document.getElementById('token-list');
+        const emptyRow = list.querySelector('.empty-row');
+        if (emptyRow) {
+            emptyRow.remove();
+        }
+
+        const row = document.createElement('tr');
+        row.setAttribute('data-id', token.id);
+        row.innerHTML = `
+            <td></td>
+            <td><code></code></td>
+            <td>${formatDate(token.created_at)}</td>
+            <td>从未使用</td>
+            <td>${token.expires_at ? formatDate(token.expires_at) : '永不过期'}</td>
+            <td><button class="btn small danger revoke-btn" data-id="${token.id}">吊销</button></td>
+        `;
+        row.children[0].textContent = token.name;
+        row.querySelector('code').textContent = token.hint + '…';
+        list.appendChild(row);
+    }
+
+    // 格式化日期
+    function formatDate(dateString) {
+        const date = new Date(dateString);
+        return date.toLocaleString('zh-CN', {
+            year: 'numeric',
+            month: '2-digit',
+            day: '2-digit',
+            hour: '2-digit',
+            minute: '2-digit'
+        });
+    }
+});
@@ -0,0 +1,64 @@
+// 回收站页面的JavaScript功能
+document.addEventListener('DOMContentLoaded', function() {
+    const trashGrid = document.getElementById('trash-grid');
+    const emptyTrashBtn = document.getElementById('empty-trash');
+
+    // 发送请求，失败时抛出服务器返回的错误信息
+    function request(url, method, fallback) {
+        return fetch(url, { method: method })
+            .then(response => response.json().then(data => {
+                if (!response.ok) {
+                    throw new Error(data.error || fallback);
+                }
+                return data;
+            }));
+    }
+
+    // 移除处理过的图片，回收站清空后刷新页面
+    function removeCard(id) {
+        const card = trashGrid.querySelector(`.image-card[data-id="${id}"]`);
+        if (card) {
+            card.remove();
+        }
+        if (trashGrid.querySelectorAll('.image-card').length === 0) {
+            location.reload();
+        }
+    }
+
+    trashGrid.addEventListener('click', function(e) {
+        const restoreBtn = e.target.closest('.restore-btn');
+        if (restoreBtn) {
+            const id = restoreBtn.getAttribute('data-id');
+            request(`/trash/${id}/restore`, 'POST', '恢复失败')
+                .then(() => removeCard(id))
+                .catch(error => alert('恢复失败：' + error.message));
+            return;
+        }
+
+        const purgeBtn = e.target.closest('.purge-btn');
+        if (purgeBtn) {
+            if (!confirm('永久删除后无法恢复，确定要永久删除这张图片吗？')) {
+                return;
+            }
+            const id = purgeBtn.getAttribute('data-id');
+            request(`/trash/${id}`, 'DELETE', '删除失败')
+                .then(() => removeCard(id))
+                .catch(error => alert('删除失败：' + error.message));
+        }
+    });
+
+    // 清空回收站
+    if (emptyTrashBtn) {
+        emptyTrashBtn.addEventListener('click', function() {
+            if (!confirm('回收站中的所有图片都将被永久删除，无法恢复。确定要清空回收站吗？')) {
+                return;
+            }
+            request('/trash', 'DELETE', '清空失败')
+                .then(() => location.reload())
+                .catch(error => {
+                    alert('清空失败：' + error.message);
+                    location.reload(); // 部分图片可能已经删除
+                });
+        });
+    }
+});
@@ -20,9 +20,22 @@ document.addEventListener('DOMContentLoaded', function() {
     const uploadError = document.getElementById('upload-error');
     const errorMessage = document.getElementById('error-message');
     const tryAgain = document.getElementById('try-again');
+    const similarWarning = document.getElementById('similar-warning');
+    const similarList = document.getElementById('similar-list');
+    const previewList = document.getElementById('preview-list');
+    const batchResult = document.getElementById('batch-result');
+    const batchSummary = document.getElementById('batch-summary');
+    const batchList = document.getElementById('batch-list');
+    const batchUrls = document.getElementById('batch-urls');
+    const batchMarkdown = document.getElementById('batch-markdown');
+
+    // 服务端配置的文件大小上限
+    const maxSize = parseInt(dropArea.getAttribute('data-max-size'), 10) || 10 * 1024 * 1024;
+    // 一次最多上传的图片数量
+    const maxBatch = parseInt(dropArea.getAttribute('data-max-batch'), 10) || 50;
 
     // 当前选择的文件
-    let currentFile = null;
+    let currentFiles = [];
 
     // 阻止默认拖放行为
     ['dragenter', 'dragover', 'dragleave', 'drop'].forEach(eventName => {
@@ -59,19 +72,63 @@ document.addEventListener('DOMContentLoaded', function() {
         const files = dt.files;
 
         if (files.length > 0) {
-            handleFiles(files[0]);
+            handleFiles(files);
         }
     }
 
     // 处理文件选择
     fileInput.addEventListener('change', function() {
         if (this.files.length > 0) {
-            handleFiles(this.files[0]);
+            handleFiles(this.files);
         }
     });
 
-    // 处理选择的文件
-    function handleFiles(file) {
+    // 处理选择的文件，选择多张时批量上传
+    function handleFiles(files) {
+        files = Array.from(files);
+        if (files.length > maxBatch) {
+            showError(`一次最多上传 ${maxBatch} 张图片`);
+            return;
+        }
+        if (files.length === 1) {
+            handleFile(files[0]);
+            return;
+        }
+
+        // 跳过不是图片或超过大小限制的文件
+        const skipped = files.filter(file => !file.type.match('image.*') || file.size > maxSize);
+        files = files.filter(file => !skipped.includes(file));
+        if (files.length === 0) {
+            showError(`请选择不超过${formatFileSize(maxSize)}的图片文件`);
+            return;
+        }
+
+        currentFiles = files;
+
+        // 显示文件列表
+        previewList.innerHTML = '';
+        files.forEach(file => {
+            const item = document.createElement('p');
+            item.textContent = `${file.name}（${formatFileSize(file.size)}）`;
+            previewList.appendChild(item);
+        });
+        skipped.forEach(file => {
+            const item = document.createElement('p');
+            item.className = 'skipped';
+            item.textContent = `${file.name}：不是图片或超过${formatFileSize(maxSize)}，已跳过`;
+            previewList.appendChild(item);
+        });
+        const totalSize = files.reduce((sum, file) => sum + file.size, 0);
+        previewImage.style.display = 'none';
+        previewList.style.display = 'block';
+        fileName.textContent = `已选择 ${files.length} 张图片`;
+        fileSize.textContent = `总大小: ${formatFileSize(totalSize)}`;
+        uploadPreview.style.display = 'block';
+        dropArea.style.display = 'none';
+    }
+
+    // 处理选择的单个文件
+    function handleFile(file) {
         // 检查是否为图片
         if (!file.type.match('image.*')) {
             showError('请选择图片文件');
@@ -79,17 +136,19 @@ document.addEventListener('DOMContentLoaded', function() {
         }
 
         // 检查文件大小
-        if (file.size > 10 * 1024 * 1024) { // 10MB
-            showError('图片大小不能超过10MB');
+        if (file.size > maxSize) {
+            showError(`图片大小不能超过${formatFileSize(maxSize)}`);
             return;
         }
 
-        currentFile = file;
+        currentFiles = [file];
 
         // 显示预览
         const reader = new FileReader();
         reader.onload = function(e) {
             previewImage.src = e.target.result;
+            previewImage.style.display = '';
+            previewList.style.display = 'none';
             fileName.textContent = `文件名: ${file.name}`;
             fileSize.textContent = `大小: ${formatFileSize(file.size)}`;
             uploadPreview.style.display = 'block';
@@ -114,18 +173,30 @@ document.addEventListener('DOMContentLoaded', function() {
 
     // 上传文件
     function uploadFile() {
-        if (!currentFile) {
+        if (currentFiles.length === 0) {
             return;
         }
 
+        const batch = currentFiles.length > 1;
         const formData = new FormData();
-        formData.append('image', currentFile);
+        currentFiles.forEach(file => {
+            formData.append(batch ? 'images' : 'image', file);
+        });
+        formData.append('visibility', document.getElementById('visibility-select').value);
+        formData.append('tags', document.getElementById('tags-input').value);
+        formData.append('description', document.getElementById('description-input').value);
+        formData.append('strip_metadata', document.getElementById('strip-metadata-input').checked ? 'true' : 'false');
+        // 水印只在与个人设置不同时单独指定
+        const watermarkInput = document.getElementById('watermark-input');
+        if (watermarkInput.checked !== watermarkInput.defaultChecked) {
+            formData.append('watermark', watermarkInput.checked ? 'on' : 'off');
+        }
 
         // 显示上传中状态
         uploadButton.disabled = true;
         uploadButton.textContent = '上传中...';
 
-        fetch('/upload', {
+        fetch(batch ? '/upload/batch' : '/upload', {
             method: 'POST',
             body: formData
         })
@@ -138,8 +209,12 @@ document.addEventListener('DOMContentLoaded', function() {
             return response.json();
         })
         .then(data => {
-            // 上传成功
-            showResult(data);
+            // 上传成功，批量上传时部分文件可能失败
+            if (batch) {
+                showBatchResult(data);
+            } else {
+                showResult(data);
+            }
         })
         .catch(error => {
             // 上传失败
@@ -161,6 +236,21 @@ document.addEventListener('DOMContentLoaded', function() {
         imageUrl.value = imageFullUrl;
         markdownUrl.value = `![${data.filename || 'image'}](${imageFullUrl})`;
         resultImagePreview.src = imageFullUrl;
+
+        // 列出相似的已有图片
+        similarList.innerHTML = '';
+        (data.similar || []).forEach(image => {
+            const link = document.createElement('a');
+            link.href = `/i/${image.id}`;
+            link.target = '_blank';
+            link.title = `${image.filename}（距离 ${image.distance}）`;
+            const img = document.createElement('img');
+            img.src = `/i/${image.id}?thumb=1`;
+            img.alt = image.filename;
+            link.appendChild(img);
+            similarList.appendChild(link);
+        });
+        similarWarning.style.display = similarList.children.length > 0 ? 'block' : 'none';
         
         // 显示结果页面
         uploadContainer.style.display = 'none';
@@ -168,11 +258,49 @@ document.addEventListener('DOMContentLoaded', function() {
         uploadError.style.display = 'none';
     }
 
+    // 显示批量上传的结果
+    function showBatchResult(data) {
+        const results = data.results || [];
+        const uploaded = results.filter(result => !result.error);
+
+        batchSummary.textContent = data.failed > 0
+            ? `上传完成：成功 ${data.succeeded} 张，失败 ${data.failed} 张`
+            : `上传成功 ${data.succeeded} 张图片！`;
+
+        batchList.innerHTML = '';
+        results.forEach(result => {
+            const item = document.createElement('li');
+            if (result.error) {
+                item.className = 'failed';
+                item.textContent = `${result.filename}：${result.error}`;
+            } else {
+                const link = document.createElement('a');
+                link.href = result.url;
+                link.target = '_blank';
+                link.textContent = result.filename;
+                item.appendChild(link);
+                if (result.similar && result.similar.length > 0) {
+                    item.appendChild(document.createTextNode(`（已有 ${result.similar.length} 张相似的图片）`));
+                }
+            }
+            batchList.appendChild(item);
+        });
+
+        batchUrls.value = uploaded.map(result => result.url).join('\n');
+        batchMarkdown.value = uploaded.map(result => `![${result.filename || 'image'}](${result.url})`).join('\n');
+
+        uploadContainer.style.display = 'none';
+        batchResult.style.display = 'block';
+        uploadResult.style.display = 'none';
+        uploadError.style.display = 'none';
+    }
+
     // 显示错误信息
     function showError(message) {
         errorMessage.textContent = message;
         uploadContainer.style.display = 'none';
         uploadResult.style.display = 'none';
+        batchResult.style.display = 'none';
         uploadError.style.display = 'block';
     }
 
@@ -182,18 +310,22 @@ document.addEventListener('DOMContentLoaded', function() {
     // 继续上传
     uploadAnother.addEventListener('click', resetUpload);
 
+    document.getElementById('batch-upload-another').addEventListener('click', resetUpload);
+
     // 重试
     tryAgain.addEventListener('click', resetUpload);
 
     // 重置上传界面
     function resetUpload() {
-        currentFile = null;
+        currentFiles = [];
         fileInput.value = '';
         previewImage.src = '';
+        previewList.innerHTML = '';
         uploadPreview.style.display = 'none';
         dropArea.style.display = 'block';
         uploadContainer.style.display = 'block';
         uploadResult.style.display = 'none';
+        batchResult.style.display = 'none';
         uploadError.style.display = 'none';
     }
 
@@ -207,6 +339,15 @@ document.addEventListener('DOMContentLoaded', function() {
         copyToClipboard(markdownUrl);
     });
 
+    // 复制批量上传的链接
+    document.getElementById('copy-batch-urls').addEventListener('click', function() {
+        copyToClipboard(batchUrls);
+    });
+
+    document.getElementById('copy-batch-markdown').addEventListener('click', function() {
+        copyToClipboard(batchMarkdown);
+    });
+
     // 复制到剪贴板
     function copyToClipboard(input) {
         input.select();
@@ -228,7 +369,7 @@ document.addEventListener('DOMContentLoaded', function() {
         for (let i = 0; i < items.length; i++) {
             if (items[i].type.indexOf('image') !== -1) {
                 const file = items[i].getAsFile();
-                handleFiles(file);
+                handleFile(file);
                 break;
             }
         }